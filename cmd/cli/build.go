@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/compiler"
+)
+
+// Build compiles the file at path with the modules it imports and saves the
+// bytecode to output, which defaults to path with the .wmofnc extension.
+func Build(path string, output string, searchPath []string) {
+	if output == "" {
+		output = strings.TrimSuffix(path, filepath.Ext(path)) + ".wmofnc"
+	}
+
+	resolver := compiler.NewResolver(searchPath, os.ReadFile)
+	compileResult, errors := resolver.CompileFile(path)
+	if len(errors) > 0 {
+		fmt.Fprintf(os.Stderr, "failed to compile file %s\n", path)
+		printDiagnostics("error", errors)
+		os.Exit(1)
+	}
+	printDiagnostics("warning", compileResult.Warnings)
+
+	var buffer bytes.Buffer
+	if err := compiler.Save(&buffer, compileResult); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save %s: %v\n", output, err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(output, buffer.Bytes(), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", output, err)
+		os.Exit(1)
+	}
+}
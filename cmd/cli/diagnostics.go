@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+)
+
+// printDiagnostics writes errors or warnings to stderr, keeping stdout for
+// the output of the script itself.
+func printDiagnostics(severity string, diagnostics []common.Error) {
+	for _, diagnostic := range diagnostics {
+		location := diagnostic.File
+		if diagnostic.Pos != nil {
+			location = fmt.Sprintf("%d:%d", diagnostic.Pos.Line, diagnostic.Pos.Column)
+			if diagnostic.File != "" {
+				location = diagnostic.File + ":" + location
+			}
+		}
+		if location == "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", severity, diagnostic.Message)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s at %s\n", severity, diagnostic.Message, location)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/compiler"
+)
+
+// Disasm prints the bytecode of the file at path, a source file or a file
+// saved by `build`, without running it.
+func Disasm(path string, searchPath []string) {
+	compileResult, source := load(path, searchPath)
+	fmt.Print(disassemble(path, compileResult, source))
+}
+
+// disassemble prints the imported modules in the order they run, then the
+// module at path. The source lines of modules compiled from source are
+// interleaved with their instructions.
+func disassemble(path string, compileResult *compiler.CompileResult, source string) string {
+	sections := []string{}
+	for i := range compileResult.Imports {
+		name := fmt.Sprintf("import #%d", i)
+		importSource := ""
+		if i < len(compileResult.ImportPaths) {
+			name = compileResult.ImportPaths[i]
+			if buffer, err := os.ReadFile(name); err == nil {
+				importSource = string(buffer)
+			}
+		}
+		sections = append(sections, compiler.Disassemble(name, &compileResult.Imports[i], compileResult.GlobalTable, importSource))
+	}
+	sections = append(sections, compiler.Disassemble(path, &compileResult.ModuleProto, compileResult.GlobalTable, source))
+	return strings.Join(sections, "\n")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
+)
+
+// Fmt formats the files at paths. The formatted source is printed, unless
+// write is set, in which case files are rewritten in place when formatting
+// changes them. With check, the files that are not formatted are listed and
+// the exit status is 1 when there is any. A file that does not parse is
+// reported and left as is.
+func Fmt(paths []string, write bool, check bool) {
+	failed := false
+	for _, path := range paths {
+		buffer, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read file %s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		source := string(buffer)
+		formatted, errors := ast.Format(source)
+		if len(errors) > 0 {
+			fmt.Fprintf(os.Stderr, "failed to parse file %s\n", path)
+			for i := range errors {
+				errors[i].File = path
+			}
+			printDiagnostics("error", errors)
+			failed = true
+			continue
+		}
+
+		changed := formatted != source
+		if check && changed {
+			fmt.Println(path)
+			failed = true
+		}
+		if write {
+			if changed {
+				if err := os.WriteFile(path, []byte(formatted), 0o644); err != nil {
+					fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", path, err)
+					failed = true
+				}
+			}
+			continue
+		}
+		if !check {
+			fmt.Print(formatted)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
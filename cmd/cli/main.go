@@ -1,26 +1,84 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
+const usage = "usage: \n\tcli run [-path dirs] [--dump-tokens] [--dump-ast] [--dump-bytecode] <file>\n\tcli build [-path dirs] <file> [-o output]\n\tcli disasm [-path dirs] <file>\n\tcli fmt [-w] [-check] <files...>\n\tcli repl"
+
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println("usage: \n\tcli run <file>\n\tcli repl")
+		fmt.Println(usage)
 		os.Exit(1)
 	}
 	switch os.Args[1] {
 	case "run":
-		if len(os.Args) < 3 {
-			fmt.Println("usage: cli run <file>")
+		flags := flag.NewFlagSet("run", flag.ExitOnError)
+		path := searchPathFlag(flags)
+		var dump DumpOptions
+		flags.BoolVar(&dump.Tokens, "dump-tokens", false, "print the tokens of the file before running it")
+		flags.BoolVar(&dump.AST, "dump-ast", false, "print the syntax tree of the file before running it")
+		flags.BoolVar(&dump.Bytecode, "dump-bytecode", false, "print the bytecode of the program before running it")
+		args := parseArgs(flags, os.Args[2:])
+		if len(args) != 1 {
+			fmt.Println("usage: cli run [-path dirs] [--dump-tokens] [--dump-ast] [--dump-bytecode] <file>")
+			os.Exit(1)
+		}
+		Run(args[0], filepath.SplitList(*path), dump)
+	case "build":
+		flags := flag.NewFlagSet("build", flag.ExitOnError)
+		path := searchPathFlag(flags)
+		output := flags.String("o", "", "output file, the input file with the .wmofnc extension by default")
+		args := parseArgs(flags, os.Args[2:])
+		if len(args) != 1 {
+			fmt.Println("usage: cli build [-path dirs] <file> [-o output]")
+			os.Exit(1)
+		}
+		Build(args[0], *output, filepath.SplitList(*path))
+	case "disasm":
+		flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+		path := searchPathFlag(flags)
+		args := parseArgs(flags, os.Args[2:])
+		if len(args) != 1 {
+			fmt.Println("usage: cli disasm [-path dirs] <file>")
+			os.Exit(1)
+		}
+		Disasm(args[0], filepath.SplitList(*path))
+	case "fmt":
+		flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+		write := flags.Bool("w", false, "write the formatted source back to the files instead of printing it")
+		check := flags.Bool("check", false, "list the files that are not formatted and exit with status 1 if there are any")
+		args := parseArgs(flags, os.Args[2:])
+		if len(args) == 0 {
+			fmt.Println("usage: cli fmt [-w] [-check] <files...>")
 			os.Exit(1)
 		}
-		Run(os.Args[2])
+		Fmt(args, *write, *check)
 	case "repl":
 		REPL()
 	default:
-		fmt.Println("usage: \n\tcli run <file>\n\tcli repl")
+		fmt.Println(usage)
 		os.Exit(1)
 	}
 }
+
+func searchPathFlag(flags *flag.FlagSet) *string {
+	return flags.String("path", os.Getenv("WMOFN_PATH"), "directories searched for imported modules, separated by "+string(filepath.ListSeparator))
+}
+
+// parseArgs parses flags written before or after the file, as in
+// `cli build foo.wmofn -o foo.wmofnc`, and returns the other arguments.
+func parseArgs(flags *flag.FlagSet, args []string) []string {
+	positional := []string{}
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 
 	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
@@ -20,6 +21,10 @@ func REPL() {
 	for {
 		fmt.Print("> ")
 		input, err := reader.ReadString('\n')
+		if err == io.EOF {
+			fmt.Println()
+			return
+		}
 		if err != nil {
 			fmt.Println("Error reading input:", err)
 			continue
@@ -35,8 +40,18 @@ func REPL() {
 			fmt.Println("Error parsing input:", parser.Errors)
 			continue
 		}
-		compileResult, _ := compiler.CompileREPLChunk(program)
-		retval := vm.RunModuleProto(&compileResult.ModuleProto)
+		compileResult, errors := compiler.CompileREPLChunk(program)
+		if len(errors) > 0 {
+			printDiagnostics("error", errors)
+			continue
+		}
+		printDiagnostics("warning", compileResult.Warnings)
+		retval, runtimeError := vm.RunModuleProto(&compileResult.ModuleProto)
+		if runtimeError != nil {
+			fmt.Fprintln(os.Stderr, runtimeError.Error())
+			compiler.RollbackREPLChunk()
+			continue
+		}
 		fmt.Printf("retval: %d\n", retval)
 	}
 }
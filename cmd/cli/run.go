@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 
@@ -10,42 +11,88 @@ import (
 	"youpiteron.dev/white-monster-on-friday-night/internal/vm"
 )
 
-func Run(path string) {
+// DumpOptions selects the stages of compilation Run prints before running
+// the program.
+type DumpOptions struct {
+	Tokens   bool
+	AST      bool
+	Bytecode bool
+}
+
+// Run runs the file at path. Bytecode saved by `build` runs as is, a source
+// file is compiled first with the modules it imports, looking up imports in
+// searchPath after the importing file's directory.
+func Run(path string, searchPath []string, dump DumpOptions) {
+	compileResult, source := load(path, searchPath)
+	if dump.Tokens || dump.AST {
+		if source == "" {
+			fmt.Fprintf(os.Stderr, "%s is bytecode, it has no tokens or AST to dump\n", path)
+		} else {
+			dumpSource(source, dump)
+		}
+	}
+	if dump.Bytecode {
+		fmt.Print(disassemble(path, compileResult, source))
+	}
+	execute(compileResult)
+}
+
+// load compiles the file at path, or loads it when it is bytecode. source is
+// the text of the file, it is empty for bytecode.
+func load(path string, searchPath []string) (*compiler.CompileResult, string) {
 	buffer, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Printf("failed to read file %s: %v\n", path, err)
+		fmt.Fprintf(os.Stderr, "failed to read file %s: %v\n", path, err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("read %d bytes from %s\n", len(buffer), path)
-	fmt.Printf("buffer: %s\n", string(buffer))
-
-	lexer := lexer.NewLexer()
+	if compiler.IsBytecode(buffer) {
+		compileResult, err := compiler.Load(bytes.NewReader(buffer))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		return compileResult, ""
+	}
 
-	lexerResult := lexer.Lex(string(buffer))
-	if len(lexerResult.Errors) > 0 {
-		fmt.Printf("failed to lex file %s: %v\n", path, lexerResult.Errors)
+	resolver := compiler.NewResolver(searchPath, os.ReadFile)
+	compileResult, errors := resolver.CompileFile(path)
+	if len(errors) > 0 {
+		fmt.Fprintf(os.Stderr, "failed to compile file %s\n", path)
+		printDiagnostics("error", errors)
 		os.Exit(1)
 	}
+	printDiagnostics("warning", compileResult.Warnings)
+	return compileResult, string(buffer)
+}
 
-	for _, token := range lexerResult.Tokens {
-		fmt.Printf("token: %s\n", token.String())
+// dumpSource prints the tokens and the AST of a file that compiled, so it
+// lexes and parses without errors.
+func dumpSource(source string, dump DumpOptions) {
+	lexerResult := lexer.NewLexer().Lex(source)
+	if dump.Tokens {
+		for _, token := range lexerResult.Tokens {
+			fmt.Println(token.String())
+		}
+	}
+	if dump.AST {
+		fmt.Print(ast.Dump(ast.NewParser(lexerResult.Tokens).ParseProgram()))
 	}
+}
 
-	parser := ast.NewParser(lexerResult.Tokens)
-	program := parser.ParseProgram()
-	if len(parser.Errors) > 0 {
-		fmt.Printf("failed to parse tokens from file %s\n", path)
-		for _, error := range parser.Errors {
-			fmt.Printf("  %s at %v\n", error.Message, error.Pos)
+// execute runs the imports of a compiled program, then the program itself.
+func execute(compileResult *compiler.CompileResult) {
+	vm := vm.NewVM(compileResult.GlobalTable)
+	for _, imported := range compileResult.Imports {
+		if runtimeError := vm.RunImport(&imported); runtimeError != nil {
+			fmt.Fprintln(os.Stderr, runtimeError.Error())
+			os.Exit(1)
 		}
+	}
+	retval, runtimeError := vm.RunModuleProto(&compileResult.ModuleProto)
+	if runtimeError != nil {
+		fmt.Fprintln(os.Stderr, runtimeError.Error())
 		os.Exit(1)
 	}
-
-	compiler := compiler.NewCompiler()
-	compileResult := compiler.CompileToModuleProto(program)
-
-	vm := vm.NewVM(compileResult.GlobalTable)
-	retval := vm.RunModuleProto(&compileResult.ModuleProto)
 	fmt.Printf("retval: %d\n", retval)
 }
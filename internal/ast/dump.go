@@ -0,0 +1,95 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+)
+
+var (
+	typePointer    = reflect.TypeOf(&Type{})
+	posPointer     = reflect.TypeOf(&common.SourcePos{})
+	assignmentType = reflect.TypeOf(Assignment{})
+)
+
+// Dump prints node as an indented tree, one node per line. Scalar fields,
+// types and operators are shown next to the node's name, nodes and lists of
+// nodes are shown below it, named after their field.
+func Dump(node Node) string {
+	var builder strings.Builder
+	dumpValue(&builder, "", reflect.ValueOf(node), 0)
+	return builder.String()
+}
+
+func dumpValue(builder *strings.Builder, label string, value reflect.Value, depth int) {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return
+	}
+
+	attributes := []string{}
+	position := ""
+	type child struct {
+		label string
+		value reflect.Value
+	}
+	children := []child{}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		fieldValue := value.Field(i)
+		switch {
+		case value.Type() == assignmentType && field.Name == "Operator" && !value.FieldByName("Compound").Bool():
+			// the operator of a plain assignment is unused
+		case field.Type == posPointer:
+			// only the start of a node is shown, not the end of its body
+			if field.Name == "PosAt" && !fieldValue.IsNil() {
+				pos := fieldValue.Interface().(*common.SourcePos)
+				position = fmt.Sprintf(" @%d:%d", pos.Line, pos.Column)
+			}
+		case field.Type == typePointer:
+			if !fieldValue.IsNil() {
+				attributes = append(attributes, fmt.Sprintf("%s=%s", field.Name, fieldValue.Interface()))
+			}
+		case field.Type.Kind() == reflect.String:
+			if fieldValue.String() != "" {
+				attributes = append(attributes, fmt.Sprintf("%s=%q", field.Name, fieldValue.String()))
+			}
+		case field.Type.Kind() == reflect.Bool:
+			if fieldValue.Bool() {
+				attributes = append(attributes, field.Name)
+			}
+		case fieldValue.CanInt() || fieldValue.CanFloat():
+			attributes = append(attributes, fmt.Sprintf("%s=%v", field.Name, fieldValue.Interface()))
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.String:
+			if fieldValue.Len() > 0 {
+				attributes = append(attributes, fmt.Sprintf("%s=%v", field.Name, fieldValue.Interface()))
+			}
+		case field.Type.Kind() == reflect.Slice:
+			for j := 0; j < fieldValue.Len(); j++ {
+				children = append(children, child{fmt.Sprintf("%s[%d]", field.Name, j), fieldValue.Index(j)})
+			}
+		default:
+			children = append(children, child{field.Name, fieldValue})
+		}
+	}
+
+	builder.WriteString(strings.Repeat("  ", depth))
+	if label != "" {
+		builder.WriteString(label + ": ")
+	}
+	builder.WriteString(value.Type().Name())
+	for _, attribute := range attributes {
+		builder.WriteString(" " + attribute)
+	}
+	builder.WriteString(position + "\n")
+	for _, c := range children {
+		dumpValue(builder, c.label, c.value, depth+1)
+	}
+}
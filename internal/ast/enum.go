@@ -0,0 +1,28 @@
+package ast
+
+import (
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+)
+
+type EnumVariant struct {
+	Name   string
+	Fields []StructField
+	PosAt  *common.SourcePos
+}
+
+// Enum declares a tagged union, `enum Shape { Circle(r: int), Rect(w: int, h: int) }`.
+// A variant's index in Variants is its tag at runtime.
+type Enum struct {
+	Name     string
+	Variants []EnumVariant
+	Exported bool
+	Doc      string
+	PosAt    *common.SourcePos
+	End      *common.SourcePos
+}
+
+func (e *Enum) Pos() *common.SourcePos { return e.PosAt }
+func (e *Enum) statementNode()         {}
+func (e *Enum) Visit(v Visitor[any]) any {
+	return v.VisitEnum(e)
+}
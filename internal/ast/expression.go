@@ -23,6 +23,35 @@ func (n *IntLiteral) Visit(v Visitor[any]) any {
 	return v.VisitIntLiteral(n)
 }
 
+type FloatLiteral struct {
+	Value       float64
+	PosAt       *common.SourcePos
+	IsStatement bool
+}
+
+func (n *FloatLiteral) Pos() *common.SourcePos { return n.PosAt }
+func (n *FloatLiteral) statementNode()         {}
+func (n *FloatLiteral) expressionNode()        {}
+func (n *FloatLiteral) Visit(v Visitor[any]) any {
+	return v.VisitFloatLiteral(n)
+}
+
+type StringLiteral struct {
+	Value string
+	// Raw is the literal as written, quotes and escapes included, empty when
+	// the node was not parsed from source
+	Raw         string
+	PosAt       *common.SourcePos
+	IsStatement bool
+}
+
+func (n *StringLiteral) Pos() *common.SourcePos { return n.PosAt }
+func (n *StringLiteral) statementNode()         {}
+func (n *StringLiteral) expressionNode()        {}
+func (n *StringLiteral) Visit(v Visitor[any]) any {
+	return v.VisitStringLiteral(n)
+}
+
 type BoolLiteral struct {
 	Value       bool
 	PosAt       *common.SourcePos
@@ -61,6 +90,47 @@ func (a *ArrayLiteral) Visit(v Visitor[any]) any {
 	return v.VisitArrayLiteral(a)
 }
 
+type MapEntry struct {
+	Key   Expression
+	Value Expression
+}
+
+// MapLiteral is `{ "a": 1, "b": 2 }`, the entries keep their source order.
+type MapLiteral struct {
+	Entries     []MapEntry
+	PosAt       *common.SourcePos
+	IsStatement bool
+}
+
+func (m *MapLiteral) Pos() *common.SourcePos { return m.PosAt }
+func (m *MapLiteral) statementNode()         {}
+func (m *MapLiteral) expressionNode()        {}
+func (m *MapLiteral) Visit(v Visitor[any]) any {
+	return v.VisitMapLiteral(m)
+}
+
+type FieldValue struct {
+	Name  string
+	Value Expression
+	PosAt *common.SourcePos
+}
+
+// StructLiteral is `Point { x: 1, y: 2 }`, every field of the struct has to
+// be given.
+type StructLiteral struct {
+	Name        string
+	Fields      []FieldValue
+	PosAt       *common.SourcePos
+	IsStatement bool
+}
+
+func (s *StructLiteral) Pos() *common.SourcePos { return s.PosAt }
+func (s *StructLiteral) statementNode()         {}
+func (s *StructLiteral) expressionNode()        {}
+func (s *StructLiteral) Visit(v Visitor[any]) any {
+	return v.VisitStructLiteral(s)
+}
+
 type Identifier struct {
 	Name        string
 	PosAt       *common.SourcePos
@@ -89,10 +159,63 @@ func (b *BinaryExpr) Visit(v Visitor[any]) any {
 	return v.VisitBinaryExpr(b)
 }
 
+type UnaryExpr struct {
+	Operator    lexer.OperatorSubkind
+	Operand     Expression
+	PosAt       *common.SourcePos
+	IsStatement bool
+}
+
+func (u *UnaryExpr) Pos() *common.SourcePos { return u.PosAt }
+func (u *UnaryExpr) statementNode()         {}
+func (u *UnaryExpr) expressionNode()        {}
+func (u *UnaryExpr) Visit(v Visitor[any]) any {
+	return v.VisitUnaryExpr(u)
+}
+
+type ConditionalExpr struct {
+	Condition   Expression
+	Then        Expression
+	Else        Expression
+	PosAt       *common.SourcePos
+	IsStatement bool
+}
+
+func (c *ConditionalExpr) Pos() *common.SourcePos { return c.PosAt }
+func (c *ConditionalExpr) statementNode()         {}
+func (c *ConditionalExpr) expressionNode()        {}
+func (c *ConditionalExpr) Visit(v Visitor[any]) any {
+	return v.VisitConditionalExpr(c)
+}
+
+// MatchArm is one `Variant(a, b) => body` arm of a match. Variant is "_" for
+// the wildcard arm, and a binding named "_" ignores its payload field.
+type MatchArm struct {
+	Variant  string
+	Bindings []string
+	Body     Expression
+	PosAt    *common.SourcePos
+}
+
+type MatchExpr struct {
+	Subject     Expression
+	Arms        []MatchArm
+	PosAt       *common.SourcePos
+	End         *common.SourcePos
+	IsStatement bool
+}
+
+func (m *MatchExpr) Pos() *common.SourcePos { return m.PosAt }
+func (m *MatchExpr) statementNode()         {}
+func (m *MatchExpr) expressionNode()        {}
+func (m *MatchExpr) Visit(v Visitor[any]) any {
+	return v.VisitMatchExpr(m)
+}
+
 type CallExpr struct {
-	Identifier Identifier
-	Arguments  []Expression
-	PosAt      *common.SourcePos
+	Callee    Expression
+	Arguments []Expression
+	PosAt     *common.SourcePos
 }
 
 func (c *CallExpr) Pos() *common.SourcePos { return c.PosAt }
@@ -102,6 +225,21 @@ func (c *CallExpr) Visit(v Visitor[any]) any {
 	return v.VisitCallExpr(c)
 }
 
+// FieldExpr reads the field Field of Object, as in `p.x`.
+type FieldExpr struct {
+	Object      Expression
+	Field       string
+	PosAt       *common.SourcePos
+	IsStatement bool
+}
+
+func (f *FieldExpr) Pos() *common.SourcePos { return f.PosAt }
+func (f *FieldExpr) statementNode()         {}
+func (f *FieldExpr) expressionNode()        {}
+func (f *FieldExpr) Visit(v Visitor[any]) any {
+	return v.VisitFieldExpr(f)
+}
+
 type IndexExpr struct {
 	Array       Expression
 	Index       Expression
@@ -115,3 +253,24 @@ func (i *IndexExpr) expressionNode()        {}
 func (i *IndexExpr) Visit(v Visitor[any]) any {
 	return v.VisitIndexExpr(i)
 }
+
+// FunctionExpr is an anonymous function used as a value. The arrow form
+// `(x: int) => x * 2` has no ReturnType and an ExprBody instead of a Body, its
+// return type is the type of ExprBody.
+type FunctionExpr struct {
+	Params      []Param
+	Vararg      bool
+	Body        []Statement
+	ExprBody    Expression
+	ReturnType  *Type
+	PosAt       *common.SourcePos
+	BodyEnd     *common.SourcePos
+	IsStatement bool
+}
+
+func (n *FunctionExpr) Pos() *common.SourcePos { return n.PosAt }
+func (n *FunctionExpr) statementNode()         {}
+func (n *FunctionExpr) expressionNode()        {}
+func (n *FunctionExpr) Visit(v Visitor[any]) any {
+	return v.VisitFunctionExpr(n)
+}
@@ -16,13 +16,19 @@ func (p *Param) Visit(v Visitor[any]) any {
 	return v.VisitParam(p)
 }
 
+// Function is a function declaration. TypeParams are the names declared in
+// `function first<T>(xs: []T): T`, the body sees them as opaque named types.
 type Function struct {
 	Name       string
+	TypeParams []string
 	Params     []Param
 	Vararg     bool
 	Body       []Statement
 	ReturnType *Type
+	Exported   bool
+	Doc        string
 	PosAt      *common.SourcePos
+	BodyEnd    *common.SourcePos
 }
 
 func (f *Function) Pos() *common.SourcePos { return f.PosAt }
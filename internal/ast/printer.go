@@ -0,0 +1,647 @@
+package ast
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+	"youpiteron.dev/white-monster-on-friday-night/internal/lexer"
+)
+
+// Format lexes, parses and prints source. When source does not parse it is
+// returned unchanged, along with the errors.
+func Format(source string) (string, []common.Error) {
+	lexerResult := lexer.NewLexer().Lex(source)
+	if len(lexerResult.Errors) > 0 {
+		return source, lexerResult.Errors
+	}
+	parser := NewParser(lexerResult.Tokens)
+	program := parser.ParseProgram()
+	if len(parser.Errors) > 0 {
+		return source, parser.Errors
+	}
+	return Print(program, lexerResult.Trivia), nil
+}
+
+// Print prints program the canonical way: two spaces of indentation, one
+// statement per line, struct fields, enum variants and match arms on lines of
+// their own, and parentheses only where precedence needs them. trivia are the
+// comments and blank lines of the source, as lexed. A comment is printed in
+// front of the statement, field or closing brace that follows it, or at the
+// end of the line it trailed. A block comment followed by code on its line
+// keeps that code on its line, as in `{ /* empty */ }`. String literals are
+// printed as written. Runs of blank lines shrink to one, and blank lines at
+// the start or the end of a body are dropped.
+func Print(program *Program, trivia []lexer.Trivia) string {
+	p := &printer{trivia: trivia, lineStart: true, opened: true}
+	for _, statement := range program.Statements {
+		p.statement(statement)
+	}
+	p.flushBefore(math.MaxInt, 0)
+	return string(p.out)
+}
+
+const indentation = "  "
+
+// The precedence levels of expressions, an operand that binds less tightly
+// than its position requires is wrapped in parentheses.
+const (
+	precLowest = iota // conditionals and arrow functions
+	precCoalesce
+	precOr
+	precAnd
+	precEquality
+	precComparison
+	precAdditive
+	precMultiplicative
+	precUnary
+	precPostfix
+)
+
+type printer struct {
+	out       []byte
+	indent    int
+	lineStart bool
+
+	trivia []lexer.Trivia
+	next   int
+
+	// blank is set when a blank line is due before the next line
+	blank bool
+	// opened is set when the last line opened a body, or nothing is printed yet
+	opened bool
+	// appendable is set when a trailing comment can go at the end of the last
+	// line: it holds code and does not end with a line comment
+	appendable bool
+}
+
+func (p *printer) write(s string) {
+	if p.lineStart {
+		p.out = append(p.out, strings.Repeat(indentation, p.indent)...)
+		p.lineStart = false
+	}
+	p.out = append(p.out, s...)
+}
+
+func (p *printer) line() {
+	p.out = append(p.out, '\n')
+	p.lineStart = true
+	p.opened = false
+	p.appendable = true
+}
+
+// separate prints the blank line due before the next line.
+func (p *printer) separate() {
+	if p.blank && !p.opened {
+		p.out = append(p.out, '\n')
+	}
+	p.blank = false
+}
+
+// ---------- Trivia ----------
+
+// flush prints the trivia in front of pos. Nodes built without positions
+// leave their trivia to the next node that has one.
+func (p *printer) flush(pos *common.SourcePos) {
+	if pos != nil {
+		p.flushBefore(pos.Offset, pos.Line)
+	}
+}
+
+// flushBefore prints the trivia in front of offset, line is the line of the
+// code at offset. A block comment that ends on that line stays in front of
+// the code, as in `/* why */ return;`.
+func (p *printer) flushBefore(offset int, line int) {
+	for ; p.next < len(p.trivia) && p.trivia[p.next].Pos.Offset < offset; p.next++ {
+		trivia := p.trivia[p.next]
+		text := strings.TrimRight(trivia.Text, " \t")
+		switch {
+		case trivia.Kind == lexer.TriviaBlankLine:
+			p.blank = true
+		case trivia.Trailing && p.lineStart && p.appendable:
+			p.out = append(p.out[:len(p.out)-1], ' ')
+			p.out = append(p.out, text...)
+			p.out = append(p.out, '\n')
+			p.appendable = trivia.Kind == lexer.TriviaBlockComment
+		case inline(trivia, line):
+			p.separate()
+			p.write(text + " ")
+		default:
+			p.separate()
+			p.write(text)
+			p.line()
+			p.appendable = false
+		}
+	}
+}
+
+// inline reports whether trivia is a block comment that ends on line.
+func inline(trivia lexer.Trivia, line int) bool {
+	return trivia.Kind == lexer.TriviaBlockComment && trivia.Pos.Line+strings.Count(trivia.Text, "\n") == line
+}
+
+// hasComments reports whether comments are left in front of pos.
+func (p *printer) hasComments(pos *common.SourcePos) bool {
+	if pos == nil {
+		return false
+	}
+	for _, trivia := range p.trivia[p.next:] {
+		if trivia.Pos.Offset >= pos.Offset {
+			return false
+		}
+		if trivia.Kind != lexer.TriviaBlankLine {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyInlineComments reports whether the trivia left in front of pos are
+// block comments trailing the token before them and ending on the line of
+// pos, as the comment in `{ /* empty */ }` is.
+func (p *printer) onlyInlineComments(pos *common.SourcePos) bool {
+	if pos == nil {
+		return false
+	}
+	for _, trivia := range p.trivia[p.next:] {
+		if trivia.Pos.Offset >= pos.Offset {
+			return true
+		}
+		if !trivia.Trailing || !inline(trivia, pos.Line) {
+			return false
+		}
+	}
+	return true
+}
+
+// start returns the position of the first token of node. Binary expressions,
+// calls and fields are positioned at their operator, which is not their start.
+func start(node Node) *common.SourcePos {
+	switch n := node.(type) {
+	case *BinaryExpr:
+		return start(n.Left)
+	case *ConditionalExpr:
+		return start(n.Condition)
+	case *CallExpr:
+		return start(n.Callee)
+	case *IndexExpr:
+		return start(n.Array)
+	case *FieldExpr:
+		return start(n.Object)
+	case *Assignment:
+		return start(n.Target)
+	}
+	return node.Pos()
+}
+
+// ---------- Statements ----------
+
+func (p *printer) statement(statement Statement) {
+	p.flush(start(statement))
+	p.separate()
+	switch n := statement.(type) {
+	case *Declaration:
+		if n.Exported {
+			p.write("export ")
+		}
+		p.declaration(n)
+		p.write(";")
+	case *Assignment:
+		p.assignment(n)
+		p.write(";")
+	case *Block:
+		p.body(n.Statements, n.End)
+	case *Return:
+		p.write("return")
+		if n.Value != nil {
+			p.write(" ")
+			p.expression(n.Value, precLowest)
+		}
+		p.write(";")
+	case *If:
+		p.write("if (")
+		p.expression(n.Condition, precLowest)
+		p.write(") ")
+		p.body(n.Body, n.BodyEnd)
+		if n.ElseEnd != nil || len(n.ElseBody) > 0 {
+			p.write(" else ")
+			p.body(n.ElseBody, n.ElseEnd)
+		}
+	case *While:
+		p.write("while (")
+		p.expression(n.Condition, precLowest)
+		p.write(") ")
+		p.body(n.Body, n.BodyEnd)
+	case *For:
+		p.write("for (")
+		if n.Init != nil {
+			p.clause(n.Init)
+		}
+		p.write(";")
+		if n.Condition != nil {
+			p.write(" ")
+			p.expression(n.Condition, precLowest)
+		}
+		p.write(";")
+		if n.Step != nil {
+			p.write(" ")
+			p.clause(n.Step)
+		}
+		p.write(") ")
+		p.body(n.Body, n.BodyEnd)
+	case *Break:
+		p.write("break;")
+	case *Continue:
+		p.write("continue;")
+	case *Import:
+		p.write("import " + lexer.Quote(n.Path) + ";")
+	case *Function:
+		if n.Exported {
+			p.write("export ")
+		}
+		p.write("function " + n.Name)
+		if len(n.TypeParams) > 0 {
+			p.write("<" + strings.Join(n.TypeParams, ", ") + ">")
+		}
+		p.params(n.Params)
+		p.write(": " + n.ReturnType.String() + " ")
+		p.body(n.Body, n.BodyEnd)
+	case *Struct:
+		if n.Exported {
+			p.write("export ")
+		}
+		p.write("struct " + n.Name + " ")
+		p.structBody(n)
+	case *Enum:
+		if n.Exported {
+			p.write("export ")
+		}
+		p.write("enum " + n.Name + " ")
+		p.enumBody(n)
+	case Expression:
+		// a '{' starting a statement opens a block, not a map literal
+		if _, ok := leftmost(n).(*MapLiteral); ok {
+			p.write("(")
+			p.expression(n, precLowest)
+			p.write(")")
+		} else {
+			p.expression(n, precLowest)
+		}
+		p.write(";")
+	}
+	p.line()
+}
+
+// clause prints the init or the step of a for loop.
+func (p *printer) clause(statement Statement) {
+	switch n := statement.(type) {
+	case *Declaration:
+		p.declaration(n)
+	case *Assignment:
+		p.assignment(n)
+	case Expression:
+		p.expression(n, precLowest)
+	}
+}
+
+func (p *printer) declaration(n *Declaration) {
+	if n.IsMutable {
+		p.write("var ")
+	} else {
+		p.write("const ")
+	}
+	p.write(n.Identifier.Name)
+	if n.IsTyped && n.TypeOf != nil {
+		p.write(": " + n.TypeOf.String())
+	}
+	if n.Value != nil {
+		p.write(" = ")
+		p.expression(n.Value, precLowest)
+	}
+}
+
+func (p *printer) assignment(n *Assignment) {
+	p.expression(n.Target, precPostfix)
+	if binary, ok := n.Value.(*BinaryExpr); ok && n.IncDec {
+		if binary.Operator == lexer.OperatorMinus {
+			p.write("--")
+		} else {
+			p.write("++")
+		}
+		return
+	}
+	if n.Compound {
+		p.write(" " + n.Operator.String() + "= ")
+	} else {
+		p.write(" = ")
+	}
+	p.expression(n.Value, precLowest)
+}
+
+// body prints statements between braces, end is the position of the closing
+// brace. An empty body without comments is printed as `{}`.
+func (p *printer) body(statements []Statement, end *common.SourcePos) {
+	if len(statements) == 0 && !p.hasComments(end) {
+		p.flush(end)
+		p.blank = false
+		p.write("{}")
+		return
+	}
+	if len(statements) == 0 && p.inlineBody(end) {
+		return
+	}
+	p.open()
+	for _, statement := range statements {
+		p.statement(statement)
+	}
+	p.close(end)
+}
+
+// inlineBody prints an empty body whose comments sit between its braces on
+// one line as `{ /* c */ }`, end is the position of the closing brace. It
+// reports whether it printed the body.
+func (p *printer) inlineBody(end *common.SourcePos) bool {
+	if !p.onlyInlineComments(end) {
+		return false
+	}
+	p.write("{ ")
+	p.flush(end)
+	p.blank = false
+	p.write("}")
+	return true
+}
+
+func (p *printer) open() {
+	p.write("{")
+	p.line()
+	p.opened = true
+	p.indent++
+}
+
+func (p *printer) close(end *common.SourcePos) {
+	p.flush(end)
+	p.blank = false
+	p.indent--
+	p.write("}")
+}
+
+func (p *printer) structBody(n *Struct) {
+	if len(n.Fields) == 0 && !p.hasComments(n.End) {
+		p.write("{}")
+		return
+	}
+	if len(n.Fields) == 0 && p.inlineBody(n.End) {
+		return
+	}
+	p.open()
+	for _, field := range n.Fields {
+		p.flush(field.PosAt)
+		p.separate()
+		p.write(field.Name + ": " + field.TypeOf.String() + ",")
+		p.line()
+	}
+	p.close(n.End)
+}
+
+func (p *printer) enumBody(n *Enum) {
+	if len(n.Variants) == 0 && !p.hasComments(n.End) {
+		p.write("{}")
+		return
+	}
+	if len(n.Variants) == 0 && p.inlineBody(n.End) {
+		return
+	}
+	p.open()
+	for _, variant := range n.Variants {
+		p.flush(variant.PosAt)
+		p.separate()
+		p.write(variant.Name)
+		if len(variant.Fields) > 0 {
+			fields := make([]string, len(variant.Fields))
+			for i, field := range variant.Fields {
+				fields[i] = field.Name + ": " + field.TypeOf.String()
+			}
+			p.write("(" + strings.Join(fields, ", ") + ")")
+		}
+		p.write(",")
+		p.line()
+	}
+	p.close(n.End)
+}
+
+func (p *printer) params(params []Param) {
+	p.write("(")
+	for i, param := range params {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(param.Name + ": " + param.TypeOf.String())
+		if param.Vararg {
+			p.write("...")
+		}
+	}
+	p.write(")")
+}
+
+// ---------- Expressions ----------
+
+func precedence(expression Expression) int {
+	switch n := expression.(type) {
+	case *ConditionalExpr:
+		return precLowest
+	case *FunctionExpr:
+		// the body of an arrow function extends as far as it can
+		if n.ExprBody != nil {
+			return precLowest
+		}
+	case *BinaryExpr:
+		return binaryPrecedence(n.Operator)
+	case *UnaryExpr:
+		return precUnary
+	}
+	return precPostfix
+}
+
+func binaryPrecedence(operator lexer.OperatorSubkind) int {
+	switch operator {
+	case lexer.OperatorNullCoalesce:
+		return precCoalesce
+	case lexer.OperatorOr:
+		return precOr
+	case lexer.OperatorAnd:
+		return precAnd
+	case lexer.OperatorEqual, lexer.OperatorNotEqual:
+		return precEquality
+	case lexer.OperatorLess, lexer.OperatorLessEqual, lexer.OperatorGreater, lexer.OperatorGreaterEqual:
+		return precComparison
+	case lexer.OperatorPlus, lexer.OperatorMinus:
+		return precAdditive
+	}
+	return precMultiplicative
+}
+
+// leftmost returns the expression printed first in expression.
+func leftmost(expression Expression) Expression {
+	switch n := expression.(type) {
+	case *BinaryExpr:
+		return leftmost(n.Left)
+	case *ConditionalExpr:
+		return leftmost(n.Condition)
+	case *CallExpr:
+		return leftmost(n.Callee)
+	case *IndexExpr:
+		return leftmost(n.Array)
+	case *FieldExpr:
+		return leftmost(n.Object)
+	}
+	return expression
+}
+
+// expression prints expression, in parentheses when it binds less tightly
+// than min.
+func (p *printer) expression(expression Expression, min int) {
+	if precedence(expression) < min {
+		p.write("(")
+		p.expression(expression, precLowest)
+		p.write(")")
+		return
+	}
+
+	switch n := expression.(type) {
+	case *IntLiteral:
+		p.write(strconv.Itoa(n.Value))
+	case *FloatLiteral:
+		p.write(floatLiteral(n.Value))
+	case *BoolLiteral:
+		p.write(strconv.FormatBool(n.Value))
+	case *StringLiteral:
+		if n.Raw != "" {
+			p.write(n.Raw)
+		} else {
+			p.write(lexer.Quote(n.Value))
+		}
+	case *NullLiteral:
+		p.write("null")
+	case *Identifier:
+		p.write(n.Name)
+	case *ArrayLiteral:
+		p.write("[")
+		for i, element := range n.Elements {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(element, precLowest)
+		}
+		p.write("]")
+	case *MapLiteral:
+		if len(n.Entries) == 0 {
+			p.write("{}")
+			return
+		}
+		p.write("{ ")
+		for i, entry := range n.Entries {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(entry.Key, precLowest)
+			p.write(": ")
+			p.expression(entry.Value, precLowest)
+		}
+		p.write(" }")
+	case *StructLiteral:
+		if len(n.Fields) == 0 {
+			p.write(n.Name + " {}")
+			return
+		}
+		p.write(n.Name + " { ")
+		for i, field := range n.Fields {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(field.Name + ": ")
+			p.expression(field.Value, precLowest)
+		}
+		p.write(" }")
+	case *BinaryExpr:
+		prec := binaryPrecedence(n.Operator)
+		left, right := prec, prec+1
+		if n.Operator == lexer.OperatorNullCoalesce {
+			// `??` groups to the right
+			left, right = prec+1, prec
+		}
+		p.expression(n.Left, left)
+		p.write(" " + n.Operator.String() + " ")
+		p.expression(n.Right, right)
+	case *UnaryExpr:
+		p.write(n.Operator.String())
+		// `- -x` would lex as a decrement without the space
+		if operand, ok := n.Operand.(*UnaryExpr); ok && operand.Operator == lexer.OperatorMinus && n.Operator == lexer.OperatorMinus {
+			p.write(" ")
+		}
+		p.expression(n.Operand, precUnary)
+	case *ConditionalExpr:
+		p.expression(n.Condition, precCoalesce)
+		p.write(" ? ")
+		p.expression(n.Then, precLowest)
+		p.write(" : ")
+		p.expression(n.Else, precLowest)
+	case *MatchExpr:
+		p.write("match (")
+		p.expression(n.Subject, precLowest)
+		p.write(") ")
+		p.open()
+		for _, arm := range n.Arms {
+			p.flush(arm.PosAt)
+			p.separate()
+			p.write(arm.Variant)
+			if len(arm.Bindings) > 0 {
+				p.write("(" + strings.Join(arm.Bindings, ", ") + ")")
+			}
+			p.write(" => ")
+			p.expression(arm.Body, precLowest)
+			p.write(",")
+			p.line()
+		}
+		p.close(n.End)
+	case *CallExpr:
+		p.expression(n.Callee, precPostfix)
+		p.write("(")
+		for i, argument := range n.Arguments {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(argument, precLowest)
+		}
+		p.write(")")
+	case *IndexExpr:
+		p.expression(n.Array, precPostfix)
+		p.write("[")
+		p.expression(n.Index, precLowest)
+		p.write("]")
+	case *FieldExpr:
+		p.expression(n.Object, precPostfix)
+		p.write("." + n.Field)
+	case *FunctionExpr:
+		if n.ExprBody != nil {
+			p.params(n.Params)
+			p.write(" => ")
+			p.expression(n.ExprBody, precLowest)
+			return
+		}
+		p.write("function ")
+		p.params(n.Params)
+		p.write(": " + n.ReturnType.String() + " ")
+		p.body(n.Body, n.BodyEnd)
+	}
+}
+
+// floatLiteral prints value so that it lexes as a float again, `1.0` rather
+// than `1`.
+func floatLiteral(value float64) string {
+	text := strconv.FormatFloat(value, 'g', -1, 64)
+	if !strings.ContainsAny(text, ".e") {
+		text += ".0"
+	}
+	return text
+}
@@ -0,0 +1,221 @@
+package ast
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func expectFormat(t *testing.T, source string, expected string) {
+	t.Helper()
+	formatted, errors := Format(source)
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	if formatted != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", formatted, expected)
+	}
+}
+
+func TestFormat_Layout(t *testing.T) {
+	source := `export   struct P{x:int,y:int}
+enum S { A(r: int), B }
+function first<T>(xs: []T, rest: int...): T? {
+if(len(xs)==0){return null;}else{i++;}
+for (var i = 0; i < 3; i++) { x += i; }
+for (;;) { break; }
+var k = match (s) { A(r) => r, _ => 0 };
+var g = function (x: int): int { return x; };
+return xs[0];
+}
+while (true) {}
+`
+	expected := `export struct P {
+  x: int,
+  y: int,
+}
+enum S {
+  A(r: int),
+  B,
+}
+function first<T>(xs: []T, rest: int...): T? {
+  if (len(xs) == 0) {
+    return null;
+  } else {
+    i++;
+  }
+  for (var i = 0; i < 3; i++) {
+    x += i;
+  }
+  for (;;) {
+    break;
+  }
+  var k = match (s) {
+    A(r) => r,
+    _ => 0,
+  };
+  var g = function (x: int): int {
+    return x;
+  };
+  return xs[0];
+}
+while (true) {}
+`
+	expectFormat(t, source, expected)
+}
+
+func TestFormat_Parentheses(t *testing.T) {
+	source := `x = (a + b) * c;
+x = (a * b) + c;
+x = a - (b - c);
+x = (a ?? b) ?? c;
+x = -(-a);
+x = !(a && b) || c;
+x = (c ? a : b) ? 1 : 2;
+x = ((y: int) => y)(3);
+({ "a": 1 })["a"];
+x = 1.0 + 2.50 + "a\"\n";
+`
+	expected := `x = (a + b) * c;
+x = a * b + c;
+x = a - (b - c);
+x = (a ?? b) ?? c;
+x = - -a;
+x = !(a && b) || c;
+x = (c ? a : b) ? 1 : 2;
+x = ((y: int) => y)(3);
+({ "a": 1 }["a"]);
+x = 1.0 + 2.5 + "a\"\n";
+`
+	expectFormat(t, source, expected)
+}
+
+func TestFormat_KeepsComments(t *testing.T) {
+	source := `// header
+
+
+
+/// Adds one.
+function inc(x: int): int { // trailing the brace
+    /* leading
+       block */
+    return x + 1; // trailing
+
+    // end of the body
+}
+struct P {
+  // about x
+  x: int,
+}
+var xs = [1, // one
+  2];
+{ /* empty */ }
+// at the end
+`
+	expected := `// header
+
+/// Adds one.
+function inc(x: int): int { // trailing the brace
+  /* leading
+       block */
+  return x + 1; // trailing
+
+  // end of the body
+}
+struct P {
+  // about x
+  x: int,
+}
+var xs = [1, 2]; // one
+{ /* empty */ }
+// at the end
+`
+	expectFormat(t, source, expected)
+}
+
+var positions = regexp.MustCompile(` @\d+:\d+`)
+
+/*
+*
+Test that formatting is idempotent and does not change the program
+
+- the examples and a source with unusual layout are formatted twice
+- the second pass changes nothing
+- the syntax tree of the output is the one of the input, positions aside
+*/
+func TestFormat_IsIdempotent(t *testing.T) {
+	sources := map[string]string{
+		"layout": `var a=[1,/* c */2];/* d */ // e
+if (a[0]>1) { } else { // f
+}
+{ { } }
+x = -(-1) - - 1 ?? (b ?? c);`,
+		"unicode": `println("héllo 🐟"+"\u{e9}"); // café`,
+		"inline":  `/* a */ /* b */ x = 1; /* c */ y = 2; { /* d */ } while (x) {/* e */ /* f */}`,
+	}
+	paths, err := filepath.Glob("../../example/*.wmofn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[path] = string(source)
+	}
+
+	for name, source := range sources {
+		once, errors := Format(source)
+		if len(errors) > 0 {
+			t.Fatalf("%s: unexpected errors: %v", name, errors)
+		}
+		twice, _ := Format(once)
+		if twice != once {
+			t.Errorf("%s: formatting twice differs from formatting once:\n%s\nthen:\n%s", name, once, twice)
+		}
+		before, _ := parseSource(t, source)
+		after, _ := parseSource(t, once)
+		if positions.ReplaceAllString(Dump(before), "") != positions.ReplaceAllString(Dump(after), "") {
+			t.Errorf("%s: formatting changed the program:\n%s", name, once)
+		}
+	}
+}
+
+func TestFormat_KeepsNonASCIIStrings(t *testing.T) {
+	expectFormat(t, `var s="héllo 🐟";// café`, "var s = \"héllo 🐟\"; // café\n")
+	expectFormat(t, "var s = \"héllo 🐟\"; // café\n", "var s = \"héllo 🐟\"; // café\n")
+}
+
+func TestFormat_KeepsStringsAsWritten(t *testing.T) {
+	expectFormat(t, `var s="\u{48}i\t"+"\u{1F41F}";`, `var s = "\u{48}i\t" + "\u{1F41F}";`+"\n")
+}
+
+func TestFormat_KeepsInlineComments(t *testing.T) {
+	source := `var a = 1;   // one
+/* why */ return;
+while (x) {/* later */}
+struct P { /* none yet */ }
+var b = 2; /* two */ var c = 3;
+`
+	expected := `var a = 1; // one
+/* why */ return;
+while (x) { /* later */ }
+struct P { /* none yet */ }
+var b = 2; /* two */
+var c = 3;
+`
+	expectFormat(t, source, expected)
+}
+
+func TestFormat_ReturnsSourceOnErrors(t *testing.T) {
+	source := "var = 1;"
+	formatted, errors := Format(source)
+	if len(errors) == 0 {
+		t.Fatal("expected a parse error")
+	}
+	if formatted != source {
+		t.Errorf("expected the source back, got %q", formatted)
+	}
+}
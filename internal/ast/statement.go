@@ -2,6 +2,7 @@ package ast
 
 import (
 	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+	"youpiteron.dev/white-monster-on-friday-night/internal/lexer"
 )
 
 type Statement interface {
@@ -15,6 +16,8 @@ type Declaration struct {
 	TypeOf     *Type
 	Identifier *Identifier
 	Value      Expression
+	Exported   bool
+	Doc        string
 	PosAt      *common.SourcePos
 }
 
@@ -24,10 +27,17 @@ func (d *Declaration) Visit(v Visitor[any]) any {
 	return v.VisitDeclaration(d)
 }
 
+// Assignment stores Value into Target, which is an Identifier or an
+// IndexExpr. A compound assignment such as `x += 1` is Compound, and Operator
+// is the binary operator that combines the current value of Target with Value.
+// `i++` and `i--` are parsed as `i = i + 1` and `i = i - 1` and are IncDec.
 type Assignment struct {
-	Identifier *Identifier
-	Value      Expression
-	PosAt      *common.SourcePos
+	Target   Expression
+	Value    Expression
+	Compound bool
+	Operator lexer.OperatorSubkind
+	IncDec   bool
+	PosAt    *common.SourcePos
 }
 
 func (a *Assignment) Pos() *common.SourcePos { return a.PosAt }
@@ -36,9 +46,13 @@ func (a *Assignment) Visit(v Visitor[any]) any {
 	return v.VisitAssignment(a)
 }
 
+// Block is a `{ }` scope. End is the position of its closing '}', as for
+// the BodyEnd of the statements below, the formatter keeps the comments in
+// front of it inside the block.
 type Block struct {
 	Statements []Statement
 	PosAt      *common.SourcePos
+	End        *common.SourcePos
 }
 
 func (b *Block) Pos() *common.SourcePos { return b.PosAt }
@@ -58,11 +72,15 @@ func (r *Return) Visit(v Visitor[any]) any {
 	return v.VisitReturn(r)
 }
 
+// If is `if (cond) { ... } else { ... }`, ElseEnd is nil when there is no
+// else branch.
 type If struct {
 	Condition Expression
 	Body      []Statement
 	ElseBody  []Statement
 	PosAt     *common.SourcePos
+	BodyEnd   *common.SourcePos
+	ElseEnd   *common.SourcePos
 }
 
 func (i *If) Pos() *common.SourcePos { return i.PosAt }
@@ -70,3 +88,65 @@ func (i *If) statementNode()         {}
 func (i *If) Visit(v Visitor[any]) any {
 	return v.VisitIf(i)
 }
+
+type While struct {
+	Condition Expression
+	Body      []Statement
+	PosAt     *common.SourcePos
+	BodyEnd   *common.SourcePos
+}
+
+func (w *While) Pos() *common.SourcePos { return w.PosAt }
+func (w *While) statementNode()         {}
+func (w *While) Visit(v Visitor[any]) any {
+	return v.VisitWhile(w)
+}
+
+type For struct {
+	Init      Statement
+	Condition Expression
+	Step      Statement
+	Body      []Statement
+	PosAt     *common.SourcePos
+	BodyEnd   *common.SourcePos
+}
+
+func (f *For) Pos() *common.SourcePos { return f.PosAt }
+func (f *For) statementNode()         {}
+func (f *For) Visit(v Visitor[any]) any {
+	return v.VisitFor(f)
+}
+
+type Break struct {
+	PosAt *common.SourcePos
+}
+
+func (b *Break) Pos() *common.SourcePos { return b.PosAt }
+func (b *Break) statementNode()         {}
+func (b *Break) Visit(v Visitor[any]) any {
+	return v.VisitBreak(b)
+}
+
+type Continue struct {
+	PosAt *common.SourcePos
+}
+
+func (c *Continue) Pos() *common.SourcePos { return c.PosAt }
+func (c *Continue) statementNode()         {}
+func (c *Continue) Visit(v Visitor[any]) any {
+	return v.VisitContinue(c)
+}
+
+// Import makes the declarations another file exports visible in this one,
+// `import "lib/math.wmofn";`. Path is resolved against the importing file's
+// directory first, then against the module search path.
+type Import struct {
+	Path  string
+	PosAt *common.SourcePos
+}
+
+func (i *Import) Pos() *common.SourcePos { return i.PosAt }
+func (i *Import) statementNode()         {}
+func (i *Import) Visit(v Visitor[any]) any {
+	return v.VisitImport(i)
+}
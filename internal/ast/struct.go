@@ -0,0 +1,28 @@
+package ast
+
+import (
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+)
+
+type StructField struct {
+	Name   string
+	TypeOf *Type
+	PosAt  *common.SourcePos
+}
+
+// Struct declares a nominal record type, `struct Point { x: int, y: int }`.
+// The fields keep their declaration order, which is their index at runtime.
+type Struct struct {
+	Name     string
+	Fields   []StructField
+	Exported bool
+	Doc      string
+	PosAt    *common.SourcePos
+	End      *common.SourcePos
+}
+
+func (s *Struct) Pos() *common.SourcePos { return s.PosAt }
+func (s *Struct) statementNode()         {}
+func (s *Struct) Visit(v Visitor[any]) any {
+	return v.VisitStruct(s)
+}
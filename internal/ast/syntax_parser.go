@@ -2,6 +2,8 @@ package ast
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"youpiteron.dev/white-monster-on-friday-night/internal/common"
 	"youpiteron.dev/white-monster-on-friday-night/internal/lexer"
@@ -37,7 +39,7 @@ func (p *Parser) eat() *lexer.Token {
 func (p *Parser) eatExpected(kind lexer.TokenKind, subkind any, msg string) *lexer.Token {
 	t := p.peek(0)
 	if t == nil {
-		p.addError(msg, nil)
+		p.addError("unexpected end of input, "+msg, nil)
 		return nil
 	}
 	if t.Kind == kind && (subkind == nil || t.Subkind == subkind) {
@@ -50,6 +52,49 @@ func (p *Parser) eatExpected(kind lexer.TokenKind, subkind any, msg string) *lex
 	return nil
 }
 
+// parserState is a snapshot of the parser position used to backtrack after
+// looking ahead.
+type parserState struct {
+	idx    int
+	errors int
+}
+
+func (p *Parser) mark() parserState {
+	return parserState{idx: p.idx, errors: len(p.Errors)}
+}
+
+func (p *Parser) rewind(state parserState) {
+	p.idx = state.idx
+	p.Errors = p.Errors[:state.errors]
+}
+
+func (p *Parser) peekOperator(subkind lexer.OperatorSubkind) bool {
+	t := p.peek(0)
+	return t != nil && t.Kind == lexer.Operator && t.Subkind == subkind
+}
+
+// asStatement and asExpression keep a failed parse (a nil node pointer) from
+// turning into a non-nil interface value.
+func asStatement[T any, P interface {
+	*T
+	Statement
+}](node P) Statement {
+	if node == nil {
+		return nil
+	}
+	return node
+}
+
+func asExpression[T any, P interface {
+	*T
+	Expression
+}](node P) Expression {
+	if node == nil {
+		return nil
+	}
+	return node
+}
+
 func (p *Parser) addError(msg string, pos *common.SourcePos) {
 	p.Errors = append(p.Errors, common.Error{
 		Message: msg,
@@ -63,14 +108,104 @@ func (p *Parser) ParseProgram() *Program {
 	if t == nil {
 		return &Program{Statements: statements, PosAt: nil}
 	}
+	for p.peek(0) != nil {
+		if stray := p.peek(0); stray.Kind == lexer.Punctuator && stray.Subkind == lexer.BlockEnd {
+			p.addError("unexpected '}'", stray.Pos)
+			p.eat()
+			continue
+		}
+		statement := p.parseStatementOrSync()
+		if statement != nil {
+			statements = append(statements, statement)
+		}
+	}
+	return &Program{Statements: statements, PosAt: t.Pos}
+}
+
+// parseStatementsUntilBlockEnd parses statements up to the closing '}' of the
+// current block without consuming it.
+func (p *Parser) parseStatementsUntilBlockEnd() []Statement {
+	statements := []Statement{}
 	for {
-		statement := p.ParseStatement()
-		if statement == nil {
+		t := p.peek(0)
+		if t == nil {
 			break
 		}
-		statements = append(statements, statement)
+		if t.Kind == lexer.Punctuator && t.Subkind == lexer.BlockEnd {
+			break
+		}
+		statement := p.parseStatementOrSync()
+		if statement != nil {
+			statements = append(statements, statement)
+		}
 	}
-	return &Program{Statements: statements, PosAt: t.Pos}
+	return statements
+}
+
+// parseStatementOrSync parses a statement and, when it fails, skips to the
+// start of the next one so the following statements are still parsed and
+// their errors reported.
+func (p *Parser) parseStatementOrSync() Statement {
+	start := p.mark()
+	statement := p.ParseStatement()
+	if statement != nil {
+		return statement
+	}
+
+	if len(p.Errors) == start.errors {
+		if t := p.peek(0); t != nil {
+			p.addError(fmt.Sprintf("unexpected %v(%v)", t.Kind, t.Subkind), t.Pos)
+		} else {
+			p.addError("unexpected end of input", nil)
+		}
+	}
+	if p.idx == start.idx {
+		p.eat()
+	}
+	p.synchronize()
+	return nil
+}
+
+// synchronize skips tokens until the end of the broken statement: past the
+// next ';', or up to a '}' closing the enclosing block or a keyword starting
+// a new statement. Nested blocks are skipped as a whole.
+func (p *Parser) synchronize() {
+	depth := 0
+	for {
+		t := p.peek(0)
+		if t == nil {
+			return
+		}
+		if t.Kind == lexer.Punctuator && t.Subkind == lexer.BlockStart {
+			depth++
+		}
+		if t.Kind == lexer.Punctuator && t.Subkind == lexer.BlockEnd {
+			if depth == 0 {
+				return
+			}
+			depth--
+		}
+		if depth == 0 && isStatementKeyword(t) {
+			return
+		}
+		p.eat()
+		if depth == 0 && t.Kind == lexer.Punctuator && (t.Subkind == lexer.StatementEnd || t.Subkind == lexer.BlockEnd) {
+			return
+		}
+	}
+}
+
+func isStatementKeyword(t *lexer.Token) bool {
+	if t.Kind != lexer.Keyword {
+		return false
+	}
+	switch t.Subkind {
+	case lexer.KeywordVar, lexer.KeywordConst, lexer.KeywordFunction, lexer.KeywordReturn,
+		lexer.KeywordIf, lexer.KeywordWhile, lexer.KeywordFor, lexer.KeywordBreak, lexer.KeywordContinue, lexer.KeywordStruct, lexer.KeywordEnum,
+		lexer.KeywordImport, lexer.KeywordExport:
+		return true
+	}
+	return false
 }
 
 func (p *Parser) ParseStatement() Statement {
@@ -82,10 +217,11 @@ func (p *Parser) ParseStatement() Statement {
 	next := p.peek(1)
 
 	if t.Kind == lexer.Punctuator && t.Subkind == lexer.BlockStart {
-		return p.ParseBlock()
+		return asStatement(p.ParseBlock())
 	}
 
-	if t.Kind == lexer.Keyword && t.Subkind == lexer.KeywordFunction {
+	// `function (` starts an anonymous function used as an expression statement
+	if t.Kind == lexer.Keyword && t.Subkind == lexer.KeywordFunction && !(next != nil && next.Kind == lexer.Punctuator && next.Subkind == lexer.ParenOpen) {
 		return p.ParseFunction()
 	}
 
@@ -93,21 +229,60 @@ func (p *Parser) ParseStatement() Statement {
 		return p.ParseReturn()
 	}
 
+	if t.Kind == lexer.Keyword && t.Subkind == lexer.KeywordStruct {
+		return asStatement(p.ParseStruct())
+	}
+
+	if t.Kind == lexer.Keyword && t.Subkind == lexer.KeywordEnum {
+		return asStatement(p.ParseEnum())
+	}
+
 	if t.Kind == lexer.Keyword && (t.Subkind == lexer.KeywordVar || t.Subkind == lexer.KeywordConst) {
-		return p.ParseDeclaration()
+		return asStatement(p.ParseDeclaration())
+	}
+
+	if t.Kind == lexer.Keyword && t.Subkind == lexer.KeywordImport {
+		return asStatement(p.ParseImport())
+	}
+
+	if t.Kind == lexer.Keyword && t.Subkind == lexer.KeywordExport {
+		return p.ParseExport()
+	}
+
+	if p.isAssignmentStart() {
+		return asStatement(p.ParseAssignment())
 	}
 
-	if t.Kind == lexer.Identifier && t.Subkind == lexer.IdentifierName && next != nil && next.Kind == lexer.Punctuator && next.Subkind == lexer.Assign {
-		return p.ParseAssignment()
+	if isIncDecStart(t, next) {
+		return asStatement(p.ParseIncDec())
 	}
 
 	if t.Kind == lexer.Keyword && t.Subkind == lexer.KeywordIf {
-		return p.ParseIf()
+		return asStatement(p.ParseIf())
+	}
+
+	if t.Kind == lexer.Keyword && t.Subkind == lexer.KeywordWhile {
+		return asStatement(p.ParseWhile())
+	}
+
+	if t.Kind == lexer.Keyword && t.Subkind == lexer.KeywordFor {
+		return asStatement(p.ParseFor())
+	}
+
+	if t.Kind == lexer.Keyword && t.Subkind == lexer.KeywordBreak {
+		return asStatement(p.ParseBreak())
+	}
+
+	if t.Kind == lexer.Keyword && t.Subkind == lexer.KeywordContinue {
+		return asStatement(p.ParseContinue())
 	}
 
+	errorsBefore := len(p.Errors)
 	expression := p.ParseExpression(true)
 	if expression == nil {
-		p.addError(fmt.Sprintf("expected statement but got %v(%v)", t.Kind, t.Subkind), t.Pos)
+		if len(p.Errors) == errorsBefore {
+			p.addError(fmt.Sprintf("expected statement but got %v(%v)", t.Kind, t.Subkind), t.Pos)
+		}
 		return nil
 	}
 	semicolon := p.eatExpected(lexer.Punctuator, lexer.StatementEnd, "expected ';'")
@@ -118,7 +293,22 @@ func (p *Parser) ParseStatement() Statement {
 	return expression
 }
 
+// ParseType parses a type annotation, followed by any number of `?` that make
+// it nullable. The element type of `[]T` is parsed recursively, so `[][]int`
+// and `[](int) -> bool` are arrays of arrays and of functions.
 func (p *Parser) ParseType() *Type {
+	typeOf := p.parseBaseType()
+	if typeOf == nil {
+		return nil
+	}
+	for p.peekOperator(lexer.OperatorQuestion) {
+		p.eat()
+		typeOf = TypeNullableOf(typeOf)
+	}
+	return typeOf
+}
+
+func (p *Parser) parseBaseType() *Type {
 	tok := p.peek(0)
 	if tok == nil {
 		return nil
@@ -129,289 +319,1016 @@ func (p *Parser) ParseType() *Type {
 		if arrClose == nil {
 			return nil
 		}
-		elementType := p.eatExpected(lexer.Type, nil, "expected type")
+		elementType := p.ParseType()
 		if elementType == nil {
 			return nil
 		}
-		return TypeArrayOf(TypeFromTypeSubkind(elementType.Subkind.(lexer.TypeSubkind)))
+		return TypeArrayOf(elementType)
 	} else if tok.Kind == lexer.Type {
 		p.eat()
 		return TypeFromTypeSubkind(tok.Subkind.(lexer.TypeSubkind))
+	} else if tok.Kind == lexer.Constant && tok.Subkind == lexer.Null {
+		// `null` always lexes as a constant, in a type position it names the null type
+		p.eat()
+		return TypeNull()
+	} else if tok.Kind == lexer.Punctuator && tok.Subkind == lexer.ParenOpen {
+		return p.ParseFunctionType()
+	} else if tok.Kind == lexer.Identifier && tok.Lexeme == "map" {
+		return p.ParseMapType()
+	} else if tok.Kind == lexer.Identifier {
+		p.eat()
+		return TypeNamed(tok.Lexeme)
 	}
 	p.addError(fmt.Sprintf("expected type but got %v(%v)", tok.Kind, tok.Subkind), tok.Pos)
 	return nil
 }
 
-func (p *Parser) ParseFunction() Statement {
-	kw := p.eatExpected(lexer.Keyword, lexer.KeywordFunction, "expected 'function'")
-	if kw == nil {
+// ParseMapType parses `map[string]int`. `map` is not a keyword, it only names
+// the map type in a type position.
+func (p *Parser) ParseMapType() *Type {
+	p.eat()
+	lbracket := p.eatExpected(lexer.Punctuator, lexer.BracketOpen, "expected '['")
+	if lbracket == nil {
 		return nil
 	}
-
-	idTok := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected identifier")
-	if idTok == nil {
+	keyPos := p.peek(0)
+	keyType := p.ParseType()
+	if keyType == nil {
+		return nil
+	}
+	if !keyType.IsHashable() {
+		p.addError(fmt.Sprintf("map keys must be of type int, bool or string, but got %s", keyType), keyPos.Pos)
+		return nil
+	}
+	rbracket := p.eatExpected(lexer.Punctuator, lexer.BracketClose, "expected ']'")
+	if rbracket == nil {
+		return nil
+	}
+	valueType := p.ParseType()
+	if valueType == nil {
 		return nil
 	}
+	return TypeMapOf(keyType, valueType)
+}
 
+// ParseFunctionType parses `(int, bool) -> int`. The return type is parsed
+// with ParseType, so `(int) -> (int) -> int` is a function returning a function.
+func (p *Parser) ParseFunctionType() *Type {
 	lparen := p.eatExpected(lexer.Punctuator, lexer.ParenOpen, "expected '('")
 	if lparen == nil {
 		return nil
 	}
 
-	params := []Param{}
-	vararg := false
-	for {
-		param := p.ParseParam()
-		if param == nil {
-			break
-		}
-		t := p.peek(0)
-		if t == nil {
-			break
-		}
-		if t.Kind == lexer.Operator && t.Subkind == lexer.OperatorRest {
-			vararg = true
-			param.Vararg = true
-			p.eat()
-			params = append(params, *param)
-			break
-		} else {
-			params = append(params, *param)
-		}
-
-		if !(t.Kind == lexer.Punctuator && t.Subkind == lexer.Comma) {
+	params := []*Type{}
+	if t := p.peek(0); t != nil && !(t.Kind == lexer.Punctuator && t.Subkind == lexer.ParenClose) {
+		for {
+			param := p.ParseType()
+			if param == nil {
+				return nil
+			}
+			params = append(params, param)
+			t := p.peek(0)
+			if t != nil && t.Kind == lexer.Punctuator && t.Subkind == lexer.Comma {
+				p.eat()
+				continue
+			}
 			break
 		}
-		p.eat()
 	}
+
 	rparen := p.eatExpected(lexer.Punctuator, lexer.ParenClose, "expected ')'")
 	if rparen == nil {
 		return nil
 	}
-	colon := p.eatExpected(lexer.Punctuator, lexer.Colon, "expected ':'")
-	if colon == nil {
+	arrow := p.eatExpected(lexer.Operator, lexer.OperatorArrow, "expected '->'")
+	if arrow == nil {
 		return nil
 	}
 	returnType := p.ParseType()
 	if returnType == nil {
 		return nil
 	}
-	body := p.ParseBody()
-
-	return &Function{Name: idTok.Lexeme, Params: params, Vararg: vararg, Body: body, ReturnType: returnType, PosAt: kw.Pos}
+	return TypeFunction(&Signature{Params: params, ReturnType: returnType, Vararg: false})
 }
 
-func (p *Parser) ParseParam() *Param {
-	idTok := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected identifier")
-	if idTok == nil {
-		return nil
-	}
-	colon := p.eatExpected(lexer.Punctuator, lexer.Colon, "expected ':'")
-	if colon == nil {
+// ParseStruct parses `struct Point { x: int, y: int }`.
+func (p *Parser) ParseStruct() *Struct {
+	kw := p.eatExpected(lexer.Keyword, lexer.KeywordStruct, "expected 'struct'")
+	if kw == nil {
 		return nil
 	}
-	typeOf := p.ParseType()
-	if typeOf == nil {
+	name := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected struct name")
+	if name == nil {
 		return nil
 	}
-	return &Param{Name: idTok.Lexeme, TypeOf: typeOf, PosAt: idTok.Pos, Vararg: false}
-}
-
-func (p *Parser) ParseBody() []Statement {
 	lbrace := p.eatExpected(lexer.Punctuator, lexer.BlockStart, "expected '{'")
 	if lbrace == nil {
 		return nil
 	}
-	statements := []Statement{}
+	fields := []StructField{}
 	for {
 		t := p.peek(0)
 		if t == nil {
-			break
+			return nil
 		}
 		if t.Kind == lexer.Punctuator && t.Subkind == lexer.BlockEnd {
 			break
 		}
-		statement := p.ParseStatement()
-		if statement == nil {
+		field := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected field name")
+		if field == nil {
+			return nil
+		}
+		colon := p.eatExpected(lexer.Punctuator, lexer.Colon, "expected ':'")
+		if colon == nil {
+			return nil
+		}
+		typeOf := p.ParseType()
+		if typeOf == nil {
+			return nil
+		}
+		fields = append(fields, StructField{Name: field.Lexeme, TypeOf: typeOf, PosAt: field.Pos})
+		commaTok := p.peek(0)
+		if commaTok == nil || !(commaTok.Kind == lexer.Punctuator && commaTok.Subkind == lexer.Comma) {
 			break
 		}
-		statements = append(statements, statement)
+		p.eat()
 	}
 	rbrace := p.eatExpected(lexer.Punctuator, lexer.BlockEnd, "expected '}'")
 	if rbrace == nil {
 		return nil
 	}
-	return statements
+	return &Struct{Name: name.Lexeme, Fields: fields, Doc: docComment(kw), PosAt: kw.Pos, End: rbrace.Pos}
 }
 
-func (p *Parser) ParseBlock() *Block {
+func (p *Parser) ParseEnum() *Enum {
+	kw := p.eatExpected(lexer.Keyword, lexer.KeywordEnum, "expected 'enum'")
+	if kw == nil {
+		return nil
+	}
+	name := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected enum name")
+	if name == nil {
+		return nil
+	}
 	lbrace := p.eatExpected(lexer.Punctuator, lexer.BlockStart, "expected '{'")
 	if lbrace == nil {
 		return nil
 	}
-	statements := []Statement{}
+	variants := []EnumVariant{}
 	for {
 		t := p.peek(0)
 		if t == nil {
-			break
+			return nil
 		}
 		if t.Kind == lexer.Punctuator && t.Subkind == lexer.BlockEnd {
 			break
 		}
-		statement := p.ParseStatement()
-		if statement == nil {
+		variant := p.ParseEnumVariant()
+		if variant == nil {
+			return nil
+		}
+		variants = append(variants, *variant)
+		commaTok := p.peek(0)
+		if commaTok == nil || !(commaTok.Kind == lexer.Punctuator && commaTok.Subkind == lexer.Comma) {
 			break
 		}
-		statements = append(statements, statement)
+		p.eat()
 	}
 	rbrace := p.eatExpected(lexer.Punctuator, lexer.BlockEnd, "expected '}'")
 	if rbrace == nil {
 		return nil
 	}
-	return &Block{Statements: statements, PosAt: lbrace.Pos}
+	return &Enum{Name: name.Lexeme, Variants: variants, Doc: docComment(kw), PosAt: kw.Pos, End: rbrace.Pos}
 }
 
-func (p *Parser) ParseReturn() Statement {
-	kw := p.eatExpected(lexer.Keyword, lexer.KeywordReturn, "expected 'return'")
-	if kw == nil {
+// ParseEnumVariant parses `Name` or `Name(field: type, ...)`.
+func (p *Parser) ParseEnumVariant() *EnumVariant {
+	name := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected variant name")
+	if name == nil {
 		return nil
 	}
-
-	value := p.ParseExpression(false)
-
-	semicolon := p.eatExpected(lexer.Punctuator, lexer.StatementEnd, "expected ';'")
-	if semicolon == nil {
-		return nil
+	fields := []StructField{}
+	t := p.peek(0)
+	if t == nil || !(t.Kind == lexer.Punctuator && t.Subkind == lexer.ParenOpen) {
+		return &EnumVariant{Name: name.Lexeme, Fields: fields, PosAt: name.Pos}
 	}
-
-	return &Return{
-		Value: value,
-		PosAt: kw.Pos,
+	p.eat()
+	for {
+		t := p.peek(0)
+		if t == nil {
+			return nil
+		}
+		if t.Kind == lexer.Punctuator && t.Subkind == lexer.ParenClose {
+			break
+		}
+		field := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected field name")
+		if field == nil {
+			return nil
+		}
+		colon := p.eatExpected(lexer.Punctuator, lexer.Colon, "expected ':'")
+		if colon == nil {
+			return nil
+		}
+		typeOf := p.ParseType()
+		if typeOf == nil {
+			return nil
+		}
+		fields = append(fields, StructField{Name: field.Lexeme, TypeOf: typeOf, PosAt: field.Pos})
+		commaTok := p.peek(0)
+		if commaTok == nil || !(commaTok.Kind == lexer.Punctuator && commaTok.Subkind == lexer.Comma) {
+			break
+		}
+		p.eat()
 	}
-}
-
-func (p *Parser) ParseDeclaration() *Declaration {
-	kw := p.eatExpected(lexer.Keyword, nil, "expected 'var' or 'const'")
-	if kw == nil {
+	rparen := p.eatExpected(lexer.Punctuator, lexer.ParenClose, "expected ')'")
+	if rparen == nil {
 		return nil
 	}
+	return &EnumVariant{Name: name.Lexeme, Fields: fields, PosAt: name.Pos}
+}
 
-	specifier := kw.Subkind.(lexer.KeywordSubkind)
-	if specifier != lexer.KeywordVar && specifier != lexer.KeywordConst {
-		p.addError(fmt.Sprintf("expected 'var' or 'const' but got %v(%v)", kw.Kind, kw.Subkind), kw.Pos)
+func (p *Parser) ParseFunction() Statement {
+	kw := p.eatExpected(lexer.Keyword, lexer.KeywordFunction, "expected 'function'")
+	if kw == nil {
 		return nil
 	}
-	isMutable := specifier == lexer.KeywordVar
 
 	idTok := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected identifier")
 	if idTok == nil {
 		return nil
 	}
 
-	isTyped := false
-	var typeOf *Type
-	colonTok := p.peek(0)
-	if colonTok != nil && colonTok.Kind == lexer.Punctuator && colonTok.Subkind == lexer.Colon {
-		isTyped = true
-		p.eat()
-		typeOf = p.ParseType()
-		if typeOf == nil {
+	typeParams := []string{}
+	if p.peekOperator(lexer.OperatorLess) {
+		parsed, ok := p.parseTypeParams()
+		if !ok {
 			return nil
 		}
+		typeParams = parsed
 	}
 
-	eqTok := p.peek(0)
-	var value Expression = nil
-	if eqTok != nil && eqTok.Kind == lexer.Punctuator && eqTok.Subkind == lexer.Assign {
-		p.eat()
-		value = p.ParseExpression(false)
-		if value == nil {
-			return nil
-		}
+	params, vararg, ok := p.parseParams()
+	if !ok {
+		return nil
 	}
-
-	semicolon := p.eatExpected(lexer.Punctuator, lexer.StatementEnd, "expected ';'")
-	if semicolon == nil {
+	colon := p.eatExpected(lexer.Punctuator, lexer.Colon, "expected ':'")
+	if colon == nil {
 		return nil
 	}
-
-	return &Declaration{
-		IsMutable:  isMutable,
-		IsTyped:    isTyped,
-		TypeOf:     typeOf,
-		Identifier: &Identifier{Name: idTok.Lexeme, PosAt: idTok.Pos},
-		Value:      value,
-		PosAt:      kw.Pos,
+	returnType := p.ParseType()
+	if returnType == nil {
+		return nil
 	}
+	body, end := p.parseBody()
+
+	return &Function{Name: idTok.Lexeme, TypeParams: typeParams, Params: params, Vararg: vararg, Body: body, ReturnType: returnType, Doc: docComment(kw), PosAt: kw.Pos, BodyEnd: end}
 }
 
-func (p *Parser) ParseAssignment() *Assignment {
-	idTok := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected identifier")
-	if idTok == nil {
+// parseTypeParams parses the `<T, U>` after the name of a generic function.
+func (p *Parser) parseTypeParams() ([]string, bool) {
+	p.eat()
+	typeParams := []string{}
+	for {
+		name := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected type parameter name")
+		if name == nil {
+			return nil, false
+		}
+		typeParams = append(typeParams, name.Lexeme)
+		if p.peekOperator(lexer.OperatorGreater) {
+			p.eat()
+			return typeParams, true
+		}
+		if p.eatExpected(lexer.Punctuator, lexer.Comma, "expected ',' or '>'") == nil {
+			return nil, false
+		}
+	}
+}
+
+// ParseFunctionExpr parses an anonymous function, either
+// `function (x: int): int { ... }` or the arrow form `(x: int) => x * 2`.
+func (p *Parser) ParseFunctionExpr(isStatement bool) *FunctionExpr {
+	start := p.peek(0)
+	if start == nil {
+		return nil
+	}
+
+	if start.Kind == lexer.Keyword && start.Subkind == lexer.KeywordFunction {
+		p.eat()
+		params, vararg, ok := p.parseParams()
+		if !ok {
+			return nil
+		}
+		colon := p.eatExpected(lexer.Punctuator, lexer.Colon, "expected ':'")
+		if colon == nil {
+			return nil
+		}
+		returnType := p.ParseType()
+		if returnType == nil {
+			return nil
+		}
+		body, end := p.parseBody()
+
+		return &FunctionExpr{Params: params, Vararg: vararg, Body: body, ReturnType: returnType, PosAt: start.Pos, BodyEnd: end, IsStatement: isStatement}
+	}
+
+	params, vararg, ok := p.parseParams()
+	if !ok {
+		return nil
+	}
+	arrow := p.eatExpected(lexer.Operator, lexer.OperatorFatArrow, "expected '=>'")
+	if arrow == nil {
+		return nil
+	}
+	body := p.ParseExpression(false)
+	if body == nil {
+		return nil
+	}
+
+	return &FunctionExpr{Params: params, Vararg: vararg, ExprBody: body, PosAt: start.Pos, IsStatement: isStatement}
+}
+
+// parseParams parses a parenthesized parameter list, the last param may be a
+// vararg `name: type...`.
+func (p *Parser) parseParams() ([]Param, bool, bool) {
+	lparen := p.eatExpected(lexer.Punctuator, lexer.ParenOpen, "expected '('")
+	if lparen == nil {
+		return nil, false, false
+	}
+
+	params := []Param{}
+	vararg := false
+	closeTok := p.peek(0)
+	hasParams := closeTok != nil && !(closeTok.Kind == lexer.Punctuator && closeTok.Subkind == lexer.ParenClose)
+	for hasParams {
+		param := p.ParseParam()
+		if param == nil {
+			break
+		}
+		t := p.peek(0)
+		if t == nil {
+			break
+		}
+		if t.Kind == lexer.Operator && t.Subkind == lexer.OperatorRest {
+			vararg = true
+			param.Vararg = true
+			p.eat()
+			params = append(params, *param)
+			break
+		} else {
+			params = append(params, *param)
+		}
+
+		if !(t.Kind == lexer.Punctuator && t.Subkind == lexer.Comma) {
+			break
+		}
+		p.eat()
+	}
+	rparen := p.eatExpected(lexer.Punctuator, lexer.ParenClose, "expected ')'")
+	if rparen == nil {
+		return nil, false, false
+	}
+	return params, vararg, true
+}
+
+// isArrowFunctionStart reports whether the '(' at the cursor opens the params
+// of an arrow function rather than a parenthesized expression: either `()`
+// followed by '=>' or an identifier followed by ':'.
+func (p *Parser) isArrowFunctionStart() bool {
+	t := p.peek(0)
+	if t == nil || !(t.Kind == lexer.Punctuator && t.Subkind == lexer.ParenOpen) {
+		return false
+	}
+	next := p.peek(1)
+	if next == nil {
+		return false
+	}
+	if next.Kind == lexer.Punctuator && next.Subkind == lexer.ParenClose {
+		arrow := p.peek(2)
+		return arrow != nil && arrow.Kind == lexer.Operator && arrow.Subkind == lexer.OperatorFatArrow
+	}
+	colon := p.peek(2)
+	return next.Kind == lexer.Identifier && colon != nil && colon.Kind == lexer.Punctuator && colon.Subkind == lexer.Colon
+}
+
+func (p *Parser) ParseParam() *Param {
+	idTok := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected identifier")
+	if idTok == nil {
+		return nil
+	}
+	colon := p.eatExpected(lexer.Punctuator, lexer.Colon, "expected ':'")
+	if colon == nil {
+		return nil
+	}
+	typeOf := p.ParseType()
+	if typeOf == nil {
+		return nil
+	}
+	return &Param{Name: idTok.Lexeme, TypeOf: typeOf, PosAt: idTok.Pos, Vararg: false}
+}
+
+func (p *Parser) ParseBody() []Statement {
+	statements, _ := p.parseBody()
+	return statements
+}
+
+// parseBody parses a body and also returns the position of its closing '}',
+// which is nil when the '}' is missing.
+func (p *Parser) parseBody() ([]Statement, *common.SourcePos) {
+	lbrace := p.eatExpected(lexer.Punctuator, lexer.BlockStart, "expected '{'")
+	if lbrace == nil {
+		return nil, nil
+	}
+	statements := p.parseStatementsUntilBlockEnd()
+	// a missing '}' is reported, but the statements are kept for tooling
+	rbrace := p.eatExpected(lexer.Punctuator, lexer.BlockEnd, "expected '}'")
+	if rbrace == nil {
+		return statements, nil
+	}
+	return statements, rbrace.Pos
+}
+
+func (p *Parser) ParseBlock() *Block {
+	lbrace := p.eatExpected(lexer.Punctuator, lexer.BlockStart, "expected '{'")
+	if lbrace == nil {
+		return nil
+	}
+	statements := p.parseStatementsUntilBlockEnd()
+	block := &Block{Statements: statements, PosAt: lbrace.Pos}
+	if rbrace := p.eatExpected(lexer.Punctuator, lexer.BlockEnd, "expected '}'"); rbrace != nil {
+		block.End = rbrace.Pos
+	}
+	return block
+}
+
+func (p *Parser) ParseReturn() Statement {
+	kw := p.eatExpected(lexer.Keyword, lexer.KeywordReturn, "expected 'return'")
+	if kw == nil {
+		return nil
+	}
+
+	var value Expression
+	if t := p.peek(0); t == nil || !(t.Kind == lexer.Punctuator && t.Subkind == lexer.StatementEnd) {
+		value = p.ParseExpression(false)
+		if value == nil {
+			return nil
+		}
+	}
+
+	semicolon := p.eatExpected(lexer.Punctuator, lexer.StatementEnd, "expected ';'")
+	if semicolon == nil {
+		return nil
+	}
+
+	return &Return{
+		Value: value,
+		PosAt: kw.Pos,
+	}
+}
+
+func (p *Parser) ParseDeclaration() *Declaration {
+	kw := p.eatExpected(lexer.Keyword, nil, "expected 'var' or 'const'")
+	if kw == nil {
+		return nil
+	}
+
+	specifier := kw.Subkind.(lexer.KeywordSubkind)
+	if specifier != lexer.KeywordVar && specifier != lexer.KeywordConst {
+		p.addError(fmt.Sprintf("expected 'var' or 'const' but got %v(%v)", kw.Kind, kw.Subkind), kw.Pos)
+		return nil
+	}
+	isMutable := specifier == lexer.KeywordVar
+
+	idTok := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected identifier")
+	if idTok == nil {
+		return nil
+	}
+
+	isTyped := false
+	var typeOf *Type
+	colonTok := p.peek(0)
+	if colonTok != nil && colonTok.Kind == lexer.Punctuator && colonTok.Subkind == lexer.Colon {
+		isTyped = true
+		p.eat()
+		typeOf = p.ParseType()
+		if typeOf == nil {
+			return nil
+		}
+	}
+
+	eqTok := p.peek(0)
+	var value Expression = nil
+	if eqTok != nil && eqTok.Kind == lexer.Punctuator && eqTok.Subkind == lexer.Assign {
+		p.eat()
+		value = p.ParseExpression(false)
+		if value == nil {
+			return nil
+		}
+	}
+
+	semicolon := p.eatExpected(lexer.Punctuator, lexer.StatementEnd, "expected ';'")
+	if semicolon == nil {
+		return nil
+	}
+
+	return &Declaration{
+		IsMutable:  isMutable,
+		IsTyped:    isTyped,
+		TypeOf:     typeOf,
+		Identifier: &Identifier{Name: idTok.Lexeme, PosAt: idTok.Pos},
+		Value:      value,
+		Doc:        docComment(kw),
+		PosAt:      kw.Pos,
+	}
+}
+
+func (p *Parser) ParseAssignment() *Assignment {
+	assignment := p.parseAssignmentClause()
+	if assignment == nil {
+		return nil
+	}
+
+	semicolon := p.eatExpected(lexer.Punctuator, lexer.StatementEnd, "expected ';'")
+	if semicolon == nil {
+		return nil
+	}
+
+	return assignment
+}
+
+// parseAssignmentClause parses an assignment without the trailing ';', as
+// used by ParseAssignment and by the step clause of a for loop.
+func (p *Parser) parseAssignmentClause() *Assignment {
+	target := p.ParsePostfixExpr(false)
+	if target == nil {
+		return nil
+	}
+	if !isAssignable(target) {
+		p.addError("only variables, elements and fields can be assigned", target.Pos())
+		return nil
+	}
+
+	opTok := p.peek(0)
+	if !isAssignOperator(opTok) {
+		p.eatExpected(lexer.Punctuator, lexer.Assign, "expected '='")
+		return nil
+	}
+	p.eat()
+
+	value := p.ParseExpression(false)
+	if value == nil {
+		return nil
+	}
+
+	assignment := &Assignment{Target: target, Value: value, PosAt: target.Pos()}
+	if operator, ok := compoundAssignOperator(opTok); ok {
+		assignment.Compound = true
+		assignment.Operator = operator
+	}
+	return assignment
+}
+
+// compoundAssignOperator returns the binary operator applied by `+=`, `-=`,
+// `*=` or `/=`.
+func compoundAssignOperator(t *lexer.Token) (lexer.OperatorSubkind, bool) {
+	if t == nil || t.Kind != lexer.Operator {
+		return 0, false
+	}
+	switch t.Subkind {
+	case lexer.OperatorPlusAssign:
+		return lexer.OperatorPlus, true
+	case lexer.OperatorMinusAssign:
+		return lexer.OperatorMinus, true
+	case lexer.OperatorStarAssign:
+		return lexer.OperatorStar, true
+	case lexer.OperatorSlashAssign:
+		return lexer.OperatorSlash, true
+	}
+	return 0, false
+}
+
+func isAssignOperator(t *lexer.Token) bool {
+	if t != nil && t.Kind == lexer.Punctuator && t.Subkind == lexer.Assign {
+		return true
+	}
+	_, ok := compoundAssignOperator(t)
+	return ok
+}
+
+func isAssignable(expr Expression) bool {
+	switch expr.(type) {
+	case *Identifier, *IndexExpr, *FieldExpr:
+		return true
+	}
+	return false
+}
+
+// isAssignmentStart reports whether the statement at the cursor is an
+// assignment. The target can be any postfix expression, such as `a[i][j]`, so
+// it is parsed speculatively and the parser is rewound afterwards.
+func (p *Parser) isAssignmentStart() bool {
+	t := p.peek(0)
+	if t == nil || t.Kind != lexer.Identifier {
+		return false
+	}
+	state := p.mark()
+	defer p.rewind(state)
+	target := p.ParsePostfixExpr(false)
+	return target != nil && isAssignOperator(p.peek(0))
+}
+
+// isIncDecStart reports whether t and next begin an increment or decrement
+// statement, either prefix (`++i`) or postfix (`i++`).
+func isIncDecStart(t *lexer.Token, next *lexer.Token) bool {
+	if isIncDecOperator(t) {
+		return true
+	}
+	return t.Kind == lexer.Identifier && isIncDecOperator(next)
+}
+
+func isIncDecOperator(t *lexer.Token) bool {
+	return t != nil && t.Kind == lexer.Operator && (t.Subkind == lexer.OperatorIncrement || t.Subkind == lexer.OperatorDecrement)
+}
+
+func (p *Parser) ParseIncDec() *Assignment {
+	assignment := p.parseIncDecClause()
+	if assignment == nil {
+		return nil
+	}
+
+	semicolon := p.eatExpected(lexer.Punctuator, lexer.StatementEnd, "expected ';'")
+	if semicolon == nil {
+		return nil
+	}
+
+	return assignment
+}
+
+// parseIncDecClause parses `++i`, `i++`, `--i` or `i--` and desugars it to
+// the assignment `i = i + 1` (or `i = i - 1`).
+func (p *Parser) parseIncDecClause() *Assignment {
+	var opTok, idTok *lexer.Token
+	if isIncDecOperator(p.peek(0)) {
+		opTok = p.eat()
+		idTok = p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected identifier")
+		if idTok == nil {
+			return nil
+		}
+	} else {
+		idTok = p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected identifier")
+		if idTok == nil {
+			return nil
+		}
+		opTok = p.eat()
+	}
+
+	operator := lexer.OperatorPlus
+	if opTok.Subkind == lexer.OperatorDecrement {
+		operator = lexer.OperatorMinus
+	}
+
+	return &Assignment{
+		Target: &Identifier{Name: idTok.Lexeme, PosAt: idTok.Pos},
+		Value: &BinaryExpr{
+			Left:     &Identifier{Name: idTok.Lexeme, PosAt: idTok.Pos},
+			Operator: operator,
+			Right:    &IntLiteral{Value: 1, PosAt: opTok.Pos},
+			PosAt:    opTok.Pos,
+		},
+		IncDec: true,
+		PosAt:  idTok.Pos,
+	}
+}
+
+func (p *Parser) ParseIf() *If {
+	kw := p.eatExpected(lexer.Keyword, lexer.KeywordIf, "expected 'if'")
+	if kw == nil {
+		return nil
+	}
+	lparen := p.eatExpected(lexer.Punctuator, lexer.ParenOpen, "expected '('")
+	if lparen == nil {
+		return nil
+	}
+	condition := p.ParseExpression(false)
+	if condition == nil {
+		return nil
+	}
+	rparen := p.eatExpected(lexer.Punctuator, lexer.ParenClose, "expected ')'")
+	if rparen == nil {
+		return nil
+	}
+	body, bodyEnd := p.parseBody()
+	if body == nil {
+		return nil
+	}
+	elseBody := []Statement{}
+	var elseEnd *common.SourcePos
+	elseKw := p.peek(0)
+	if elseKw != nil && elseKw.Kind == lexer.Keyword && elseKw.Subkind == lexer.KeywordElse {
+		p.eat()
+		elseBody, elseEnd = p.parseBody()
+	}
+	return &If{Condition: condition, Body: body, ElseBody: elseBody, PosAt: kw.Pos, BodyEnd: bodyEnd, ElseEnd: elseEnd}
+}
+
+func (p *Parser) ParseWhile() *While {
+	kw := p.eatExpected(lexer.Keyword, lexer.KeywordWhile, "expected 'while'")
+	if kw == nil {
 		return nil
 	}
+	lparen := p.eatExpected(lexer.Punctuator, lexer.ParenOpen, "expected '('")
+	if lparen == nil {
+		return nil
+	}
+	condition := p.ParseExpression(false)
+	if condition == nil {
+		return nil
+	}
+	rparen := p.eatExpected(lexer.Punctuator, lexer.ParenClose, "expected ')'")
+	if rparen == nil {
+		return nil
+	}
+	body, end := p.parseBody()
+	if body == nil {
+		return nil
+	}
+	return &While{Condition: condition, Body: body, PosAt: kw.Pos, BodyEnd: end}
+}
+
+func (p *Parser) ParseFor() *For {
+	kw := p.eatExpected(lexer.Keyword, lexer.KeywordFor, "expected 'for'")
+	if kw == nil {
+		return nil
+	}
+	lparen := p.eatExpected(lexer.Punctuator, lexer.ParenOpen, "expected '('")
+	if lparen == nil {
+		return nil
+	}
+
+	var init Statement
+	t := p.peek(0)
+	if t != nil && t.Kind == lexer.Punctuator && t.Subkind == lexer.StatementEnd {
+		p.eat()
+	} else if t != nil && t.Kind == lexer.Keyword && (t.Subkind == lexer.KeywordVar || t.Subkind == lexer.KeywordConst) {
+		declaration := p.ParseDeclaration()
+		if declaration == nil {
+			return nil
+		}
+		init = declaration
+	} else {
+		init = p.parseForClause()
+		if init == nil {
+			return nil
+		}
+		semicolon := p.eatExpected(lexer.Punctuator, lexer.StatementEnd, "expected ';'")
+		if semicolon == nil {
+			return nil
+		}
+	}
+
+	var condition Expression
+	t = p.peek(0)
+	if t != nil && !(t.Kind == lexer.Punctuator && t.Subkind == lexer.StatementEnd) {
+		condition = p.ParseExpression(false)
+		if condition == nil {
+			return nil
+		}
+	}
+	semicolon := p.eatExpected(lexer.Punctuator, lexer.StatementEnd, "expected ';'")
+	if semicolon == nil {
+		return nil
+	}
+
+	var step Statement
+	t = p.peek(0)
+	if t != nil && !(t.Kind == lexer.Punctuator && t.Subkind == lexer.ParenClose) {
+		step = p.parseForClause()
+		if step == nil {
+			return nil
+		}
+	}
+	rparen := p.eatExpected(lexer.Punctuator, lexer.ParenClose, "expected ')'")
+	if rparen == nil {
+		return nil
+	}
+
+	body, end := p.parseBody()
+	if body == nil {
+		return nil
+	}
+	return &For{Init: init, Condition: condition, Step: step, Body: body, PosAt: kw.Pos, BodyEnd: end}
+}
+
+// parseForClause parses the init or step clause of a for loop: either an
+// assignment or an expression evaluated for its side effects.
+func (p *Parser) parseForClause() Statement {
+	t := p.peek(0)
+	if t == nil {
+		p.addError("expected statement", nil)
+		return nil
+	}
+	next := p.peek(1)
+	if p.isAssignmentStart() {
+		return asStatement(p.parseAssignmentClause())
+	}
+	if isIncDecStart(t, next) {
+		return asStatement(p.parseIncDecClause())
+	}
+	return p.ParseExpression(true)
+}
+
+func (p *Parser) ParseImport() *Import {
+	kw := p.eatExpected(lexer.Keyword, lexer.KeywordImport, "expected 'import'")
+	if kw == nil {
+		return nil
+	}
+	pathTok := p.eatExpected(lexer.Constant, lexer.String, "expected module path")
+	if pathTok == nil {
+		return nil
+	}
+	path, err := lexer.Unquote(pathTok.Lexeme)
+	if err != nil {
+		p.addError(err.Error(), pathTok.Pos)
+		return nil
+	}
+	semicolon := p.eatExpected(lexer.Punctuator, lexer.StatementEnd, "expected ';'")
+	if semicolon == nil {
+		return nil
+	}
+	return &Import{Path: path, PosAt: kw.Pos}
+}
+
+// ParseExport parses `export` in front of a function, a constant, a struct or
+// an enum. A doc comment written above `export` documents the declaration.
+func (p *Parser) ParseExport() Statement {
+	kw := p.eatExpected(lexer.Keyword, lexer.KeywordExport, "expected 'export'")
+	if kw == nil {
+		return nil
+	}
+	t := p.peek(0)
+	if t == nil || t.Kind != lexer.Keyword {
+		p.addError("only functions, constants, structs and enums can be exported", kw.Pos)
+		return nil
+	}
+	switch t.Subkind {
+	case lexer.KeywordFunction:
+		function, ok := p.ParseFunction().(*Function)
+		if !ok {
+			return nil
+		}
+		function.Exported = true
+		function.Doc = exportDoc(function.Doc, kw)
+		return function
+	case lexer.KeywordConst:
+		declaration := p.ParseDeclaration()
+		if declaration == nil {
+			return nil
+		}
+		declaration.Exported = true
+		declaration.Doc = exportDoc(declaration.Doc, kw)
+		return declaration
+	case lexer.KeywordStruct:
+		decl := p.ParseStruct()
+		if decl == nil {
+			return nil
+		}
+		decl.Exported = true
+		decl.Doc = exportDoc(decl.Doc, kw)
+		return decl
+	case lexer.KeywordEnum:
+		decl := p.ParseEnum()
+		if decl == nil {
+			return nil
+		}
+		decl.Exported = true
+		decl.Doc = exportDoc(decl.Doc, kw)
+		return decl
+	case lexer.KeywordVar:
+		p.addError("exported variables must be constants, use 'export const'", t.Pos)
+		return nil
+	}
+	p.addError("only functions, constants, structs and enums can be exported", t.Pos)
+	return nil
+}
+
+func exportDoc(doc string, kw *lexer.Token) string {
+	if doc != "" {
+		return doc
+	}
+	return docComment(kw)
+}
+
+func (p *Parser) ParseBreak() *Break {
+	kw := p.eatExpected(lexer.Keyword, lexer.KeywordBreak, "expected 'break'")
+	if kw == nil {
+		return nil
+	}
+	semicolon := p.eatExpected(lexer.Punctuator, lexer.StatementEnd, "expected ';'")
+	if semicolon == nil {
+		return nil
+	}
+	return &Break{PosAt: kw.Pos}
+}
+
+func (p *Parser) ParseContinue() *Continue {
+	kw := p.eatExpected(lexer.Keyword, lexer.KeywordContinue, "expected 'continue'")
+	if kw == nil {
+		return nil
+	}
+	semicolon := p.eatExpected(lexer.Punctuator, lexer.StatementEnd, "expected ';'")
+	if semicolon == nil {
+		return nil
+	}
+	return &Continue{PosAt: kw.Pos}
+}
+
+func (p *Parser) ParseExpression(isStatement bool) Expression {
+	return p.ParseConditionalExpr(isStatement)
+}
+
+func (p *Parser) ParseConditionalExpr(isStatement bool) Expression {
+	start := p.mark()
+	condition := p.ParseNullCoalesceExpr(isStatement)
+	if condition == nil {
+		return nil
+	}
+
+	op := p.peek(0)
+	if op == nil || op.Kind != lexer.Operator || op.Subkind != lexer.OperatorQuestion {
+		return condition
+	}
+	if isStatement {
+		// both branches are type-checked against each other, so they are
+		// parsed as values and the result is dropped by the compiler
+		p.rewind(start)
+		condition = p.ParseNullCoalesceExpr(false)
+	}
 
-	eq := p.eatExpected(lexer.Punctuator, lexer.Assign, "expected '='")
-	if eq == nil {
+	p.eat()
+	then := p.ParseExpression(false)
+	if then == nil {
 		return nil
 	}
 
-	value := p.ParseExpression(false)
-	if value == nil {
+	if p.eatExpected(lexer.Punctuator, lexer.Colon, "expected ':' in conditional expression") == nil {
 		return nil
 	}
 
-	semicolon := p.eatExpected(lexer.Punctuator, lexer.StatementEnd, "expected ';'")
-	if semicolon == nil {
+	elseExpr := p.ParseConditionalExpr(false)
+	if elseExpr == nil {
 		return nil
 	}
 
-	return &Assignment{
-		Identifier: &Identifier{Name: idTok.Lexeme, PosAt: idTok.Pos},
-		Value:      value,
-		PosAt:      idTok.Pos,
+	return &ConditionalExpr{
+		Condition:   condition,
+		Then:        then,
+		Else:        elseExpr,
+		PosAt:       op.Pos,
+		IsStatement: isStatement,
 	}
 }
 
-func (p *Parser) ParseIf() *If {
-	kw := p.eatExpected(lexer.Keyword, lexer.KeywordIf, "expected 'if'")
-	if kw == nil {
-		return nil
-	}
-	lparen := p.eatExpected(lexer.Punctuator, lexer.ParenOpen, "expected '('")
-	if lparen == nil {
+// ParseNullCoalesceExpr parses `a ?? b`, which is right associative so that
+// `a ?? b ?? c` tries a, then b, then c.
+func (p *Parser) ParseNullCoalesceExpr(isStatement bool) Expression {
+	start := p.mark()
+	left := p.ParseLogicalOrExpr(isStatement)
+	if left == nil {
 		return nil
 	}
-	condition := p.ParseExpression(false)
-	if condition == nil {
-		return nil
+	op := p.peek(0)
+	if op == nil || op.Kind != lexer.Operator || op.Subkind != lexer.OperatorNullCoalesce {
+		return left
 	}
-	rparen := p.eatExpected(lexer.Punctuator, lexer.ParenClose, "expected ')'")
-	if rparen == nil {
-		return nil
+	if isStatement {
+		// as with `||`, the left operand decides whether the right one runs
+		p.rewind(start)
+		return p.ParseNullCoalesceExpr(false)
 	}
-	body := p.ParseBody()
-	if body == nil {
+
+	p.eat()
+	right := p.ParseNullCoalesceExpr(false)
+	if right == nil {
 		return nil
 	}
-	elseBody := []Statement{}
-	elseKw := p.peek(0)
-	if elseKw != nil && elseKw.Kind == lexer.Keyword && elseKw.Subkind == lexer.KeywordElse {
-		p.eat()
-		elseBody = p.ParseBody()
+	return &BinaryExpr{
+		Left:     left,
+		Operator: lexer.OperatorNullCoalesce,
+		Right:    right,
+		PosAt:    op.Pos,
 	}
-	return &If{Condition: condition, Body: body, ElseBody: elseBody, PosAt: kw.Pos}
-}
-
-func (p *Parser) ParseExpression(isStatement bool) Expression {
-	return p.ParseLogicalOrExpr(isStatement)
 }
 
 func (p *Parser) ParseLogicalOrExpr(isStatement bool) Expression {
+	start := p.mark()
 	left := p.ParseLogicalAndExpr(isStatement)
 	if left == nil {
 		return nil
 	}
+	if isStatement && p.peekOperator(lexer.OperatorOr) {
+		// the left operand decides whether the right one runs, so it has to
+		// be parsed as a value even when the whole expression is a statement
+		p.rewind(start)
+		return p.ParseLogicalOrExpr(false)
+	}
 
 	for {
 		op := p.peek(0)
@@ -425,17 +1342,28 @@ func (p *Parser) ParseLogicalOrExpr(isStatement bool) Expression {
 			return nil
 		}
 
-		left = &BinaryExpr{Left: left, Operator: lexer.OperatorOr, Right: right, IsStatement: isStatement}
+		left = &BinaryExpr{
+			Left:        left,
+			Operator:    lexer.OperatorOr,
+			Right:       right,
+			PosAt:       op.Pos,
+			IsStatement: isStatement,
+		}
 	}
 
 	return left
 }
 
 func (p *Parser) ParseLogicalAndExpr(isStatement bool) Expression {
+	start := p.mark()
 	left := p.ParseEqualityExpr(isStatement)
 	if left == nil {
 		return nil
 	}
+	if isStatement && p.peekOperator(lexer.OperatorAnd) {
+		p.rewind(start)
+		return p.ParseLogicalAndExpr(false)
+	}
 
 	for {
 		op := p.peek(0)
@@ -559,7 +1487,7 @@ func (p *Parser) ParseAdditiveExpr(isStatement bool) Expression {
 }
 
 func (p *Parser) ParseMultiplicativeExpr(isStatement bool) Expression {
-	left := p.ParsePrimaryExpr(isStatement)
+	left := p.ParseUnaryExpr(isStatement)
 	if left == nil {
 		return nil
 	}
@@ -572,7 +1500,7 @@ func (p *Parser) ParseMultiplicativeExpr(isStatement bool) Expression {
 		}
 
 		p.eat()
-		right := p.ParsePrimaryExpr(isStatement)
+		right := p.ParseUnaryExpr(isStatement)
 		if right == nil {
 			return nil
 		}
@@ -589,10 +1517,102 @@ func (p *Parser) ParseMultiplicativeExpr(isStatement bool) Expression {
 	return left
 }
 
+func (p *Parser) ParseUnaryExpr(isStatement bool) Expression {
+	op := p.peek(0)
+	if isIncDecOperator(op) {
+		p.addError(fmt.Sprintf("'%s' can only be used as a statement", op.Lexeme), op.Pos)
+		return nil
+	}
+	if op == nil || op.Kind != lexer.Operator ||
+		(op.Subkind != lexer.OperatorMinus && op.Subkind != lexer.OperatorNot) {
+		return p.ParsePostfixExpr(isStatement)
+	}
+
+	p.eat()
+	operand := p.ParseUnaryExpr(isStatement)
+	if operand == nil {
+		return nil
+	}
+
+	return &UnaryExpr{
+		Operator:    op.Subkind.(lexer.OperatorSubkind),
+		Operand:     operand,
+		PosAt:       op.Pos,
+		IsStatement: isStatement,
+	}
+}
+
+// ParsePostfixExpr parses a primary expression followed by any chain of calls
+// and indexes, such as `f(x)[2]`, `a[0][1]` or `g()()`.
+func (p *Parser) ParsePostfixExpr(isStatement bool) Expression {
+	state := p.mark()
+	// the primary is only a statement on its own, as a callee or an indexed
+	// value it is used by the postfix around it
+	expr := p.ParsePrimaryExpr(false)
+	if expr == nil {
+		return nil
+	}
+	if !isPostfixStart(p.peek(0)) {
+		if isStatement {
+			p.rewind(state)
+			return p.ParsePrimaryExpr(true)
+		}
+		return expr
+	}
+
+	for expr != nil && isPostfixStart(p.peek(0)) {
+		t := p.peek(0)
+		if t.Subkind == lexer.ParenOpen {
+			expr = asExpression(p.ParseCallExpr(expr))
+		} else if t.Subkind == lexer.OperatorDot {
+			expr = asExpression(p.ParseFieldExpr(expr, false))
+		} else {
+			expr = asExpression(p.ParseIndexExpr(expr, false))
+		}
+	}
+	// only the outermost index or field of the chain is the statement
+	if isStatement {
+		switch outer := expr.(type) {
+		case *IndexExpr:
+			outer.IsStatement = true
+		case *FieldExpr:
+			outer.IsStatement = true
+		}
+	}
+	return expr
+}
+
+func isPostfixStart(t *lexer.Token) bool {
+	if t == nil {
+		return false
+	}
+	if t.Kind == lexer.Operator && t.Subkind == lexer.OperatorDot {
+		return true
+	}
+	return t.Kind == lexer.Punctuator && (t.Subkind == lexer.ParenOpen || t.Subkind == lexer.BracketOpen)
+}
+
+// ParseFieldExpr parses `.name` after object.
+func (p *Parser) ParseFieldExpr(object Expression, isStatement bool) *FieldExpr {
+	dot := p.eatExpected(lexer.Operator, lexer.OperatorDot, "expected '.'")
+	if dot == nil {
+		return nil
+	}
+	field := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected field name")
+	if field == nil {
+		return nil
+	}
+	return &FieldExpr{Object: object, Field: field.Lexeme, PosAt: field.Pos, IsStatement: isStatement}
+}
+
 func (p *Parser) ParsePrimaryExpr(isStatement bool) Expression {
 	tok := p.peek(0)
+	if tok == nil {
+		p.addError("unexpected end of input, expected expression", nil)
+		return nil
+	}
 
-	if tok.Kind == lexer.Punctuator && tok.Subkind == lexer.ParenOpen {
+	if tok.Kind == lexer.Punctuator && tok.Subkind == lexer.ParenOpen && !p.isArrowFunctionStart() {
 		p.eat()
 
 		expr := p.ParseExpression(isStatement)
@@ -618,34 +1638,57 @@ func (p *Parser) ParseAtomExpr(isStatement bool) Expression {
 	}
 
 	if t.Kind == lexer.Constant && t.Subkind == lexer.Integer {
-		return p.ParseIntLiteral(isStatement)
+		return asExpression(p.ParseIntLiteral(isStatement))
+	}
+
+	if t.Kind == lexer.Constant && t.Subkind == lexer.Float {
+		return asExpression(p.ParseFloatLiteral(isStatement))
 	}
 
 	if t.Kind == lexer.Constant && t.Subkind == lexer.Boolean {
-		return p.ParseBoolLiteral(isStatement)
+		return asExpression(p.ParseBoolLiteral(isStatement))
 	}
 
 	if t.Kind == lexer.Constant && t.Subkind == lexer.Null {
-		return p.ParseNullLiteral(isStatement)
+		return asExpression(p.ParseNullLiteral(isStatement))
+	}
+
+	if t.Kind == lexer.Constant && t.Subkind == lexer.String {
+		return asExpression(p.ParseStringLiteral(isStatement))
 	}
 
 	if t.Kind == lexer.Punctuator && t.Subkind == lexer.BracketOpen {
-		return p.ParseArrayLiteral(isStatement)
+		return asExpression(p.ParseArrayLiteral(isStatement))
 	}
 
-	if t.Kind == lexer.Identifier {
-		t := p.peek(1)
-		if t != nil && t.Kind == lexer.Punctuator && t.Subkind == lexer.ParenOpen {
-			return p.ParseCallExpr()
-		}
+	if t.Kind == lexer.Punctuator && t.Subkind == lexer.BlockStart {
+		return asExpression(p.ParseMapLiteral(isStatement))
+	}
 
-		if t != nil && t.Kind == lexer.Punctuator && t.Subkind == lexer.BracketOpen {
-			return p.ParseIndexExpr(isStatement)
-		}
+	if (t.Kind == lexer.Keyword && t.Subkind == lexer.KeywordFunction) || p.isArrowFunctionStart() {
+		return asExpression(p.ParseFunctionExpr(isStatement))
+	}
+
+	if t.Kind == lexer.Type {
+		// conversions such as `float(x)` call the builtin named after the type,
+		// which can also be passed as a value, as in `map(xs, float)`
+		p.eat()
+		return &Identifier{Name: t.Lexeme, PosAt: t.Pos, IsStatement: isStatement}
+	}
+
+	if t.Kind == lexer.Keyword && t.Subkind == lexer.KeywordMatch {
+		return asExpression(p.ParseMatchExpr(isStatement))
+	}
+
+	if t.Kind == lexer.Identifier && p.isStructLiteralStart() {
+		return asExpression(p.ParseStructLiteral(isStatement))
+	}
 
-		return p.ParseIdentifier(isStatement)
+	if t.Kind == lexer.Identifier {
+		return asExpression(p.ParseIdentifier(isStatement))
 	}
 
+	p.addError(fmt.Sprintf("expected expression but got %v(%v)", t.Kind, t.Subkind), t.Pos)
 	return nil
 }
 
@@ -664,6 +1707,25 @@ func (p *Parser) ParseIntLiteral(isStatement bool) *IntLiteral {
 	}
 }
 
+func (p *Parser) ParseFloatLiteral(isStatement bool) *FloatLiteral {
+	t := p.eat()
+	if t == nil {
+		return nil
+	}
+
+	value, err := strconv.ParseFloat(t.Lexeme, 64)
+	if err != nil {
+		p.addError(fmt.Sprintf("invalid float literal %s", t.Lexeme), t.Pos)
+		return nil
+	}
+
+	return &FloatLiteral{
+		Value:       value,
+		PosAt:       t.Pos,
+		IsStatement: isStatement,
+	}
+}
+
 func (p *Parser) ParseBoolLiteral(isStatement bool) *BoolLiteral {
 	t := p.eat()
 	if t == nil {
@@ -678,6 +1740,21 @@ func (p *Parser) ParseBoolLiteral(isStatement bool) *BoolLiteral {
 	return &BoolLiteral{Value: t.Lexeme == "true", PosAt: t.Pos, IsStatement: isStatement}
 }
 
+func (p *Parser) ParseStringLiteral(isStatement bool) *StringLiteral {
+	t := p.eat()
+	if t == nil {
+		return nil
+	}
+
+	value, err := lexer.Unquote(t.Lexeme)
+	if err != nil {
+		p.addError(err.Error(), t.Pos)
+		return nil
+	}
+
+	return &StringLiteral{Value: value, Raw: t.Lexeme, PosAt: t.Pos, IsStatement: isStatement}
+}
+
 func (p *Parser) ParseNullLiteral(isStatement bool) *NullLiteral {
 	t := p.eat()
 	if t == nil {
@@ -724,6 +1801,208 @@ func (p *Parser) ParseArrayLiteral(isStatement bool) *ArrayLiteral {
 	return &ArrayLiteral{Elements: elements, PosAt: lparen.Pos, IsStatement: isStatement}
 }
 
+// ParseMapLiteral parses `{ key: value, ... }`. A `{` at the start of a
+// statement opens a block, so a map literal is only parsed in expression
+// position.
+func (p *Parser) ParseMapLiteral(isStatement bool) *MapLiteral {
+	lbrace := p.eatExpected(lexer.Punctuator, lexer.BlockStart, "expected '{'")
+	if lbrace == nil {
+		return nil
+	}
+	entries := []MapEntry{}
+	for {
+		t := p.peek(0)
+		if t == nil {
+			return nil
+		}
+		if t.Kind == lexer.Punctuator && t.Subkind == lexer.BlockEnd {
+			break
+		}
+		key := p.ParseExpression(false)
+		if key == nil {
+			return nil
+		}
+		colon := p.eatExpected(lexer.Punctuator, lexer.Colon, "expected ':'")
+		if colon == nil {
+			return nil
+		}
+		value := p.ParseExpression(false)
+		if value == nil {
+			return nil
+		}
+		entries = append(entries, MapEntry{Key: key, Value: value})
+		commaTok := p.peek(0)
+		if commaTok == nil || !(commaTok.Kind == lexer.Punctuator && commaTok.Subkind == lexer.Comma) {
+			break
+		}
+		p.eat()
+	}
+	rbrace := p.eatExpected(lexer.Punctuator, lexer.BlockEnd, "expected '}'")
+	if rbrace == nil {
+		return nil
+	}
+	return &MapLiteral{Entries: entries, PosAt: lbrace.Pos, IsStatement: isStatement}
+}
+
+// isStructLiteralStart reports whether the identifier at the cursor names the
+// struct of a literal: it is followed by `{` and then by `}` or `field:`.
+func (p *Parser) isStructLiteralStart() bool {
+	brace := p.peek(1)
+	if brace == nil || brace.Kind != lexer.Punctuator || brace.Subkind != lexer.BlockStart {
+		return false
+	}
+	next := p.peek(2)
+	if next != nil && next.Kind == lexer.Punctuator && next.Subkind == lexer.BlockEnd {
+		return true
+	}
+	colon := p.peek(3)
+	return next != nil && next.Kind == lexer.Identifier &&
+		colon != nil && colon.Kind == lexer.Punctuator && colon.Subkind == lexer.Colon
+}
+
+// ParseStructLiteral parses `Point { x: 1, y: 2 }`.
+func (p *Parser) ParseStructLiteral(isStatement bool) *StructLiteral {
+	name := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected struct name")
+	if name == nil {
+		return nil
+	}
+	lbrace := p.eatExpected(lexer.Punctuator, lexer.BlockStart, "expected '{'")
+	if lbrace == nil {
+		return nil
+	}
+	fields := []FieldValue{}
+	for {
+		t := p.peek(0)
+		if t == nil {
+			return nil
+		}
+		if t.Kind == lexer.Punctuator && t.Subkind == lexer.BlockEnd {
+			break
+		}
+		field := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected field name")
+		if field == nil {
+			return nil
+		}
+		colon := p.eatExpected(lexer.Punctuator, lexer.Colon, "expected ':'")
+		if colon == nil {
+			return nil
+		}
+		value := p.ParseExpression(false)
+		if value == nil {
+			return nil
+		}
+		fields = append(fields, FieldValue{Name: field.Lexeme, Value: value, PosAt: field.Pos})
+		commaTok := p.peek(0)
+		if commaTok == nil || !(commaTok.Kind == lexer.Punctuator && commaTok.Subkind == lexer.Comma) {
+			break
+		}
+		p.eat()
+	}
+	rbrace := p.eatExpected(lexer.Punctuator, lexer.BlockEnd, "expected '}'")
+	if rbrace == nil {
+		return nil
+	}
+	return &StructLiteral{Name: name.Lexeme, Fields: fields, PosAt: name.Pos, IsStatement: isStatement}
+}
+
+// ParseMatchExpr parses `match (subject) { Variant(a, b) => expr, _ => expr }`,
+// the arms are separated by commas.
+func (p *Parser) ParseMatchExpr(isStatement bool) *MatchExpr {
+	kw := p.eatExpected(lexer.Keyword, lexer.KeywordMatch, "expected 'match'")
+	if kw == nil {
+		return nil
+	}
+	lparen := p.eatExpected(lexer.Punctuator, lexer.ParenOpen, "expected '('")
+	if lparen == nil {
+		return nil
+	}
+	subject := p.ParseExpression(false)
+	if subject == nil {
+		return nil
+	}
+	rparen := p.eatExpected(lexer.Punctuator, lexer.ParenClose, "expected ')'")
+	if rparen == nil {
+		return nil
+	}
+	lbrace := p.eatExpected(lexer.Punctuator, lexer.BlockStart, "expected '{'")
+	if lbrace == nil {
+		return nil
+	}
+	arms := []MatchArm{}
+	for {
+		t := p.peek(0)
+		if t == nil {
+			return nil
+		}
+		if t.Kind == lexer.Punctuator && t.Subkind == lexer.BlockEnd {
+			break
+		}
+		arm := p.ParseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		arms = append(arms, *arm)
+		commaTok := p.peek(0)
+		if commaTok == nil || !(commaTok.Kind == lexer.Punctuator && commaTok.Subkind == lexer.Comma) {
+			break
+		}
+		p.eat()
+	}
+	rbrace := p.eatExpected(lexer.Punctuator, lexer.BlockEnd, "expected '}'")
+	if rbrace == nil {
+		return nil
+	}
+	if len(arms) == 0 {
+		p.addError("match must have at least one arm", kw.Pos)
+		return nil
+	}
+	return &MatchExpr{Subject: subject, Arms: arms, PosAt: kw.Pos, End: rbrace.Pos, IsStatement: isStatement}
+}
+
+func (p *Parser) ParseMatchArm() *MatchArm {
+	variant := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected variant name or '_'")
+	if variant == nil {
+		return nil
+	}
+	bindings := []string{}
+	t := p.peek(0)
+	if t != nil && t.Kind == lexer.Punctuator && t.Subkind == lexer.ParenOpen {
+		p.eat()
+		for {
+			t := p.peek(0)
+			if t == nil {
+				return nil
+			}
+			if t.Kind == lexer.Punctuator && t.Subkind == lexer.ParenClose {
+				break
+			}
+			binding := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected binding name")
+			if binding == nil {
+				return nil
+			}
+			bindings = append(bindings, binding.Lexeme)
+			commaTok := p.peek(0)
+			if commaTok == nil || !(commaTok.Kind == lexer.Punctuator && commaTok.Subkind == lexer.Comma) {
+				break
+			}
+			p.eat()
+		}
+		rparen := p.eatExpected(lexer.Punctuator, lexer.ParenClose, "expected ')'")
+		if rparen == nil {
+			return nil
+		}
+	}
+	arrow := p.eatExpected(lexer.Operator, lexer.OperatorFatArrow, "expected '=>'")
+	if arrow == nil {
+		return nil
+	}
+	body := p.ParseExpression(false)
+	if body == nil {
+		return nil
+	}
+	return &MatchArm{Variant: variant.Lexeme, Bindings: bindings, Body: body, PosAt: variant.Pos}
+}
+
 func (p *Parser) ParseIdentifier(isStatement bool) *Identifier {
 	idTok := p.eatExpected(lexer.Identifier, lexer.IdentifierName, "expected identifier")
 	if idTok == nil {
@@ -733,11 +2012,8 @@ func (p *Parser) ParseIdentifier(isStatement bool) *Identifier {
 	return &Identifier{Name: idTok.Lexeme, PosAt: idTok.Pos, IsStatement: isStatement}
 }
 
-func (p *Parser) ParseCallExpr() *CallExpr {
-	identifier := p.ParseIdentifier(false)
-	if identifier == nil {
-		return nil
-	}
+// ParseCallExpr parses the argument list of a call on callee.
+func (p *Parser) ParseCallExpr(callee Expression) *CallExpr {
 	lparen := p.eatExpected(lexer.Punctuator, lexer.ParenOpen, "expected '('")
 	if lparen == nil {
 		return nil
@@ -748,7 +2024,8 @@ func (p *Parser) ParseCallExpr() *CallExpr {
 		return nil
 	}
 	if t.Kind == lexer.Punctuator && t.Subkind == lexer.ParenClose {
-		return &CallExpr{Identifier: *identifier, Arguments: arguments, PosAt: lparen.Pos}
+		p.eat()
+		return &CallExpr{Callee: callee, Arguments: arguments, PosAt: lparen.Pos}
 	}
 	for {
 		argument := p.ParseExpression(false)
@@ -766,14 +2043,11 @@ func (p *Parser) ParseCallExpr() *CallExpr {
 	if rparen == nil {
 		return nil
 	}
-	return &CallExpr{Identifier: *identifier, Arguments: arguments, PosAt: lparen.Pos}
+	return &CallExpr{Callee: callee, Arguments: arguments, PosAt: lparen.Pos}
 }
 
-func (p *Parser) ParseIndexExpr(isStatement bool) *IndexExpr {
-	array := p.ParseIdentifier(false)
-	if array == nil {
-		return nil
-	}
+// ParseIndexExpr parses the `[index]` applied to array.
+func (p *Parser) ParseIndexExpr(array Expression, isStatement bool) *IndexExpr {
 	bracketOpen := p.eatExpected(lexer.Punctuator, lexer.BracketOpen, "expected '['")
 	if bracketOpen == nil {
 		return nil
@@ -786,7 +2060,21 @@ func (p *Parser) ParseIndexExpr(isStatement bool) *IndexExpr {
 	if bracketClose == nil {
 		return nil
 	}
-	return &IndexExpr{Array: array, Index: index, PosAt: array.PosAt, IsStatement: isStatement}
+	return &IndexExpr{Array: array, Index: index, PosAt: array.Pos(), IsStatement: isStatement}
+}
+
+// docComment joins the `///` lines in front of t into the text of a doc
+// comment, without the slashes and the single space after them.
+func docComment(t *lexer.Token) string {
+	lines := []string{}
+	for _, trivia := range t.Trivia {
+		if trivia.Kind != lexer.TriviaDocComment {
+			continue
+		}
+		line := strings.TrimPrefix(trivia.Text, "///")
+		lines = append(lines, strings.TrimPrefix(line, " "))
+	}
+	return strings.Join(lines, "\n")
 }
 
 func atoi(s string) int {
@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"strings"
 	"testing"
 
 	"youpiteron.dev/white-monster-on-friday-night/internal/common"
@@ -117,6 +118,48 @@ func TestParseDeclaration_VarWithoutValue(t *testing.T) {
 	}
 }
 
+func TestParseDeclaration_DocComment(t *testing.T) {
+	kw := makeToken("const", lexer.Keyword, lexer.KeywordConst, 24, 3, 1)
+	kw.Trivia = []lexer.Trivia{
+		{Kind: lexer.TriviaDocComment, Text: "/// Maximum retries.", Pos: &common.SourcePos{}},
+		{Kind: lexer.TriviaDocComment, Text: "///   Must be positive.", Pos: &common.SourcePos{}},
+	}
+	tokens := []lexer.Token{
+		kw,
+		makeToken("max", lexer.Identifier, lexer.IdentifierName, 30, 3, 7),
+		makeToken("=", lexer.Punctuator, lexer.Assign, 34, 3, 11),
+		makeToken("3", lexer.Constant, lexer.Integer, 36, 3, 13),
+		makeToken(";", lexer.Punctuator, lexer.StatementEnd, 37, 3, 14),
+	}
+
+	parser := NewParser(tokens)
+	decl := parser.ParseDeclaration()
+
+	if decl == nil {
+		t.Fatalf("expected declaration, got errors: %v", parser.Errors)
+	}
+	if decl.Doc != "Maximum retries.\n  Must be positive." {
+		t.Errorf("unexpected doc comment %q", decl.Doc)
+	}
+}
+
+func TestParseFunction_DocCommentFromSource(t *testing.T) {
+	source := "/// Doubles x.\n// plain comments are dropped\nfunction double(x: int): int { return x * 2; }"
+	parser := NewParser(lexer.NewLexer().Lex(source).Tokens)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	function, ok := program.Statements[0].(*Function)
+	if !ok {
+		t.Fatalf("expected Function, got %T", program.Statements[0])
+	}
+	if function.Doc != "Doubles x." {
+		t.Errorf("unexpected doc comment %q", function.Doc)
+	}
+}
+
 func TestParseDeclaration_MissingIdentifier(t *testing.T) {
 	tokens := []lexer.Token{
 		makeToken("var", lexer.Keyword, lexer.KeywordVar, 0, 1, 1),
@@ -175,6 +218,89 @@ func TestParseFunction_Basic(t *testing.T) {
 	}
 }
 
+func TestParseFunction_NoParameters(t *testing.T) {
+	tokens := []lexer.Token{
+		makeToken("function", lexer.Keyword, lexer.KeywordFunction, 0, 1, 1),
+		makeToken("one", lexer.Identifier, lexer.IdentifierName, 9, 1, 10),
+		makeToken("(", lexer.Punctuator, lexer.ParenOpen, 12, 1, 13),
+		makeToken(")", lexer.Punctuator, lexer.ParenClose, 13, 1, 14),
+		makeToken(":", lexer.Punctuator, lexer.Colon, 14, 1, 15),
+		makeToken("int", lexer.Type, lexer.TypeInt, 16, 1, 17),
+		makeToken("{", lexer.Punctuator, lexer.BlockStart, 20, 1, 21),
+		makeToken("}", lexer.Punctuator, lexer.BlockEnd, 21, 1, 22),
+	}
+
+	parser := NewParser(tokens)
+	fnStmt := parser.ParseFunction()
+
+	if fnStmt == nil {
+		t.Fatal("expected function but got nil")
+	}
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	fn, ok := fnStmt.(*Function)
+	if !ok {
+		t.Fatalf("expected *Function, got %T", fnStmt)
+	}
+	if len(fn.Params) != 0 {
+		t.Errorf("expected 0 parameters, got %d", len(fn.Params))
+	}
+}
+
+// ---------- ParseFunctionExpr Tests ----------
+
+func TestParseFunctionExpr_Arrow(t *testing.T) {
+	program, errors := parseSource(t, "const f = (x: int, y: int) => x * y;")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	declaration := program.Statements[0].(*Declaration)
+	lambda, ok := declaration.Value.(*FunctionExpr)
+	if !ok {
+		t.Fatalf("expected FunctionExpr, got %T", declaration.Value)
+	}
+	if len(lambda.Params) != 2 || lambda.ReturnType != nil || lambda.Body != nil {
+		t.Errorf("expected two params, no return type and no body, got %+v", lambda)
+	}
+	if _, ok := lambda.ExprBody.(*BinaryExpr); !ok {
+		t.Errorf("expected the body to be a BinaryExpr, got %T", lambda.ExprBody)
+	}
+}
+
+func TestParseFunctionExpr_ParenthesizedExpressionIsNotArrow(t *testing.T) {
+	program, errors := parseSource(t, "const a = (x) * 2;")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	declaration := program.Statements[0].(*Declaration)
+	if _, ok := declaration.Value.(*BinaryExpr); !ok {
+		t.Errorf("expected BinaryExpr, got %T", declaration.Value)
+	}
+}
+
+func TestParseFunctionExpr_Anonymous(t *testing.T) {
+	program, errors := parseSource(t, "apply(function (x: int): int { return x; }, () => 1);")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	call := program.Statements[0].(*CallExpr)
+	if len(call.Arguments) != 2 {
+		t.Fatalf("expected 2 arguments, got %d", len(call.Arguments))
+	}
+	anonymous, ok := call.Arguments[0].(*FunctionExpr)
+	if !ok || len(anonymous.Body) != 1 || !anonymous.ReturnType.IsEqual(TypeInt()) {
+		t.Errorf("expected an anonymous function returning int, got %+v", call.Arguments[0])
+	}
+	arrow, ok := call.Arguments[1].(*FunctionExpr)
+	if !ok || len(arrow.Params) != 0 || arrow.ExprBody == nil {
+		t.Errorf("expected an arrow function without params, got %+v", call.Arguments[1])
+	}
+}
+
 // ---------- ParseReturn Tests ----------
 
 func TestParseReturn_WithValue(t *testing.T) {
@@ -226,8 +352,9 @@ func TestParseAssignment_Basic(t *testing.T) {
 	if len(parser.Errors) > 0 {
 		t.Fatalf("unexpected errors: %v", parser.Errors)
 	}
-	if assign.Identifier.Name != "x" {
-		t.Errorf("expected identifier name 'x', got '%s'", assign.Identifier.Name)
+	target, ok := assign.Target.(*Identifier)
+	if !ok || target.Name != "x" {
+		t.Errorf("expected target identifier 'x', got %v", assign.Target)
 	}
 	if value == nil {
 		t.Fatal("expected value but got nil")
@@ -268,22 +395,89 @@ func TestParseCallExpr_NoArguments(t *testing.T) {
 	}
 
 	parser := NewParser(tokens)
-	callExpr := parser.ParseCallExpr()
+	callExpr, ok := parser.ParseExpression(false).(*CallExpr)
 
-	if callExpr == nil {
-		t.Fatal("expected call expression but got nil")
+	if !ok {
+		t.Fatal("expected call expression")
 	}
 	if len(parser.Errors) > 0 {
 		t.Fatalf("unexpected errors: %v", parser.Errors)
 	}
-	if callExpr.Identifier.Name != "foo" {
-		t.Errorf("expected identifier name 'foo', got '%s'", callExpr.Identifier.Name)
+	callee, ok := callExpr.Callee.(*Identifier)
+	if !ok || callee.Name != "foo" {
+		t.Errorf("expected callee 'foo', got %v", callExpr.Callee)
 	}
 	if len(callExpr.Arguments) != 0 {
 		t.Errorf("expected 0 arguments, got %d", len(callExpr.Arguments))
 	}
 }
 
+func TestParseStatement_CallWithoutArguments(t *testing.T) {
+	tokens := []lexer.Token{
+		makeToken("foo", lexer.Identifier, lexer.IdentifierName, 0, 1, 1),
+		makeToken("(", lexer.Punctuator, lexer.ParenOpen, 3, 1, 4),
+		makeToken(")", lexer.Punctuator, lexer.ParenClose, 4, 1, 5),
+		makeToken(";", lexer.Punctuator, lexer.StatementEnd, 5, 1, 6),
+	}
+
+	parser := NewParser(tokens)
+	stmt := parser.ParseStatement()
+
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	if _, ok := stmt.(*CallExpr); !ok {
+		t.Fatalf("expected *CallExpr, got %T", stmt)
+	}
+}
+
+/*
+*
+Test `f(x)[2]`, `a[0][1]` and `g()()` as statements.
+
+- Calls and indexes chain left to right on any callee
+- Only the outermost index is marked as a statement
+*/
+func TestParseCallExpr_PostfixChains(t *testing.T) {
+	program, errors := parseSource(t, "f(x)[2]; a[0][1]; g()();")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	if len(program.Statements) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(program.Statements))
+	}
+
+	index, ok := program.Statements[0].(*IndexExpr)
+	if !ok {
+		t.Fatalf("expected IndexExpr, got %T", program.Statements[0])
+	}
+	if _, ok := index.Array.(*CallExpr); !ok || !index.IsStatement {
+		t.Errorf("expected a statement index on a call, got %+v", index)
+	}
+
+	outer, ok := program.Statements[1].(*IndexExpr)
+	if !ok {
+		t.Fatalf("expected IndexExpr, got %T", program.Statements[1])
+	}
+	inner, ok := outer.Array.(*IndexExpr)
+	if !ok || inner.IsStatement {
+		t.Errorf("expected a nested non-statement index, got %+v", outer.Array)
+	}
+
+	call, ok := program.Statements[2].(*CallExpr)
+	if !ok {
+		t.Fatalf("expected CallExpr, got %T", program.Statements[2])
+	}
+	innerCall, ok := call.Callee.(*CallExpr)
+	if !ok {
+		t.Fatalf("expected the callee to be a call, got %T", call.Callee)
+	}
+	if callee, ok := innerCall.Callee.(*Identifier); !ok || callee.IsStatement {
+		t.Errorf("expected the identifier g not to be a statement, got %+v", innerCall.Callee)
+	}
+}
+
 // ---------- ParseMultiplicativeExpr Tests ----------
 
 func TestParseMultiplicativeExpr_IntLiteral(t *testing.T) {
@@ -361,6 +555,78 @@ func TestParseIntLiteral_Basic(t *testing.T) {
 	}
 }
 
+func TestParseFloatLiteral_Exponent(t *testing.T) {
+	tokens := []lexer.Token{
+		makeToken("2.5e-1", lexer.Constant, lexer.Float, 0, 1, 1),
+	}
+
+	parser := NewParser(tokens)
+	lit := parser.ParseFloatLiteral(false)
+
+	if lit == nil {
+		t.Fatal("expected float literal but got nil")
+	}
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	if lit.Value != 0.25 {
+		t.Errorf("expected value 0.25, got %v", lit.Value)
+	}
+}
+
+/*
+*
+Test `float(n)`.
+
+- Should parse the type keyword as the callee of a conversion call
+*/
+func TestParseCallExpr_Conversion(t *testing.T) {
+	tokens := []lexer.Token{
+		makeToken("float", lexer.Type, lexer.TypeFloat, 0, 1, 1),
+		makeToken("(", lexer.Punctuator, lexer.ParenOpen, 5, 1, 6),
+		makeToken("n", lexer.Identifier, lexer.IdentifierName, 6, 1, 7),
+		makeToken(")", lexer.Punctuator, lexer.ParenClose, 7, 1, 8),
+	}
+
+	parser := NewParser(tokens)
+	expr := parser.ParseExpression(false)
+
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	call, ok := expr.(*CallExpr)
+	if !ok {
+		t.Fatalf("expected CallExpr, got %T", expr)
+	}
+	if callee, ok := call.Callee.(*Identifier); !ok || callee.Name != "float" {
+		t.Errorf("expected callee float, got %v", call.Callee)
+	}
+	if len(call.Arguments) != 1 {
+		t.Errorf("expected 1 argument, got %d", len(call.Arguments))
+	}
+}
+
+// ---------- ParseStringLiteral Tests ----------
+
+func TestParseStringLiteral_Escapes(t *testing.T) {
+	tokens := []lexer.Token{
+		makeToken(`"a\n\"b\u{41}"`, lexer.Constant, lexer.String, 0, 1, 1),
+	}
+
+	parser := NewParser(tokens)
+	lit := parser.ParseStringLiteral(false)
+
+	if lit == nil {
+		t.Fatal("expected string literal but got nil")
+	}
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	if lit.Value != "a\n\"bA" {
+		t.Errorf("expected value %q, got %q", "a\n\"bA", lit.Value)
+	}
+}
+
 // ---------- ParseBoolLiteral Tests ----------
 
 func TestParseBoolLiteral_True(t *testing.T) {
@@ -447,6 +713,95 @@ func TestParseParam_Basic(t *testing.T) {
 	}
 }
 
+// ---------- ParseType Tests ----------
+
+func TestParseType_FunctionType(t *testing.T) {
+	tokens := []lexer.Token{
+		makeToken("(", lexer.Punctuator, lexer.ParenOpen, 0, 1, 1),
+		makeToken("int", lexer.Type, lexer.TypeInt, 1, 1, 2),
+		makeToken(",", lexer.Punctuator, lexer.Comma, 4, 1, 5),
+		makeToken("bool", lexer.Type, lexer.TypeBool, 6, 1, 7),
+		makeToken(")", lexer.Punctuator, lexer.ParenClose, 10, 1, 11),
+		makeToken("->", lexer.Operator, lexer.OperatorArrow, 12, 1, 13),
+		makeToken("int", lexer.Type, lexer.TypeInt, 15, 1, 16),
+	}
+
+	parser := NewParser(tokens)
+	typeOf := parser.ParseType()
+
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	if typeOf == nil || typeOf.Signature == nil {
+		t.Fatalf("expected function type, got %v", typeOf)
+	}
+	if len(typeOf.Signature.Params) != 2 || !typeOf.Signature.Params[1].IsEqual(TypeBool()) {
+		t.Errorf("expected params (int, bool), got %v", typeOf)
+	}
+	if typeOf.String() != "(int, bool) -> int" {
+		t.Errorf("expected (int, bool) -> int, got %s", typeOf)
+	}
+}
+
+func TestParseType_FunctionReturningFunction(t *testing.T) {
+	program, errors := parseSource(t, "var f: () -> (int) -> []int;")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	declaration := program.Statements[0].(*Declaration)
+	if declaration.TypeOf.String() != "() -> (int) -> []int" {
+		t.Errorf("expected () -> (int) -> []int, got %s", declaration.TypeOf)
+	}
+	returnType := declaration.TypeOf.Signature.ReturnType
+	if returnType.Signature == nil || !returnType.Signature.ReturnType.IsEqual(TypeArrayOf(TypeInt())) {
+		t.Errorf("expected the return type to be a function returning []int, got %s", returnType)
+	}
+}
+
+func TestParseType_FunctionTypeMissingArrow(t *testing.T) {
+	_, errors := parseSource(t, "var f: (int) int;")
+
+	if len(errors) == 0 {
+		t.Fatal("expected an error for a function type without '->'")
+	}
+}
+
+func TestParseType_NestedArrays(t *testing.T) {
+	program, errors := parseSource(t, "var grid: [][][]int; var fs: [](int) -> []bool;")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	grid := program.Statements[0].(*Declaration).TypeOf
+	if !grid.IsEqual(TypeArrayOf(TypeArrayOf(TypeArrayOf(TypeInt())))) {
+		t.Errorf("expected [][][]int, got %s", grid)
+	}
+	if grid.IsEqual(TypeArrayOf(TypeArrayOf(TypeArrayOf(TypeBool())))) {
+		t.Error("expected [][][]int to differ from [][][]bool")
+	}
+	fs := program.Statements[1].(*Declaration).TypeOf
+	if fs.String() != "[](int) -> []bool" {
+		t.Errorf("expected [](int) -> []bool, got %s", fs)
+	}
+}
+
+func TestParseType_Nullable(t *testing.T) {
+	program, errors := parseSource(t, "var a: []int?; var f: (int?) -> Point?;")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	a := program.Statements[0].(*Declaration).TypeOf
+	if !a.IsEqual(TypeArrayOf(TypeNullableOf(TypeInt()))) {
+		t.Errorf("expected an array of int?, got %s", a)
+	}
+	f := program.Statements[1].(*Declaration).TypeOf
+	if f.String() != "(int?) -> Point?" {
+		t.Errorf("expected (int?) -> Point?, got %s", f)
+	}
+}
+
 // ---------- ParseBody Tests ----------
 
 func TestParseBody_WithStatement(t *testing.T) {
@@ -513,3 +868,821 @@ func TestParseStatement_Expression(t *testing.T) {
 		t.Errorf("expected value 42, got %d", ret.Value)
 	}
 }
+
+// ---------- ParseWhile Tests ----------
+
+func TestParseWhile_Basic(t *testing.T) {
+	tokens := []lexer.Token{
+		makeToken("while", lexer.Keyword, lexer.KeywordWhile, 0, 1, 1),
+		makeToken("(", lexer.Punctuator, lexer.ParenOpen, 6, 1, 7),
+		makeToken("x", lexer.Identifier, lexer.IdentifierName, 7, 1, 8),
+		makeToken(")", lexer.Punctuator, lexer.ParenClose, 8, 1, 9),
+		makeToken("{", lexer.Punctuator, lexer.BlockStart, 10, 1, 11),
+		makeToken("break", lexer.Keyword, lexer.KeywordBreak, 12, 1, 13),
+		makeToken(";", lexer.Punctuator, lexer.StatementEnd, 17, 1, 18),
+		makeToken("}", lexer.Punctuator, lexer.BlockEnd, 19, 1, 20),
+	}
+
+	parser := NewParser(tokens)
+	stmt := parser.ParseStatement()
+
+	if stmt == nil {
+		t.Fatal("expected while statement but got nil")
+	}
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	while, ok := stmt.(*While)
+	if !ok {
+		t.Fatalf("expected *While, got %T", stmt)
+	}
+	if _, ok := while.Condition.(*Identifier); !ok {
+		t.Errorf("expected condition to be an Identifier, got %T", while.Condition)
+	}
+	if len(while.Body) != 1 {
+		t.Fatalf("expected 1 statement in body, got %d", len(while.Body))
+	}
+	if _, ok := while.Body[0].(*Break); !ok {
+		t.Errorf("expected Break statement, got %T", while.Body[0])
+	}
+}
+
+// ---------- ParseFor Tests ----------
+
+func TestParseFor_AllClauses(t *testing.T) {
+	// for (var i = 0; i < 3; i = i + 1) { continue; }
+	tokens := []lexer.Token{
+		makeToken("for", lexer.Keyword, lexer.KeywordFor, 0, 1, 1),
+		makeToken("(", lexer.Punctuator, lexer.ParenOpen, 4, 1, 5),
+		makeToken("var", lexer.Keyword, lexer.KeywordVar, 5, 1, 6),
+		makeToken("i", lexer.Identifier, lexer.IdentifierName, 9, 1, 10),
+		makeToken("=", lexer.Punctuator, lexer.Assign, 11, 1, 12),
+		makeToken("0", lexer.Constant, lexer.Integer, 13, 1, 14),
+		makeToken(";", lexer.Punctuator, lexer.StatementEnd, 14, 1, 15),
+		makeToken("i", lexer.Identifier, lexer.IdentifierName, 16, 1, 17),
+		makeToken("<", lexer.Operator, lexer.OperatorLess, 18, 1, 19),
+		makeToken("3", lexer.Constant, lexer.Integer, 20, 1, 21),
+		makeToken(";", lexer.Punctuator, lexer.StatementEnd, 21, 1, 22),
+		makeToken("i", lexer.Identifier, lexer.IdentifierName, 23, 1, 24),
+		makeToken("=", lexer.Punctuator, lexer.Assign, 25, 1, 26),
+		makeToken("i", lexer.Identifier, lexer.IdentifierName, 27, 1, 28),
+		makeToken("+", lexer.Operator, lexer.OperatorPlus, 29, 1, 30),
+		makeToken("1", lexer.Constant, lexer.Integer, 31, 1, 32),
+		makeToken(")", lexer.Punctuator, lexer.ParenClose, 32, 1, 33),
+		makeToken("{", lexer.Punctuator, lexer.BlockStart, 34, 1, 35),
+		makeToken("continue", lexer.Keyword, lexer.KeywordContinue, 36, 1, 37),
+		makeToken(";", lexer.Punctuator, lexer.StatementEnd, 44, 1, 45),
+		makeToken("}", lexer.Punctuator, lexer.BlockEnd, 46, 1, 47),
+	}
+
+	parser := NewParser(tokens)
+	forStmt := parser.ParseFor()
+
+	if forStmt == nil {
+		t.Fatal("expected for statement but got nil")
+	}
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	if _, ok := forStmt.Init.(*Declaration); !ok {
+		t.Errorf("expected init to be a Declaration, got %T", forStmt.Init)
+	}
+	if _, ok := forStmt.Condition.(*BinaryExpr); !ok {
+		t.Errorf("expected condition to be a BinaryExpr, got %T", forStmt.Condition)
+	}
+	if _, ok := forStmt.Step.(*Assignment); !ok {
+		t.Errorf("expected step to be an Assignment, got %T", forStmt.Step)
+	}
+	if len(forStmt.Body) != 1 {
+		t.Fatalf("expected 1 statement in body, got %d", len(forStmt.Body))
+	}
+	if _, ok := forStmt.Body[0].(*Continue); !ok {
+		t.Errorf("expected Continue statement, got %T", forStmt.Body[0])
+	}
+}
+
+func TestParseFor_EmptyClauses(t *testing.T) {
+	// for (;;) {}
+	tokens := []lexer.Token{
+		makeToken("for", lexer.Keyword, lexer.KeywordFor, 0, 1, 1),
+		makeToken("(", lexer.Punctuator, lexer.ParenOpen, 4, 1, 5),
+		makeToken(";", lexer.Punctuator, lexer.StatementEnd, 5, 1, 6),
+		makeToken(";", lexer.Punctuator, lexer.StatementEnd, 6, 1, 7),
+		makeToken(")", lexer.Punctuator, lexer.ParenClose, 7, 1, 8),
+		makeToken("{", lexer.Punctuator, lexer.BlockStart, 9, 1, 10),
+		makeToken("}", lexer.Punctuator, lexer.BlockEnd, 10, 1, 11),
+	}
+
+	parser := NewParser(tokens)
+	forStmt := parser.ParseFor()
+
+	if forStmt == nil {
+		t.Fatal("expected for statement but got nil")
+	}
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	if forStmt.Init != nil || forStmt.Condition != nil || forStmt.Step != nil {
+		t.Errorf("expected all clauses to be nil, got %v, %v, %v", forStmt.Init, forStmt.Condition, forStmt.Step)
+	}
+}
+
+// ---------- ParseUnaryExpr Tests ----------
+
+func TestParseUnaryExpr_NestedNegationAndNot(t *testing.T) {
+	// -!x
+	tokens := []lexer.Token{
+		makeToken("-", lexer.Operator, lexer.OperatorMinus, 0, 1, 1),
+		makeToken("!", lexer.Operator, lexer.OperatorNot, 1, 1, 2),
+		makeToken("x", lexer.Identifier, lexer.IdentifierName, 2, 1, 3),
+	}
+
+	parser := NewParser(tokens)
+	expr := parser.ParseExpression(false)
+
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	neg, ok := expr.(*UnaryExpr)
+	if !ok || neg.Operator != lexer.OperatorMinus {
+		t.Fatalf("expected negation, got %T", expr)
+	}
+	not, ok := neg.Operand.(*UnaryExpr)
+	if !ok || not.Operator != lexer.OperatorNot {
+		t.Fatalf("expected logical not operand, got %T", neg.Operand)
+	}
+}
+
+func TestParseUnaryExpr_BindsTighterThanMultiplication(t *testing.T) {
+	// -a * b
+	tokens := []lexer.Token{
+		makeToken("-", lexer.Operator, lexer.OperatorMinus, 0, 1, 1),
+		makeToken("a", lexer.Identifier, lexer.IdentifierName, 1, 1, 2),
+		makeToken("*", lexer.Operator, lexer.OperatorStar, 3, 1, 4),
+		makeToken("b", lexer.Identifier, lexer.IdentifierName, 5, 1, 6),
+	}
+
+	parser := NewParser(tokens)
+	expr := parser.ParseExpression(false)
+
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	mul, ok := expr.(*BinaryExpr)
+	if !ok || mul.Operator != lexer.OperatorStar {
+		t.Fatalf("expected multiplication at the root, got %T", expr)
+	}
+	if _, ok := mul.Left.(*UnaryExpr); !ok {
+		t.Errorf("expected left operand to be a UnaryExpr, got %T", mul.Left)
+	}
+}
+
+func TestParseUnaryExpr_IncrementInExpressionIsError(t *testing.T) {
+	// ++i
+	tokens := []lexer.Token{
+		makeToken("++", lexer.Operator, lexer.OperatorIncrement, 0, 1, 1),
+		makeToken("i", lexer.Identifier, lexer.IdentifierName, 2, 1, 3),
+	}
+
+	parser := NewParser(tokens)
+	expr := parser.ParseExpression(false)
+
+	if expr != nil {
+		t.Errorf("expected nil expression, got %T", expr)
+	}
+	if len(parser.Errors) == 0 {
+		t.Error("expected errors but got none")
+	}
+}
+
+// ---------- ParseIncDec Tests ----------
+
+func TestParseIncDec_PostfixDesugarsToAssignment(t *testing.T) {
+	tokens := []lexer.Token{
+		makeToken("i", lexer.Identifier, lexer.IdentifierName, 0, 1, 1),
+		makeToken("--", lexer.Operator, lexer.OperatorDecrement, 1, 1, 2),
+		makeToken(";", lexer.Punctuator, lexer.StatementEnd, 3, 1, 4),
+	}
+
+	parser := NewParser(tokens)
+	stmt := parser.ParseStatement()
+
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	assign, ok := stmt.(*Assignment)
+	if !ok {
+		t.Fatalf("expected *Assignment, got %T", stmt)
+	}
+	target, ok := assign.Target.(*Identifier)
+	if !ok || target.Name != "i" {
+		t.Errorf("expected target identifier 'i', got %v", assign.Target)
+	}
+	value, ok := assign.Value.(*BinaryExpr)
+	if !ok || value.Operator != lexer.OperatorMinus {
+		t.Fatalf("expected value to be i - 1, got %T", assign.Value)
+	}
+	if one, ok := value.Right.(*IntLiteral); !ok || one.Value != 1 {
+		t.Errorf("expected right operand to be 1, got %v", value.Right)
+	}
+}
+
+func TestParseIncDec_Prefix(t *testing.T) {
+	tokens := []lexer.Token{
+		makeToken("++", lexer.Operator, lexer.OperatorIncrement, 0, 1, 1),
+		makeToken("i", lexer.Identifier, lexer.IdentifierName, 2, 1, 3),
+		makeToken(";", lexer.Punctuator, lexer.StatementEnd, 3, 1, 4),
+	}
+
+	parser := NewParser(tokens)
+	stmt := parser.ParseStatement()
+
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	assign, ok := stmt.(*Assignment)
+	if !ok {
+		t.Fatalf("expected *Assignment, got %T", stmt)
+	}
+	if value, ok := assign.Value.(*BinaryExpr); !ok || value.Operator != lexer.OperatorPlus {
+		t.Errorf("expected value to be i + 1, got %v", assign.Value)
+	}
+}
+
+// ---------- Logical Expression Tests ----------
+
+/*
+*
+Test `ok && run();` as a statement.
+
+- The left operand must not be optimized away as a statement expression
+*/
+func TestParseStatement_LogicalAndKeepsLeftValue(t *testing.T) {
+	tokens := []lexer.Token{
+		makeToken("ok", lexer.Identifier, lexer.IdentifierName, 0, 1, 1),
+		makeToken("&&", lexer.Operator, lexer.OperatorAnd, 3, 1, 4),
+		makeToken("run", lexer.Identifier, lexer.IdentifierName, 6, 1, 7),
+		makeToken("(", lexer.Punctuator, lexer.ParenOpen, 9, 1, 10),
+		makeToken(")", lexer.Punctuator, lexer.ParenClose, 10, 1, 11),
+		makeToken(";", lexer.Punctuator, lexer.StatementEnd, 11, 1, 12),
+	}
+
+	parser := NewParser(tokens)
+	stmt := parser.ParseStatement()
+
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	and, ok := stmt.(*BinaryExpr)
+	if !ok || and.Operator != lexer.OperatorAnd {
+		t.Fatalf("expected && expression, got %T", stmt)
+	}
+	left, ok := and.Left.(*Identifier)
+	if !ok {
+		t.Fatalf("expected left operand to be an Identifier, got %T", and.Left)
+	}
+	if left.IsStatement {
+		t.Error("expected left operand to be parsed as a value")
+	}
+	if and.PosAt == nil {
+		t.Error("expected position of the operator")
+	}
+}
+
+// ---------- Conditional Expression Tests ----------
+
+/*
+*
+Test `a ? 1 : b ? 2 : 3`.
+
+- Should nest the second conditional in the else branch
+*/
+func TestParseConditionalExpr_RightAssociative(t *testing.T) {
+	tokens := []lexer.Token{
+		makeToken("a", lexer.Identifier, lexer.IdentifierName, 0, 1, 1),
+		makeToken("?", lexer.Operator, lexer.OperatorQuestion, 2, 1, 3),
+		makeToken("1", lexer.Constant, lexer.Integer, 4, 1, 5),
+		makeToken(":", lexer.Punctuator, lexer.Colon, 6, 1, 7),
+		makeToken("b", lexer.Identifier, lexer.IdentifierName, 8, 1, 9),
+		makeToken("?", lexer.Operator, lexer.OperatorQuestion, 10, 1, 11),
+		makeToken("2", lexer.Constant, lexer.Integer, 12, 1, 13),
+		makeToken(":", lexer.Punctuator, lexer.Colon, 14, 1, 15),
+		makeToken("3", lexer.Constant, lexer.Integer, 16, 1, 17),
+	}
+
+	parser := NewParser(tokens)
+	expr := parser.ParseExpression(false)
+
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", parser.Errors)
+	}
+	outer, ok := expr.(*ConditionalExpr)
+	if !ok {
+		t.Fatalf("expected ConditionalExpr, got %T", expr)
+	}
+	if _, ok := outer.Then.(*IntLiteral); !ok {
+		t.Errorf("expected then branch to be an IntLiteral, got %T", outer.Then)
+	}
+	inner, ok := outer.Else.(*ConditionalExpr)
+	if !ok {
+		t.Fatalf("expected else branch to be a ConditionalExpr, got %T", outer.Else)
+	}
+	if cond, ok := inner.Condition.(*Identifier); !ok || cond.Name != "b" {
+		t.Errorf("expected inner condition b, got %v", inner.Condition)
+	}
+}
+
+func TestParseConditionalExpr_MissingColon(t *testing.T) {
+	tokens := []lexer.Token{
+		makeToken("a", lexer.Identifier, lexer.IdentifierName, 0, 1, 1),
+		makeToken("?", lexer.Operator, lexer.OperatorQuestion, 2, 1, 3),
+		makeToken("1", lexer.Constant, lexer.Integer, 4, 1, 5),
+		makeToken(";", lexer.Punctuator, lexer.StatementEnd, 5, 1, 6),
+	}
+
+	parser := NewParser(tokens)
+	expr := parser.ParseExpression(false)
+
+	if expr != nil {
+		t.Errorf("expected nil expression, got %T", expr)
+	}
+	if len(parser.Errors) != 1 {
+		t.Errorf("expected 1 error, got %d", len(parser.Errors))
+	}
+}
+
+// ---------- Error Recovery Tests ----------
+
+func parseSource(t *testing.T, source string) (*Program, []common.Error) {
+	t.Helper()
+
+	lexerResult := lexer.NewLexer().Lex(source)
+	if len(lexerResult.Errors) > 0 {
+		t.Fatalf("unexpected lexer errors: %v", lexerResult.Errors)
+	}
+	parser := NewParser(lexerResult.Tokens)
+	program := parser.ParseProgram()
+	return program, parser.Errors
+}
+
+/*
+*
+Test a program with three independent syntax errors.
+
+- Should report each error once, on its own line
+- Should keep the statements that parsed in the program
+*/
+func TestParseProgram_ReportsAllErrors(t *testing.T) {
+	source := `var a = ;
+var b = 1;
+const c = 2 * (3 + 4;
+var d = 2;
+x + ;
+var e = 3;`
+
+	program, errors := parseSource(t, source)
+
+	if len(errors) != 3 {
+		t.Fatalf("expected 3 errors, got %d: %v", len(errors), errors)
+	}
+	for i, line := range []int{1, 3, 5} {
+		if errors[i].Pos == nil || errors[i].Pos.Line != line {
+			t.Errorf("expected error %d on line %d, got %v", i, line, errors[i].Pos)
+		}
+	}
+	names := []string{}
+	for _, statement := range program.Statements {
+		if declaration, ok := statement.(*Declaration); ok {
+			names = append(names, declaration.Identifier.Name)
+		}
+	}
+	if strings.Join(names, ",") != "b,d,e" {
+		t.Errorf("expected declarations b,d,e to survive, got %v", names)
+	}
+}
+
+/*
+*
+Test an error inside a function body.
+
+- Should skip the broken if statement including its block
+- Should keep the function with the rest of its body
+*/
+func TestParseProgram_RecoversInsideFunctionBody(t *testing.T) {
+	source := `function f(x: int): int {
+  if (x > ) { return 1; }
+  return x;
+}
+}
+var after = 1;`
+
+	program, errors := parseSource(t, source)
+
+	if len(errors) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(errors), errors)
+	}
+	if errors[1].Message != "unexpected '}'" {
+		t.Errorf("expected stray '}' error, got %q", errors[1].Message)
+	}
+	if len(program.Statements) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(program.Statements))
+	}
+	function, ok := program.Statements[0].(*Function)
+	if !ok {
+		t.Fatalf("expected Function, got %T", program.Statements[0])
+	}
+	if len(function.Body) != 1 {
+		t.Fatalf("expected 1 statement in the body, got %d", len(function.Body))
+	}
+	if _, ok := function.Body[0].(*Return); !ok {
+		t.Errorf("expected Return, got %T", function.Body[0])
+	}
+}
+
+/*
+*
+Test programs cut off in the middle of a statement.
+
+- Should report the end of the input instead of accepting the truncated statement
+*/
+func TestParseProgram_TruncatedInput(t *testing.T) {
+	sources := []string{"var m: map[", "var m: map[int]", "var x: []", "var f: (int) ->", "struct S { x:", "enum E { A(", "var x = (1", "if (x) {"}
+
+	for _, source := range sources {
+		_, errors := parseSource(t, source)
+		if len(errors) != 1 || !strings.HasPrefix(errors[0].Message, "unexpected end of input") {
+			t.Errorf("%q: expected an unexpected end of input error, got %v", source, errors)
+		}
+	}
+}
+
+func TestParseProgram_NoNilStatements(t *testing.T) {
+	program, errors := parseSource(t, "{ var a = ; } while (true { } for (;;) [1, ;")
+
+	if len(errors) == 0 {
+		t.Fatal("expected errors")
+	}
+	for i, statement := range program.Statements {
+		if statement == nil {
+			t.Errorf("statement %d is nil", i)
+		}
+	}
+}
+
+func TestParseReturn_WithoutValue(t *testing.T) {
+	program, errors := parseSource(t, "function f(): null { return; }")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	function := program.Statements[0].(*Function)
+	if !function.ReturnType.IsEqual(TypeNull()) {
+		t.Errorf("expected null return type, got %s", function.ReturnType)
+	}
+	ret, ok := function.Body[0].(*Return)
+	if !ok || ret.Value != nil {
+		t.Errorf("expected return without value, got %v", function.Body[0])
+	}
+}
+
+/*
+*
+Test `grid[i][j] += 2;`.
+
+- Should parse an Assignment whose target is the outer IndexExpr
+- Should mark it Compound with the `+` operator
+*/
+func TestParseAssignment_CompoundIndexTarget(t *testing.T) {
+	program, errors := parseSource(t, "grid[i][j] += 2;")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	assign, ok := program.Statements[0].(*Assignment)
+	if !ok {
+		t.Fatalf("expected Assignment, got %T", program.Statements[0])
+	}
+	target, ok := assign.Target.(*IndexExpr)
+	if !ok {
+		t.Fatalf("expected IndexExpr target, got %T", assign.Target)
+	}
+	if target.IsStatement {
+		t.Error("expected target IsStatement to be false")
+	}
+	if _, ok := target.Array.(*IndexExpr); !ok {
+		t.Errorf("expected nested IndexExpr, got %T", target.Array)
+	}
+	if !assign.Compound || assign.Operator != lexer.OperatorPlus {
+		t.Errorf("expected compound '+', got compound=%t operator=%s", assign.Compound, assign.Operator)
+	}
+}
+
+func TestParseAssignment_CallIsNotAssignable(t *testing.T) {
+	_, errors := parseSource(t, "f() = 1;")
+
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(errors), errors)
+	}
+	if errors[0].Message != "only variables, elements and fields can be assigned" {
+		t.Errorf("unexpected error %q", errors[0].Message)
+	}
+}
+
+// ---------- Map Tests ----------
+
+func TestParseMapLiteral(t *testing.T) {
+	program, errors := parseSource(t, `var m: map[string][]int = { "a": [1], "b": [], };`)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	declaration := program.Statements[0].(*Declaration)
+	if declaration.TypeOf.String() != "map[string][]int" {
+		t.Errorf("expected map[string][]int, got %s", declaration.TypeOf)
+	}
+	literal, ok := declaration.Value.(*MapLiteral)
+	if !ok {
+		t.Fatalf("expected MapLiteral, got %T", declaration.Value)
+	}
+	if len(literal.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(literal.Entries))
+	}
+	if key, ok := literal.Entries[1].Key.(*StringLiteral); !ok || key.Value != "b" {
+		t.Errorf("expected second key \"b\", got %v", literal.Entries[1].Key)
+	}
+}
+
+func TestParseMapLiteral_BraceAtStatementStartIsBlock(t *testing.T) {
+	program, errors := parseSource(t, `{ var m = {}; }`)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	block, ok := program.Statements[0].(*Block)
+	if !ok {
+		t.Fatalf("expected Block, got %T", program.Statements[0])
+	}
+	declaration := block.Statements[0].(*Declaration)
+	if literal, ok := declaration.Value.(*MapLiteral); !ok || len(literal.Entries) != 0 {
+		t.Errorf("expected empty MapLiteral, got %v", declaration.Value)
+	}
+}
+
+func TestParseMapType_KeyMustBeHashable(t *testing.T) {
+	_, errors := parseSource(t, "var m: map[float]int;")
+
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(errors), errors)
+	}
+	if errors[0].Message != "map keys must be of type int, bool or string, but got float" {
+		t.Errorf("unexpected error %q", errors[0].Message)
+	}
+}
+
+// ---------- Struct Tests ----------
+
+func TestParseStruct(t *testing.T) {
+	program, errors := parseSource(t, "/// A point.\nstruct Point { x: int, y: []Point, }")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	decl, ok := program.Statements[0].(*Struct)
+	if !ok {
+		t.Fatalf("expected Struct, got %T", program.Statements[0])
+	}
+	if decl.Name != "Point" || decl.Doc != "A point." || len(decl.Fields) != 2 {
+		t.Fatalf("unexpected struct %+v", decl)
+	}
+	if !decl.Fields[1].TypeOf.IsEqual(TypeArrayOf(TypeNamed("Point"))) {
+		t.Errorf("expected field y of type []Point, got %s", decl.Fields[1].TypeOf)
+	}
+}
+
+/*
+*
+Test `s.points[0].x = Point { x: 1, y: 2 }.y;`.
+
+- Should parse the target as a FieldExpr on an IndexExpr on a FieldExpr
+- Should parse the struct literal with its fields in source order
+*/
+func TestParseStructLiteralAndFields(t *testing.T) {
+	program, errors := parseSource(t, "s.points[0].x = Point { x: 1, y: 2 }.y;")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	assign := program.Statements[0].(*Assignment)
+	target, ok := assign.Target.(*FieldExpr)
+	if !ok || target.Field != "x" {
+		t.Fatalf("expected field x as target, got %v", assign.Target)
+	}
+	index, ok := target.Object.(*IndexExpr)
+	if !ok {
+		t.Fatalf("expected IndexExpr, got %T", target.Object)
+	}
+	if inner, ok := index.Array.(*FieldExpr); !ok || inner.Field != "points" {
+		t.Errorf("expected field points, got %v", index.Array)
+	}
+	value, ok := assign.Value.(*FieldExpr)
+	if !ok || value.Field != "y" {
+		t.Fatalf("expected field y as value, got %v", assign.Value)
+	}
+	literal, ok := value.Object.(*StructLiteral)
+	if !ok || literal.Name != "Point" || len(literal.Fields) != 2 || literal.Fields[1].Name != "y" {
+		t.Errorf("unexpected struct literal %v", value.Object)
+	}
+}
+
+func TestParseStructLiteral_IfConditionIsNotLiteral(t *testing.T) {
+	program, errors := parseSource(t, "if (ok) { x = 1; }")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	if _, ok := program.Statements[0].(*If); !ok {
+		t.Errorf("expected If, got %T", program.Statements[0])
+	}
+}
+
+// ---------- Enums ----------
+
+func TestParseEnum(t *testing.T) {
+	program, errors := parseSource(t, "/// A shape.\nenum Shape { Circle(r: int), Rect(w: int, h: int), Empty, }")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	decl, ok := program.Statements[0].(*Enum)
+	if !ok {
+		t.Fatalf("expected Enum, got %T", program.Statements[0])
+	}
+	if decl.Name != "Shape" || decl.Doc != "A shape." || len(decl.Variants) != 3 {
+		t.Fatalf("unexpected enum %+v", decl)
+	}
+	if rect := decl.Variants[1]; rect.Name != "Rect" || len(rect.Fields) != 2 || rect.Fields[1].Name != "h" {
+		t.Errorf("unexpected variant %+v", rect)
+	}
+	if empty := decl.Variants[2]; empty.Name != "Empty" || len(empty.Fields) != 0 {
+		t.Errorf("unexpected variant %+v", empty)
+	}
+}
+
+/*
+*
+Test `var a = match (s) { Rect(w, _) => w, _ => 0 };`.
+
+- Should parse the match as the declaration value
+- Should keep the bindings of each arm, including '_'
+- Should parse the wildcard arm as variant "_"
+*/
+func TestParseMatchExpr(t *testing.T) {
+	program, errors := parseSource(t, "var a = match (s) { Rect(w, _) => w, _ => 0 };")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	decl := program.Statements[0].(*Declaration)
+	match, ok := decl.Value.(*MatchExpr)
+	if !ok {
+		t.Fatalf("expected MatchExpr, got %T", decl.Value)
+	}
+	if len(match.Arms) != 2 {
+		t.Fatalf("expected 2 arms, got %d", len(match.Arms))
+	}
+	rect := match.Arms[0]
+	if rect.Variant != "Rect" || len(rect.Bindings) != 2 || rect.Bindings[0] != "w" || rect.Bindings[1] != "_" {
+		t.Errorf("unexpected arm %+v", rect)
+	}
+	if wildcard := match.Arms[1]; wildcard.Variant != "_" || len(wildcard.Bindings) != 0 {
+		t.Errorf("unexpected arm %+v", wildcard)
+	}
+}
+
+func TestParseMatchExpr_NeedsArms(t *testing.T) {
+	_, errors := parseSource(t, "var a = match (s) {};")
+
+	if len(errors) == 0 || errors[0].Message != "match must have at least one arm" {
+		t.Errorf("expected missing arms error, got %v", errors)
+	}
+}
+
+// ---------- Null Coalescing ----------
+
+/*
+*
+Test `var a = x ?? y ?? 0 == 1;`.
+
+- Should bind looser than `==`
+- Should group to the right, as `x ?? (y ?? (0 == 1))`
+*/
+func TestParseNullCoalesceExpr_RightAssociative(t *testing.T) {
+	program, errors := parseSource(t, "var a = x ?? y ?? 0 == 1;")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	outer, ok := program.Statements[0].(*Declaration).Value.(*BinaryExpr)
+	if !ok || outer.Operator != lexer.OperatorNullCoalesce {
+		t.Fatalf("expected ??, got %v", program.Statements[0].(*Declaration).Value)
+	}
+	if left, ok := outer.Left.(*Identifier); !ok || left.Name != "x" {
+		t.Errorf("expected x on the left, got %v", outer.Left)
+	}
+	inner, ok := outer.Right.(*BinaryExpr)
+	if !ok || inner.Operator != lexer.OperatorNullCoalesce {
+		t.Fatalf("expected ?? on the right, got %v", outer.Right)
+	}
+	if equal, ok := inner.Right.(*BinaryExpr); !ok || equal.Operator != lexer.OperatorEqual {
+		t.Errorf("expected == on the right, got %v", inner.Right)
+	}
+}
+
+// ---------- Generic Functions ----------
+
+func TestParseFunction_TypeParams(t *testing.T) {
+	program, errors := parseSource(t, "function pair<K, V>(k: K, v: V): (K) -> V { return (x: K) => v; }")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	function := program.Statements[0].(*Function)
+	if len(function.TypeParams) != 2 || function.TypeParams[0] != "K" || function.TypeParams[1] != "V" {
+		t.Errorf("expected type params K and V, got %v", function.TypeParams)
+	}
+	if function.ReturnType.String() != "(K) -> V" {
+		t.Errorf("expected (K) -> V, got %s", function.ReturnType)
+	}
+}
+
+func TestParseFunction_TypeParamsNeedClosingBracket(t *testing.T) {
+	_, errors := parseSource(t, "function f<T(x: T): T { return x; }")
+
+	if len(errors) == 0 || errors[0].Pos.Column != 13 {
+		t.Errorf("expected an error at the '(' after T, got %v", errors)
+	}
+}
+
+// ---------- Modules ----------
+
+func TestParseImportAndExport(t *testing.T) {
+	source := `
+		import "lib/geo.wmofn";
+		/// The origin.
+		export const origin = 0;
+		export function f(): int { return 1; }
+		export struct P { x: int }
+		export enum E { A }
+		function g(): int { return 2; }
+	`
+	program, errors := parseSource(t, source)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	if n, ok := program.Statements[0].(*Import); !ok || n.Path != "lib/geo.wmofn" {
+		t.Errorf("expected an import of lib/geo.wmofn, got %#v", program.Statements[0])
+	}
+	origin := program.Statements[1].(*Declaration)
+	if !origin.Exported || origin.Doc != "The origin." {
+		t.Errorf("expected an exported, documented constant, got exported %t and doc %q", origin.Exported, origin.Doc)
+	}
+	if !program.Statements[2].(*Function).Exported || !program.Statements[3].(*Struct).Exported || !program.Statements[4].(*Enum).Exported {
+		t.Error("expected the function, struct and enum to be exported")
+	}
+	if program.Statements[5].(*Function).Exported {
+		t.Error("expected g not to be exported")
+	}
+}
+
+func TestParseExport_RejectsVariables(t *testing.T) {
+	_, errors := parseSource(t, "export var x = 1;")
+
+	if len(errors) == 0 || errors[0].Message != "exported variables must be constants, use 'export const'" {
+		t.Errorf("expected an export error, got %v", errors)
+	}
+}
+
+// ---------- Dump ----------
+
+func TestDump(t *testing.T) {
+	program, errors := parseSource(t, "var x: int? = -a + 1;\nx += 2;")
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	expected := `Program @1:1
+  Statements[0]: Declaration IsMutable IsTyped TypeOf=int? @1:1
+    Identifier: Identifier Name="x" @1:5
+    Value: BinaryExpr Operator=+ @1:18
+      Left: UnaryExpr Operator=- @1:15
+        Operand: Identifier Name="a" @1:16
+      Right: IntLiteral Value=1 @1:20
+  Statements[1]: Assignment Compound Operator=+ @2:1
+    Target: Identifier Name="x" @2:1
+    Value: IntLiteral Value=2 @2:6
+`
+	if dump := Dump(program); dump != expected {
+		t.Errorf("unexpected dump:\n%s", dump)
+	}
+}
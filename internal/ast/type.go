@@ -2,6 +2,7 @@ package ast
 
 import (
 	"fmt"
+	"strings"
 
 	"youpiteron.dev/white-monster-on-friday-night/internal/lexer"
 )
@@ -16,6 +17,13 @@ const (
 	TYPE_CLOSURE
 	TYPE_NATIVE_FUNCTION
 	TYPE_ARRAY
+	TYPE_STRING
+	TYPE_ANY
+	TYPE_FLOAT
+	TYPE_MAP
+	TYPE_PARAM
+	TYPE_NAMED
+	TYPE_NULLABLE
 )
 
 func (t TypeEnum) String() string {
@@ -27,27 +35,54 @@ func (t TypeEnum) String() string {
 		"closure",
 		"native_function",
 		"array",
+		"string",
+		"any",
+		"float",
+		"map",
+		"param",
+		"named",
+		"nullable",
 	}[t]
 }
 
+// Signature describes the parameters and the return type of a callable type.
+// Vararg means that the last param is an array collecting the remaining
+// arguments.
+type Signature struct {
+	Params     []*Type
+	ReturnType *Type
+	Vararg     bool
+}
+
+// Type describes a value at compile time. ElementType is the element of an
+// array, the value of a map or the type wrapped by a nullable type, KeyType is the key of a map and Name is the
+// name of a type parameter or of a named type.
 type Type struct {
 	Type        TypeEnum
 	ElementType *Type
+	KeyType     *Type
+	Signature   *Signature
+	Name        string
 }
 
 var primitiveTypes = map[TypeEnum]*Type{
-	TYPE_INT:             {Type: TYPE_INT},
-	TYPE_BOOL:            {Type: TYPE_BOOL},
-	TYPE_NULL:            {Type: TYPE_NULL},
-	TYPE_VOID:            {Type: TYPE_VOID},
-	TYPE_CLOSURE:         {Type: TYPE_CLOSURE},
-	TYPE_NATIVE_FUNCTION: {Type: TYPE_NATIVE_FUNCTION},
+	TYPE_INT:    {Type: TYPE_INT},
+	TYPE_BOOL:   {Type: TYPE_BOOL},
+	TYPE_NULL:   {Type: TYPE_NULL},
+	TYPE_VOID:   {Type: TYPE_VOID},
+	TYPE_STRING: {Type: TYPE_STRING},
+	TYPE_ANY:    {Type: TYPE_ANY},
+	TYPE_FLOAT:  {Type: TYPE_FLOAT},
 }
 
 func TypeInt() *Type {
 	return primitiveTypes[TYPE_INT]
 }
 
+func TypeFloat() *Type {
+	return primitiveTypes[TYPE_FLOAT]
+}
+
 func TypeBool() *Type {
 	return primitiveTypes[TYPE_BOOL]
 }
@@ -60,18 +95,95 @@ func TypeVoid() *Type {
 	return primitiveTypes[TYPE_VOID]
 }
 
-func TypeClosure() *Type {
-	return primitiveTypes[TYPE_CLOSURE]
+// TypeFunction is the type of closures with the given signature, it is what
+// `(int, bool) -> int` annotations parse to.
+func TypeFunction(signature *Signature) *Type {
+	return &Type{Type: TYPE_CLOSURE, Signature: signature}
+}
+
+func TypeNativeFunctionOf(signature *Signature) *Type {
+	return &Type{Type: TYPE_NATIVE_FUNCTION, Signature: signature}
+}
+
+func TypeString() *Type {
+	return primitiveTypes[TYPE_STRING]
 }
 
-func TypeNativeFunction() *Type {
-	return primitiveTypes[TYPE_NATIVE_FUNCTION]
+// TypeAny is only used in native function signatures, it accepts a value of
+// any type.
+func TypeAny() *Type {
+	return primitiveTypes[TYPE_ANY]
 }
 
 func TypeArrayOf(elementType *Type) *Type {
 	return &Type{Type: TYPE_ARRAY, ElementType: elementType}
 }
 
+func TypeMapOf(keyType *Type, valueType *Type) *Type {
+	return &Type{Type: TYPE_MAP, KeyType: keyType, ElementType: valueType}
+}
+
+// TypeParam is a placeholder in a native function signature, it is bound to
+// the type of the argument passed in its place, see Unify.
+func TypeParam(name string) *Type {
+	return &Type{Type: TYPE_PARAM, Name: name}
+}
+
+// TypeNamed refers to a type declared in the script, such as a struct. Named
+// types are nominal, two of them are equal only when their names are.
+func TypeNamed(name string) *Type {
+	return &Type{Type: TYPE_NAMED, Name: name}
+}
+
+// TypeNullableOf is `T?`, a value of type T or null. Wrapping null or a type
+// that is already nullable returns it unchanged.
+func TypeNullableOf(t *Type) *Type {
+	if t.Type == TYPE_NULL || t.Type == TYPE_NULLABLE {
+		return t
+	}
+	return &Type{Type: TYPE_NULLABLE, ElementType: t}
+}
+
+// IsNullable reports whether null is a value of this type.
+func (t *Type) IsNullable() bool {
+	return t.Type == TYPE_NULLABLE || t.Type == TYPE_NULL
+}
+
+// NonNull is the type of a value of type t once it is known not to be null.
+func (t *Type) NonNull() *Type {
+	if t.Type == TYPE_NULLABLE {
+		return t.ElementType
+	}
+	return t
+}
+
+// Join is the type of a value that is either of type t or of type other, as
+// the result of a conditional expression. It is nil when no such type exists.
+func (t *Type) Join(other *Type) *Type {
+	if t.Accepts(other) {
+		return t
+	}
+	if other.Accepts(t) {
+		return other
+	}
+	if t.IsNullable() || other.IsNullable() {
+		base := t
+		if t.Type == TYPE_NULL {
+			base = other
+		}
+		nullable := TypeNullableOf(base.NonNull())
+		if nullable.Accepts(t) && nullable.Accepts(other) {
+			return nullable
+		}
+	}
+	return nil
+}
+
+// IsHashable reports whether values of this type can be used as map keys.
+func (t *Type) IsHashable() bool {
+	return t.Type == TYPE_INT || t.Type == TYPE_BOOL || t.Type == TYPE_STRING
+}
+
 func TypeFromTypeSubkind(typeSubkind lexer.TypeSubkind) *Type {
 	switch typeSubkind {
 	case lexer.TypeInt:
@@ -80,24 +192,138 @@ func TypeFromTypeSubkind(typeSubkind lexer.TypeSubkind) *Type {
 		return TypeBool()
 	case lexer.TypeNull:
 		return TypeNull()
+	case lexer.TypeString:
+		return TypeString()
+	case lexer.TypeFloat:
+		return TypeFloat()
 	default:
 		panic(fmt.Sprintf("invalid type subkind %s", typeSubkind.String()))
 	}
 }
 
 func (t *Type) IsEqual(other *Type) bool {
-	if t.Type != other.Type {
+	if t.Type != other.Type || t.Name != other.Name {
 		return false
 	}
-	if t.ElementType == nil {
+	if !t.Signature.IsEqual(other.Signature) {
+		return false
+	}
+	if !isEqualOrNil(t.KeyType, other.KeyType) {
+		return false
+	}
+	return isEqualOrNil(t.ElementType, other.ElementType)
+}
+
+func isEqualOrNil(t *Type, other *Type) bool {
+	if t == nil || other == nil {
+		return t == other
+	}
+	return t.IsEqual(other)
+}
+
+// IsComplete reports whether every element type is known. The empty array
+// literal `[]` and the empty map literal `{}` have no element type, so
+// neither has `[[]]`.
+func (t *Type) IsComplete() bool {
+	for t.Type == TYPE_ARRAY || t.Type == TYPE_MAP || t.Type == TYPE_NULLABLE {
+		if t.ElementType == nil {
+			return false
+		}
+		t = t.ElementType
+	}
+	return true
+}
+
+// IsCallable reports whether values of this type can be called, closures and
+// native functions are interchangeable wherever their signatures match.
+func (t *Type) IsCallable() bool {
+	return t.Type == TYPE_CLOSURE || t.Type == TYPE_NATIVE_FUNCTION
+}
+
+// Accepts reports whether a value of type other can be passed where t is
+// expected.
+func (t *Type) Accepts(other *Type) bool {
+	if t.Type == TYPE_ANY {
 		return true
 	}
-	return t.ElementType.IsEqual(other.ElementType)
+	if t.Type == TYPE_NULLABLE {
+		if other.Type == TYPE_NULL {
+			return true
+		}
+		return t.ElementType.Accepts(other.NonNull())
+	}
+	if t.Type == TYPE_ARRAY && other.Type == TYPE_ARRAY {
+		// an empty array literal fits any array type
+		if other.ElementType == nil {
+			return true
+		}
+		if t.ElementType == nil {
+			return false
+		}
+		return t.ElementType.Accepts(other.ElementType)
+	}
+	if t.Type == TYPE_MAP && other.Type == TYPE_MAP {
+		// as with arrays, the empty map literal fits any map type
+		if other.ElementType == nil {
+			return true
+		}
+		if t.ElementType == nil {
+			return false
+		}
+		return t.KeyType.IsEqual(other.KeyType) && t.ElementType.Accepts(other.ElementType)
+	}
+	if t.IsCallable() && other.IsCallable() {
+		return t.Signature.IsEqual(other.Signature)
+	}
+	return t.IsEqual(other)
 }
 
 func (t *Type) String() string {
+	if t.Signature != nil {
+		return t.Signature.String()
+	}
+	if t.Type == TYPE_PARAM || t.Type == TYPE_NAMED {
+		return t.Name
+	}
 	if t.ElementType == nil {
 		return t.Type.String()
 	}
+	if t.Type == TYPE_NULLABLE {
+		if t.ElementType.Signature != nil {
+			return fmt.Sprintf("(%s)?", t.ElementType.String())
+		}
+		return t.ElementType.String() + "?"
+	}
+	if t.Type == TYPE_MAP {
+		return fmt.Sprintf("map[%s]%s", t.KeyType.String(), t.ElementType.String())
+	}
 	return fmt.Sprintf("[]%s", t.ElementType.String())
 }
+
+// IsEqual compares params and return types exactly, a nil signature is only
+// equal to another nil signature.
+func (s *Signature) IsEqual(other *Signature) bool {
+	if s == nil || other == nil {
+		return s == other
+	}
+	if s.Vararg != other.Vararg || len(s.Params) != len(other.Params) {
+		return false
+	}
+	for i, param := range s.Params {
+		if !param.IsEqual(other.Params[i]) {
+			return false
+		}
+	}
+	return s.ReturnType.IsEqual(other.ReturnType)
+}
+
+func (s *Signature) String() string {
+	params := make([]string, len(s.Params))
+	for i, param := range s.Params {
+		params[i] = param.String()
+		if s.Vararg && i == len(s.Params)-1 {
+			params[i] = "..." + param.ElementType.String()
+		}
+	}
+	return fmt.Sprintf("(%s) -> %s", strings.Join(params, ", "), s.ReturnType.String())
+}
@@ -0,0 +1,162 @@
+package ast
+
+import "slices"
+
+// HasTypeParams reports whether a type parameter appears anywhere in t.
+func (t *Type) HasTypeParams() bool {
+	if t == nil {
+		return false
+	}
+	if t.Type == TYPE_PARAM {
+		return true
+	}
+	if t.ElementType.HasTypeParams() || t.KeyType.HasTypeParams() {
+		return true
+	}
+	if t.Signature == nil {
+		return false
+	}
+	for _, param := range t.Signature.Params {
+		if param.HasTypeParams() {
+			return true
+		}
+	}
+	return t.Signature.ReturnType.HasTypeParams()
+}
+
+// UnboundParam returns the name of a type parameter of t that bindings leaves
+// unbound, the empty string when every one of them is bound.
+func (t *Type) UnboundParam(bindings map[string]*Type) string {
+	if !t.HasTypeParams() {
+		return ""
+	}
+	if t.Type == TYPE_PARAM {
+		if _, ok := bindings[t.Name]; ok {
+			return ""
+		}
+		return t.Name
+	}
+	if name := t.ElementType.UnboundParam(bindings); name != "" {
+		return name
+	}
+	if name := t.KeyType.UnboundParam(bindings); name != "" {
+		return name
+	}
+	if t.Signature == nil {
+		return ""
+	}
+	for _, param := range t.Signature.Params {
+		if name := param.UnboundParam(bindings); name != "" {
+			return name
+		}
+	}
+	return t.Signature.ReturnType.UnboundParam(bindings)
+}
+
+// Unify matches the expected type param against the actual type of an
+// argument, binding the type parameters found in param. A parameter that is
+// already bound has to accept the new type, unless the new type is the more
+// complete one, as when `[]` is bound first and `[1]` comes after it.
+func Unify(param *Type, actual *Type, bindings map[string]*Type) bool {
+	switch param.Type {
+	case TYPE_PARAM:
+		bound, ok := bindings[param.Name]
+		if !ok || (!bound.Accepts(actual) && actual.Accepts(bound)) {
+			bindings[param.Name] = actual
+			return true
+		}
+		return bound.Accepts(actual)
+	case TYPE_ARRAY:
+		if actual.Type != TYPE_ARRAY {
+			return false
+		}
+		if actual.ElementType == nil {
+			return true
+		}
+		return Unify(param.ElementType, actual.ElementType, bindings)
+	case TYPE_NULLABLE:
+		if actual.Type == TYPE_NULL {
+			return true
+		}
+		return Unify(param.ElementType, actual.NonNull(), bindings)
+	case TYPE_MAP:
+		if actual.Type != TYPE_MAP {
+			return false
+		}
+		if actual.ElementType == nil {
+			return true
+		}
+		return Unify(param.KeyType, actual.KeyType, bindings) && Unify(param.ElementType, actual.ElementType, bindings)
+	}
+	if param.IsCallable() && param.HasTypeParams() {
+		if !actual.IsCallable() || actual.Signature == nil {
+			return false
+		}
+		if param.Signature.Vararg != actual.Signature.Vararg || len(param.Signature.Params) != len(actual.Signature.Params) {
+			return false
+		}
+		for i, p := range param.Signature.Params {
+			if !Unify(p, actual.Signature.Params[i], bindings) {
+				return false
+			}
+		}
+		return Unify(param.Signature.ReturnType, actual.Signature.ReturnType, bindings)
+	}
+	return param.Accepts(actual)
+}
+
+// Substitute replaces the bound type parameters in t. A parameter that is not
+// bound is unknown, so `[]T` becomes the type of an empty array and a bare `T`
+// becomes nil. Call sites reject such types with UnboundParam first.
+func (t *Type) Substitute(bindings map[string]*Type) *Type {
+	if !t.HasTypeParams() {
+		return t
+	}
+	if t.Type == TYPE_PARAM {
+		return bindings[t.Name]
+	}
+	substituted := &Type{Type: t.Type, Name: t.Name}
+	if t.ElementType != nil {
+		substituted.ElementType = t.ElementType.Substitute(bindings)
+	}
+	if t.KeyType != nil {
+		substituted.KeyType = t.KeyType.Substitute(bindings)
+	}
+	if t.Signature != nil {
+		params := make([]*Type, len(t.Signature.Params))
+		for i, param := range t.Signature.Params {
+			params[i] = param.Substitute(bindings)
+		}
+		substituted.Signature = &Signature{
+			Params:     params,
+			ReturnType: t.Signature.ReturnType.Substitute(bindings),
+			Vararg:     t.Signature.Vararg,
+		}
+	}
+	return substituted
+}
+
+// Quantify turns the named types called like one of names into type
+// parameters. A generic function sees `T` as an opaque named type in its body,
+// while callers see the type parameter T that Unify binds at each call.
+func (t *Type) Quantify(names []string) *Type {
+	if t == nil || len(names) == 0 {
+		return t
+	}
+	if t.Type == TYPE_NAMED && slices.Contains(names, t.Name) {
+		return TypeParam(t.Name)
+	}
+	quantified := &Type{Type: t.Type, Name: t.Name, ElementType: t.ElementType.Quantify(names), KeyType: t.KeyType.Quantify(names)}
+	if t.Signature != nil {
+		params := make([]*Type, len(t.Signature.Params))
+		for i, param := range t.Signature.Params {
+			params[i] = param.Quantify(names)
+		}
+		quantified.Signature = &Signature{
+			Params:     params,
+			ReturnType: t.Signature.ReturnType.Quantify(names),
+			Vararg:     t.Signature.Vararg,
+		}
+	}
+	return quantified
+}
@@ -0,0 +1,85 @@
+package ast
+
+import "testing"
+
+func TestUnify_BindsTypeParams(t *testing.T) {
+	param := TypeMapOf(TypeParam("K"), TypeArrayOf(TypeParam("V")))
+	bindings := map[string]*Type{}
+
+	if !Unify(param, TypeMapOf(TypeString(), TypeArrayOf(TypeBool())), bindings) {
+		t.Fatal("expected map[string][]bool to unify with map[K][]V")
+	}
+	if !bindings["K"].IsEqual(TypeString()) || !bindings["V"].IsEqual(TypeBool()) {
+		t.Errorf("expected K = string and V = bool, got K = %v and V = %v", bindings["K"], bindings["V"])
+	}
+	if Unify(TypeParam("K"), TypeInt(), bindings) {
+		t.Error("expected K bound to string to reject int")
+	}
+	returnType := TypeArrayOf(TypeParam("K")).Substitute(bindings)
+	if !returnType.IsEqual(TypeArrayOf(TypeString())) {
+		t.Errorf("expected []string, got %s", returnType)
+	}
+}
+
+func TestUnify_EmptyLiteralLeavesParamUnbound(t *testing.T) {
+	param := TypeArrayOf(TypeParam("T"))
+	bindings := map[string]*Type{}
+
+	if !Unify(param, TypeArrayOf(nil), bindings) {
+		t.Fatal("expected the empty array to unify with []T")
+	}
+	if _, ok := bindings["T"]; ok {
+		t.Error("expected T to stay unbound")
+	}
+	if param.Substitute(bindings).IsComplete() {
+		t.Error("expected []T with T unbound to be incomplete")
+	}
+	if name := TypeFunction(&Signature{ReturnType: TypeNullableOf(param)}).UnboundParam(bindings); name != "T" {
+		t.Errorf("expected T to be reported unbound, got %q", name)
+	}
+}
+
+func TestUnify_NullableParam(t *testing.T) {
+	param := TypeNullableOf(TypeParam("T"))
+	bindings := map[string]*Type{}
+
+	if !Unify(param, TypeNull(), bindings) {
+		t.Fatal("expected null to unify with T?")
+	}
+	if _, ok := bindings["T"]; ok {
+		t.Error("expected null to leave T unbound")
+	}
+	if !Unify(param, TypeNullableOf(TypeInt()), bindings) || !bindings["T"].IsEqual(TypeInt()) {
+		t.Errorf("expected int? to bind T = int, got %v", bindings["T"])
+	}
+}
+
+func TestJoin_Nullable(t *testing.T) {
+	if joined := TypeInt().Join(TypeNull()); joined == nil || !joined.IsEqual(TypeNullableOf(TypeInt())) {
+		t.Errorf("expected int?, got %v", joined)
+	}
+	if joined := TypeNullableOf(TypeInt()).Join(TypeInt()); joined == nil || joined.String() != "int?" {
+		t.Errorf("expected int?, got %v", joined)
+	}
+	if joined := TypeNullableOf(TypeInt()).Join(TypeString()); joined != nil {
+		t.Errorf("expected int? and string not to join, got %s", joined)
+	}
+	if TypeInt().Accepts(TypeNullableOf(TypeInt())) {
+		t.Error("expected int to reject int?")
+	}
+}
+
+func TestQuantify_TurnsTypeParamsIntoParams(t *testing.T) {
+	signature := &Signature{Params: []*Type{TypeArrayOf(TypeNamed("T")), TypeNamed("Point")}, ReturnType: TypeNullableOf(TypeNamed("T"))}
+	quantified := TypeFunction(signature).Quantify([]string{"T"})
+
+	if quantified.Signature.Params[0].ElementType.Type != TYPE_PARAM || quantified.Signature.ReturnType.ElementType.Type != TYPE_PARAM {
+		t.Errorf("expected T to become a type parameter, got %s", quantified)
+	}
+	if quantified.Signature.Params[1].Type != TYPE_NAMED {
+		t.Errorf("expected Point to stay a named type, got %s", quantified.Signature.Params[1])
+	}
+	if signature.Params[0].ElementType.Type != TYPE_NAMED {
+		t.Error("expected the original signature to be left untouched")
+	}
+}
@@ -6,15 +6,31 @@ type Visitor[R any] interface {
 	VisitAssignment(n *Assignment) R
 	VisitReturn(n *Return) R
 	VisitIntLiteral(n *IntLiteral) R
+	VisitFloatLiteral(n *FloatLiteral) R
 	VisitBoolLiteral(n *BoolLiteral) R
+	VisitStringLiteral(n *StringLiteral) R
 	VisitNullLiteral(n *NullLiteral) R
 	VisitArrayLiteral(n *ArrayLiteral) R
+	VisitMapLiteral(n *MapLiteral) R
+	VisitStructLiteral(n *StructLiteral) R
 	VisitIdentifier(n *Identifier) R
 	VisitBinaryExpr(n *BinaryExpr) R
+	VisitUnaryExpr(n *UnaryExpr) R
+	VisitConditionalExpr(n *ConditionalExpr) R
+	VisitMatchExpr(n *MatchExpr) R
 	VisitIndexExpr(n *IndexExpr) R
+	VisitFieldExpr(n *FieldExpr) R
 	VisitParam(n *Param) R
 	VisitFunction(n *Function) R
+	VisitFunctionExpr(n *FunctionExpr) R
+	VisitStruct(n *Struct) R
+	VisitEnum(n *Enum) R
 	VisitBlock(n *Block) R
 	VisitCallExpr(n *CallExpr) R
 	VisitIf(n *If) R
+	VisitWhile(n *While) R
+	VisitFor(n *For) R
+	VisitBreak(n *Break) R
+	VisitContinue(n *Continue) R
+	VisitImport(n *Import) R
 }
@@ -2,9 +2,12 @@ package common
 
 import "fmt"
 
+// Error is a diagnostic at Pos. File is the path of the file it was found in,
+// it is empty when only one file was compiled.
 type Error struct {
 	Message string
 	Pos     *SourcePos
+	File    string
 }
 
 func (e *Error) String() string {
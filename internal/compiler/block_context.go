@@ -1,15 +1,28 @@
 package compiler
 
-import "youpiteron.dev/white-monster-on-friday-night/internal/ast"
+import (
+	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+)
 
 type BlockContext struct {
 	parent         Context
+	startVarSlot   int
 	currentVarSlot int
+	maxVarSlot     int
 	variables      map[string]Variable
 }
 
 func NewBlockContext(parent Context) *BlockContext {
-	return &BlockContext{parent: parent, variables: make(map[string]Variable), currentVarSlot: parent.VarSlot()}
+	return &BlockContext{parent: parent, variables: make(map[string]Variable), startVarSlot: parent.VarSlot(), currentVarSlot: parent.VarSlot(), maxVarSlot: parent.VarSlot()}
+}
+
+func CastBlockContext(context Context) *BlockContext {
+	blockContext, ok := context.(*BlockContext)
+	if !ok {
+		panic("COMPILER ERROR: context is not a block context")
+	}
+	return blockContext
 }
 
 func (c *BlockContext) ImplementContextInterface() Context {
@@ -18,15 +31,9 @@ func (c *BlockContext) ImplementContextInterface() Context {
 
 func (c *BlockContext) DefineVariable(name string, mutable bool, typeOf *ast.Type) int {
 	slot := c.currentVarSlot
-	c.variables[name] = Variable{Name: name, Slot: slot, Mutable: mutable, TypeOf: typeOf, FuncSignature: nil}
-	c.currentVarSlot++
-	return slot
-}
-
-func (c *BlockContext) DefineFunctionVariable(name string, mutable bool, typeOf *ast.Type, funcSignature *FuncSignature) int {
-	slot := c.currentVarSlot
-	c.variables[name] = Variable{Name: name, Slot: slot, Mutable: mutable, TypeOf: typeOf, FuncSignature: funcSignature}
+	c.variables[name] = Variable{Name: name, Slot: slot, Mutable: mutable, TypeOf: typeOf}
 	c.currentVarSlot++
+	c.ReserveVarSlot(slot)
 	return slot
 }
 
@@ -66,11 +73,11 @@ func (c *BlockContext) FindVariable(name string) (*Variable, *Upvar, bool) {
 	return nil, nil, false
 }
 
-func (c *BlockContext) AddInstruction(instruction Instruction) int {
+func (c *BlockContext) AddInstruction(instruction Instruction, pos *common.SourcePos) int {
 	if c.parent == nil {
 		panic("COMPILER ERROR: cannot add instruction to root block context")
 	}
-	return c.parent.AddInstruction(instruction)
+	return c.parent.AddInstruction(instruction, pos)
 }
 
 func (c *BlockContext) SetInstruction(index int, instruction Instruction) {
@@ -94,12 +101,30 @@ func (c *BlockContext) AddParam(param *ast.Type) {
 	c.parent.AddParam(param)
 }
 
+// ReserveVarSlot records that slot is used by this block or one of its nested
+// blocks, and propagates it to the enclosing function or module so that the
+// frame is allocated with enough locals.
+func (c *BlockContext) ReserveVarSlot(slot int) {
+	if slot >= c.maxVarSlot {
+		c.maxVarSlot = slot + 1
+	}
+	if c.parent != nil {
+		c.parent.ReserveVarSlot(slot)
+	}
+}
+
 // ---------- Getters ----------
 
 func (c *BlockContext) VarSlot() int {
 	return c.currentVarSlot
 }
 
+// VarSlotRange returns the half-open range of local slots used by this block
+// and its nested blocks.
+func (c *BlockContext) VarSlotRange() (int, int) {
+	return c.startVarSlot, c.maxVarSlot
+}
+
 func (c *BlockContext) InstructionsLength() int {
 	if c.parent == nil {
 		panic("COMPILER ERROR: cannot get instructions length in root block context")
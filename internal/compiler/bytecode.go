@@ -0,0 +1,635 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+)
+
+// A bytecode file is the magic number, the format version, the length of the
+// payload, the payload and its CRC-32. The payload holds the names of the
+// globals, then the imported modules in the order they run, then the main
+// module. Opcodes are stored by their number, so changing their order or
+// removing one needs a new BytecodeVersion.
+const BytecodeVersion = 2
+
+var bytecodeMagic = []byte("WMOF")
+
+const bytecodeHeaderLength = 4 + 2 + 4
+
+// IsBytecode reports whether data starts like a file written by Save.
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, bytecodeMagic)
+}
+
+// Save writes the compiled program in the bytecode format. Warnings are not
+// saved.
+func Save(w io.Writer, result *CompileResult) error {
+	e := &encoder{}
+	e.uvarint(uint64(result.GlobalTable.Length()))
+	for _, variable := range result.GlobalTable.variables {
+		e.string(variable.Name)
+		e.bool(variable.Mutable)
+	}
+	e.uvarint(uint64(len(result.Imports) + 1))
+	for i := range result.Imports {
+		e.module(&result.Imports[i])
+	}
+	e.module(&result.ModuleProto)
+	if e.err != nil {
+		return e.err
+	}
+
+	header := make([]byte, 0, bytecodeHeaderLength)
+	header = append(header, bytecodeMagic...)
+	header = binary.LittleEndian.AppendUint16(header, BytecodeVersion)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(e.buf)))
+	data := append(header, e.buf...)
+	data = binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(e.buf))
+	_, err := w.Write(data)
+	return err
+}
+
+// Load reads a program written by Save. Input that is not bytecode, that was
+// written by another version or that is corrupt is reported as an error.
+func Load(r io.Reader) (*CompileResult, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !IsBytecode(data) {
+		return nil, errors.New("not a bytecode file")
+	}
+	if len(data) < bytecodeHeaderLength {
+		return nil, errors.New("corrupt bytecode: truncated header")
+	}
+	if version := binary.LittleEndian.Uint16(data[4:]); version != BytecodeVersion {
+		return nil, fmt.Errorf("bytecode version %d is not supported, expected %d", version, BytecodeVersion)
+	}
+	length := uint64(binary.LittleEndian.Uint32(data[6:]))
+	if uint64(len(data)) != bytecodeHeaderLength+length+4 {
+		return nil, errors.New("corrupt bytecode: wrong length")
+	}
+	payload := data[bytecodeHeaderLength : bytecodeHeaderLength+length]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[bytecodeHeaderLength+length:]) {
+		return nil, errors.New("corrupt bytecode: checksum mismatch")
+	}
+
+	d := &decoder{data: payload}
+	globalTable := NewGlobalTable()
+	numGlobals := d.count()
+	for i := 0; i < numGlobals && d.err == nil; i++ {
+		globalTable.DefineVariable(d.string(), d.bool(), nil)
+	}
+	numModules := d.count()
+	if d.err == nil && numModules == 0 {
+		d.fail("no module")
+	}
+	modules := []ModuleProto{}
+	for i := 0; i < numModules && d.err == nil; i++ {
+		modules = append(modules, *d.module(globalTable.Length()))
+	}
+	if d.err == nil && d.pos != len(d.data) {
+		d.fail("trailing data")
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	importPaths := []string{}
+	for _, imported := range modules[:len(modules)-1] {
+		importPaths = append(importPaths, imported.Path())
+	}
+	return &CompileResult{
+		ModuleProto: modules[len(modules)-1],
+		GlobalTable: globalTable,
+		Warnings:    []common.Error{},
+		Imports:     modules[:len(modules)-1],
+		ImportPaths: importPaths,
+	}, nil
+}
+
+// ---------- Encoder ----------
+
+type encoder struct {
+	buf []byte
+	err error
+}
+
+func (e *encoder) uvarint(value uint64) {
+	e.buf = binary.AppendUvarint(e.buf, value)
+}
+
+func (e *encoder) varint(value int) {
+	e.buf = binary.AppendVarint(e.buf, int64(value))
+}
+
+func (e *encoder) bool(value bool) {
+	if value {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) string(value string) {
+	e.uvarint(uint64(len(value)))
+	e.buf = append(e.buf, value...)
+}
+
+func (e *encoder) strings(values []string) {
+	e.uvarint(uint64(len(values)))
+	for _, value := range values {
+		e.string(value)
+	}
+}
+
+func (e *encoder) module(m *ModuleProto) {
+	e.string(m.path)
+	e.uvarint(uint64(m.numLocals))
+	e.code(m.instructions, m.positions, m.constants)
+	e.uvarint(uint64(len(m.functions)))
+	for i := range m.functions {
+		f := &m.functions[i]
+		e.string(f.name)
+		e.uvarint(uint64(f.numLocals))
+		e.code(f.instructions, f.positions, f.constants)
+		e.uvarint(uint64(len(f.upvars)))
+		for _, upvar := range f.upvars {
+			e.uvarint(uint64(upvar.SlotInParent))
+			e.bool(upvar.IsFromParent)
+		}
+	}
+}
+
+// code writes the instructions of a proto with their positions and the
+// constants they load.
+func (e *encoder) code(instructions []Instruction, positions []*common.SourcePos, constants []Value) {
+	e.uvarint(uint64(len(instructions)))
+	for i, instruction := range instructions {
+		e.uvarint(uint64(instruction.OpCode))
+		e.uvarint(uint64(len(instruction.Args)))
+		for _, arg := range instruction.Args {
+			e.varint(arg)
+		}
+		var pos *common.SourcePos
+		if i < len(positions) {
+			pos = positions[i]
+		}
+		e.bool(pos != nil)
+		if pos != nil {
+			e.uvarint(uint64(pos.Offset))
+			e.uvarint(uint64(pos.Line))
+			e.uvarint(uint64(pos.Column))
+			e.uvarint(uint64(pos.Length))
+		}
+	}
+	e.uvarint(uint64(len(constants)))
+	for _, constant := range constants {
+		e.value(constant)
+	}
+}
+
+// value writes a constant. Only the values the compiler puts in constants
+// can be saved, the descriptions of structs and enums included.
+func (e *encoder) value(value Value) {
+	e.uvarint(uint64(value.TypeOf))
+	switch value.TypeOf {
+	case VAL_INT:
+		e.varint(value.Int)
+	case VAL_FLOAT:
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(value.Float))
+	case VAL_BOOL:
+		e.bool(value.Bool)
+	case VAL_STRING:
+		e.string(value.String)
+	case VAL_NULL:
+	case VAL_ARRAY:
+		e.uvarint(uint64(len(value.Array)))
+		for _, element := range value.Array {
+			e.value(element)
+		}
+	case VAL_STRUCT:
+		e.string(value.Struct.Desc.Name)
+		e.strings(value.Struct.Desc.Fields)
+	case VAL_VARIANT:
+		e.string(value.Variant.Desc.Name)
+		e.uvarint(uint64(len(value.Variant.Desc.Variants)))
+		for _, variant := range value.Variant.Desc.Variants {
+			e.string(variant.Name)
+			e.strings(variant.Fields)
+		}
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("cannot save a constant of type %s", value.TypeOf)
+		}
+	}
+}
+
+// ---------- Decoder ----------
+
+// decoder reads the payload. The first error sticks, every read after it
+// returns a zero value, so callers check err once they are done.
+type decoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *decoder) fail(reason string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("corrupt bytecode: %s", reason)
+	}
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail("bad number")
+		return 0
+	}
+	d.pos += n
+	return value
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail("bad number")
+		return 0
+	}
+	d.pos += n
+	return value
+}
+
+// arg reads an instruction argument: a register, a slot, an index or a jump
+// target, which all fit in 32 bits.
+func (d *decoder) arg() int {
+	value := d.varint()
+	if value < math.MinInt32 || value > math.MaxInt32 {
+		d.fail("argument out of range")
+		return 0
+	}
+	return int(value)
+}
+
+// count reads a length. Every element takes at least a byte, so a length
+// larger than what is left is corrupt and never allocated.
+func (d *decoder) count() int {
+	value := d.uvarint()
+	if value > uint64(len(d.data)-d.pos) {
+		d.fail("length out of range")
+		return 0
+	}
+	return int(value)
+}
+
+// index reads a non negative number such as a slot or a line.
+func (d *decoder) index() int {
+	value := d.uvarint()
+	if value > math.MaxInt32 {
+		d.fail("number out of range")
+		return 0
+	}
+	return int(value)
+}
+
+func (d *decoder) bool() bool {
+	if d.err != nil {
+		return false
+	}
+	if d.pos >= len(d.data) || d.data[d.pos] > 1 {
+		d.fail("bad bool")
+		return false
+	}
+	d.pos++
+	return d.data[d.pos-1] == 1
+}
+
+func (d *decoder) string() string {
+	length := d.count()
+	if d.err != nil {
+		return ""
+	}
+	value := string(d.data[d.pos : d.pos+length])
+	d.pos += length
+	return value
+}
+
+func (d *decoder) strings() []string {
+	var values []string
+	count := d.count()
+	for i := 0; i < count && d.err == nil; i++ {
+		values = append(values, d.string())
+	}
+	return values
+}
+
+func (d *decoder) module(numGlobals int) *ModuleProto {
+	path := d.string()
+	numLocals := d.index()
+	instructions, positions, constants := d.code()
+	functions := []FunctionProto{}
+	numFunctions := d.count()
+	for i := 0; i < numFunctions && d.err == nil; i++ {
+		f := FunctionProto{name: d.string(), numLocals: d.index()}
+		f.instructions, f.positions, f.constants = d.code()
+		numUpvars := d.count()
+		f.upvars = []UpvarDesc{}
+		for j := 0; j < numUpvars && d.err == nil; j++ {
+			f.upvars = append(f.upvars, UpvarDesc{SlotInParent: d.index(), IsFromParent: d.bool()})
+		}
+		functions = append(functions, f)
+	}
+	d.checkCode(instructions, codeBounds{numInstructions: len(instructions), constants: constants, functions: functions, numGlobals: numGlobals, numLocals: numLocals})
+	for _, f := range functions {
+		d.checkCode(f.instructions, codeBounds{numInstructions: len(f.instructions), constants: f.constants, functions: functions, numGlobals: numGlobals, numLocals: f.numLocals, numUpvars: len(f.upvars)})
+	}
+	return newModuleProto(path, numLocals, instructions, positions, constants, functions)
+}
+
+func (d *decoder) code() ([]Instruction, []*common.SourcePos, []Value) {
+	instructions := []Instruction{}
+	positions := []*common.SourcePos{}
+	numInstructions := d.count()
+	for i := 0; i < numInstructions && d.err == nil; i++ {
+		instruction := Instruction{OpCode: OpCode(d.uvarint()), Args: []int{}}
+		numArgs := d.count()
+		for j := 0; j < numArgs && d.err == nil; j++ {
+			instruction.Args = append(instruction.Args, d.arg())
+		}
+		var pos *common.SourcePos
+		if d.bool() {
+			pos = &common.SourcePos{BasePos: common.BasePos{Offset: d.index(), Line: d.index(), Column: d.index()}, Length: d.index()}
+		}
+		instructions = append(instructions, instruction)
+		positions = append(positions, pos)
+	}
+	constants := []Value{}
+	numConstants := d.count()
+	for i := 0; i < numConstants && d.err == nil; i++ {
+		constants = append(constants, d.value())
+	}
+	return instructions, positions, constants
+}
+
+func (d *decoder) value() Value {
+	typeOf := ValueType(d.uvarint())
+	switch typeOf {
+	case VAL_INT:
+		return NewIntValue(int(d.varint()))
+	case VAL_FLOAT:
+		if d.err != nil || len(d.data)-d.pos < 8 {
+			d.fail("truncated float")
+			return Value{}
+		}
+		bits := binary.LittleEndian.Uint64(d.data[d.pos:])
+		d.pos += 8
+		return NewFloatValue(math.Float64frombits(bits))
+	case VAL_BOOL:
+		return NewBoolValue(d.bool())
+	case VAL_STRING:
+		return NewStringValue(d.string())
+	case VAL_NULL:
+		return NewNullValue()
+	case VAL_ARRAY:
+		elements := []Value{}
+		count := d.count()
+		for i := 0; i < count && d.err == nil; i++ {
+			elements = append(elements, d.value())
+		}
+		return NewArrayValue(elements)
+	case VAL_STRUCT:
+		return NewStructValue(&Struct{Desc: &StructDesc{Name: d.string(), Fields: d.strings()}})
+	case VAL_VARIANT:
+		desc := &EnumDesc{Name: d.string()}
+		count := d.count()
+		for i := 0; i < count && d.err == nil; i++ {
+			desc.Variants = append(desc.Variants, VariantDesc{Name: d.string(), Fields: d.strings()})
+		}
+		return NewVariantValue(&Variant{Desc: desc})
+	}
+	d.fail(fmt.Sprintf("bad constant type %d", typeOf))
+	return Value{}
+}
+
+// operand is what an instruction argument refers to, checkCode bounds it by
+// what the proto holding the instruction has.
+type operand int
+
+const (
+	operandRegister operand = iota
+	operandConstant
+	operandGlobal
+	operandLocal
+	// operandLocalEnd is the end of a half-open range of locals
+	operandLocalEnd
+	operandUpvar
+	operandFunction
+	// operandTarget is a jump target, stored as the target minus one
+	operandTarget
+	// operandIndex is a tag or the index of a field, which depends on the
+	// value the instruction runs on
+	operandIndex
+)
+
+// operandLayout lists the arguments of an opcode. When registers is set, any
+// number of registers follow them.
+type operandLayout struct {
+	operands  []operand
+	registers bool
+}
+
+var (
+	binaryLayout = operandLayout{operands: []operand{operandRegister, operandRegister, operandRegister}}
+	unaryLayout  = operandLayout{operands: []operand{operandRegister, operandRegister}}
+)
+
+var operandLayouts = [opCodeCount]operandLayout{
+	LOAD_CONST:    {operands: []operand{operandRegister, operandConstant}},
+	LOAD_VAR:      {operands: []operand{operandRegister, operandLocal}},
+	LOAD_GLOBAL:   {operands: []operand{operandRegister, operandGlobal}},
+	LOAD_UPVAR:    {operands: []operand{operandRegister, operandUpvar}},
+	STORE_VAR:     {operands: []operand{operandRegister, operandLocal}},
+	ASSIGN_GLOBAL: {operands: []operand{operandRegister, operandGlobal}},
+	ASSIGN_UPVAR:  {operands: []operand{operandRegister, operandUpvar}},
+	ADD_INT:       binaryLayout,
+	SUB_INT:       binaryLayout,
+	MUL_INT:       binaryLayout,
+	DIV_INT:       binaryLayout,
+	EQ_INT:        binaryLayout,
+	EQ_BOOL:       binaryLayout,
+	NE_INT:        binaryLayout,
+	NE_BOOL:       binaryLayout,
+	GT_INT:        binaryLayout,
+	GTE_INT:       binaryLayout,
+	LT_INT:        binaryLayout,
+	LTE_INT:       binaryLayout,
+	AND_BOOL:      binaryLayout,
+	OR_BOOL:       binaryLayout,
+	CLOSURE:       {operands: []operand{operandRegister, operandFunction}},
+	CALL:          {operands: []operand{operandRegister, operandRegister}, registers: true},
+	RETURN:        {operands: []operand{operandRegister}},
+	JUMP_IF_FALSE: {operands: []operand{operandRegister, operandTarget}},
+	JUMP:          {operands: []operand{operandTarget}},
+	MAKE_ARRAY:    {operands: []operand{operandRegister}, registers: true},
+	INDEX_ARRAY:   binaryLayout,
+	CLOSE_VARS:    {operands: []operand{operandLocalEnd, operandLocalEnd}},
+	NEG_INT:       unaryLayout,
+	NOT_BOOL:      unaryLayout,
+	MOVE:          unaryLayout,
+	JUMP_IF_TRUE:  {operands: []operand{operandRegister, operandTarget}},
+	CONCAT_STRING: binaryLayout,
+	EQ_STRING:     binaryLayout,
+	NE_STRING:     binaryLayout,
+	LT_STRING:     binaryLayout,
+	ADD_FLOAT:     binaryLayout,
+	SUB_FLOAT:     binaryLayout,
+	MUL_FLOAT:     binaryLayout,
+	DIV_FLOAT:     binaryLayout,
+	EQ_FLOAT:      binaryLayout,
+	NE_FLOAT:      binaryLayout,
+	GT_FLOAT:      binaryLayout,
+	GTE_FLOAT:     binaryLayout,
+	LT_FLOAT:      binaryLayout,
+	LTE_FLOAT:     binaryLayout,
+	NEG_FLOAT:     unaryLayout,
+	STORE_INDEX:   binaryLayout,
+	MAKE_MAP:      {operands: []operand{operandRegister}, registers: true},
+	INDEX_MAP:     binaryLayout,
+	STORE_MAP:     binaryLayout,
+	MAKE_STRUCT:   {operands: []operand{operandRegister, operandConstant}, registers: true},
+	GET_FIELD:     {operands: []operand{operandRegister, operandRegister, operandIndex}},
+	SET_FIELD:     {operands: []operand{operandRegister, operandIndex, operandRegister}},
+	MAKE_VARIANT:  {operands: []operand{operandRegister, operandConstant, operandIndex}, registers: true},
+	GET_TAG:       unaryLayout,
+	GET_PAYLOAD:   {operands: []operand{operandRegister, operandRegister, operandIndex}},
+	IS_NULL:       unaryLayout,
+	COPY:          unaryLayout,
+}
+
+// codeBounds is what the arguments of the instructions of a proto may refer
+// to.
+type codeBounds struct {
+	numInstructions int
+	constants       []Value
+	functions       []FunctionProto
+	numGlobals      int
+	numLocals       int
+	numUpvars       int
+}
+
+// checkCode rejects the instructions the VM could not run safely: an unknown
+// opcode, a wrong number of arguments, or an argument out of the range of
+// what it refers to. Registers are only bounded by maxRegisters, the VM grows
+// the registers of a frame to the ones it reads. The indexes of fields and
+// payloads are only known to be non negative, the VM checks them against the
+// struct or enum value when the instruction runs.
+func (d *decoder) checkCode(instructions []Instruction, bounds codeBounds) {
+	for _, instruction := range instructions {
+		if d.err != nil {
+			return
+		}
+		if instruction.OpCode < 0 || instruction.OpCode >= opCodeCount {
+			d.fail(fmt.Sprintf("bad opcode %d", instruction.OpCode))
+			return
+		}
+		layout := operandLayouts[instruction.OpCode]
+		args := instruction.Args
+		if len(args) < len(layout.operands) || (!layout.registers && len(args) > len(layout.operands)) {
+			d.fail(fmt.Sprintf("wrong number of arguments for %s", instruction.OpCode))
+			return
+		}
+		for i, arg := range args {
+			kind := operandRegister
+			if i < len(layout.operands) {
+				kind = layout.operands[i]
+			}
+			d.checkOperand(kind, arg, bounds)
+		}
+		if d.err == nil {
+			d.checkInstruction(instruction, bounds)
+		}
+	}
+}
+
+func (d *decoder) checkOperand(kind operand, arg int, bounds codeBounds) {
+	switch kind {
+	case operandRegister:
+		if arg < 0 || arg >= maxRegisters {
+			d.fail("register out of range")
+		}
+	case operandConstant:
+		if arg < 0 || arg >= len(bounds.constants) {
+			d.fail("constant out of range")
+		}
+	case operandGlobal:
+		if arg < 0 || arg >= bounds.numGlobals {
+			d.fail("global out of range")
+		}
+	case operandLocal:
+		if arg < 0 || arg >= bounds.numLocals {
+			d.fail("local out of range")
+		}
+	case operandLocalEnd:
+		if arg < 0 || arg > bounds.numLocals {
+			d.fail("local out of range")
+		}
+	case operandUpvar:
+		if arg < 0 || arg >= bounds.numUpvars {
+			d.fail("upvar out of range")
+		}
+	case operandFunction:
+		if arg < 0 || arg >= len(bounds.functions) {
+			d.fail("function out of range")
+		}
+	case operandTarget:
+		if arg < -1 || arg >= bounds.numInstructions {
+			d.fail("jump target out of range")
+		}
+	case operandIndex:
+		if arg < 0 {
+			d.fail("index out of range")
+		}
+	}
+}
+
+// checkInstruction checks what the arguments of an instruction have to agree
+// on, once each of them is known to be in range.
+func (d *decoder) checkInstruction(instruction Instruction, bounds codeBounds) {
+	args := instruction.Args
+	switch instruction.OpCode {
+	case MAKE_MAP:
+		if len(args)%2 != 1 {
+			d.fail("map entry without value")
+		}
+	case MAKE_STRUCT:
+		constant := bounds.constants[args[1]]
+		if constant.TypeOf != VAL_STRUCT || len(args)-2 != len(constant.Struct.Desc.Fields) {
+			d.fail("bad struct description")
+		}
+	case MAKE_VARIANT:
+		constant := bounds.constants[args[1]]
+		if constant.TypeOf != VAL_VARIANT || args[2] >= len(constant.Variant.Desc.Variants) || len(args)-3 != len(constant.Variant.Desc.Variants[args[2]].Fields) {
+			d.fail("bad enum description")
+		}
+	case CLOSURE:
+		// the closure captures its upvars from the proto creating it
+		for _, upvar := range bounds.functions[args[1]].upvars {
+			if (upvar.IsFromParent && upvar.SlotInParent >= bounds.numLocals) || (!upvar.IsFromParent && upvar.SlotInParent >= bounds.numUpvars) {
+				d.fail("captured variable out of range")
+			}
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func compileFiles(t *testing.T, files map[string]string, path string) *CompileResult {
+	t.Helper()
+
+	result, errors := NewResolver(nil, readFiles(files, map[string]int{})).CompileFile(path)
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	return result
+}
+
+func saveResult(t *testing.T, result *CompileResult) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	if err := Save(&buffer, result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return buffer.Bytes()
+}
+
+var bytecodeFiles = map[string]string{
+	"shapes.wmofn": `
+		export enum Shape { Circle(r: float), Square(side: int), Empty }
+		export struct Named { name: string, shape: Shape }
+	`,
+	"main.wmofn": `
+		import "shapes.wmofn";
+		function area(s: Shape): float {
+			return match (s) {
+				Circle(r) => r * r * 3.5,
+				Square(side) => float(side * side),
+				Empty => 0.0,
+			};
+		}
+		var total: float;
+		const named = Named { name: "sq", shape: Shape.Square(3) };
+		const adders = [(x: int) => x + 1];
+		total = area(named.shape) + area(Shape.Circle(2.0));
+		return int(total) + adders[0](1) + 1099511627776 - 1099511627776;
+	`,
+}
+
+/*
+*
+Test saving and loading a program with an imported module.
+
+- Should keep the modules, functions, constants and positions
+- Should keep the path of every module, which stack traces and disasm show
+- Should keep the names of the globals
+*/
+func TestSaveLoad_RoundTrip(t *testing.T) {
+	result := compileFiles(t, bytecodeFiles, "main.wmofn")
+
+	loaded, err := Load(bytes.NewReader(saveResult(t, result)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(loaded.Imports) != 1 {
+		t.Fatalf("expected 1 import, got %d", len(loaded.Imports))
+	}
+	if !reflect.DeepEqual(loaded.ImportPaths, result.ImportPaths) {
+		t.Errorf("expected import paths %v, got %v", result.ImportPaths, loaded.ImportPaths)
+	}
+	for _, pair := range [][2]*ModuleProto{{&result.ModuleProto, &loaded.ModuleProto}, {&result.Imports[0], &loaded.Imports[0]}} {
+		saved, got := pair[0], pair[1]
+		if saved.NumLocals() != got.NumLocals() || !reflect.DeepEqual(saved.Instructions(), got.Instructions()) || !reflect.DeepEqual(saved.Positions(), got.Positions()) {
+			t.Errorf("expected the module code to be kept, got\n%s\nwant\n%s", got, saved)
+		}
+		if !reflect.DeepEqual(saved.Constants(), got.Constants()) {
+			t.Errorf("expected constants %v, got %v", saved.Constants(), got.Constants())
+		}
+		if saved.Path() == "" || got.Path() != saved.Path() {
+			t.Errorf("expected path %q, got %q", saved.Path(), got.Path())
+		}
+		if len(saved.Functions()) != len(got.Functions()) {
+			t.Fatalf("expected %d functions, got %d", len(saved.Functions()), len(got.Functions()))
+		}
+		for i, function := range got.Functions() {
+			want := saved.Functions()[i]
+			if function.Name() != want.Name() || !reflect.DeepEqual(function.Instructions(), want.Instructions()) || !reflect.DeepEqual(function.Upvars(), want.Upvars()) || !reflect.DeepEqual(function.Constants(), want.Constants()) {
+				t.Errorf("expected function %s to be kept", want.Name())
+			}
+			if function.Path() != saved.Path() {
+				t.Errorf("expected function %s to keep the path of its module, got %q", want.Name(), function.Path())
+			}
+			if len(function.Functions()) != len(got.Functions()) {
+				t.Errorf("expected function %s to see the functions of its module", want.Name())
+			}
+		}
+	}
+	if loaded.GlobalTable.Length() != result.GlobalTable.Length() {
+		t.Errorf("expected %d globals, got %d", result.GlobalTable.Length(), loaded.GlobalTable.Length())
+	}
+	if _, ok := loaded.GlobalTable.FindVariable("println"); !ok {
+		t.Error("expected println to be kept")
+	}
+}
+
+/*
+*
+Test loading input that is not a valid bytecode file.
+
+- Should report a wrong magic number, version and checksum
+- Should reject every truncated or altered copy of a valid file without panicking
+*/
+func TestLoad_RejectsCorruptInput(t *testing.T) {
+	data := saveResult(t, compileFiles(t, bytecodeFiles, "main.wmofn"))
+
+	if _, err := Load(strings.NewReader("function f(): int {}")); err == nil || err.Error() != "not a bytecode file" {
+		t.Errorf("expected a magic number error, got %v", err)
+	}
+	newer := bytes.Clone(data)
+	binary.LittleEndian.PutUint16(newer[4:], BytecodeVersion+1)
+	if _, err := Load(bytes.NewReader(newer)); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("expected a version error, got %v", err)
+	}
+	altered := bytes.Clone(data)
+	altered[len(altered)/2] ^= 0xff
+	if _, err := Load(bytes.NewReader(altered)); err == nil || err.Error() != "corrupt bytecode: checksum mismatch" {
+		t.Errorf("expected a checksum error, got %v", err)
+	}
+
+	for length := 0; length < len(data); length++ {
+		if _, err := Load(bytes.NewReader(data[:length])); err == nil {
+			t.Fatalf("expected an error for a file truncated to %d bytes", length)
+		}
+	}
+	// a payload with a valid checksum still has to decode
+	for i := bytecodeHeaderLength; i < len(data)-4; i++ {
+		for _, b := range []byte{0x00, 0x7f, 0xff} {
+			payload := bytes.Clone(data[:len(data)-4])
+			payload[i] = b
+			resealed := binary.LittleEndian.AppendUint32(payload, crc32.ChecksumIEEE(payload[bytecodeHeaderLength:]))
+			Load(bytes.NewReader(resealed))
+		}
+	}
+}
+
+/*
+*
+Test loading files whose instructions have bad arguments, with a valid checksum.
+
+- Should reject each of them with the reason instead of letting the VM panic
+*/
+func TestLoad_RejectsBadOperands(t *testing.T) {
+	source := `
+		function counter(): () -> int {
+			var n = 0;
+			return () => n;
+		}
+		var total = counter()();
+		while (total < 3) {
+			total++;
+		}
+		return total;
+	`
+	loadConst := func(m *ModuleProto) *Instruction {
+		for i := range m.instructions {
+			if m.instructions[i].OpCode == LOAD_CONST {
+				return &m.instructions[i]
+			}
+		}
+		t.Fatal("no LOAD_CONST in the module")
+		return nil
+	}
+	closure := func(m *ModuleProto) *FunctionProto {
+		for i := range m.functions {
+			if len(m.functions[i].upvars) > 0 {
+				return &m.functions[i]
+			}
+		}
+		t.Fatal("no closure in the module")
+		return nil
+	}
+	cases := []struct {
+		name   string
+		alter  func(m *ModuleProto)
+		reason string
+	}{
+		{"negative register", func(m *ModuleProto) {
+			loadConst(m).Args[0] = -3
+		}, "register out of range"},
+		{"register past the bound", func(m *ModuleProto) {
+			loadConst(m).Args[0] = maxRegisters
+		}, "register out of range"},
+		{"missing argument", func(m *ModuleProto) {
+			loadConst(m).Args = loadConst(m).Args[:1]
+		}, "wrong number of arguments for LOAD_CONST"},
+		{"extra argument", func(m *ModuleProto) {
+			loadConst(m).Args = append(loadConst(m).Args, 0)
+		}, "wrong number of arguments for LOAD_CONST"},
+		{"local past the frame", func(m *ModuleProto) {
+			m.instructions = append(m.instructions, InstrStoreVar(0, 1<<30))
+		}, "local out of range"},
+		{"close past the frame", func(m *ModuleProto) {
+			m.instructions = append(m.instructions, InstrCloseVars(0, m.numLocals+1))
+		}, "local out of range"},
+		{"upvar past the closure", func(m *ModuleProto) {
+			f := closure(m)
+			f.instructions = append(f.instructions, InstrLoadUpvar(0, len(f.upvars)))
+		}, "upvar out of range"},
+		{"upvar in the module", func(m *ModuleProto) {
+			m.instructions = append(m.instructions, InstrLoadUpvar(0, 0))
+		}, "upvar out of range"},
+		{"captured slot past the frame", func(m *ModuleProto) {
+			closure(m).upvars[0].SlotInParent = 1 << 20
+		}, "captured variable out of range"},
+		{"jump past the end", func(m *ModuleProto) {
+			m.instructions = append(m.instructions, InstrJump(len(m.instructions)+1))
+		}, "jump target out of range"},
+		{"jump before the start", func(m *ModuleProto) {
+			m.instructions = append(m.instructions, InstrJumpIfFalse(0, -2))
+		}, "jump target out of range"},
+	}
+
+	for _, c := range cases {
+		result := compileFiles(t, map[string]string{"main.wmofn": source}, "main.wmofn")
+		c.alter(&result.ModuleProto)
+		_, err := Load(bytes.NewReader(saveResult(t, result)))
+		if err == nil || err.Error() != "corrupt bytecode: "+c.reason {
+			t.Errorf("%s: expected %q, got %v", c.name, c.reason, err)
+		}
+	}
+}
+
+func TestSave_RejectsRuntimeConstants(t *testing.T) {
+	result := compileFiles(t, map[string]string{"main.wmofn": "return 1;"}, "main.wmofn")
+	result.ModuleProto.constants = append(result.ModuleProto.constants, NewMapValue(NewMap()))
+
+	if err := Save(&bytes.Buffer{}, result); err == nil || err.Error() != "cannot save a constant of type MAP" {
+		t.Errorf("expected a constant error, got %v", err)
+	}
+}
@@ -1,20 +1,24 @@
 package compiler
 
 import (
-	"fmt"
-	"os"
-
 	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
 	"youpiteron.dev/white-monster-on-friday-night/internal/common"
 )
 
+// CompileResult is a compiled program. Imports are the modules it imports,
+// they have to run in order before ModuleProto. ImportPaths are the files
+// they were compiled from, Load reads them from the path of each module.
 type CompileResult struct {
 	ModuleProto ModuleProto
 	GlobalTable *GlobalTable
+	Warnings    []common.Error
+	Imports     []ModuleProto
+	ImportPaths []string
 }
 
 type Compiler struct {
 	replMode            bool
+	replMark            moduleMark
 	instructionsVisitor *InstructionsVisitor
 }
 
@@ -22,20 +26,22 @@ func NewCompiler() *Compiler {
 	return &Compiler{replMode: false, instructionsVisitor: NewInstructionsVisitor()}
 }
 
-func (c *Compiler) CompileToModuleProto(program *ast.Program) *CompileResult {
+// CompileToModuleProto compiles a whole program. When compilation fails the
+// result is nil and the returned errors describe every problem found.
+func (c *Compiler) CompileToModuleProto(program *ast.Program) (*CompileResult, []common.Error) {
+	c.instructionsVisitor.resetDiagnostics()
 	c.instructionsVisitor.EnterModuleContext()
 	program.Visit(c.instructionsVisitor)
 	c.instructionsVisitor.ExitModuleContext()
 	if len(c.instructionsVisitor.errors) > 0 {
-		fmt.Printf("COMPILATION ERROR: failed to generate instructions from program\n")
-		for _, error := range c.instructionsVisitor.errors {
-			fmt.Printf("  %s at %v\n", error.Message, error.Pos)
-		}
-		os.Exit(1)
+		return nil, c.instructionsVisitor.errors
 	}
 	moduleProto := c.instructionsVisitor.moduleProtos[len(c.instructionsVisitor.moduleProtos)-1]
-	fmt.Printf("module proto: %s\n", moduleProto.String())
-	return &CompileResult{ModuleProto: moduleProto, GlobalTable: c.instructionsVisitor.globalTable}
+	return &CompileResult{
+		ModuleProto: moduleProto,
+		GlobalTable: c.instructionsVisitor.globalTable,
+		Warnings:    c.instructionsVisitor.warnings,
+	}, nil
 }
 
 func (c *Compiler) StartREPL() *GlobalTable {
@@ -56,14 +62,29 @@ func (c *Compiler) CompileREPLChunk(program *ast.Program) (*CompileResult, []com
 	if !c.replMode {
 		panic("COMPILER ERROR: cannot compile REPL chunk without starting REPL mode")
 	}
+	c.instructionsVisitor.resetDiagnostics()
+	c.replMark = c.instructionsVisitor.markModule()
 	program.Visit(c.instructionsVisitor)
 	if len(c.instructionsVisitor.errors) > 0 {
-		fmt.Printf("COMPILATION ERROR: failed to generate instructions from program\n")
-		for _, error := range c.instructionsVisitor.errors {
-			fmt.Printf("  %s at %v\n", error.Message, error.Pos)
-		}
+		// drop the instructions of the failed chunk so they don't run with the next one
+		CastModuleContext(c.instructionsVisitor.context).ClearInstructions()
+		c.instructionsVisitor.restoreModule(c.replMark)
 		return nil, c.instructionsVisitor.errors
 	}
 	moduleProto := c.instructionsVisitor.EmitModuleProto()
-	return &CompileResult{ModuleProto: *moduleProto, GlobalTable: c.instructionsVisitor.globalTable}, nil
+	return &CompileResult{
+		ModuleProto: *moduleProto,
+		GlobalTable: c.instructionsVisitor.globalTable,
+		Warnings:    c.instructionsVisitor.warnings,
+	}, nil
+}
+
+// RollbackREPLChunk forgets what the last chunk compiled by CompileREPLChunk
+// declared. The REPL calls it when the chunk fails at runtime, since its
+// variables may never have been given a value.
+func (c *Compiler) RollbackREPLChunk() {
+	if !c.replMode {
+		panic("COMPILER ERROR: cannot roll back a REPL chunk without starting REPL mode")
+	}
+	c.instructionsVisitor.restoreModule(c.replMark)
 }
@@ -0,0 +1,227 @@
+package compiler
+
+import (
+	"fmt"
+	"testing"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
+)
+
+func makeProgram(statements ...ast.Statement) *ast.Program {
+	return &ast.Program{Statements: statements, PosAt: makeSourcePos(0, 1, 1, 1)}
+}
+
+func makeReturn(value ast.Expression, offset, line, col int) *ast.Return {
+	return &ast.Return{Value: value, PosAt: makeSourcePos(offset, line, col, 6)}
+}
+
+// ---------- CompileToModuleProto Tests ----------
+
+/*
+*
+Test compiling `return missing; return true;`.
+
+- Should return every error instead of exiting
+- Should not return a result
+*/
+func TestCompileToModuleProto_ReturnsErrors(t *testing.T) {
+	program := makeProgram(
+		makeReturn(makeIdentifier("missing", false, 7, 1, 8), 0, 1, 1),
+		makeReturn(&ast.BoolLiteral{Value: true, PosAt: makeSourcePos(23, 2, 8, 4)}, 16, 2, 1),
+	)
+
+	result, errors := NewCompiler().CompileToModuleProto(program)
+
+	if result != nil {
+		t.Errorf("expected nil result, got %v", result)
+	}
+	if len(errors) != 2 {
+		t.Fatalf("expected 2 errors, got %d", len(errors))
+	}
+	if errors[0].Pos.Line != 1 || errors[1].Pos.Line != 2 {
+		t.Errorf("expected errors on lines 1 and 2, got %d and %d", errors[0].Pos.Line, errors[1].Pos.Line)
+	}
+}
+
+func TestCompileToModuleProto_Success(t *testing.T) {
+	program := makeProgram(makeReturn(makeIntLiteral(1, false, 7, 1, 8), 0, 1, 1))
+
+	result, errors := NewCompiler().CompileToModuleProto(program)
+
+	if len(errors) != 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	if result == nil || result.GlobalTable == nil {
+		t.Fatal("expected result with global table")
+	}
+	if len(result.ModuleProto.Instructions()) != 2 {
+		t.Errorf("expected 2 instructions, got %d", len(result.ModuleProto.Instructions()))
+	}
+}
+
+// ---------- CompileREPLChunk Tests ----------
+
+/*
+*
+Test a failing REPL chunk followed by a valid one.
+
+- Errors of the first chunk should not be reported again
+- Instructions of the failed chunk should be dropped
+*/
+func TestCompileREPLChunk_ErrorsDoNotLeak(t *testing.T) {
+	compiler := NewCompiler()
+	compiler.StartREPL()
+
+	failing := makeProgram(
+		makeCallExpr(makeIdentifier("println", false, 0, 1, 1), []ast.Expression{makeIntLiteral(1, false, 8, 1, 9)}, 7, 1, 8),
+		makeReturn(makeIdentifier("missing", false, 17, 1, 18), 10, 1, 11),
+	)
+	if _, errors := compiler.CompileREPLChunk(failing); len(errors) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errors))
+	}
+
+	result, errors := compiler.CompileREPLChunk(makeProgram(makeReturn(makeIntLiteral(2, false, 7, 1, 8), 0, 1, 1)))
+	if len(errors) != 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	for _, instr := range result.ModuleProto.Instructions() {
+		if instr.OpCode == CALL {
+			t.Errorf("expected instructions of the failed chunk to be dropped, got %s", instr.String())
+		}
+	}
+}
+
+/*
+*
+Test `var x = 1; var y = nope;` followed by `return x;` in the REPL.
+
+- Should forget x with the failed chunk instead of leaving it uninitialized
+*/
+func TestCompileREPLChunk_ErrorsDropDeclarations(t *testing.T) {
+	compiler := NewCompiler()
+	compiler.StartREPL()
+
+	failing := makeProgram(
+		makeVar("x", nil, makeIntLiteral(1, false, 28, 1, 9), 1),
+		makeVar("y", nil, makeIdentifier("nope", false, 48, 2, 9), 2),
+	)
+	if _, errors := compiler.CompileREPLChunk(failing); len(errors) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errors))
+	}
+
+	_, errors := compiler.CompileREPLChunk(makeProgram(makeReturn(makeIdentifier("x", false, 7, 1, 8), 0, 1, 1)))
+	if len(errors) != 1 || errors[0].Message != "variable x not found" {
+		t.Errorf("expected x to be dropped with the failed chunk, got %v", errors)
+	}
+	if _, errors := compiler.CompileREPLChunk(makeProgram(makeVar("x", nil, makeIntLiteral(2, false, 28, 1, 9), 1))); len(errors) != 0 {
+		t.Errorf("expected x to be declared again, got %v", errors)
+	}
+}
+
+// ---------- CompileFile Tests ----------
+
+// readFiles serves files from memory and counts how many times each is read.
+func readFiles(files map[string]string, reads map[string]int) func(path string) ([]byte, error) {
+	return func(path string) ([]byte, error) {
+		source, ok := files[path]
+		if !ok {
+			return nil, fmt.Errorf("no such file")
+		}
+		reads[path]++
+		return []byte(source), nil
+	}
+}
+
+/*
+*
+Test a main file importing two modules which both import lib/base.wmofn.
+
+- Should compile the shared module once
+- Should list every import once, after the modules it imports
+- Should find lib/base.wmofn through the search path
+*/
+func TestCompileFile_CachesSharedModules(t *testing.T) {
+	files := map[string]string{
+		"main.wmofn":     "import \"a.wmofn\";\nimport \"b.wmofn\";\nreturn a + b;",
+		"a.wmofn":        "import \"base.wmofn\";\nexport const a = base;",
+		"b.wmofn":        "import \"base.wmofn\";\nexport const b = base * 2;",
+		"lib/base.wmofn": "export const base = 1;",
+	}
+	reads := map[string]int{}
+
+	result, errors := NewResolver([]string{"lib"}, readFiles(files, reads)).CompileFile("main.wmofn")
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	if reads["lib/base.wmofn"] != 1 {
+		t.Errorf("expected lib/base.wmofn to be read once, got %d", reads["lib/base.wmofn"])
+	}
+	if len(result.Imports) != 3 {
+		t.Fatalf("expected 3 imports, got %d", len(result.Imports))
+	}
+	if _, ok := result.GlobalTable.FindVariable(QualifiedName("lib/base.wmofn", "base")); !ok {
+		t.Error("expected base to be exported under its module")
+	}
+}
+
+func TestCompileFile_ReportsImportCycles(t *testing.T) {
+	files := map[string]string{
+		"main.wmofn": "import \"a.wmofn\";\nreturn 0;",
+		"a.wmofn":    "import \"b.wmofn\";\nexport const a = 1;",
+		"b.wmofn":    "import \"a.wmofn\";\nexport const b = 1;",
+	}
+
+	_, errors := NewResolver(nil, readFiles(files, map[string]int{})).CompileFile("main.wmofn")
+
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 error, got %v", errors)
+	}
+	if errors[0].Message != "import cycle: a.wmofn -> b.wmofn -> a.wmofn" || errors[0].File != "b.wmofn" {
+		t.Errorf("unexpected error %q in %s", errors[0].Message, errors[0].File)
+	}
+}
+
+/*
+*
+Test the errors of a module and of the file importing it.
+
+- Should report a module that is not found in the importing file
+- Should report an export using a type that is not exported
+- Should report a name exported by two imported modules
+*/
+func TestCompileFile_ReportsModuleErrors(t *testing.T) {
+	files := map[string]string{
+		"main.wmofn":   "import \"x1.wmofn\";\nimport \"x2.wmofn\";\nimport \"gone.wmofn\";\nreturn x;",
+		"x1.wmofn":     "export const x = 1;",
+		"x2.wmofn":     "export const x = 2;",
+		"hidden.wmofn": "struct Hidden { x: int }\nexport function h(): Hidden { return Hidden { x: 1 }; }",
+	}
+	resolver := NewResolver(nil, readFiles(files, map[string]int{}))
+
+	_, errors := resolver.CompileFile("main.wmofn")
+	if len(errors) != 1 || errors[0].Message != "module gone.wmofn not found" || errors[0].Pos.Line != 3 {
+		t.Fatalf("expected a module not found error, got %v", errors)
+	}
+
+	files["main.wmofn"] = "import \"x1.wmofn\";\nimport \"x2.wmofn\";\nreturn x;"
+	_, errors = resolver.CompileFile("main.wmofn")
+	if len(errors) != 1 || errors[0].Message != "x is imported from both x1.wmofn and x2.wmofn" {
+		t.Fatalf("expected a conflict error, got %v", errors)
+	}
+
+	_, errors = resolver.CompileFile("hidden.wmofn")
+	if len(errors) != 1 || errors[0].Message != "exported h uses the type Hidden, which is not exported" || errors[0].File != "hidden.wmofn" {
+		t.Errorf("expected an unexported type error, got %v", errors)
+	}
+}
+
+func TestCompileToModuleProto_RejectsImports(t *testing.T) {
+	program := makeProgram(&ast.Import{Path: "lib.wmofn", PosAt: makeSourcePos(0, 1, 1, 6)})
+
+	_, errors := NewCompiler().CompileToModuleProto(program)
+
+	if len(errors) != 1 || errors[0].Message != "cannot import lib.wmofn, imports are only resolved when compiling a file" {
+		t.Errorf("expected an import error, got %v", errors)
+	}
+}
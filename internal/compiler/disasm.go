@@ -0,0 +1,148 @@
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Disassemble prints a module and its functions for reading: each
+// instruction with the constants, globals, functions and jump targets its
+// arguments refer to. When source is the text the module was compiled from,
+// each source line is printed above the instructions compiled from it.
+func Disassemble(name string, proto *ModuleProto, globals *GlobalTable, source string) string {
+	d := &disassembler{globals: globals, functions: proto.functions}
+	if source != "" {
+		d.lines = strings.Split(source, "\n")
+	}
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "module %s (locals: %d)\n", name, proto.numLocals)
+	d.code(&builder, proto)
+	for i := range proto.functions {
+		function := &proto.functions[i]
+		fmt.Fprintf(&builder, "\nfunction #%d %s (locals: %d, upvars: %d)\n", i, function.name, function.numLocals, len(function.upvars))
+		for j, upvar := range function.upvars {
+			from := "upvar"
+			if upvar.IsFromParent {
+				from = "local"
+			}
+			fmt.Fprintf(&builder, "  upvar %d = %s %d of the enclosing function\n", j, from, upvar.SlotInParent)
+		}
+		d.code(&builder, function)
+	}
+	return builder.String()
+}
+
+type disassembler struct {
+	globals   *GlobalTable
+	functions []FunctionProto
+	lines     []string
+}
+
+// code prints the constants and the instructions of proto. Jump targets get
+// labels, L<n> marks the instruction at index n.
+func (d *disassembler) code(builder *strings.Builder, proto Proto) {
+	constants := proto.Constants()
+	if len(constants) > 0 {
+		builder.WriteString("  constants:\n")
+		for i, constant := range constants {
+			fmt.Fprintf(builder, "    k%d = %s\n", i, constantString(constant))
+		}
+	}
+
+	instructions := proto.Instructions()
+	labels := map[int]bool{}
+	for _, instruction := range instructions {
+		if target, ok := jumpTarget(instruction); ok {
+			labels[target] = true
+		}
+	}
+	positions := proto.Positions()
+	line := 0
+	for i, instruction := range instructions {
+		if i < len(positions) && positions[i] != nil && positions[i].Line != line {
+			line = positions[i].Line
+			if line > 0 && line <= len(d.lines) {
+				fmt.Fprintf(builder, "  ; %d: %s\n", line, strings.TrimSpace(d.lines[line-1]))
+			}
+		}
+		if labels[i] {
+			fmt.Fprintf(builder, "L%d:\n", i)
+		}
+		fmt.Fprintf(builder, "  %04d  %-14s %s\n", i, instruction.OpCode, d.operands(instruction, constants))
+	}
+	if labels[len(instructions)] {
+		fmt.Fprintf(builder, "L%d:\n", len(instructions))
+	}
+}
+
+// jumpTarget returns the index a jump continues at. The VM advances past the
+// instruction it jumped to, so the target is one after the argument.
+func jumpTarget(instruction Instruction) (int, bool) {
+	switch instruction.OpCode {
+	case JUMP:
+		return instruction.Args[0] + 1, true
+	case JUMP_IF_FALSE, JUMP_IF_TRUE:
+		return instruction.Args[1] + 1, true
+	}
+	return 0, false
+}
+
+func (d *disassembler) operands(instruction Instruction, constants []Value) string {
+	args := make([]string, len(instruction.Args))
+	for i, arg := range instruction.Args {
+		args[i] = strconv.Itoa(arg)
+	}
+	operands := strings.Join(args, ", ")
+	if target, ok := jumpTarget(instruction); ok {
+		args[len(args)-1] = fmt.Sprintf("L%d", target)
+		return strings.Join(args, ", ")
+	}
+	switch instruction.OpCode {
+	case LOAD_CONST, MAKE_STRUCT, MAKE_VARIANT:
+		if index := instruction.Args[1]; index >= 0 && index < len(constants) {
+			return fmt.Sprintf("%s ; %s", operands, constantString(constants[index]))
+		}
+	case LOAD_GLOBAL, ASSIGN_GLOBAL:
+		if slot := instruction.Args[1]; d.globals != nil && slot >= 0 && slot < d.globals.Length() {
+			return fmt.Sprintf("%s ; %s", operands, d.globals.variables[slot].Name)
+		}
+	case CLOSURE:
+		if index := instruction.Args[1]; index >= 0 && index < len(d.functions) {
+			return fmt.Sprintf("%s ; #%d %s", operands, index, d.functions[index].name)
+		}
+	}
+	return operands
+}
+
+// constantString shows a constant the way it is written in the source, the
+// descriptions used to build structs and variants show the type they build.
+func constantString(value Value) string {
+	switch value.TypeOf {
+	case VAL_INT:
+		return strconv.Itoa(value.Int)
+	case VAL_FLOAT:
+		return strconv.FormatFloat(value.Float, 'g', -1, 64)
+	case VAL_BOOL:
+		return strconv.FormatBool(value.Bool)
+	case VAL_STRING:
+		return strconv.Quote(value.String)
+	case VAL_NULL:
+		return "null"
+	case VAL_ARRAY:
+		elements := make([]string, len(value.Array))
+		for i, element := range value.Array {
+			elements[i] = constantString(element)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case VAL_STRUCT:
+		return fmt.Sprintf("struct %s { %s }", value.Struct.Desc.Name, strings.Join(value.Struct.Desc.Fields, ", "))
+	case VAL_VARIANT:
+		variants := make([]string, len(value.Variant.Desc.Variants))
+		for i, variant := range value.Variant.Desc.Variants {
+			variants[i] = variant.Name
+		}
+		return fmt.Sprintf("enum %s { %s }", value.Variant.Desc.Name, strings.Join(variants, ", "))
+	}
+	return value.TypeOf.String()
+}
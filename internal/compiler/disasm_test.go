@@ -0,0 +1,57 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+// ---------- Disassemble Tests ----------
+
+/*
+*
+Test disassembling `var i = 0; while (i < 3) { i++; } return i;`.
+
+- Should resolve constants and show jump targets as labels
+- Should print each source line above its instructions
+*/
+func TestDisassemble(t *testing.T) {
+	source := "var i = 0;\nwhile (i < 3) {\n  i++;\n}\nreturn i;\n"
+	result := compileFiles(t, map[string]string{"main.wmofn": source}, "main.wmofn")
+
+	listing := Disassemble("main.wmofn", &result.ModuleProto, result.GlobalTable, source)
+
+	for _, expected := range []string{
+		"module main.wmofn (locals: 1)",
+		"    k1 = 3",
+		"  ; 2: while (i < 3) {\nL2:\n  0002  LOAD_VAR       0, 0",
+		"  0003  LOAD_CONST     1, 1 ; 3",
+		"  0005  JUMP_IF_FALSE  2, L11",
+		"  0010  JUMP           L2",
+		"  ; 5: return i;\nL11:\n",
+	} {
+		if !strings.Contains(listing, expected) {
+			t.Errorf("expected the listing to contain %q, got\n%s", expected, listing)
+		}
+	}
+}
+
+func TestDisassemble_Functions(t *testing.T) {
+	source := "function add(a: int): (int) -> int {\n  return (b: int) => a + b;\n}\nprintln(add(1)(2));"
+	result := compileFiles(t, map[string]string{"main.wmofn": source}, "main.wmofn")
+
+	listing := Disassemble("main.wmofn", &result.ModuleProto, result.GlobalTable, "")
+
+	for _, expected := range []string{
+		"CLOSURE        4, 1 ; #1 add",
+		"LOAD_GLOBAL    0, 0 ; println",
+		"function #0 <lambda> (locals: 1, upvars: 1)\n  upvar 0 = local 0 of the enclosing function",
+		"function #1 add (locals: 1, upvars: 0)",
+	} {
+		if !strings.Contains(listing, expected) {
+			t.Errorf("expected the listing to contain %q, got\n%s", expected, listing)
+		}
+	}
+	if strings.Contains(listing, ";  ") || strings.Contains(listing, "; 2:") {
+		t.Errorf("expected no source lines without a source, got\n%s", listing)
+	}
+}
@@ -0,0 +1,21 @@
+package compiler
+
+// EnumDesc describes an enum declared in the script. A variant's index in
+// Variants is its tag.
+type EnumDesc struct {
+	Name     string
+	Variants []VariantDesc
+}
+
+type VariantDesc struct {
+	Name   string
+	Fields []string
+}
+
+// Variant is a value of an enum, the tag selects the variant in Desc and the
+// payload holds its fields in declaration order.
+type Variant struct {
+	Desc    *EnumDesc
+	Tag     int
+	Payload []Value
+}
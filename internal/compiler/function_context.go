@@ -1,10 +1,15 @@
 package compiler
 
-import "youpiteron.dev/white-monster-on-friday-night/internal/ast"
+import (
+	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+)
 
 type FunctionContext struct {
+	name             string
 	parent           Context
 	currentVarSlot   int
+	numLocals        int
 	currentUpvarSlot int
 	variables        map[string]Variable
 	upvarsMap        map[string]Upvar
@@ -12,11 +17,12 @@ type FunctionContext struct {
 	params       []*ast.Type
 	returnType   *ast.Type
 	instructions []Instruction
+	positions    []*common.SourcePos
 	constants    []Value
 }
 
-func NewFunctionContext(parent Context, returnType *ast.Type) *FunctionContext {
-	return &FunctionContext{parent: parent, variables: make(map[string]Variable), upvarsMap: make(map[string]Upvar), currentVarSlot: 0, currentUpvarSlot: 0, returnType: returnType}
+func NewFunctionContext(name string, parent Context, returnType *ast.Type) *FunctionContext {
+	return &FunctionContext{name: name, parent: parent, variables: make(map[string]Variable), upvarsMap: make(map[string]Upvar), currentVarSlot: 0, currentUpvarSlot: 0, returnType: returnType}
 }
 
 func CastFunctionContext(context Context) *FunctionContext {
@@ -33,15 +39,9 @@ func (c *FunctionContext) ImplementContextInterface() Context {
 
 func (c *FunctionContext) DefineVariable(name string, mutable bool, typeOf *ast.Type) int {
 	slot := c.currentVarSlot
-	c.variables[name] = Variable{Name: name, Slot: slot, Mutable: mutable, TypeOf: typeOf, FuncSignature: nil}
-	c.currentVarSlot++
-	return slot
-}
-
-func (c *FunctionContext) DefineFunctionVariable(name string, mutable bool, typeOf *ast.Type, funcSignature *FuncSignature) int {
-	slot := c.currentVarSlot
-	c.variables[name] = Variable{Name: name, Slot: slot, Mutable: mutable, TypeOf: typeOf, FuncSignature: funcSignature}
+	c.variables[name] = Variable{Name: name, Slot: slot, Mutable: mutable, TypeOf: typeOf}
 	c.currentVarSlot++
+	c.ReserveVarSlot(slot)
 	return slot
 }
 
@@ -83,7 +83,7 @@ func (c *FunctionContext) FindUpvar(name string) (*Upvar, bool) {
 			Name:         name,
 			Mutable:      parentUpvar.Mutable,
 			LocalSlot:    c.currentUpvarSlot,
-			SlotInParent: parentUpvar.SlotInParent,
+			SlotInParent: parentUpvar.LocalSlot,
 			IsFromParent: false,
 			TypeOf:       parentUpvar.TypeOf,
 		}
@@ -106,8 +106,9 @@ func (c *FunctionContext) FindVariable(name string) (*Variable, *Upvar, bool) {
 	return nil, nil, false
 }
 
-func (c *FunctionContext) AddInstruction(instruction Instruction) int {
+func (c *FunctionContext) AddInstruction(instruction Instruction, pos *common.SourcePos) int {
 	c.instructions = append(c.instructions, instruction)
+	c.positions = append(c.positions, pos)
 	return len(c.instructions) - 1
 }
 
@@ -124,12 +125,22 @@ func (c *FunctionContext) AddParam(param *ast.Type) {
 	c.params = append(c.params, param)
 }
 
+func (c *FunctionContext) ReserveVarSlot(slot int) {
+	if slot >= c.numLocals {
+		c.numLocals = slot + 1
+	}
+}
+
 // ---------- Getters ----------
 
 func (c *FunctionContext) VarSlot() int {
 	return c.currentVarSlot
 }
 
+func (c *FunctionContext) NumLocals() int {
+	return c.numLocals
+}
+
 func (c *FunctionContext) InstructionsLength() int {
 	return len(c.instructions)
 }
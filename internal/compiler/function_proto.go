@@ -1,6 +1,10 @@
 package compiler
 
-import "fmt"
+import (
+	"fmt"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+)
 
 type UpvarDesc struct {
 	SlotInParent int
@@ -12,10 +16,18 @@ func (u *UpvarDesc) String() string {
 }
 
 type FunctionProto struct {
+	name         string
 	numLocals    int
 	instructions []Instruction
+	positions    []*common.SourcePos
 	upvars       []UpvarDesc
 	constants    []Value
+	// functions are the functions of the module the function belongs to,
+	// which its CLOSURE instructions index. They stay reachable when the
+	// function is called from another module.
+	functions []FunctionProto
+	// path is the file of the module the function belongs to.
+	path string
 }
 
 func (f *FunctionProto) ImplementProtoInterface() Proto {
@@ -36,6 +48,10 @@ func (f *FunctionProto) String() string {
 
 // ---------- Getters ----------
 
+func (f *FunctionProto) Name() string {
+	return f.name
+}
+
 func (f *FunctionProto) NumLocals() int {
 	return f.numLocals
 }
@@ -44,6 +60,10 @@ func (f *FunctionProto) Instructions() []Instruction {
 	return f.instructions
 }
 
+func (f *FunctionProto) Positions() []*common.SourcePos {
+	return f.positions
+}
+
 func (f *FunctionProto) Constants() []Value {
 	return f.constants
 }
@@ -52,16 +72,27 @@ func (f *FunctionProto) Upvars() []UpvarDesc {
 	return f.upvars
 }
 
+func (f *FunctionProto) Functions() []FunctionProto {
+	return f.functions
+}
+
+func (f *FunctionProto) Path() string {
+	return f.path
+}
+
 func BuildFunctionProto(context Context) *FunctionProto {
 	functionContext := CastFunctionContext(context)
-	numLocals := functionContext.currentVarSlot
-	upvars := []UpvarDesc{}
+	numLocals := functionContext.numLocals
+	// upvars are laid out by LocalSlot, which is the index LOAD_UPVAR uses
+	upvars := make([]UpvarDesc, len(functionContext.upvarsMap))
 	for _, upvar := range functionContext.upvarsMap {
-		upvars = append(upvars, UpvarDesc{SlotInParent: upvar.SlotInParent, IsFromParent: upvar.IsFromParent})
+		upvars[upvar.LocalSlot] = UpvarDesc{SlotInParent: upvar.SlotInParent, IsFromParent: upvar.IsFromParent}
 	}
 	return &FunctionProto{
+		name:         functionContext.name,
 		numLocals:    numLocals,
 		instructions: functionContext.instructions,
+		positions:    functionContext.positions,
 		upvars:       upvars,
 		constants:    functionContext.constants,
 	}
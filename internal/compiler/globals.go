@@ -13,14 +13,7 @@ func NewGlobalTable() *GlobalTable {
 
 func (g *GlobalTable) DefineVariable(name string, mutable bool, typeOf *ast.Type) int {
 	slot := len(g.variables)
-	g.variables = append(g.variables, Variable{Name: name, Slot: slot, Mutable: mutable, TypeOf: typeOf, FuncSignature: nil})
-	g.ids[name] = slot
-	return slot
-}
-
-func (g *GlobalTable) DefineFunctionVariable(name string, mutable bool, typeOf *ast.Type, funcSignature *FuncSignature) int {
-	slot := len(g.variables)
-	g.variables = append(g.variables, Variable{Name: name, Slot: slot, Mutable: mutable, TypeOf: typeOf, FuncSignature: funcSignature})
+	g.variables = append(g.variables, Variable{Name: name, Slot: slot, Mutable: mutable, TypeOf: typeOf})
 	g.ids[name] = slot
 	return slot
 }
@@ -36,3 +29,33 @@ func (g *GlobalTable) FindVariable(name string) (*Variable, bool) {
 func (g *GlobalTable) Length() int {
 	return len(g.variables)
 }
+
+// Namespace is the view a module has of the globals: the names it imported
+// from other modules, then the standard globals. Exported names are qualified
+// by their module in the table, so two modules can export the same name
+// without clashing until a third one imports both.
+type Namespace struct {
+	table *GlobalTable
+	names map[string]int
+}
+
+func NewNamespace(table *GlobalTable) *Namespace {
+	return &Namespace{table: table, names: make(map[string]int)}
+}
+
+// QualifiedName is the name the export name of the module at path has in the
+// global table.
+func QualifiedName(path string, name string) string {
+	return path + "::" + name
+}
+
+func (n *Namespace) Import(name string, slot int) {
+	n.names[name] = slot
+}
+
+func (n *Namespace) FindVariable(name string) (*Variable, bool) {
+	if slot, ok := n.names[name]; ok {
+		return &n.table.variables[slot], true
+	}
+	return n.table.FindVariable(name)
+}
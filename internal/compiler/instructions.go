@@ -15,6 +15,10 @@ func (i *Instruction) String() string {
 
 type OpCode int
 
+// maxRegisters bounds the registers of a frame. The compiler rejects a
+// statement needing more of them and Load rejects code using more.
+const maxRegisters = 1 << 16
+
 const (
 	LOAD_CONST OpCode = iota
 	LOAD_VAR
@@ -44,6 +48,41 @@ const (
 	JUMP
 	MAKE_ARRAY
 	INDEX_ARRAY
+	CLOSE_VARS
+	NEG_INT
+	NOT_BOOL
+	MOVE
+	JUMP_IF_TRUE
+	CONCAT_STRING
+	EQ_STRING
+	NE_STRING
+	LT_STRING
+	ADD_FLOAT
+	SUB_FLOAT
+	MUL_FLOAT
+	DIV_FLOAT
+	EQ_FLOAT
+	NE_FLOAT
+	GT_FLOAT
+	GTE_FLOAT
+	LT_FLOAT
+	LTE_FLOAT
+	NEG_FLOAT
+	STORE_INDEX
+	MAKE_MAP
+	INDEX_MAP
+	STORE_MAP
+	MAKE_STRUCT
+	GET_FIELD
+	SET_FIELD
+	MAKE_VARIANT
+	GET_TAG
+	GET_PAYLOAD
+	IS_NULL
+	COPY
+
+	// opCodeCount is the number of opcodes, not an opcode itself
+	opCodeCount
 )
 
 func (o OpCode) String() string {
@@ -76,6 +115,38 @@ func (o OpCode) String() string {
 		"JUMP",
 		"ARRAY_MAKE",
 		"ARRAY_INDEX",
+		"CLOSE_VARS",
+		"NEG_INT",
+		"NOT_BOOL",
+		"MOVE",
+		"JUMP_IF_TRUE",
+		"CONCAT_STRING",
+		"EQ_STRING",
+		"NE_STRING",
+		"LT_STRING",
+		"ADD_FLOAT",
+		"SUB_FLOAT",
+		"MUL_FLOAT",
+		"DIV_FLOAT",
+		"EQ_FLOAT",
+		"NE_FLOAT",
+		"GT_FLOAT",
+		"GTE_FLOAT",
+		"LT_FLOAT",
+		"LTE_FLOAT",
+		"NEG_FLOAT",
+		"STORE_INDEX",
+		"MAKE_MAP",
+		"INDEX_MAP",
+		"STORE_MAP",
+		"MAKE_STRUCT",
+		"GET_FIELD",
+		"SET_FIELD",
+		"MAKE_VARIANT",
+		"GET_TAG",
+		"GET_PAYLOAD",
+		"IS_NULL",
+		"COPY",
 	}[o]
 }
 
@@ -93,6 +164,13 @@ func InstrLoadVar(reg int, slot int) Instruction {
 	}
 }
 
+func InstrMove(regResult int, regSource int) Instruction {
+	return Instruction{
+		OpCode: MOVE,
+		Args:   []int{regResult, regSource},
+	}
+}
+
 func InstrLoadGlobal(reg int, slot int) Instruction {
 	return Instruction{
 		OpCode: LOAD_GLOBAL,
@@ -135,6 +213,13 @@ func InstrBinary(op OpCode, regResult int, regLeft int, regRight int) Instructio
 	}
 }
 
+func InstrUnary(op OpCode, regResult int, regOperand int) Instruction {
+	return Instruction{
+		OpCode: op,
+		Args:   []int{regResult, regOperand},
+	}
+}
+
 func InstrAddInt(regResult int, regLeft int, regRight int) Instruction {
 	return Instruction{
 		OpCode: ADD_INT,
@@ -233,6 +318,104 @@ func InstrOrBool(regResult int, regLeft int, regRight int) Instruction {
 	}
 }
 
+func InstrAddFloat(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: ADD_FLOAT,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
+func InstrSubFloat(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: SUB_FLOAT,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
+func InstrMulFloat(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: MUL_FLOAT,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
+func InstrDivFloat(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: DIV_FLOAT,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
+func InstrEqualFloat(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: EQ_FLOAT,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
+func InstrNotEqualFloat(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: NE_FLOAT,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
+func InstrGreaterFloat(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: GT_FLOAT,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
+func InstrGreaterEqualFloat(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: GTE_FLOAT,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
+func InstrLessFloat(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: LT_FLOAT,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
+func InstrLessEqualFloat(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: LTE_FLOAT,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
+func InstrConcatString(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: CONCAT_STRING,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
+func InstrEqualString(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: EQ_STRING,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
+func InstrNotEqualString(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: NE_STRING,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
+func InstrLessString(regResult int, regLeft int, regRight int) Instruction {
+	return Instruction{
+		OpCode: LT_STRING,
+		Args:   []int{regResult, regLeft, regRight},
+	}
+}
+
 func InstrClosure(resultReg int, functionSlot int) Instruction {
 	return Instruction{
 		OpCode: CLOSURE,
@@ -261,6 +444,13 @@ func InstrJumpIfFalse(reg int, target int) Instruction {
 	}
 }
 
+func InstrJumpIfTrue(reg int, target int) Instruction {
+	return Instruction{
+		OpCode: JUMP_IF_TRUE,
+		Args:   []int{reg, target},
+	}
+}
+
 func InstrJump(target int) Instruction {
 	return Instruction{
 		OpCode: JUMP,
@@ -281,3 +471,108 @@ func InstrIndexArray(resultReg int, arrayReg int, indexReg int) Instruction {
 		Args:   []int{resultReg, arrayReg, indexReg},
 	}
 }
+
+// InstrStoreIndex writes the value in valueReg into the array element, the
+// VM checks the index against the length of the array.
+func InstrStoreIndex(arrayReg int, indexReg int, valueReg int) Instruction {
+	return Instruction{
+		OpCode: STORE_INDEX,
+		Args:   []int{arrayReg, indexReg, valueReg},
+	}
+}
+
+// InstrMakeMap creates a new map, entries holds the key and value registers
+// of every entry one after the other.
+func InstrMakeMap(resultReg int, entries []int) Instruction {
+	return Instruction{
+		OpCode: MAKE_MAP,
+		Args:   append([]int{resultReg}, entries...),
+	}
+}
+
+func InstrIndexMap(resultReg int, mapReg int, keyReg int) Instruction {
+	return Instruction{
+		OpCode: INDEX_MAP,
+		Args:   []int{resultReg, mapReg, keyReg},
+	}
+}
+
+func InstrStoreMap(mapReg int, keyReg int, valueReg int) Instruction {
+	return Instruction{
+		OpCode: STORE_MAP,
+		Args:   []int{mapReg, keyReg, valueReg},
+	}
+}
+
+// InstrMakeStruct creates a struct from the constant descConst, which holds
+// the struct with its StructDesc but no fields, and the field registers in
+// declaration order.
+func InstrMakeStruct(resultReg int, descConst int, fields []int) Instruction {
+	return Instruction{
+		OpCode: MAKE_STRUCT,
+		Args:   append([]int{resultReg, descConst}, fields...),
+	}
+}
+
+// InstrGetField reads a field by the index resolved at compile time.
+func InstrGetField(resultReg int, structReg int, fieldIndex int) Instruction {
+	return Instruction{
+		OpCode: GET_FIELD,
+		Args:   []int{resultReg, structReg, fieldIndex},
+	}
+}
+
+func InstrSetField(structReg int, fieldIndex int, valueReg int) Instruction {
+	return Instruction{
+		OpCode: SET_FIELD,
+		Args:   []int{structReg, fieldIndex, valueReg},
+	}
+}
+
+// InstrMakeVariant creates the variant tag of the enum in the constant
+// descConst, which holds a variant with the EnumDesc, from the payload
+// registers in declaration order.
+func InstrMakeVariant(resultReg int, descConst int, tag int, payload []int) Instruction {
+	return Instruction{
+		OpCode: MAKE_VARIANT,
+		Args:   append([]int{resultReg, descConst, tag}, payload...),
+	}
+}
+
+func InstrGetTag(resultReg int, variantReg int) Instruction {
+	return Instruction{
+		OpCode: GET_TAG,
+		Args:   []int{resultReg, variantReg},
+	}
+}
+
+func InstrGetPayload(resultReg int, variantReg int, fieldIndex int) Instruction {
+	return Instruction{
+		OpCode: GET_PAYLOAD,
+		Args:   []int{resultReg, variantReg, fieldIndex},
+	}
+}
+
+func InstrIsNull(resultReg int, reg int) Instruction {
+	return Instruction{
+		OpCode: IS_NULL,
+		Args:   []int{resultReg, reg},
+	}
+}
+
+// InstrCopy stores a deep copy of the value in reg, see Value.Copy.
+func InstrCopy(resultReg int, reg int) Instruction {
+	return Instruction{
+		OpCode: COPY,
+		Args:   []int{resultReg, reg},
+	}
+}
+
+// InstrCloseVars detaches locals in slots [from, to) from the closures that
+// captured them, so each loop iteration gets a fresh binding.
+func InstrCloseVars(from int, to int) Instruction {
+	return Instruction{
+		OpCode: CLOSE_VARS,
+		Args:   []int{from, to},
+	}
+}
@@ -2,15 +2,18 @@ package compiler
 
 import (
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 
 	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
 	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+	"youpiteron.dev/white-monster-on-friday-night/internal/lexer"
 )
 
 type VisitExprResult struct {
-	Reg           int
-	TypeOf        *ast.Type
-	FuncSignature *FuncSignature
+	Reg    int
+	TypeOf *ast.Type
 }
 
 func CastVisitExprResult(result any) (*VisitExprResult, bool) {
@@ -24,14 +27,53 @@ func CastVisitExprResult(result any) (*VisitExprResult, bool) {
 	return resultVisitExpr, true
 }
 
+// loopState collects the jumps emitted by break and continue statements so
+// that they can be patched once the loop's exit and continue targets are known.
+// varsFrom and varsTo are the slots closed at the end of every iteration, which
+// the break path has to close as well.
+type loopState struct {
+	breakJumps    []int
+	continueJumps []int
+	varsFrom      int
+	varsTo        int
+}
+
 type InstructionsVisitor struct {
 	context        Context
 	globalTable    *GlobalTable
+	globals        *Namespace
 	errors         []common.Error
 	warnings       []common.Error
 	reg            int
 	functionProtos []FunctionProto
 	moduleProtos   []ModuleProto
+	loops          []*loopState
+	hoisted        map[*ast.Function]int
+	structs        map[string]*structInfo
+	enums          map[string]*enumInfo
+	narrowings     []*narrowing
+	closureWrites  map[string]bool
+	typeParams     []string
+	path           string
+	imports        map[*ast.Import]*Module
+	importedFrom   map[string]string
+	exports        *moduleExports
+}
+
+// structInfo is what the compiler knows about a declared struct, fields maps
+// each field name to its index.
+type structInfo struct {
+	decl   *ast.Struct
+	desc   *StructDesc
+	fields map[string]int
+}
+
+// enumInfo is what the compiler knows about a declared enum, variants maps
+// each variant name to its tag.
+type enumInfo struct {
+	decl     *ast.Enum
+	desc     *EnumDesc
+	variants map[string]int
 }
 
 // ---------- Constructor ----------
@@ -39,7 +81,14 @@ type InstructionsVisitor struct {
 func NewInstructionsVisitor() *InstructionsVisitor {
 	globalTable := NewGlobalTable()
 	RegisterStdGlobals(globalTable)
-	return &InstructionsVisitor{context: nil, globalTable: globalTable, errors: []common.Error{}, warnings: []common.Error{}, reg: 0, functionProtos: []FunctionProto{}}
+	return newModuleVisitor(globalTable, "", map[*ast.Import]*Module{})
+}
+
+// newModuleVisitor returns a visitor for the file at path, which defines its
+// exports in globalTable. imports are the modules its import statements
+// resolved to.
+func newModuleVisitor(globalTable *GlobalTable, path string, imports map[*ast.Import]*Module) *InstructionsVisitor {
+	return &InstructionsVisitor{context: nil, globalTable: globalTable, globals: NewNamespace(globalTable), errors: []common.Error{}, warnings: []common.Error{}, reg: 0, functionProtos: []FunctionProto{}, hoisted: map[*ast.Function]int{}, structs: map[string]*structInfo{}, enums: map[string]*enumInfo{}, closureWrites: map[string]bool{}, path: path, imports: imports, importedFrom: map[string]string{}, exports: newModuleExports()}
 }
 
 // ---------- Helpers ----------
@@ -50,7 +99,7 @@ func (v *InstructionsVisitor) EnterModuleContext() {
 
 func (v *InstructionsVisitor) ExitModuleContext() {
 	moduleContext := CastModuleContext(v.context)
-	moduleProto := BuildModuleProto(*moduleContext, v.functionProtos)
+	moduleProto := BuildModuleProto(*moduleContext, v.functionProtos, v.path)
 	v.moduleProtos = append(v.moduleProtos, *moduleProto)
 	v.context = nil
 	v.functionProtos = []FunctionProto{}
@@ -58,11 +107,43 @@ func (v *InstructionsVisitor) ExitModuleContext() {
 
 func (v *InstructionsVisitor) EmitModuleProto() *ModuleProto {
 	moduleContext := CastModuleContext(v.context)
-	moduleProto := BuildModuleProto(*moduleContext, v.functionProtos)
+	moduleProto := BuildModuleProto(*moduleContext, v.functionProtos, v.path)
 	moduleContext.ClearInstructions()
 	return moduleProto
 }
 
+// moduleMark is what markModule saves of a module being compiled in chunks.
+type moduleMark struct {
+	scope          moduleScope
+	reg            int
+	functionProtos int
+	structs        map[string]*structInfo
+	enums          map[string]*enumInfo
+	narrowings     int
+}
+
+// markModule saves the declarations of the module context, so that the REPL
+// can drop a chunk that fails with restoreModule.
+func (v *InstructionsVisitor) markModule() moduleMark {
+	return moduleMark{
+		scope:          CastModuleContext(v.context).markScope(),
+		reg:            v.reg,
+		functionProtos: len(v.functionProtos),
+		structs:        maps.Clone(v.structs),
+		enums:          maps.Clone(v.enums),
+		narrowings:     v.markNarrowings(),
+	}
+}
+
+func (v *InstructionsVisitor) restoreModule(mark moduleMark) {
+	CastModuleContext(v.context).restoreScope(mark.scope)
+	v.reg = mark.reg
+	v.functionProtos = v.functionProtos[:mark.functionProtos]
+	v.structs = mark.structs
+	v.enums = mark.enums
+	v.restoreNarrowings(mark.narrowings)
+}
+
 func (v *InstructionsVisitor) addError(message string, pos *common.SourcePos) {
 	v.errors = append(v.errors, common.Error{Message: message, Pos: pos})
 }
@@ -71,6 +152,11 @@ func (v *InstructionsVisitor) addWarning(message string, pos *common.SourcePos)
 	v.warnings = append(v.warnings, common.Error{Message: message, Pos: pos})
 }
 
+func (v *InstructionsVisitor) resetDiagnostics() {
+	v.errors = []common.Error{}
+	v.warnings = []common.Error{}
+}
+
 func (v *InstructionsVisitor) nextReg() int {
 	reg := v.reg
 	v.reg++
@@ -83,8 +169,8 @@ func (v *InstructionsVisitor) resetReg() int {
 	return reg
 }
 
-func (v *InstructionsVisitor) enterFunctionContext(returnType *ast.Type) {
-	v.context = NewFunctionContext(v.context, returnType)
+func (v *InstructionsVisitor) enterFunctionContext(name string, returnType *ast.Type) {
+	v.context = NewFunctionContext(name, v.context, returnType)
 }
 
 func (v *InstructionsVisitor) exitFunctionContext() int {
@@ -102,12 +188,229 @@ func (v *InstructionsVisitor) exitBlockContext() {
 	v.context = v.context.Parent()
 }
 
+func (v *InstructionsVisitor) enterLoop() *loopState {
+	loop := &loopState{}
+	v.loops = append(v.loops, loop)
+	return loop
+}
+
+// exitLoop patches the break and continue jumps of loop and returns the index
+// of the first instruction after the loop. Breaking skips the CLOSE_VARS at the
+// end of the iteration, so the break jumps land on a CLOSE_VARS of their own.
+func (v *InstructionsVisitor) exitLoop(loop *loopState, continueTarget int, pos *common.SourcePos) int {
+	breakTarget := v.context.InstructionsLength()
+	if len(loop.breakJumps) > 0 && loop.varsTo > loop.varsFrom {
+		v.context.AddInstruction(InstrCloseVars(loop.varsFrom, loop.varsTo), pos)
+	}
+	for _, index := range loop.continueJumps {
+		v.context.SetInstruction(index, InstrJump(continueTarget-1))
+	}
+	for _, index := range loop.breakJumps {
+		v.context.SetInstruction(index, InstrJump(breakTarget-1))
+	}
+	v.loops = v.loops[:len(v.loops)-1]
+	return v.context.InstructionsLength()
+}
+
+// closeBlockVars emits a CLOSE_VARS for every local declared in the current
+// block scope, so closures created in a block, a loop iteration or a match arm
+// keep their own binding once the slots are reused.
+func (v *InstructionsVisitor) closeBlockVars(pos *common.SourcePos) {
+	from, to := CastBlockContext(v.context).VarSlotRange()
+	if to > from {
+		v.context.AddInstruction(InstrCloseVars(from, to), pos)
+	}
+}
+
+// closeLoopVars closes the locals of the current loop iteration like
+// closeBlockVars and records their slots for the break path.
+func (v *InstructionsVisitor) closeLoopVars(loop *loopState, pos *common.SourcePos) {
+	loop.varsFrom, loop.varsTo = CastBlockContext(v.context).VarSlotRange()
+	v.closeBlockVars(pos)
+}
+
+// hoistFunctions defines every function declared directly in statements
+// before any of them is compiled, so that functions in the same scope can call
+// themselves and each other. The closures are still created where they are
+// declared; hoisting only makes the names and signatures known up front.
+func (v *InstructionsVisitor) hoistFunctions(statements []ast.Statement) {
+	for _, statement := range statements {
+		function, ok := statement.(*ast.Function)
+		if !ok {
+			continue
+		}
+		// redefinitions are reported when the function itself is visited
+		if _, ok := v.context.FindLocalVariable(function.Name); ok {
+			continue
+		}
+		params := make([]*ast.Type, len(function.Params))
+		for i, param := range function.Params {
+			params[i] = param.TypeOf
+			if param.Vararg {
+				params[i] = ast.TypeArrayOf(param.TypeOf)
+			}
+		}
+		signature := &ast.Signature{Params: params, ReturnType: function.ReturnType, Vararg: function.Vararg}
+		typeOf := ast.TypeFunction(signature).Quantify(function.TypeParams)
+		v.hoisted[function] = v.context.DefineVariable(function.Name, false, typeOf)
+	}
+}
+
+// declareTypes registers the structs and enums declared at the top level of
+// a program before anything is compiled, so types can name a struct or an enum
+// declared further down, including the type itself.
+func (v *InstructionsVisitor) declareTypes(statements []ast.Statement) {
+	fieldLists := [][]ast.StructField{}
+	for _, statement := range statements {
+		switch decl := statement.(type) {
+		case *ast.Struct:
+			if v.isTypeDeclared(decl.Name) {
+				v.addError(fmt.Sprintf("struct %s already defined", decl.Name), decl.Pos())
+				continue
+			}
+			info := &structInfo{decl: decl, desc: &StructDesc{Name: decl.Name}, fields: map[string]int{}}
+			for i, field := range decl.Fields {
+				if _, ok := info.fields[field.Name]; ok {
+					v.addError(fmt.Sprintf("field %s is declared twice in struct %s", field.Name, decl.Name), field.PosAt)
+					continue
+				}
+				info.fields[field.Name] = i
+				info.desc.Fields = append(info.desc.Fields, field.Name)
+			}
+			v.structs[decl.Name] = info
+			if decl.Exported {
+				v.exports.structs[decl.Name] = info
+			}
+			fieldLists = append(fieldLists, decl.Fields)
+		case *ast.Enum:
+			if v.isTypeDeclared(decl.Name) {
+				v.addError(fmt.Sprintf("enum %s already defined", decl.Name), decl.Pos())
+				continue
+			}
+			info := &enumInfo{decl: decl, desc: &EnumDesc{Name: decl.Name}, variants: map[string]int{}}
+			for tag, variant := range decl.Variants {
+				if _, ok := info.variants[variant.Name]; ok {
+					v.addError(fmt.Sprintf("variant %s is declared twice in enum %s", variant.Name, decl.Name), variant.PosAt)
+					continue
+				}
+				info.variants[variant.Name] = tag
+				desc := VariantDesc{Name: variant.Name}
+				for _, field := range variant.Fields {
+					desc.Fields = append(desc.Fields, field.Name)
+				}
+				info.desc.Variants = append(info.desc.Variants, desc)
+				fieldLists = append(fieldLists, variant.Fields)
+			}
+			v.enums[decl.Name] = info
+			if decl.Exported {
+				v.exports.enums[decl.Name] = info
+			}
+		}
+	}
+	for _, fields := range fieldLists {
+		for _, field := range fields {
+			v.checkType(field.TypeOf, field.PosAt)
+		}
+	}
+}
+
+// isTypeDeclared reports whether name is taken by a struct or an enum, they
+// share one namespace.
+func (v *InstructionsVisitor) isTypeDeclared(name string) bool {
+	_, isStruct := v.structs[name]
+	_, isEnum := v.enums[name]
+	return isStruct || isEnum
+}
+
+// checkType reports the named types in t that are not declared.
+func (v *InstructionsVisitor) checkType(t *ast.Type, pos *common.SourcePos) bool {
+	if t == nil {
+		return true
+	}
+	if t.Type == ast.TYPE_NAMED {
+		if !v.isTypeDeclared(t.Name) && !slices.Contains(v.typeParams, t.Name) {
+			v.addError(fmt.Sprintf("type %s not found", t.Name), pos)
+			return false
+		}
+		return true
+	}
+	if !v.checkType(t.ElementType, pos) || !v.checkType(t.KeyType, pos) {
+		return false
+	}
+	if t.Signature != nil {
+		for _, param := range t.Signature.Params {
+			if !v.checkType(param, pos) {
+				return false
+			}
+		}
+		return v.checkType(t.Signature.ReturnType, pos)
+	}
+	return true
+}
+
+// structOf returns the struct a value of type t is, if any.
+func (v *InstructionsVisitor) structOf(t *ast.Type) (*structInfo, bool) {
+	if t.Type != ast.TYPE_NAMED {
+		return nil, false
+	}
+	info, ok := v.structs[t.Name]
+	return info, ok
+}
+
+// enumOf returns the enum a value of type t is, if any.
+func (v *InstructionsVisitor) enumOf(t *ast.Type) (*enumInfo, bool) {
+	if t.Type != ast.TYPE_NAMED {
+		return nil, false
+	}
+	info, ok := v.enums[t.Name]
+	return info, ok
+}
+
+// variantOf resolves `Shape.Circle` to the enum and the tag of the variant.
+// A variable named like the enum shadows it, so `shape.x` stays a field read.
+func (v *InstructionsVisitor) variantOf(n *ast.FieldExpr) (*enumInfo, int, bool) {
+	identifier, ok := n.Object.(*ast.Identifier)
+	if !ok {
+		return nil, 0, false
+	}
+	info, ok := v.enums[identifier.Name]
+	if !ok {
+		return nil, 0, false
+	}
+	if _, _, ok := v.context.FindVariable(identifier.Name); ok {
+		return nil, 0, false
+	}
+	if _, ok := v.globals.FindVariable(identifier.Name); ok {
+		return nil, 0, false
+	}
+	tag, ok := info.variants[n.Field]
+	if !ok {
+		return info, -1, true
+	}
+	return info, tag, true
+}
+
+// calleeName names the callee in error messages. Calls on other expressions,
+// such as `makeAdder(1)(2)`, have no name to show.
+func calleeName(callee ast.Expression) string {
+	if identifier, ok := callee.(*ast.Identifier); ok {
+		return identifier.Name
+	}
+	return "expression"
+}
+
 // ---------- Visitor Implementations ----------
 
 func (v *InstructionsVisitor) VisitProgram(n *ast.Program) any {
+	v.importModules(n.Statements)
+	v.declareTypes(n.Statements)
+	v.hoistFunctions(n.Statements)
+	newWriteScanner(v.closureWrites).block(n.Statements)
 	for _, statement := range n.Statements {
 		statement.Visit(v)
-		v.resetReg()
+		if v.resetReg() > maxRegisters {
+			v.addError(fmt.Sprintf("statement is too large, it needs more than %d registers", maxRegisters), statement.Pos())
+		}
 	}
 	return nil
 }
@@ -124,17 +427,31 @@ func (v *InstructionsVisitor) VisitDeclaration(n *ast.Declaration) any {
 		if !ok {
 			return nil
 		}
+		// a var must not share a constant's value, a constant must not share a var's
+		resultVisitExpr = v.copyAliased(n.Value, resultVisitExpr, !n.IsMutable)
 
+		typeOf := resultVisitExpr.TypeOf
 		if n.IsTyped {
-			if !resultVisitExpr.TypeOf.IsEqual(n.TypeOf) {
+			if !v.checkType(n.TypeOf, n.Identifier.Pos()) {
+				return nil
+			}
+			if !n.TypeOf.Accepts(resultVisitExpr.TypeOf) {
 				v.addError(fmt.Sprintf("variable %s is of type %s, but declaration is of type %s", n.Identifier.Name, resultVisitExpr.TypeOf, n.TypeOf), n.Identifier.Pos())
 				return nil
 			}
+			typeOf = n.TypeOf
+		}
+		if !typeOf.IsComplete() {
+			v.addError(fmt.Sprintf("cannot infer the element type of %s from an empty array or map, add a type annotation", n.Identifier.Name), n.Identifier.Pos())
+			return nil
 		}
 
-		slot := v.context.DefineVariable(n.Identifier.Name, n.IsMutable, resultVisitExpr.TypeOf)
+		slot := v.context.DefineVariable(n.Identifier.Name, n.IsMutable, typeOf)
 
-		v.context.AddInstruction(InstrStoreVar(resultVisitExpr.Reg, slot))
+		v.context.AddInstruction(InstrStoreVar(resultVisitExpr.Reg, slot), n.Pos())
+		if n.Exported {
+			v.exportValue(n.Identifier.Name, typeOf, resultVisitExpr.Reg, n.Pos())
+		}
 	} else {
 		if !n.IsTyped {
 			v.addError(fmt.Sprintf("type is required for declaration of variable %s with default value", n.Identifier.Name), n.Identifier.Pos())
@@ -144,82 +461,345 @@ func (v *InstructionsVisitor) VisitDeclaration(n *ast.Declaration) any {
 			v.addError(fmt.Sprintf("constant %s must have a value", n.Identifier.Name), n.Identifier.Pos())
 			return nil
 		}
-		defaultValue := DefaultValue(n.TypeOf)
-		constIndex := v.context.AddConstant(defaultValue)
+		if !v.checkType(n.TypeOf, n.Identifier.Pos()) {
+			return nil
+		}
+		if n.TypeOf.IsCallable() {
+			v.addError(fmt.Sprintf("function variable %s must have a value", n.Identifier.Name), n.Identifier.Pos())
+			return nil
+		}
+		if _, ok := v.enumOf(n.TypeOf); ok {
+			v.addError(fmt.Sprintf("enum variable %s must have a value", n.Identifier.Name), n.Identifier.Pos())
+			return nil
+		}
+		if n.TypeOf.Type == ast.TYPE_NAMED && slices.Contains(v.typeParams, n.TypeOf.Name) {
+			v.addError(fmt.Sprintf("variable %s of type parameter %s must have a value", n.Identifier.Name, n.TypeOf.Name), n.Identifier.Pos())
+			return nil
+		}
+		if n.TypeOf.Type == ast.TYPE_NAMED {
+			v.addError(fmt.Sprintf("struct variable %s must have a value", n.Identifier.Name), n.Identifier.Pos())
+			return nil
+		}
 		slot := v.context.DefineVariable(n.Identifier.Name, n.IsMutable, n.TypeOf)
 
 		reg := v.nextReg()
-		v.context.AddInstruction(InstrLoadConst(reg, constIndex))
-		v.context.AddInstruction(InstrStoreVar(reg, slot))
+		if n.TypeOf.Type == ast.TYPE_MAP {
+			// a constant would share one map between every run of the declaration
+			v.context.AddInstruction(InstrMakeMap(reg, nil), n.Pos())
+		} else {
+			v.context.AddInstruction(InstrLoadConst(reg, v.context.AddConstant(DefaultValue(n.TypeOf))), n.Pos())
+		}
+		v.context.AddInstruction(InstrStoreVar(reg, slot), n.Pos())
 	}
 
 	return nil
 }
 
 func (v *InstructionsVisitor) VisitAssignment(n *ast.Assignment) any {
+	switch target := n.Target.(type) {
+	case *ast.Identifier:
+		return v.visitVariableAssignment(n, target)
+	case *ast.IndexExpr:
+		return v.visitIndexAssignment(n, target)
+	case *ast.FieldExpr:
+		return v.visitFieldAssignment(n, target)
+	}
+	v.addError("only variables, elements and fields can be assigned", n.Target.Pos())
+	return nil
+}
+
+func (v *InstructionsVisitor) visitVariableAssignment(n *ast.Assignment, target *ast.Identifier) any {
 	var globalVar *Variable
-	localVar, upvar, ok := v.context.FindVariable(n.Identifier.Name)
+	localVar, upvar, ok := v.context.FindVariable(target.Name)
 	if !ok {
-		globalVar, ok = v.globalTable.FindVariable(n.Identifier.Name)
+		globalVar, ok = v.globals.FindVariable(target.Name)
 		if !ok {
-			v.addError(fmt.Sprintf("variable %s not found", n.Identifier.Name), n.Identifier.Pos())
+			v.addError(fmt.Sprintf("variable %s not found", target.Name), target.Pos())
 			return nil
 		}
 	}
-	result := n.Value.Visit(v)
-	resultVisitExpr, ok := CastVisitExprResult(result)
-	if !ok {
+	var current *VisitExprResult
+	if n.Compound {
+		current, ok = CastVisitExprResult(target.Visit(v))
+		if !ok {
+			return nil
+		}
+	}
+	resultVisitExpr := v.visitAssignedValue(n, current)
+	if resultVisitExpr == nil {
 		return nil
 	}
 
 	if localVar != nil {
 		if !localVar.Mutable {
-			v.addError(fmt.Sprintf("variable %s is not mutable", n.Identifier.Name), n.Identifier.Pos())
+			v.addError(fmt.Sprintf("variable %s is not mutable", target.Name), target.Pos())
 			return nil
 		}
-		if !resultVisitExpr.TypeOf.IsEqual(localVar.TypeOf) {
-			v.addError(fmt.Sprintf("variable %s is of type %s, but assignment is of type %s", n.Identifier.Name, localVar.TypeOf, resultVisitExpr.TypeOf), n.Identifier.Pos())
+		if !localVar.TypeOf.Accepts(resultVisitExpr.TypeOf) {
+			v.addError(fmt.Sprintf("variable %s is of type %s, but assignment is of type %s", target.Name, localVar.TypeOf, resultVisitExpr.TypeOf), target.Pos())
 			return nil
 		}
-		v.context.AddInstruction(InstrStoreVar(resultVisitExpr.Reg, localVar.Slot))
+		v.context.AddInstruction(InstrStoreVar(resultVisitExpr.Reg, localVar.Slot), n.Pos())
+		if resultVisitExpr.TypeOf.IsNullable() {
+			v.forgetNarrowings(target.Name)
+		}
 	} else if upvar != nil {
 		if !upvar.Mutable {
-			v.addError(fmt.Sprintf("variable %s is not mutable", n.Identifier.Name), n.Identifier.Pos())
+			v.addError(fmt.Sprintf("variable %s is not mutable", target.Name), target.Pos())
 			return nil
 		}
-		if !resultVisitExpr.TypeOf.IsEqual(upvar.TypeOf) {
-			v.addError(fmt.Sprintf("variable %s is of type %s, but assignment is of type %s", n.Identifier.Name, upvar.TypeOf, resultVisitExpr.TypeOf), n.Identifier.Pos())
+		if !upvar.TypeOf.Accepts(resultVisitExpr.TypeOf) {
+			v.addError(fmt.Sprintf("variable %s is of type %s, but assignment is of type %s", target.Name, upvar.TypeOf, resultVisitExpr.TypeOf), target.Pos())
 			return nil
 		}
-		v.context.AddInstruction(InstrAssignUpvar(resultVisitExpr.Reg, upvar.LocalSlot))
+		v.context.AddInstruction(InstrAssignUpvar(resultVisitExpr.Reg, upvar.LocalSlot), n.Pos())
 	} else if globalVar != nil {
 		if !globalVar.Mutable {
-			v.addError(fmt.Sprintf("variable %s is not mutable", n.Identifier.Name), n.Identifier.Pos())
+			v.addError(fmt.Sprintf("variable %s is not mutable", target.Name), target.Pos())
 			return nil
 		}
-		if !resultVisitExpr.TypeOf.IsEqual(globalVar.TypeOf) {
-			v.addError(fmt.Sprintf("variable %s is of type %s, but assignment is of type %s", n.Identifier.Name, globalVar.TypeOf, resultVisitExpr.TypeOf), n.Identifier.Pos())
+		if !globalVar.TypeOf.Accepts(resultVisitExpr.TypeOf) {
+			v.addError(fmt.Sprintf("variable %s is of type %s, but assignment is of type %s", target.Name, globalVar.TypeOf, resultVisitExpr.TypeOf), target.Pos())
 			return nil
 		}
 
-		v.context.AddInstruction(InstrAssignGlobal(resultVisitExpr.Reg, globalVar.Slot))
+		v.context.AddInstruction(InstrAssignGlobal(resultVisitExpr.Reg, globalVar.Slot), n.Pos())
+	}
+
+	return nil
+}
+
+// visitIndexAssignment compiles `a[i] = x` and `m[k] = x`. Arrays and maps
+// share their elements, so writing into one is only allowed when the variable
+// holding it is mutable.
+func (v *InstructionsVisitor) visitIndexAssignment(n *ast.Assignment, target *ast.IndexExpr) any {
+	if !v.checkRootMutable(target) {
+		return nil
+	}
+
+	arrayResult := target.Array.Visit(v)
+	arrayVisitExpr, ok := CastVisitExprResult(arrayResult)
+	if !ok {
+		return nil
+	}
+	indexResult := target.Index.Visit(v)
+	indexVisitExpr, ok := CastVisitExprResult(indexResult)
+	if !ok {
+		return nil
+	}
+	elementType, ok := v.checkIndex(target, arrayVisitExpr.TypeOf, indexVisitExpr.TypeOf)
+	if !ok {
+		return nil
+	}
+
+	var current *VisitExprResult
+	if n.Compound {
+		reg := v.nextReg()
+		v.context.AddInstruction(indexInstruction(arrayVisitExpr.TypeOf, reg, arrayVisitExpr.Reg, indexVisitExpr.Reg), target.Pos())
+		current = &VisitExprResult{Reg: reg, TypeOf: elementType}
+	}
+	resultVisitExpr := v.visitAssignedValue(n, current)
+	if resultVisitExpr == nil {
+		return nil
+	}
+	if !elementType.Accepts(resultVisitExpr.TypeOf) {
+		v.addError(fmt.Sprintf("%s element is of type %s, but assignment is of type %s", arrayVisitExpr.TypeOf.Type, elementType, resultVisitExpr.TypeOf), target.Pos())
+		return nil
+	}
+	if arrayVisitExpr.TypeOf.Type == ast.TYPE_MAP {
+		v.context.AddInstruction(InstrStoreMap(arrayVisitExpr.Reg, indexVisitExpr.Reg, resultVisitExpr.Reg), n.Pos())
+	} else {
+		v.context.AddInstruction(InstrStoreIndex(arrayVisitExpr.Reg, indexVisitExpr.Reg, resultVisitExpr.Reg), n.Pos())
+	}
+	return nil
+}
+
+// visitFieldAssignment compiles `p.x = v`, writing the field of the shared
+// struct.
+func (v *InstructionsVisitor) visitFieldAssignment(n *ast.Assignment, target *ast.FieldExpr) any {
+	if !v.checkRootMutable(target) {
+		return nil
+	}
+
+	objectResult := target.Object.Visit(v)
+	objectVisitExpr, ok := CastVisitExprResult(objectResult)
+	if !ok {
+		return nil
+	}
+	fieldIndex, fieldType, ok := v.checkField(target, objectVisitExpr.TypeOf)
+	if !ok {
+		return nil
 	}
 
+	var current *VisitExprResult
+	if n.Compound {
+		reg := v.nextReg()
+		v.context.AddInstruction(InstrGetField(reg, objectVisitExpr.Reg, fieldIndex), target.Pos())
+		current = &VisitExprResult{Reg: reg, TypeOf: fieldType}
+	}
+	resultVisitExpr := v.visitAssignedValue(n, current)
+	if resultVisitExpr == nil {
+		return nil
+	}
+	if !fieldType.Accepts(resultVisitExpr.TypeOf) {
+		v.addError(fmt.Sprintf("field %s is of type %s, but assignment is of type %s", target.Field, fieldType, resultVisitExpr.TypeOf), target.Pos())
+		return nil
+	}
+	v.context.AddInstruction(InstrSetField(objectVisitExpr.Reg, fieldIndex, resultVisitExpr.Reg), n.Pos())
 	return nil
 }
 
+// checkRootMutable checks the variable that an element or field assignment
+// starts from, `a` in `a[i].x = v`.
+func (v *InstructionsVisitor) checkRootMutable(target ast.Expression) bool {
+	root, ok := assignmentRoot(target).(*ast.Identifier)
+	if !ok {
+		return true
+	}
+	mutable, found := v.isMutableVariable(root.Name)
+	if !found {
+		v.addError(fmt.Sprintf("variable %s not found", root.Name), root.Pos())
+		return false
+	}
+	if !mutable {
+		v.addError(fmt.Sprintf("variable %s is not mutable", root.Name), root.Pos())
+		return false
+	}
+	return true
+}
+
+// visitAssignedValue compiles the value of an assignment. For a compound
+// assignment it is combined with current, the value already in the target.
+func (v *InstructionsVisitor) visitAssignedValue(n *ast.Assignment, current *VisitExprResult) *VisitExprResult {
+	valueVisitExpr, ok := v.visitOwned(n.Value)
+	if !ok {
+		return nil
+	}
+	if !n.Compound {
+		return valueVisitExpr
+	}
+	opInfo, ok := v.resolveBinaryOp(n.Operator, current.TypeOf, valueVisitExpr.TypeOf, n.Pos())
+	if !ok {
+		return nil
+	}
+	reg := v.nextReg()
+	v.context.AddInstruction(InstrBinary(opInfo.OpCode, reg, current.Reg, valueVisitExpr.Reg), n.Pos())
+	return &VisitExprResult{Reg: reg, TypeOf: opInfo.ResultType}
+}
+
+// assignmentRoot returns the expression a chain of indexes and fields such as
+// `a[i].x` starts from.
+func assignmentRoot(expr ast.Expression) ast.Expression {
+	for {
+		switch outer := expr.(type) {
+		case *ast.IndexExpr:
+			expr = outer.Array
+		case *ast.FieldExpr:
+			expr = outer.Object
+		default:
+			return expr
+		}
+	}
+}
+
+func (v *InstructionsVisitor) isMutableVariable(name string) (bool, bool) {
+	localVar, upvar, ok := v.context.FindVariable(name)
+	if ok {
+		if localVar != nil {
+			return localVar.Mutable, true
+		}
+		return upvar.Mutable, true
+	}
+	globalVar, ok := v.globals.FindVariable(name)
+	if !ok {
+		return false, false
+	}
+	return globalVar.Mutable, true
+}
+
+// aliases reports whether the value of expr may be held by a variable whose
+// mutability is mutable, as `a` holds the value of `a[i].x` or `c ? a : b`.
+func (v *InstructionsVisitor) aliases(expr ast.Expression, mutable bool) bool {
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		isMutable, found := v.isMutableVariable(expr.Name)
+		return found && isMutable == mutable
+	case *ast.IndexExpr:
+		return v.aliases(expr.Array, mutable)
+	case *ast.FieldExpr:
+		return v.aliases(expr.Object, mutable)
+	case *ast.ConditionalExpr:
+		return v.aliases(expr.Then, mutable) || v.aliases(expr.Else, mutable)
+	case *ast.BinaryExpr:
+		return expr.Operator == lexer.OperatorNullCoalesce && (v.aliases(expr.Left, mutable) || v.aliases(expr.Right, mutable))
+	case *ast.MatchExpr:
+		for _, arm := range expr.Arms {
+			if v.aliases(arm.Body, mutable) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isShared reports whether values of type t may be arrays, maps, structs or
+// enum values, which every variable holding them shares.
+func isShared(t *ast.Type) bool {
+	switch t.Type {
+	case ast.TYPE_ARRAY, ast.TYPE_MAP, ast.TYPE_NAMED, ast.TYPE_PARAM, ast.TYPE_ANY:
+		return true
+	case ast.TYPE_NULLABLE:
+		return isShared(t.ElementType)
+	}
+	return false
+}
+
+// copyAliased copies result when expr may be held by a variable whose
+// mutability is mutable. Values of constants, parameters and match bindings
+// are copied when they are stored where they can be written through, and a
+// constant copies a value that a variable could still change.
+func (v *InstructionsVisitor) copyAliased(expr ast.Expression, result *VisitExprResult, mutable bool) *VisitExprResult {
+	if !isShared(result.TypeOf) || !v.aliases(expr, mutable) {
+		return result
+	}
+	reg := v.nextReg()
+	v.context.AddInstruction(InstrCopy(reg, result.Reg), expr.Pos())
+	return &VisitExprResult{Reg: reg, TypeOf: result.TypeOf}
+}
+
+// visitOwned visits expr and copies its value when it may be held by a
+// constant, for values stored in a variable, an element or a field, or
+// returned.
+func (v *InstructionsVisitor) visitOwned(expr ast.Expression) (*VisitExprResult, bool) {
+	result, ok := CastVisitExprResult(expr.Visit(v))
+	if !ok {
+		return nil, false
+	}
+	return v.copyAliased(expr, result, false), true
+}
+
 func (v *InstructionsVisitor) VisitReturn(n *ast.Return) any {
-	result := n.Value.Visit(v)
-	resultVisitExpr, ok := CastVisitExprResult(result)
+	if n.Value == nil {
+		returnType := v.context.ReturnType()
+		if !returnType.IsEqual(ast.TypeNull()) {
+			v.addError(fmt.Sprintf("return value of type %s is missing", returnType), n.Pos())
+			return nil
+		}
+		reg := v.nextReg()
+		v.context.AddInstruction(InstrLoadConst(reg, v.context.AddConstant(NewNullValue())), n.Pos())
+		v.context.AddInstruction(InstrReturn(reg), n.Pos())
+		return nil
+	}
+	resultVisitExpr, ok := v.visitOwned(n.Value)
 	if !ok {
 		return nil
 	}
 	returnType := v.context.ReturnType()
-	if !resultVisitExpr.TypeOf.IsEqual(returnType) {
+	if !returnType.Accepts(resultVisitExpr.TypeOf) {
 		v.addError(fmt.Sprintf("return value must be of type %s, but got %s", returnType, resultVisitExpr.TypeOf), n.Value.Pos())
 		return nil
 	}
-	v.context.AddInstruction(InstrReturn(resultVisitExpr.Reg))
+	v.context.AddInstruction(InstrReturn(resultVisitExpr.Reg), n.Pos())
 	return nil
 }
 
@@ -229,27 +809,47 @@ func (v *InstructionsVisitor) VisitIntLiteral(n *ast.IntLiteral) any {
 	}
 	reg := v.nextReg()
 	constIndex := v.context.AddConstant(NewIntValue(n.Value))
-	v.context.AddInstruction(InstrLoadConst(reg, constIndex))
+	v.context.AddInstruction(InstrLoadConst(reg, constIndex), n.Pos())
 	return &VisitExprResult{Reg: reg, TypeOf: ast.TypeInt()}
 }
 
+func (v *InstructionsVisitor) VisitFloatLiteral(n *ast.FloatLiteral) any {
+	if n.IsStatement {
+		return nil
+	}
+	reg := v.nextReg()
+	constIndex := v.context.AddConstant(NewFloatValue(n.Value))
+	v.context.AddInstruction(InstrLoadConst(reg, constIndex), n.Pos())
+	return &VisitExprResult{Reg: reg, TypeOf: ast.TypeFloat()}
+}
+
 func (v *InstructionsVisitor) VisitBoolLiteral(n *ast.BoolLiteral) any {
 	if n.IsStatement {
 		return nil
 	}
 	reg := v.nextReg()
 	constIndex := v.context.AddConstant(NewBoolValue(n.Value))
-	v.context.AddInstruction(InstrLoadConst(reg, constIndex))
+	v.context.AddInstruction(InstrLoadConst(reg, constIndex), n.Pos())
 	return &VisitExprResult{Reg: reg, TypeOf: ast.TypeBool()}
 }
 
+func (v *InstructionsVisitor) VisitStringLiteral(n *ast.StringLiteral) any {
+	if n.IsStatement {
+		return nil
+	}
+	reg := v.nextReg()
+	constIndex := v.context.AddConstant(NewStringValue(n.Value))
+	v.context.AddInstruction(InstrLoadConst(reg, constIndex), n.Pos())
+	return &VisitExprResult{Reg: reg, TypeOf: ast.TypeString()}
+}
+
 func (v *InstructionsVisitor) VisitNullLiteral(n *ast.NullLiteral) any {
 	if n.IsStatement {
 		return nil
 	}
 	reg := v.nextReg()
 	constIndex := v.context.AddConstant(NewNullValue())
-	v.context.AddInstruction(InstrLoadConst(reg, constIndex))
+	v.context.AddInstruction(InstrLoadConst(reg, constIndex), n.Pos())
 	return &VisitExprResult{Reg: reg, TypeOf: ast.TypeNull()}
 }
 
@@ -260,77 +860,616 @@ func (v *InstructionsVisitor) VisitArrayLiteral(n *ast.ArrayLiteral) any {
 	elements := []int{}
 	var typeOf *ast.Type
 	for _, element := range n.Elements {
-		elementResult := element.Visit(v)
-		elementVisitExpr, ok := CastVisitExprResult(elementResult)
+		elementVisitExpr, ok := v.visitOwned(element)
 		if !ok {
 			return nil
 		}
 		elements = append(elements, elementVisitExpr.Reg)
+		// `[[], [1]]` takes its type from the element that is known and
+		// `[1, null]` is an []int?
 		if typeOf == nil {
 			typeOf = elementVisitExpr.TypeOf
-		} else if !typeOf.IsEqual(elementVisitExpr.TypeOf) {
+		} else if joined := typeOf.Join(elementVisitExpr.TypeOf); joined != nil {
+			typeOf = joined
+		} else {
 			v.addError(fmt.Sprintf("array elements must be of type %s, but got %s", typeOf, elementVisitExpr.TypeOf), element.Pos())
 			return nil
 		}
 	}
 	reg := v.nextReg()
-	v.context.AddInstruction(InstrMakeArray(reg, elements))
+	v.context.AddInstruction(InstrMakeArray(reg, elements), n.Pos())
 	return &VisitExprResult{Reg: reg, TypeOf: ast.TypeArrayOf(typeOf)}
 }
 
-func (v *InstructionsVisitor) VisitIdentifier(n *ast.Identifier) any {
+// VisitMapLiteral compiles `{ k: v, ... }`. As with arrays, the key and value
+// types come from the entries, `{}` fits any map type.
+func (v *InstructionsVisitor) VisitMapLiteral(n *ast.MapLiteral) any {
 	if n.IsStatement {
 		return nil
 	}
-	var globalVar *Variable
-	localVar, upvar, ok := v.context.FindVariable(n.Name)
-	if !ok {
-		globalVar, ok = v.globalTable.FindVariable(n.Name)
+	entries := []int{}
+	var keyType, valueType *ast.Type
+	for _, entry := range n.Entries {
+		keyResult := entry.Key.Visit(v)
+		keyVisitExpr, ok := CastVisitExprResult(keyResult)
 		if !ok {
-			v.addError(fmt.Sprintf("variable %s not found", n.Name), n.Pos())
+			return nil
+		}
+		valueVisitExpr, ok := v.visitOwned(entry.Value)
+		if !ok {
+			return nil
+		}
+		entries = append(entries, keyVisitExpr.Reg, valueVisitExpr.Reg)
+
+		if keyType == nil {
+			if !keyVisitExpr.TypeOf.IsHashable() {
+				v.addError(fmt.Sprintf("map keys must be of type int, bool or string, but got %s", keyVisitExpr.TypeOf), entry.Key.Pos())
+				return nil
+			}
+			keyType = keyVisitExpr.TypeOf
+		} else if !keyType.IsEqual(keyVisitExpr.TypeOf) {
+			v.addError(fmt.Sprintf("map keys must be of type %s, but got %s", keyType, keyVisitExpr.TypeOf), entry.Key.Pos())
+			return nil
+		}
+		if valueType == nil {
+			valueType = valueVisitExpr.TypeOf
+		} else if joined := valueType.Join(valueVisitExpr.TypeOf); joined != nil {
+			valueType = joined
+		} else {
+			v.addError(fmt.Sprintf("map values must be of type %s, but got %s", valueType, valueVisitExpr.TypeOf), entry.Value.Pos())
 			return nil
 		}
 	}
 	reg := v.nextReg()
-	var typeOf *ast.Type
-	var funcSignature *FuncSignature
-	if localVar != nil {
-		v.context.AddInstruction(InstrLoadVar(reg, localVar.Slot))
-		typeOf = localVar.TypeOf
-		funcSignature = localVar.FuncSignature
-	} else if upvar != nil {
-		v.context.AddInstruction(InstrLoadUpvar(reg, upvar.LocalSlot))
-		typeOf = upvar.TypeOf
-		funcSignature = upvar.FuncSignature
-	} else if globalVar != nil {
-		v.context.AddInstruction(InstrLoadGlobal(reg, globalVar.Slot))
-		typeOf = globalVar.TypeOf
-		funcSignature = globalVar.FuncSignature
-	}
-	return &VisitExprResult{Reg: reg, TypeOf: typeOf, FuncSignature: funcSignature}
+	v.context.AddInstruction(InstrMakeMap(reg, entries), n.Pos())
+	return &VisitExprResult{Reg: reg, TypeOf: ast.TypeMapOf(keyType, valueType)}
 }
 
-func (v *InstructionsVisitor) VisitBinaryExpr(n *ast.BinaryExpr) any {
-	leftResult := n.Left.Visit(v)
-	leftVisitExpr, leftOk := CastVisitExprResult(leftResult)
-	rightResult := n.Right.Visit(v)
-	rightVisitExpr, rightOk := CastVisitExprResult(rightResult)
-
-	if !leftOk || !rightOk || n.IsStatement {
+// VisitStructLiteral compiles `Point { x: 1, y: 2 }`, the field values are
+// passed to MAKE_STRUCT in declaration order whatever order they are written in.
+func (v *InstructionsVisitor) VisitStructLiteral(n *ast.StructLiteral) any {
+	if n.IsStatement {
 		return nil
 	}
-
-	opInfo, ok := ResolveBinaryOp(n.Operator, leftVisitExpr.TypeOf, rightVisitExpr.TypeOf)
+	info, ok := v.structs[n.Name]
 	if !ok {
-		v.addError(fmt.Sprintf("binary operator %s is not supported for types %s and %s", n.Operator, leftVisitExpr.TypeOf, rightVisitExpr.TypeOf), n.Pos())
+		v.addError(fmt.Sprintf("struct %s not found", n.Name), n.Pos())
+		return nil
+	}
+	fields := make([]int, len(info.decl.Fields))
+	isSet := make([]bool, len(info.decl.Fields))
+	for _, field := range n.Fields {
+		index, ok := info.fields[field.Name]
+		if !ok {
+			v.addError(fmt.Sprintf("struct %s has no field %s", n.Name, field.Name), field.PosAt)
+			return nil
+		}
+		if isSet[index] {
+			v.addError(fmt.Sprintf("field %s is set twice", field.Name), field.PosAt)
+			return nil
+		}
+		valueVisitExpr, ok := v.visitOwned(field.Value)
+		if !ok {
+			return nil
+		}
+		fieldType := info.decl.Fields[index].TypeOf
+		if !fieldType.Accepts(valueVisitExpr.TypeOf) {
+			v.addError(fmt.Sprintf("field %s is of type %s, but got %s", field.Name, fieldType, valueVisitExpr.TypeOf), field.Value.Pos())
+			return nil
+		}
+		fields[index] = valueVisitExpr.Reg
+		isSet[index] = true
+	}
+	for i, field := range info.decl.Fields {
+		if !isSet[i] {
+			v.addError(fmt.Sprintf("missing field %s in %s literal", field.Name, n.Name), n.Pos())
+			return nil
+		}
+	}
+	descConst := v.context.AddConstant(NewStructValue(&Struct{Desc: info.desc}))
+	reg := v.nextReg()
+	v.context.AddInstruction(InstrMakeStruct(reg, descConst, fields), n.Pos())
+	return &VisitExprResult{Reg: reg, TypeOf: ast.TypeNamed(n.Name)}
+}
+
+func (v *InstructionsVisitor) VisitFieldExpr(n *ast.FieldExpr) any {
+	if info, tag, ok := v.variantOf(n); ok {
+		return v.visitVariant(n, info, tag, nil)
+	}
+	objectResult := n.Object.Visit(v)
+	objectVisitExpr, ok := CastVisitExprResult(objectResult)
+	if !ok {
+		return nil
+	}
+	fieldIndex, fieldType, ok := v.checkField(n, objectVisitExpr.TypeOf)
+	if !ok {
+		return nil
+	}
+	reg := v.nextReg()
+	v.context.AddInstruction(InstrGetField(reg, objectVisitExpr.Reg, fieldIndex), n.Pos())
+	return &VisitExprResult{Reg: reg, TypeOf: fieldType}
+}
+
+// checkField resolves the index and the type of the field n reads.
+func (v *InstructionsVisitor) checkField(n *ast.FieldExpr, object *ast.Type) (int, *ast.Type, bool) {
+	info, ok := v.structOf(object)
+	if !ok {
+		v.addError(fmt.Sprintf("expression of type %s has no field %s%s", object, n.Field, nullableHint(object)), n.Pos())
+		return 0, nil, false
+	}
+	index, ok := info.fields[n.Field]
+	if !ok {
+		v.addError(fmt.Sprintf("struct %s has no field %s", info.decl.Name, n.Field), n.Pos())
+		return 0, nil, false
+	}
+	return index, info.decl.Fields[index].TypeOf, true
+}
+
+// visitVariant compiles `Shape.Circle(3)`, or `Shape.Empty` with no arguments,
+// into MAKE_VARIANT.
+func (v *InstructionsVisitor) visitVariant(n *ast.FieldExpr, info *enumInfo, tag int, arguments []ast.Expression) any {
+	if n.IsStatement {
+		return nil
+	}
+	if tag < 0 {
+		v.addError(fmt.Sprintf("enum %s has no variant %s", info.decl.Name, n.Field), n.Pos())
+		return nil
+	}
+	variant := info.decl.Variants[tag]
+	if len(arguments) != len(variant.Fields) {
+		v.addError(fmt.Sprintf("variant %s.%s takes %d arguments, but got %d", info.decl.Name, variant.Name, len(variant.Fields), len(arguments)), n.Pos())
+		return nil
+	}
+	payload := make([]int, len(arguments))
+	for i, argument := range arguments {
+		argumentVisitExpr, ok := v.visitOwned(argument)
+		if !ok {
+			return nil
+		}
+		field := variant.Fields[i]
+		if !field.TypeOf.Accepts(argumentVisitExpr.TypeOf) {
+			v.addError(fmt.Sprintf("field %s is of type %s, but got %s", field.Name, field.TypeOf, argumentVisitExpr.TypeOf), argument.Pos())
+			return nil
+		}
+		payload[i] = argumentVisitExpr.Reg
+	}
+	descConst := v.context.AddConstant(NewVariantValue(&Variant{Desc: info.desc}))
+	reg := v.nextReg()
+	v.context.AddInstruction(InstrMakeVariant(reg, descConst, tag, payload), n.Pos())
+	return &VisitExprResult{Reg: reg, TypeOf: ast.TypeNamed(info.decl.Name)}
+}
+
+// VisitStruct emits nothing, the struct was registered by declareTypes.
+func (v *InstructionsVisitor) VisitStruct(n *ast.Struct) any {
+	if _, ok := v.context.(*ModuleContext); !ok {
+		v.addError(fmt.Sprintf("struct %s must be declared at the top level", n.Name), n.Pos())
+		return nil
+	}
+	if n.Exported {
+		for _, field := range n.Fields {
+			v.checkExportedType(n.Name, field.TypeOf, field.PosAt)
+		}
+	}
+	return nil
+}
+
+// VisitEnum emits nothing, the enum was registered by declareTypes.
+func (v *InstructionsVisitor) VisitEnum(n *ast.Enum) any {
+	if _, ok := v.context.(*ModuleContext); !ok {
+		v.addError(fmt.Sprintf("enum %s must be declared at the top level", n.Name), n.Pos())
+		return nil
+	}
+	if n.Exported {
+		for _, variant := range n.Variants {
+			for _, field := range variant.Fields {
+				v.checkExportedType(n.Name, field.TypeOf, field.PosAt)
+			}
+		}
+	}
+	return nil
+}
+
+// VisitImport emits nothing, the module was imported by importModules.
+func (v *InstructionsVisitor) VisitImport(n *ast.Import) any {
+	if _, ok := v.context.(*ModuleContext); !ok {
+		v.addError(fmt.Sprintf("import of %s must be at the top level", n.Path), n.Pos())
+	}
+	return nil
+}
+
+func (v *InstructionsVisitor) VisitIdentifier(n *ast.Identifier) any {
+	if n.IsStatement {
+		return nil
+	}
+	var globalVar *Variable
+	localVar, upvar, ok := v.context.FindVariable(n.Name)
+	if !ok {
+		globalVar, ok = v.globals.FindVariable(n.Name)
+		if !ok {
+			v.addError(fmt.Sprintf("variable %s not found", n.Name), n.Pos())
+			return nil
+		}
+	}
+	reg := v.nextReg()
+	var typeOf *ast.Type
+	if localVar != nil {
+		v.context.AddInstruction(InstrLoadVar(reg, localVar.Slot), n.Pos())
+		typeOf = localVar.TypeOf
+		if narrowed, ok := v.narrowedType(n.Name); ok {
+			typeOf = narrowed
+		}
+	} else if upvar != nil {
+		v.context.AddInstruction(InstrLoadUpvar(reg, upvar.LocalSlot), n.Pos())
+		typeOf = upvar.TypeOf
+	} else if globalVar != nil {
+		v.context.AddInstruction(InstrLoadGlobal(reg, globalVar.Slot), n.Pos())
+		typeOf = globalVar.TypeOf
+	}
+	return &VisitExprResult{Reg: reg, TypeOf: typeOf}
+}
+
+func (v *InstructionsVisitor) VisitBinaryExpr(n *ast.BinaryExpr) any {
+	if n.Operator == lexer.OperatorAnd || n.Operator == lexer.OperatorOr {
+		return v.visitLogicalExpr(n)
+	}
+	if n.Operator == lexer.OperatorNullCoalesce {
+		return v.visitNullCoalesceExpr(n)
+	}
+
+	leftResult := n.Left.Visit(v)
+	leftVisitExpr, leftOk := CastVisitExprResult(leftResult)
+	rightResult := n.Right.Visit(v)
+	rightVisitExpr, rightOk := CastVisitExprResult(rightResult)
+
+	if !leftOk || !rightOk || n.IsStatement {
+		return nil
+	}
+
+	if isNullComparison(n.Operator, leftVisitExpr.TypeOf, rightVisitExpr.TypeOf) {
+		operandReg := leftVisitExpr.Reg
+		if leftVisitExpr.TypeOf.Type == ast.TYPE_NULL {
+			operandReg = rightVisitExpr.Reg
+		}
+		reg := v.nextReg()
+		v.context.AddInstruction(InstrIsNull(reg, operandReg), n.Pos())
+		if n.Operator == lexer.OperatorNotEqual {
+			v.context.AddInstruction(InstrUnary(NOT_BOOL, reg, reg), n.Pos())
+		}
+		return &VisitExprResult{Reg: reg, TypeOf: ast.TypeBool()}
+	}
+
+	opInfo, ok := v.resolveBinaryOp(n.Operator, leftVisitExpr.TypeOf, rightVisitExpr.TypeOf, n.Pos())
+	if !ok {
+		return nil
+	}
+	reg := v.nextReg()
+	v.context.AddInstruction(InstrBinary(opInfo.OpCode, reg, leftVisitExpr.Reg, rightVisitExpr.Reg), n.Pos())
+	return &VisitExprResult{Reg: reg, TypeOf: opInfo.ResultType}
+}
+
+// isNullComparison reports whether `==` or `!=` compares a nullable value with
+// null, which compiles to IS_NULL whatever the type of the value.
+func isNullComparison(operator lexer.OperatorSubkind, left *ast.Type, right *ast.Type) bool {
+	if operator != lexer.OperatorEqual && operator != lexer.OperatorNotEqual {
+		return false
+	}
+	return (left.Type == ast.TYPE_NULL && right.IsNullable()) || (right.Type == ast.TYPE_NULL && left.IsNullable())
+}
+
+// visitNullCoalesceExpr compiles `a ?? b`, b only runs when a is null.
+func (v *InstructionsVisitor) visitNullCoalesceExpr(n *ast.BinaryExpr) any {
+	leftResult := n.Left.Visit(v)
+	leftVisitExpr, ok := CastVisitExprResult(leftResult)
+	if !ok {
+		return nil
+	}
+	if leftVisitExpr.TypeOf.Type != ast.TYPE_NULLABLE {
+		v.addError(fmt.Sprintf("left side of ?? must be nullable, but got %s", leftVisitExpr.TypeOf), n.Left.Pos())
+		return nil
+	}
+
+	reg := v.nextReg()
+	v.context.AddInstruction(InstrMove(reg, leftVisitExpr.Reg), n.Pos())
+	isNullReg := v.nextReg()
+	v.context.AddInstruction(InstrIsNull(isNullReg, reg), n.Pos())
+	jumpIndex := v.context.AddInstruction(InstrJumpIfFalse(isNullReg, -1), n.Pos())
+
+	rightResult := n.Right.Visit(v)
+	rightVisitExpr, ok := CastVisitExprResult(rightResult)
+	if !ok {
+		return nil
+	}
+	typeOf := leftVisitExpr.TypeOf.NonNull().Join(rightVisitExpr.TypeOf)
+	if typeOf == nil {
+		v.addError(fmt.Sprintf("right side of ?? must be of type %s, but got %s", leftVisitExpr.TypeOf.NonNull(), rightVisitExpr.TypeOf), n.Right.Pos())
+		return nil
+	}
+	v.context.AddInstruction(InstrMove(reg, rightVisitExpr.Reg), n.Pos())
+	v.context.SetInstruction(jumpIndex, InstrJumpIfFalse(isNullReg, v.context.InstructionsLength()-1))
+	return &VisitExprResult{Reg: reg, TypeOf: typeOf}
+}
+
+// nullableHint explains why an operation rejects a value of nullable type t.
+func nullableHint(t *ast.Type) string {
+	if t.Type != ast.TYPE_NULLABLE {
+		return ""
+	}
+	return fmt.Sprintf(", %s may be null, check it with != null or use ??", t)
+}
+
+func (v *InstructionsVisitor) resolveBinaryOp(operator lexer.OperatorSubkind, left *ast.Type, right *ast.Type, pos *common.SourcePos) (BinaryOpInfo, bool) {
+	opInfo, ok := ResolveBinaryOp(operator, left, right)
+	if !ok && isMixedNumeric(left, right) {
+		v.addError(fmt.Sprintf("binary operator %s is not supported for types %s and %s, convert one side with float() or int()", operator, left, right), pos)
+		return BinaryOpInfo{}, false
+	}
+	if !ok {
+		hint := nullableHint(left)
+		if hint == "" {
+			hint = nullableHint(right)
+		}
+		v.addError(fmt.Sprintf("binary operator %s is not supported for types %s and %s%s", operator, left, right, hint), pos)
+		return BinaryOpInfo{}, false
+	}
+	return opInfo, true
+}
+
+func isMixedNumeric(left *ast.Type, right *ast.Type) bool {
+	return (left.Type == ast.TYPE_INT && right.Type == ast.TYPE_FLOAT) ||
+		(left.Type == ast.TYPE_FLOAT && right.Type == ast.TYPE_INT)
+}
+
+// visitLogicalExpr compiles `&&` and `||` with short-circuit evaluation: the
+// left value is copied into the result register and, if it already decides
+// the outcome, the right operand is jumped over.
+func (v *InstructionsVisitor) visitLogicalExpr(n *ast.BinaryExpr) any {
+	leftResult := n.Left.Visit(v)
+	leftVisitExpr, ok := CastVisitExprResult(leftResult)
+	if !ok {
+		return nil
+	}
+
+	reg := v.nextReg()
+	v.context.AddInstruction(InstrMove(reg, leftVisitExpr.Reg), n.Pos())
+	jumpIndex := v.context.AddInstruction(InstrJump(-1), n.Pos())
+
+	// the right operand only runs when the left one is true for `&&` and
+	// false for `||`, so it sees what the left one proves
+	whenTrue, whenFalse := nullChecks(n.Left)
+	mark := v.markNarrowings()
+	if n.Operator == lexer.OperatorAnd {
+		v.narrow(whenTrue)
+	} else {
+		v.narrow(whenFalse)
+	}
+	rightResult := n.Right.Visit(v)
+	v.restoreNarrowings(mark)
+	rightVisitExpr, ok := CastVisitExprResult(rightResult)
+	if !ok {
+		return nil
+	}
+
+	opInfo, ok := ResolveBinaryOp(n.Operator, leftVisitExpr.TypeOf, rightVisitExpr.TypeOf)
+	if !ok {
+		v.addError(fmt.Sprintf("binary operator %s is not supported for types %s and %s", n.Operator, leftVisitExpr.TypeOf, rightVisitExpr.TypeOf), n.Pos())
+		return nil
+	}
+	v.context.AddInstruction(InstrMove(reg, rightVisitExpr.Reg), n.Pos())
+
+	endTarget := v.context.InstructionsLength() - 1
+	if n.Operator == lexer.OperatorAnd {
+		v.context.SetInstruction(jumpIndex, InstrJumpIfFalse(reg, endTarget))
+	} else {
+		v.context.SetInstruction(jumpIndex, InstrJumpIfTrue(reg, endTarget))
+	}
+	return &VisitExprResult{Reg: reg, TypeOf: opInfo.ResultType}
+}
+
+func (v *InstructionsVisitor) VisitUnaryExpr(n *ast.UnaryExpr) any {
+	operandResult := n.Operand.Visit(v)
+	operandVisitExpr, ok := CastVisitExprResult(operandResult)
+
+	if !ok || n.IsStatement {
+		return nil
+	}
+
+	opInfo, ok := ResolveUnaryOp(n.Operator, operandVisitExpr.TypeOf)
+	if !ok {
+		v.addError(fmt.Sprintf("unary operator %s is not supported for type %s%s", n.Operator, operandVisitExpr.TypeOf, nullableHint(operandVisitExpr.TypeOf)), n.Pos())
 		return nil
 	}
 	reg := v.nextReg()
-	v.context.AddInstruction(InstrBinary(opInfo.OpCode, reg, leftVisitExpr.Reg, rightVisitExpr.Reg))
+	v.context.AddInstruction(InstrUnary(opInfo.OpCode, reg, operandVisitExpr.Reg), n.Pos())
 	return &VisitExprResult{Reg: reg, TypeOf: opInfo.ResultType}
 }
 
+func (v *InstructionsVisitor) VisitConditionalExpr(n *ast.ConditionalExpr) any {
+	conditionResult := n.Condition.Visit(v)
+	conditionVisitExpr, ok := CastVisitExprResult(conditionResult)
+	if !ok {
+		return nil
+	}
+	if !conditionVisitExpr.TypeOf.IsEqual(ast.TypeBool()) {
+		v.addError(fmt.Sprintf("condition must be of type bool, but got %s", conditionVisitExpr.TypeOf), n.Condition.Pos())
+		return nil
+	}
+	jumpIfFalseIndex := v.context.AddInstruction(InstrJumpIfFalse(conditionVisitExpr.Reg, -1), n.Pos())
+
+	whenTrue, whenFalse := nullChecks(n.Condition)
+	mark := v.markNarrowings()
+	v.narrow(whenTrue)
+	reg := v.nextReg()
+	thenResult := n.Then.Visit(v)
+	v.restoreNarrowings(mark)
+	thenVisitExpr, ok := CastVisitExprResult(thenResult)
+	if !ok {
+		return nil
+	}
+	v.context.AddInstruction(InstrMove(reg, thenVisitExpr.Reg), n.Pos())
+	jumpIndex := v.context.AddInstruction(InstrJump(-1), n.Pos())
+	v.context.SetInstruction(jumpIfFalseIndex, InstrJumpIfFalse(conditionVisitExpr.Reg, v.context.InstructionsLength()-1))
+
+	v.narrow(whenFalse)
+	elseResult := n.Else.Visit(v)
+	v.restoreNarrowings(mark)
+	elseVisitExpr, ok := CastVisitExprResult(elseResult)
+	if !ok {
+		return nil
+	}
+	// `x > 0 ? x : null` is an int?
+	typeOf := thenVisitExpr.TypeOf.Join(elseVisitExpr.TypeOf)
+	if typeOf == nil {
+		v.addError(fmt.Sprintf("conditional branches must have the same type, but got %s and %s", thenVisitExpr.TypeOf, elseVisitExpr.TypeOf), n.Pos())
+		return nil
+	}
+	v.context.AddInstruction(InstrMove(reg, elseVisitExpr.Reg), n.Pos())
+	v.context.SetInstruction(jumpIndex, InstrJump(v.context.InstructionsLength()-1))
+
+	if n.IsStatement {
+		return nil
+	}
+	return &VisitExprResult{Reg: reg, TypeOf: typeOf}
+}
+
+// VisitMatchExpr compiles a match into a chain of tag tests. Each arm compares
+// the tag of the subject with its variant and jumps to the next arm when they
+// differ, the last arm needs no test once the match is exhaustive.
+func (v *InstructionsVisitor) VisitMatchExpr(n *ast.MatchExpr) any {
+	subjectResult := n.Subject.Visit(v)
+	subjectVisitExpr, ok := CastVisitExprResult(subjectResult)
+	if !ok {
+		return nil
+	}
+	info, ok := v.enumOf(subjectVisitExpr.TypeOf)
+	if !ok {
+		v.addError(fmt.Sprintf("match subject must be an enum, but got %s%s", subjectVisitExpr.TypeOf, nullableHint(subjectVisitExpr.TypeOf)), n.Subject.Pos())
+		return nil
+	}
+	tags, ok := v.checkMatchArms(n, info)
+	if !ok {
+		return nil
+	}
+
+	tagReg := v.nextReg()
+	v.context.AddInstruction(InstrGetTag(tagReg, subjectVisitExpr.Reg), n.Pos())
+	reg := v.nextReg()
+	var typeOf *ast.Type
+	jumpIndexes := []int{}
+	for i, arm := range n.Arms {
+		isLast := i == len(n.Arms)-1
+		jumpIfFalseIndex := -1
+		var conditionReg int
+		if tags[i] >= 0 && !isLast {
+			expectedReg := v.nextReg()
+			v.context.AddInstruction(InstrLoadConst(expectedReg, v.context.AddConstant(NewIntValue(tags[i]))), arm.PosAt)
+			conditionReg = v.nextReg()
+			v.context.AddInstruction(InstrEqualInt(conditionReg, tagReg, expectedReg), arm.PosAt)
+			jumpIfFalseIndex = v.context.AddInstruction(InstrJumpIfFalse(conditionReg, -1), arm.PosAt)
+		}
+
+		v.enterBlockContext()
+		for j, binding := range arm.Bindings {
+			if binding == "_" {
+				continue
+			}
+			field := info.decl.Variants[tags[i]].Fields[j]
+			slot := v.context.DefineVariable(binding, false, field.TypeOf)
+			payloadReg := v.nextReg()
+			v.context.AddInstruction(InstrGetPayload(payloadReg, subjectVisitExpr.Reg, j), arm.PosAt)
+			v.context.AddInstruction(InstrStoreVar(payloadReg, slot), arm.PosAt)
+		}
+		bodyResult := arm.Body.Visit(v)
+		v.closeBlockVars(arm.PosAt)
+		v.exitBlockContext()
+		bodyVisitExpr, ok := CastVisitExprResult(bodyResult)
+		if !ok {
+			return nil
+		}
+		if typeOf == nil {
+			typeOf = bodyVisitExpr.TypeOf
+		} else if joined := typeOf.Join(bodyVisitExpr.TypeOf); joined != nil {
+			typeOf = joined
+		} else if !n.IsStatement {
+			v.addError(fmt.Sprintf("match arms must have the same type, but got %s and %s", typeOf, bodyVisitExpr.TypeOf), arm.Body.Pos())
+			return nil
+		}
+		v.context.AddInstruction(InstrMove(reg, bodyVisitExpr.Reg), arm.PosAt)
+		if !isLast {
+			jumpIndexes = append(jumpIndexes, v.context.AddInstruction(InstrJump(-1), arm.PosAt))
+		}
+		if jumpIfFalseIndex >= 0 {
+			v.context.SetInstruction(jumpIfFalseIndex, InstrJumpIfFalse(conditionReg, v.context.InstructionsLength()-1))
+		}
+	}
+	for _, jumpIndex := range jumpIndexes {
+		v.context.SetInstruction(jumpIndex, InstrJump(v.context.InstructionsLength()-1))
+	}
+
+	if n.IsStatement {
+		return nil
+	}
+	return &VisitExprResult{Reg: reg, TypeOf: typeOf}
+}
+
+// checkMatchArms resolves the tag of every arm, -1 for the wildcard, and checks
+// that the arms cover every variant of the enum exactly once.
+func (v *InstructionsVisitor) checkMatchArms(n *ast.MatchExpr, info *enumInfo) ([]int, bool) {
+	tags := make([]int, len(n.Arms))
+	covered := make([]bool, len(info.decl.Variants))
+	for i, arm := range n.Arms {
+		if i > 0 && n.Arms[i-1].Variant == "_" {
+			v.addError("match arm is unreachable after '_'", arm.PosAt)
+			return nil, false
+		}
+		if arm.Variant == "_" {
+			if len(arm.Bindings) > 0 {
+				v.addError("'_' cannot bind the payload of a variant", arm.PosAt)
+				return nil, false
+			}
+			tags[i] = -1
+			continue
+		}
+		tag, ok := info.variants[arm.Variant]
+		if !ok {
+			v.addError(fmt.Sprintf("enum %s has no variant %s", info.decl.Name, arm.Variant), arm.PosAt)
+			return nil, false
+		}
+		if covered[tag] {
+			v.addError(fmt.Sprintf("variant %s is matched twice", arm.Variant), arm.PosAt)
+			return nil, false
+		}
+		fields := info.decl.Variants[tag].Fields
+		if len(arm.Bindings) != len(fields) {
+			v.addError(fmt.Sprintf("variant %s has %d fields, but got %d bindings", arm.Variant, len(fields), len(arm.Bindings)), arm.PosAt)
+			return nil, false
+		}
+		for j, binding := range arm.Bindings {
+			if binding != "_" && slices.Contains(arm.Bindings[:j], binding) {
+				v.addError(fmt.Sprintf("binding %s is declared twice", binding), arm.PosAt)
+				return nil, false
+			}
+		}
+		covered[tag] = true
+		tags[i] = tag
+	}
+	if n.Arms[len(n.Arms)-1].Variant == "_" {
+		return tags, true
+	}
+	missing := []string{}
+	for tag, isCovered := range covered {
+		if !isCovered {
+			missing = append(missing, info.decl.Variants[tag].Name)
+		}
+	}
+	if len(missing) > 0 {
+		v.addError(fmt.Sprintf("match is not exhaustive, missing %s", strings.Join(missing, ", ")), n.Pos())
+		return nil, false
+	}
+	return tags, true
+}
+
 func (v *InstructionsVisitor) VisitParam(n *ast.Param) any {
+	v.checkType(n.TypeOf, n.Pos())
 	typeOf := n.TypeOf
 	if n.Vararg {
 		typeOf = ast.TypeArrayOf(typeOf)
@@ -341,81 +1480,185 @@ func (v *InstructionsVisitor) VisitParam(n *ast.Param) any {
 }
 
 func (v *InstructionsVisitor) VisitFunction(n *ast.Function) any {
-	_, ok := v.context.FindLocalVariable(n.Name)
-	if ok {
-		v.addError(fmt.Sprintf("variable %s already defined", n.Name), n.Pos())
-		return nil
+	slot, hoisted := v.hoisted[n]
+	if !hoisted {
+		if _, ok := v.context.FindLocalVariable(n.Name); ok {
+			v.addError(fmt.Sprintf("variable %s already defined", n.Name), n.Pos())
+			return nil
+		}
 	}
 
-	v.enterFunctionContext(n.ReturnType)
+	if !v.checkTypeParams(n) {
+		return nil
+	}
+	outerTypeParams := v.typeParams
+	v.typeParams = slices.Concat(v.typeParams, n.TypeParams)
+	v.checkType(n.ReturnType, n.Pos())
+	v.enterFunctionContext(n.Name, n.ReturnType)
+	outerLoops := v.loops
+	v.loops = nil
 
 	for _, param := range n.Params {
 		param.Visit(v)
 	}
+	v.hoistFunctions(n.Body)
 	for _, statement := range n.Body {
 		statement.Visit(v)
 	}
 
+	v.loops = outerLoops
+	v.typeParams = outerTypeParams
+
 	params := v.context.Params()
 	returnType := v.context.ReturnType()
 
 	functionSlot := v.exitFunctionContext()
+	typeOf := ast.TypeFunction(&ast.Signature{Params: params, ReturnType: returnType, Vararg: n.Vararg}).Quantify(n.TypeParams)
 
-	slot := v.context.DefineFunctionVariable(n.Name, false, ast.TypeClosure(), &FuncSignature{CallArgs: params, ReturnType: returnType, Vararg: n.Vararg})
+	if !hoisted {
+		slot = v.context.DefineVariable(n.Name, false, typeOf)
+	}
 	reg := v.nextReg()
-	v.context.AddInstruction(InstrClosure(reg, functionSlot))
-	v.context.AddInstruction(InstrStoreVar(reg, slot))
-	return &VisitExprResult{Reg: reg, TypeOf: ast.TypeClosure()}
+	v.context.AddInstruction(InstrClosure(reg, functionSlot), n.Pos())
+	v.context.AddInstruction(InstrStoreVar(reg, slot), n.Pos())
+	if n.Exported {
+		v.exportValue(n.Name, typeOf, reg, n.Pos())
+	}
+	return &VisitExprResult{Reg: reg, TypeOf: typeOf}
+}
+
+// checkTypeParams reports type parameters declared twice or named like a type
+// they would hide.
+func (v *InstructionsVisitor) checkTypeParams(n *ast.Function) bool {
+	for i, name := range n.TypeParams {
+		if slices.Contains(n.TypeParams[:i], name) {
+			v.addError(fmt.Sprintf("type parameter %s is declared twice in function %s", name, n.Name), n.Pos())
+			return false
+		}
+		if v.isTypeDeclared(name) || slices.Contains(v.typeParams, name) {
+			v.addError(fmt.Sprintf("type parameter %s shadows the type %s", name, name), n.Pos())
+			return false
+		}
+	}
+	return true
+}
+
+func (v *InstructionsVisitor) VisitFunctionExpr(n *ast.FunctionExpr) any {
+	v.checkType(n.ReturnType, n.Pos())
+	v.enterFunctionContext("<lambda>", n.ReturnType)
+	outerLoops := v.loops
+	v.loops = nil
+
+	for _, param := range n.Params {
+		param.Visit(v)
+	}
+	returnType := n.ReturnType
+	if n.ExprBody != nil {
+		// an arrow function returns its body, whatever type it has
+		resultVisitExpr, ok := v.visitOwned(n.ExprBody)
+		if ok {
+			returnType = resultVisitExpr.TypeOf
+			v.context.AddInstruction(InstrReturn(resultVisitExpr.Reg), n.ExprBody.Pos())
+		}
+	} else {
+		v.hoistFunctions(n.Body)
+		for _, statement := range n.Body {
+			statement.Visit(v)
+		}
+	}
+
+	v.loops = outerLoops
+
+	params := v.context.Params()
+	functionSlot := v.exitFunctionContext()
+	if returnType == nil {
+		return nil
+	}
+	typeOf := ast.TypeFunction(&ast.Signature{Params: params, ReturnType: returnType, Vararg: n.Vararg})
+
+	if n.IsStatement {
+		return nil
+	}
+	reg := v.nextReg()
+	v.context.AddInstruction(InstrClosure(reg, functionSlot), n.Pos())
+	return &VisitExprResult{Reg: reg, TypeOf: typeOf}
 }
 
 func (v *InstructionsVisitor) VisitBlock(n *ast.Block) any {
 	v.enterBlockContext()
+	mark := v.markNarrowings()
+	v.hoistFunctions(n.Statements)
 	for _, statement := range n.Statements {
 		statement.Visit(v)
 	}
+	v.restoreNarrowings(mark)
+	v.closeBlockVars(n.PosAt)
 	v.exitBlockContext()
 	return nil
 }
 
 func (v *InstructionsVisitor) VisitCallExpr(n *ast.CallExpr) any {
-	result := n.Identifier.Visit(v)
+	if field, ok := n.Callee.(*ast.FieldExpr); ok {
+		if info, tag, ok := v.variantOf(field); ok {
+			return v.visitVariant(field, info, tag, n.Arguments)
+		}
+	}
+	result := n.Callee.Visit(v)
 	resultVisitExpr, ok := CastVisitExprResult(result)
 	if !ok {
 		return nil
 	}
-	if !resultVisitExpr.TypeOf.IsEqual(ast.TypeClosure()) && !resultVisitExpr.TypeOf.IsEqual(ast.TypeNativeFunction()) {
-		v.addError(fmt.Sprintf("variable %s must be callable, but got type %s", n.Identifier.Name, resultVisitExpr.TypeOf), n.Identifier.Pos())
+	if !resultVisitExpr.TypeOf.IsCallable() {
+		v.addError(fmt.Sprintf("%s must be callable, but got type %s%s", calleeName(n.Callee), resultVisitExpr.TypeOf, nullableHint(resultVisitExpr.TypeOf)), n.Callee.Pos())
 		return nil
 	}
-	if resultVisitExpr.FuncSignature == nil {
-		v.addError(fmt.Sprintf("function %s is not callable", n.Identifier.Name), n.Identifier.Pos())
+	signature := resultVisitExpr.TypeOf.Signature
+	if signature == nil {
+		v.addError(fmt.Sprintf("%s is not callable", calleeName(n.Callee)), n.Callee.Pos())
 		return nil
 	}
 
-	if len(n.Arguments) < len(resultVisitExpr.FuncSignature.CallArgs) {
-		v.addError(fmt.Sprintf("function %s takes %d arguments, but got %d", n.Identifier.Name, len(resultVisitExpr.FuncSignature.CallArgs), len(n.Arguments)), n.Identifier.Pos())
+	if len(n.Arguments) < len(signature.Params) {
+		v.addError(fmt.Sprintf("%s takes %d arguments, but got %d", calleeName(n.Callee), len(signature.Params), len(n.Arguments)), n.Callee.Pos())
+		return nil
+	} else if len(n.Arguments) > len(signature.Params) && !signature.Vararg {
+		v.addError(fmt.Sprintf("%s takes %d arguments, but got %d", calleeName(n.Callee), len(signature.Params), len(n.Arguments)), n.Callee.Pos())
 		return nil
-	} else if len(n.Arguments) > len(resultVisitExpr.FuncSignature.CallArgs) && !resultVisitExpr.FuncSignature.Vararg {
-		v.addError(fmt.Sprintf("function %s takes %d arguments, but got %d", n.Identifier.Name, len(resultVisitExpr.FuncSignature.CallArgs), len(n.Arguments)), n.Identifier.Pos())
+	}
+
+	// delete writes into its map like `m[k] = v` does
+	if resultVisitExpr.TypeOf.Type == ast.TYPE_NATIVE_FUNCTION && calleeName(n.Callee) == "delete" && !v.checkRootMutable(n.Arguments[0]) {
 		return nil
 	}
 
+	// type parameters of native signatures are bound by the arguments
+	var bindings map[string]*ast.Type
+	if resultVisitExpr.TypeOf.HasTypeParams() {
+		bindings = map[string]*ast.Type{}
+	}
+
 	args := []int{}
 	isOk := true
 
-	if resultVisitExpr.FuncSignature.Vararg {
-		args, isOk = v.handleArgsWithVararg(n.Arguments, resultVisitExpr.FuncSignature.CallArgs)
+	if signature.Vararg {
+		args, isOk = v.handleArgsWithVararg(n.Arguments, signature.Params, bindings)
 	} else {
-		args, isOk = v.handleArgsWithoutVararg(n.Arguments, resultVisitExpr.FuncSignature.CallArgs)
+		args, isOk = v.handleArgsWithoutVararg(n.Arguments, signature.Params, bindings)
 	}
 
 	if !isOk {
 		return nil
 	}
 
+	if name := signature.ReturnType.UnboundParam(bindings); name != "" {
+		v.addError(fmt.Sprintf("cannot infer %s in the return type of %s from its arguments", name, calleeName(n.Callee)), n.Pos())
+		return nil
+	}
+	returnType := signature.ReturnType.Substitute(bindings)
+
 	resultReg := v.nextReg()
-	v.context.AddInstruction(InstrCall(resultReg, resultVisitExpr.Reg, args))
-	return &VisitExprResult{Reg: resultReg, TypeOf: resultVisitExpr.FuncSignature.ReturnType}
+	v.context.AddInstruction(InstrCall(resultReg, resultVisitExpr.Reg, args), n.Pos())
+	return &VisitExprResult{Reg: resultReg, TypeOf: returnType}
 }
 
 func (v *InstructionsVisitor) VisitIndexExpr(n *ast.IndexExpr) any {
@@ -430,17 +1673,49 @@ func (v *InstructionsVisitor) VisitIndexExpr(n *ast.IndexExpr) any {
 		return nil
 	}
 
-	if arrayVisitExpr.TypeOf.Type != ast.TYPE_ARRAY {
-		v.addError(fmt.Sprintf("expression must be of type array, but got %s", arrayVisitExpr.TypeOf), n.Array.Pos())
-		return nil
-	}
-	if !indexVisitExpr.TypeOf.IsEqual(ast.TypeInt()) {
-		v.addError(fmt.Sprintf("index must be of type int, but got %s", indexVisitExpr.TypeOf), n.Index.Pos())
+	elementType, ok := v.checkIndex(n, arrayVisitExpr.TypeOf, indexVisitExpr.TypeOf)
+	if !ok {
 		return nil
 	}
 	reg := v.nextReg()
-	v.context.AddInstruction(InstrIndexArray(reg, arrayVisitExpr.Reg, indexVisitExpr.Reg))
-	return &VisitExprResult{Reg: reg, TypeOf: arrayVisitExpr.TypeOf.ElementType}
+	v.context.AddInstruction(indexInstruction(arrayVisitExpr.TypeOf, reg, arrayVisitExpr.Reg, indexVisitExpr.Reg), n.Pos())
+	return &VisitExprResult{Reg: reg, TypeOf: elementType}
+}
+
+// checkIndex returns the type of the element that n reads from an array or a
+// map.
+func (v *InstructionsVisitor) checkIndex(n *ast.IndexExpr, collection *ast.Type, index *ast.Type) (*ast.Type, bool) {
+	switch collection.Type {
+	case ast.TYPE_ARRAY:
+		if !index.IsEqual(ast.TypeInt()) {
+			v.addError(fmt.Sprintf("index must be of type int, but got %s", index), n.Index.Pos())
+			return nil, false
+		}
+		if collection.ElementType == nil {
+			v.addError("cannot index an empty array", n.Pos())
+			return nil, false
+		}
+	case ast.TYPE_MAP:
+		if collection.ElementType == nil {
+			v.addError("cannot index an empty map", n.Pos())
+			return nil, false
+		}
+		if !collection.KeyType.IsEqual(index) {
+			v.addError(fmt.Sprintf("key must be of type %s, but got %s", collection.KeyType, index), n.Index.Pos())
+			return nil, false
+		}
+	default:
+		v.addError(fmt.Sprintf("expression must be of type array or map, but got %s%s", collection, nullableHint(collection)), n.Array.Pos())
+		return nil, false
+	}
+	return collection.ElementType, true
+}
+
+func indexInstruction(collection *ast.Type, resultReg int, collectionReg int, indexReg int) Instruction {
+	if collection.Type == ast.TYPE_MAP {
+		return InstrIndexMap(resultReg, collectionReg, indexReg)
+	}
+	return InstrIndexArray(resultReg, collectionReg, indexReg)
 }
 
 func (v *InstructionsVisitor) VisitIf(n *ast.If) any {
@@ -454,30 +1729,162 @@ func (v *InstructionsVisitor) VisitIf(n *ast.If) any {
 		return nil
 	}
 	reg := conditionVisitExpr.Reg
-	jumpIfFalseIndex := v.context.AddInstruction(InstrJumpIfFalse(reg, -1))
+	jumpIfFalseIndex := v.context.AddInstruction(InstrJumpIfFalse(reg, -1), n.Pos())
 
+	whenTrue, whenFalse := nullChecks(n.Condition)
+	mark := v.markNarrowings()
+	v.narrow(whenTrue)
+	v.hoistFunctions(n.Body)
 	for _, statement := range n.Body {
 		statement.Visit(v)
 	}
+	v.restoreNarrowings(mark)
 	elseBodyIndex := -1
 	if len(n.ElseBody) > 0 {
-		elseBodyIndex = v.context.AddInstruction(InstrJump(-1))
+		elseBodyIndex = v.context.AddInstruction(InstrJump(-1), n.Pos())
 	}
 	endIfTarget := v.context.InstructionsLength() - 1
 	v.context.SetInstruction(jumpIfFalseIndex, InstrJumpIfFalse(reg, endIfTarget))
 
+	v.narrow(whenFalse)
+	v.hoistFunctions(n.ElseBody)
 	for _, statement := range n.ElseBody {
 		statement.Visit(v)
 	}
+	v.restoreNarrowings(mark)
 
 	if elseBodyIndex != -1 {
 		endElseTarget := v.context.InstructionsLength() - 1
 		v.context.SetInstruction(elseBodyIndex, InstrJump(endElseTarget))
 	}
+	// `if (x == null) { return; }` proves x for the rest of the block
+	if len(n.ElseBody) == 0 && terminates(n.Body) {
+		v.narrow(whenFalse)
+	}
 	return nil
 }
 
-func (v *InstructionsVisitor) handleArgsWithoutVararg(arguments []ast.Expression, callArgs []*ast.Type) ([]int, bool) {
+func (v *InstructionsVisitor) VisitWhile(n *ast.While) any {
+	v.forgetLoopNarrowings(n.Body)
+	loopStart := v.context.InstructionsLength()
+	conditionResult := n.Condition.Visit(v)
+	conditionVisitExpr, ok := CastVisitExprResult(conditionResult)
+	if !ok {
+		return nil
+	}
+	if !conditionVisitExpr.TypeOf.IsEqual(ast.TypeBool()) {
+		v.addError(fmt.Sprintf("condition must be of type bool, but got %s", conditionVisitExpr.TypeOf), n.Condition.Pos())
+		return nil
+	}
+	reg := conditionVisitExpr.Reg
+	jumpIfFalseIndex := v.context.AddInstruction(InstrJumpIfFalse(reg, -1), n.Pos())
+
+	loop := v.enterLoop()
+	v.enterBlockContext()
+	whenTrue, _ := nullChecks(n.Condition)
+	mark := v.markNarrowings()
+	v.narrow(whenTrue)
+	v.hoistFunctions(n.Body)
+	for _, statement := range n.Body {
+		statement.Visit(v)
+	}
+	v.restoreNarrowings(mark)
+	continueTarget := v.context.InstructionsLength()
+	v.closeLoopVars(loop, n.Pos())
+	v.exitBlockContext()
+	v.context.AddInstruction(InstrJump(loopStart-1), n.Pos())
+
+	endTarget := v.exitLoop(loop, continueTarget, n.Pos())
+	v.context.SetInstruction(jumpIfFalseIndex, InstrJumpIfFalse(reg, endTarget-1))
+	return nil
+}
+
+func (v *InstructionsVisitor) VisitFor(n *ast.For) any {
+	v.enterBlockContext()
+	defer v.exitBlockContext()
+
+	if n.Init != nil {
+		n.Init.Visit(v)
+	}
+
+	v.forgetLoopNarrowings([]ast.Statement{n.Step}, n.Body)
+	loopStart := v.context.InstructionsLength()
+	jumpIfFalseIndex := -1
+	reg := -1
+	if n.Condition != nil {
+		conditionResult := n.Condition.Visit(v)
+		conditionVisitExpr, ok := CastVisitExprResult(conditionResult)
+		if !ok {
+			return nil
+		}
+		if !conditionVisitExpr.TypeOf.IsEqual(ast.TypeBool()) {
+			v.addError(fmt.Sprintf("condition must be of type bool, but got %s", conditionVisitExpr.TypeOf), n.Condition.Pos())
+			return nil
+		}
+		reg = conditionVisitExpr.Reg
+		jumpIfFalseIndex = v.context.AddInstruction(InstrJumpIfFalse(reg, -1), n.Pos())
+	}
+
+	loop := v.enterLoop()
+	v.enterBlockContext()
+	mark := v.markNarrowings()
+	if n.Condition != nil {
+		whenTrue, _ := nullChecks(n.Condition)
+		v.narrow(whenTrue)
+	}
+	v.hoistFunctions(n.Body)
+	for _, statement := range n.Body {
+		statement.Visit(v)
+	}
+	v.restoreNarrowings(mark)
+	v.exitBlockContext()
+
+	continueTarget := v.context.InstructionsLength()
+	v.closeLoopVars(loop, n.Pos())
+	if n.Step != nil {
+		n.Step.Visit(v)
+	}
+	v.context.AddInstruction(InstrJump(loopStart-1), n.Pos())
+
+	endTarget := v.exitLoop(loop, continueTarget, n.Pos())
+	if jumpIfFalseIndex != -1 {
+		v.context.SetInstruction(jumpIfFalseIndex, InstrJumpIfFalse(reg, endTarget-1))
+	}
+	return nil
+}
+
+func (v *InstructionsVisitor) VisitBreak(n *ast.Break) any {
+	if len(v.loops) == 0 {
+		v.addError("break statement must be inside a loop", n.Pos())
+		return nil
+	}
+	loop := v.loops[len(v.loops)-1]
+	loop.breakJumps = append(loop.breakJumps, v.context.AddInstruction(InstrJump(-1), n.Pos()))
+	return nil
+}
+
+func (v *InstructionsVisitor) VisitContinue(n *ast.Continue) any {
+	if len(v.loops) == 0 {
+		v.addError("continue statement must be inside a loop", n.Pos())
+		return nil
+	}
+	loop := v.loops[len(v.loops)-1]
+	loop.continueJumps = append(loop.continueJumps, v.context.AddInstruction(InstrJump(-1), n.Pos()))
+	return nil
+}
+
+// acceptsArgument checks an argument against its param type, binding the type
+// parameters of the param when bindings is not nil. The bound param type is
+// what errors report, for `has(m, k)` with m of type map[string]int the key
+// has to be a string.
+func acceptsArgument(paramType *ast.Type, argumentType *ast.Type, bindings map[string]*ast.Type) bool {
+	if bindings != nil && paramType.HasTypeParams() {
+		return ast.Unify(paramType, argumentType, bindings)
+	}
+	return paramType.Accepts(argumentType)
+}
+
+func (v *InstructionsVisitor) handleArgsWithoutVararg(arguments []ast.Expression, callArgs []*ast.Type, bindings map[string]*ast.Type) ([]int, bool) {
 	args := []int{}
 	isOk := true
 
@@ -490,8 +1897,8 @@ func (v *InstructionsVisitor) handleArgsWithoutVararg(arguments []ast.Expression
 			return nil, false
 		}
 
-		if !argumentVisitExpr.TypeOf.IsEqual(paramType) {
-			v.addError(fmt.Sprintf("argument %d must be of type %s, but got %s", i, paramType, argumentVisitExpr.TypeOf), argument.Pos())
+		if !acceptsArgument(paramType, argumentVisitExpr.TypeOf, bindings) {
+			v.addError(fmt.Sprintf("argument %d must be of type %s, but got %s", i, paramType.Substitute(bindings), argumentVisitExpr.TypeOf), argument.Pos())
 			isOk = false
 			continue
 		}
@@ -501,7 +1908,7 @@ func (v *InstructionsVisitor) handleArgsWithoutVararg(arguments []ast.Expression
 	return args, isOk
 }
 
-func (v *InstructionsVisitor) handleArgsWithVararg(arguments []ast.Expression, callArgs []*ast.Type) ([]int, bool) {
+func (v *InstructionsVisitor) handleArgsWithVararg(arguments []ast.Expression, callArgs []*ast.Type, bindings map[string]*ast.Type) ([]int, bool) {
 	args := []int{}
 	isOk := true
 
@@ -517,8 +1924,8 @@ func (v *InstructionsVisitor) handleArgsWithVararg(arguments []ast.Expression, c
 			return nil, false
 		}
 
-		if !argumentVisitExpr.TypeOf.IsEqual(paramType) {
-			v.addError(fmt.Sprintf("argument %d must be of type %s, but got %s", i, paramType, argumentVisitExpr.TypeOf), argument.Pos())
+		if !acceptsArgument(paramType, argumentVisitExpr.TypeOf, bindings) {
+			v.addError(fmt.Sprintf("argument %d must be of type %s, but got %s", i, paramType.Substitute(bindings), argumentVisitExpr.TypeOf), argument.Pos())
 			isOk = false
 			continue
 		}
@@ -541,8 +1948,8 @@ func (v *InstructionsVisitor) handleArgsWithVararg(arguments []ast.Expression, c
 		varargRegs := []int{}
 		paramType := callArgs[len(callArgs)-1].ElementType
 
-		if !firstVarargVisitExpr.TypeOf.IsEqual(paramType) {
-			v.addError(fmt.Sprintf("argument %d must be of type %s, but got %s", firstVarargIndex, paramType, firstVarargVisitExpr.TypeOf), arguments[firstVarargIndex].Pos())
+		if !acceptsArgument(paramType, firstVarargVisitExpr.TypeOf, bindings) {
+			v.addError(fmt.Sprintf("argument %d must be of type %s, but got %s", firstVarargIndex, paramType.Substitute(bindings), firstVarargVisitExpr.TypeOf), arguments[firstVarargIndex].Pos())
 			isOk = false
 		}
 		varargRegs = append(varargRegs, firstVarargVisitExpr.Reg)
@@ -554,8 +1961,8 @@ func (v *InstructionsVisitor) handleArgsWithVararg(arguments []ast.Expression, c
 			if !ok {
 				return nil, false
 			}
-			if !argumentVisitExpr.TypeOf.IsEqual(paramType) {
-				v.addError(fmt.Sprintf("argument %d must be of type %s, but got %s", i, paramType, argumentVisitExpr.TypeOf), argument.Pos())
+			if !acceptsArgument(paramType, argumentVisitExpr.TypeOf, bindings) {
+				v.addError(fmt.Sprintf("argument %d must be of type %s, but got %s", i, paramType.Substitute(bindings), argumentVisitExpr.TypeOf), argument.Pos())
 				isOk = false
 				continue
 			}
@@ -563,7 +1970,7 @@ func (v *InstructionsVisitor) handleArgsWithVararg(arguments []ast.Expression, c
 		}
 
 		reg := v.nextReg()
-		v.context.AddInstruction(InstrMakeArray(reg, varargRegs))
+		v.context.AddInstruction(InstrMakeArray(reg, varargRegs), arguments[firstVarargIndex].Pos())
 
 		args = append(args, reg)
 	}
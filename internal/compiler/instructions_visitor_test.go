@@ -1,6 +1,7 @@
 package compiler
 
 import (
+	"strings"
 	"testing"
 
 	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
@@ -47,9 +48,9 @@ func makeBinaryExpr(left ast.Expression, operator lexer.OperatorSubkind, right a
 
 func makeCallExpr(identifier *ast.Identifier, arguments []ast.Expression, offset, line, col int) *ast.CallExpr {
 	return &ast.CallExpr{
-		Identifier: *identifier,
-		Arguments:  arguments,
-		PosAt:      makeSourcePos(offset, line, col, 1),
+		Callee:    identifier,
+		Arguments: arguments,
+		PosAt:     makeSourcePos(offset, line, col, 1),
 	}
 }
 
@@ -224,3 +225,1415 @@ func TestVisitIntLiteral_Expression_NotOptimized(t *testing.T) {
 		t.Errorf("expected LOAD_CONST instruction, got %s", instructions[0].OpCode)
 	}
 }
+
+// ---------- Loop Tests ----------
+
+/*
+*
+Test `while (true) { var x = 1; break; }`.
+
+- Should jump back to the condition at the end of the body
+- `x` should be detached with CLOSE_VARS at the end of each iteration
+- `break` should be patched to a CLOSE_VARS of its own placed past the loop
+*/
+func TestVisitWhile_JumpsAndBreak(t *testing.T) {
+	condition := &ast.BoolLiteral{Value: true, PosAt: makeSourcePos(7, 1, 8, 4)}
+	declaration := &ast.Declaration{
+		IsMutable:  true,
+		Identifier: makeIdentifier("x", false, 19, 1, 20),
+		Value:      makeIntLiteral(1, false, 23, 1, 24),
+		PosAt:      makeSourcePos(15, 1, 16, 3),
+	}
+	breakStmt := &ast.Break{PosAt: makeSourcePos(26, 1, 27, 5)}
+	while := &ast.While{Condition: condition, Body: []ast.Statement{declaration, breakStmt}, PosAt: makeSourcePos(0, 1, 1, 5)}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	while.Visit(visitor)
+
+	if len(visitor.errors) > 0 {
+		t.Fatalf("unexpected errors: %v", visitor.errors)
+	}
+
+	instructions := CastModuleContext(visitor.context).instructions
+	end := len(instructions)
+
+	if instructions[1].OpCode != JUMP_IF_FALSE || instructions[1].Args[1] != end-1 {
+		t.Errorf("expected JUMP_IF_FALSE to the end of the loop, got %s", instructions[1].String())
+	}
+	backJump := instructions[end-2]
+	if backJump.OpCode != JUMP || backJump.Args[0] != -1 {
+		t.Errorf("expected JUMP back to the condition, got %s", backJump.String())
+	}
+	closeVars := instructions[end-3]
+	if closeVars.OpCode != CLOSE_VARS || closeVars.Args[0] != 0 || closeVars.Args[1] != 1 {
+		t.Errorf("expected CLOSE_VARS [0 1] before the back jump, got %s", closeVars.String())
+	}
+	breakClose := instructions[end-1]
+	if breakClose.OpCode != CLOSE_VARS || breakClose.Args[0] != 0 || breakClose.Args[1] != 1 {
+		t.Errorf("expected CLOSE_VARS [0 1] on the break path, got %s", breakClose.String())
+	}
+
+	hasBreak := false
+	for _, instr := range instructions[:end-2] {
+		if instr.OpCode == JUMP && instr.Args[0] == end-2 {
+			hasBreak = true
+		}
+	}
+	if !hasBreak {
+		t.Error("expected break to jump to the CLOSE_VARS past the loop")
+	}
+}
+
+func TestVisitBreak_OutsideLoop(t *testing.T) {
+	breakStmt := &ast.Break{PosAt: makeSourcePos(0, 1, 1, 5)}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	breakStmt.Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error for break outside of a loop, got %d", len(visitor.errors))
+	}
+}
+
+/*
+*
+Test `while (true) { function f(): int { break; } }`.
+
+- A function body starts a new loop scope, so `break` is an error
+*/
+func TestVisitBreak_InsideFunctionInsideLoop(t *testing.T) {
+	function := &ast.Function{
+		Name:       "f",
+		Body:       []ast.Statement{&ast.Break{PosAt: makeSourcePos(33, 1, 34, 5)}},
+		ReturnType: ast.TypeInt(),
+		PosAt:      makeSourcePos(15, 1, 16, 8),
+	}
+	while := &ast.While{
+		Condition: &ast.BoolLiteral{Value: true, PosAt: makeSourcePos(7, 1, 8, 4)},
+		Body:      []ast.Statement{function},
+		PosAt:     makeSourcePos(0, 1, 1, 5),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	while.Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error for break inside a nested function, got %d", len(visitor.errors))
+	}
+}
+
+// ---------- Unary Expression Tests ----------
+
+func TestVisitUnaryExpr_NegateInt(t *testing.T) {
+	unary := &ast.UnaryExpr{Operator: lexer.OperatorMinus, Operand: makeIntLiteral(5, false, 1, 1, 2), PosAt: makeSourcePos(0, 1, 1, 1)}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	result, ok := CastVisitExprResult(unary.Visit(visitor))
+	if !ok {
+		t.Fatal("expected VisitExprResult")
+	}
+	if !result.TypeOf.IsEqual(ast.TypeInt()) {
+		t.Errorf("expected type int, got %s", result.TypeOf)
+	}
+
+	instructions := CastModuleContext(visitor.context).instructions
+	if last := instructions[len(instructions)-1]; last.OpCode != NEG_INT {
+		t.Errorf("expected NEG_INT instruction, got %s", last.OpCode)
+	}
+}
+
+func TestVisitUnaryExpr_NotOnIntIsError(t *testing.T) {
+	unary := &ast.UnaryExpr{Operator: lexer.OperatorNot, Operand: makeIntLiteral(5, false, 1, 1, 2), PosAt: makeSourcePos(0, 1, 1, 1)}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	if result := unary.Visit(visitor); result != nil {
+		t.Errorf("expected nil result, got %v", result)
+	}
+	if len(visitor.errors) != 1 {
+		t.Errorf("expected 1 error, got %d", len(visitor.errors))
+	}
+}
+
+/*
+*
+Test `const c = 1; c = c + 1;`, which is what `c++` desugars to.
+
+- Should be rejected because `c` is not mutable
+*/
+func TestVisitAssignment_IncrementConstIsError(t *testing.T) {
+	declaration := &ast.Declaration{
+		IsMutable:  false,
+		Identifier: makeIdentifier("c", false, 6, 1, 7),
+		Value:      makeIntLiteral(1, false, 10, 1, 11),
+		PosAt:      makeSourcePos(0, 1, 1, 5),
+	}
+	increment := &ast.Assignment{
+		Target: makeIdentifier("c", false, 13, 1, 14),
+		Value:  makeBinaryExpr(makeIdentifier("c", false, 13, 1, 14), lexer.OperatorPlus, makeIntLiteral(1, false, 14, 1, 15), false, 14, 1, 15),
+		PosAt:  makeSourcePos(13, 1, 14, 1),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	declaration.Visit(visitor)
+	increment.Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(visitor.errors))
+	}
+}
+
+/*
+*
+Test `const xs = [1]; xs[0] = 2;`.
+
+- Should be rejected because the array is held by a constant
+*/
+func TestVisitAssignment_ConstElementIsError(t *testing.T) {
+	declaration := &ast.Declaration{
+		IsMutable:  false,
+		Identifier: makeIdentifier("xs", false, 6, 1, 7),
+		Value: &ast.ArrayLiteral{
+			Elements: []ast.Expression{makeIntLiteral(1, false, 12, 1, 13)},
+			PosAt:    makeSourcePos(11, 1, 12, 3),
+		},
+		PosAt: makeSourcePos(0, 1, 1, 5),
+	}
+	assignment := &ast.Assignment{
+		Target: &ast.IndexExpr{
+			Array: makeIdentifier("xs", false, 16, 1, 17),
+			Index: makeIntLiteral(0, false, 19, 1, 20),
+			PosAt: makeSourcePos(16, 1, 17, 5),
+		},
+		Value: makeIntLiteral(2, false, 24, 1, 25),
+		PosAt: makeSourcePos(16, 1, 17, 5),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	declaration.Visit(visitor)
+	assignment.Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(visitor.errors))
+	}
+	if visitor.errors[0].Message != "variable xs is not mutable" {
+		t.Errorf("unexpected error %q", visitor.errors[0].Message)
+	}
+}
+
+/*
+*
+Test `const xs = [1]; var ys = xs;`.
+
+- Should store a COPY of the array in ys, writing into ys must not change xs
+*/
+func TestVisitDeclaration_VarCopiesConstArray(t *testing.T) {
+	constant := makeVar("xs", nil, &ast.ArrayLiteral{Elements: []ast.Expression{makeIntLiteral(1, false, 32, 1, 13)}, PosAt: makeSourcePos(31, 1, 12, 3)}, 1)
+	constant.IsMutable = false
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	makeProgram(constant, makeVar("ys", nil, makeIdentifier("xs", false, 49, 2, 10), 2)).Visit(visitor)
+
+	if len(visitor.errors) != 0 {
+		t.Fatalf("unexpected errors: %v", visitor.errors)
+	}
+	instructions := CastModuleContext(visitor.context).instructions
+	expected := []Instruction{InstrLoadVar(0, 0), InstrCopy(1, 0), InstrStoreVar(1, 1)}
+	for i, instr := range expected {
+		got := instructions[len(instructions)-len(expected)+i]
+		if got.String() != instr.String() {
+			t.Errorf("instruction %d: expected %s, got %s", i, instr.String(), got.String())
+		}
+	}
+}
+
+/*
+*
+Test `const m = { "a": 1 }; delete(m, "a");`.
+
+- Should be rejected like `m["a"] = 2`, delete writes into the map
+*/
+func TestVisitCallExpr_DeleteFromConstMapIsError(t *testing.T) {
+	literal := &ast.MapLiteral{Entries: []ast.MapEntry{{Key: makeStringLiteral("a", 32, 1, 13), Value: makeIntLiteral(1, false, 37, 1, 18)}}, PosAt: makeSourcePos(30, 1, 11, 10)}
+	constant := makeVar("m", nil, literal, 1)
+	constant.IsMutable = false
+	arguments := []ast.Expression{makeIdentifier("m", false, 47, 2, 8), makeStringLiteral("a", 50, 2, 11)}
+	call := makeCallExpr(makeIdentifier("delete", false, 40, 2, 1), arguments, 40, 2, 1)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	makeProgram(constant, call).Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(visitor.errors), visitor.errors)
+	}
+	if visitor.errors[0].Message != "variable m is not mutable" {
+		t.Errorf("unexpected error %q", visitor.errors[0].Message)
+	}
+}
+
+/*
+*
+Test `var xs = [1]; xs[0] += 2;`.
+
+- Should load the element with ARRAY_INDEX, add, and write it back with STORE_INDEX
+*/
+func TestVisitAssignment_CompoundElement(t *testing.T) {
+	declaration := &ast.Declaration{
+		IsMutable:  true,
+		Identifier: makeIdentifier("xs", false, 4, 1, 5),
+		Value: &ast.ArrayLiteral{
+			Elements: []ast.Expression{makeIntLiteral(1, false, 10, 1, 11)},
+			PosAt:    makeSourcePos(9, 1, 10, 3),
+		},
+		PosAt: makeSourcePos(0, 1, 1, 3),
+	}
+	assignment := &ast.Assignment{
+		Target: &ast.IndexExpr{
+			Array: makeIdentifier("xs", false, 14, 1, 15),
+			Index: makeIntLiteral(0, false, 17, 1, 18),
+			PosAt: makeSourcePos(14, 1, 15, 5),
+		},
+		Value:    makeIntLiteral(2, false, 23, 1, 24),
+		Compound: true,
+		Operator: lexer.OperatorPlus,
+		PosAt:    makeSourcePos(14, 1, 15, 5),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	declaration.Visit(visitor)
+	start := len(CastModuleContext(visitor.context).instructions)
+	assignment.Visit(visitor)
+
+	if len(visitor.errors) > 0 {
+		t.Fatalf("unexpected errors: %v", visitor.errors)
+	}
+	var opCodes []OpCode
+	for _, instruction := range CastModuleContext(visitor.context).instructions[start:] {
+		opCodes = append(opCodes, instruction.OpCode)
+	}
+	expected := []OpCode{LOAD_VAR, LOAD_CONST, INDEX_ARRAY, LOAD_CONST, ADD_INT, STORE_INDEX}
+	if len(opCodes) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, opCodes)
+	}
+	for i := range expected {
+		if opCodes[i] != expected[i] {
+			t.Errorf("instruction %d: expected %s, got %s", i, expected[i], opCodes[i])
+		}
+	}
+}
+
+// ---------- Short-Circuit Tests ----------
+
+/*
+*
+Test `false || true`.
+
+- Should move the left value into the result register
+- Should skip the right operand with JUMP_IF_TRUE when the left one is true
+- Should not emit OR_BOOL
+*/
+func TestVisitBinaryExpr_OrShortCircuits(t *testing.T) {
+	left := &ast.BoolLiteral{Value: false, PosAt: makeSourcePos(0, 1, 1, 5)}
+	right := &ast.BoolLiteral{Value: true, PosAt: makeSourcePos(9, 1, 10, 4)}
+	or := makeBinaryExpr(left, lexer.OperatorOr, right, false, 6, 1, 7)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	result, ok := CastVisitExprResult(or.Visit(visitor))
+	if !ok {
+		t.Fatal("expected VisitExprResult")
+	}
+	if !result.TypeOf.IsEqual(ast.TypeBool()) {
+		t.Errorf("expected type bool, got %s", result.TypeOf)
+	}
+
+	instructions := CastModuleContext(visitor.context).instructions
+	end := len(instructions)
+
+	jump := instructions[2]
+	if jump.OpCode != JUMP_IF_TRUE || jump.Args[0] != result.Reg || jump.Args[1] != end-1 {
+		t.Errorf("expected JUMP_IF_TRUE over the right operand, got %s", jump.String())
+	}
+	for _, instr := range instructions {
+		if instr.OpCode == OR_BOOL {
+			t.Error("expected OR_BOOL not to be emitted")
+		}
+	}
+	if last := instructions[end-1]; last.OpCode != MOVE || last.Args[0] != result.Reg {
+		t.Errorf("expected right value to be moved into the result register, got %s", last.String())
+	}
+}
+
+func TestVisitBinaryExpr_AndRequiresBool(t *testing.T) {
+	left := &ast.BoolLiteral{Value: true, PosAt: makeSourcePos(0, 1, 1, 4)}
+	and := makeBinaryExpr(left, lexer.OperatorAnd, makeIntLiteral(1, false, 8, 1, 9), false, 5, 1, 6)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	if result := and.Visit(visitor); result != nil {
+		t.Errorf("expected nil result, got %v", result)
+	}
+	if len(visitor.errors) != 1 {
+		t.Errorf("expected 1 error, got %d", len(visitor.errors))
+	}
+}
+
+// ---------- Conditional Expression Tests ----------
+
+/*
+*
+Test `true ? 1 : 2`.
+
+- Should jump over the then branch with JUMP_IF_FALSE
+- Should write both branches into the same result register
+*/
+func TestVisitConditionalExpr_SharedResultRegister(t *testing.T) {
+	conditional := &ast.ConditionalExpr{
+		Condition: &ast.BoolLiteral{Value: true, PosAt: makeSourcePos(0, 1, 1, 4)},
+		Then:      makeIntLiteral(1, false, 7, 1, 8),
+		Else:      makeIntLiteral(2, false, 11, 1, 12),
+		PosAt:     makeSourcePos(5, 1, 6, 1),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	result, ok := CastVisitExprResult(conditional.Visit(visitor))
+	if !ok {
+		t.Fatal("expected VisitExprResult")
+	}
+	if !result.TypeOf.IsEqual(ast.TypeInt()) {
+		t.Errorf("expected type int, got %s", result.TypeOf)
+	}
+
+	instructions := CastModuleContext(visitor.context).instructions
+	moves := 0
+	for i, instr := range instructions {
+		switch instr.OpCode {
+		case JUMP_IF_FALSE:
+			if instructions[instr.Args[1]+1].OpCode != LOAD_CONST {
+				t.Errorf("expected JUMP_IF_FALSE to land on the else branch, got %s", instructions[instr.Args[1]+1].String())
+			}
+		case JUMP:
+			if instr.Args[0] != len(instructions)-1 {
+				t.Errorf("expected JUMP at %d to skip the else branch, got target %d", i, instr.Args[0])
+			}
+		case MOVE:
+			moves++
+			if instr.Args[0] != result.Reg {
+				t.Errorf("expected MOVE into r%d, got %s", result.Reg, instr.String())
+			}
+		}
+	}
+	if moves != 2 {
+		t.Errorf("expected 2 MOVE instructions, got %d", moves)
+	}
+}
+
+func TestVisitConditionalExpr_BranchTypeMismatch(t *testing.T) {
+	conditional := &ast.ConditionalExpr{
+		Condition: &ast.BoolLiteral{Value: true, PosAt: makeSourcePos(0, 1, 1, 4)},
+		Then:      makeIntLiteral(1, false, 7, 1, 8),
+		Else:      &ast.BoolLiteral{Value: false, PosAt: makeSourcePos(11, 1, 12, 5)},
+		PosAt:     makeSourcePos(5, 1, 6, 1),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	if result := conditional.Visit(visitor); result != nil {
+		t.Errorf("expected nil result, got %v", result)
+	}
+	if len(visitor.errors) != 1 {
+		t.Errorf("expected 1 error, got %d", len(visitor.errors))
+	}
+}
+
+// ---------- String Tests ----------
+
+func TestVisitBinaryExpr_ConcatStrings(t *testing.T) {
+	left := &ast.StringLiteral{Value: "a", PosAt: makeSourcePos(0, 1, 1, 3)}
+	right := &ast.StringLiteral{Value: "b", PosAt: makeSourcePos(6, 1, 7, 3)}
+	concat := makeBinaryExpr(left, lexer.OperatorPlus, right, false, 4, 1, 5)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	result, ok := CastVisitExprResult(concat.Visit(visitor))
+	if !ok {
+		t.Fatal("expected VisitExprResult")
+	}
+	if !result.TypeOf.IsEqual(ast.TypeString()) {
+		t.Errorf("expected type string, got %s", result.TypeOf)
+	}
+	instructions := CastModuleContext(visitor.context).instructions
+	if last := instructions[len(instructions)-1]; last.OpCode != CONCAT_STRING {
+		t.Errorf("expected CONCAT_STRING, got %s", last.String())
+	}
+}
+
+func TestVisitBinaryExpr_StringPlusIntIsError(t *testing.T) {
+	left := &ast.StringLiteral{Value: "a", PosAt: makeSourcePos(0, 1, 1, 3)}
+	concat := makeBinaryExpr(left, lexer.OperatorPlus, makeIntLiteral(1, false, 6, 1, 7), false, 4, 1, 5)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	if result := concat.Visit(visitor); result != nil {
+		t.Errorf("expected nil result, got %v", result)
+	}
+	if len(visitor.errors) != 1 {
+		t.Errorf("expected 1 error, got %d", len(visitor.errors))
+	}
+}
+
+// ---------- Float Tests ----------
+
+func TestVisitBinaryExpr_AddFloats(t *testing.T) {
+	left := &ast.FloatLiteral{Value: 1.5, PosAt: makeSourcePos(0, 1, 1, 3)}
+	right := &ast.FloatLiteral{Value: 2.5, PosAt: makeSourcePos(6, 1, 7, 3)}
+	add := makeBinaryExpr(left, lexer.OperatorPlus, right, false, 4, 1, 5)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	result, ok := CastVisitExprResult(add.Visit(visitor))
+	if !ok {
+		t.Fatal("expected VisitExprResult")
+	}
+	if !result.TypeOf.IsEqual(ast.TypeFloat()) {
+		t.Errorf("expected type float, got %s", result.TypeOf)
+	}
+	instructions := CastModuleContext(visitor.context).instructions
+	if last := instructions[len(instructions)-1]; last.OpCode != ADD_FLOAT {
+		t.Errorf("expected ADD_FLOAT, got %s", last.String())
+	}
+}
+
+/*
+*
+Test `1 + 1.5`.
+
+- Should not coerce the int operand, mixed arithmetic is an error
+*/
+func TestVisitBinaryExpr_MixedIntFloatIsError(t *testing.T) {
+	right := &ast.FloatLiteral{Value: 1.5, PosAt: makeSourcePos(4, 1, 5, 3)}
+	add := makeBinaryExpr(makeIntLiteral(1, false, 0, 1, 1), lexer.OperatorPlus, right, false, 2, 1, 3)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	if result := add.Visit(visitor); result != nil {
+		t.Errorf("expected nil result, got %v", result)
+	}
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(visitor.errors))
+	}
+	if !strings.Contains(visitor.errors[0].Message, "float()") {
+		t.Errorf("expected conversion hint, got %q", visitor.errors[0].Message)
+	}
+}
+
+// ---------- Return Tests ----------
+
+func TestVisitReturn_WithoutValueRequiresNullReturnType(t *testing.T) {
+	function := &ast.Function{
+		Name:       "f",
+		ReturnType: ast.TypeInt(),
+		Body:       []ast.Statement{&ast.Return{PosAt: makeSourcePos(20, 1, 21, 6)}},
+		PosAt:      makeSourcePos(0, 1, 1, 8),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	function.Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Errorf("expected 1 error, got %d", len(visitor.errors))
+	}
+}
+
+// ---------- Line Table Tests ----------
+
+func TestVisitBinaryExpr_RecordsPositions(t *testing.T) {
+	// Test: 1 + 2 on line 3, each instruction maps back to the node that emitted it
+	left := makeIntLiteral(1, false, 10, 3, 1)
+	right := makeIntLiteral(2, false, 14, 3, 5)
+	binaryExpr := makeBinaryExpr(left, lexer.OperatorPlus, right, false, 12, 3, 3)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	binaryExpr.Visit(visitor)
+
+	moduleContext := CastModuleContext(visitor.context)
+	instructions := moduleContext.instructions
+	positions := moduleContext.positions
+
+	if len(positions) != len(instructions) {
+		t.Fatalf("expected %d positions, got %d", len(instructions), len(positions))
+	}
+	expectedColumns := []int{1, 5, 3}
+	for i, pos := range positions {
+		if pos == nil || pos.Line != 3 || pos.Column != expectedColumns[i] {
+			t.Errorf("instruction %d (%s): expected 3:%d, got %v", i, instructions[i].String(), expectedColumns[i], pos)
+		}
+	}
+}
+
+// ---------- Hoisting Tests ----------
+
+/*
+*
+Test `function f(): int { return g(); } function g(): int { return 1; }`.
+
+- g is hoisted, so f can call it before its declaration
+*/
+func TestVisitProgram_HoistsFunctions(t *testing.T) {
+	callG := makeCallExpr(makeIdentifier("g", false, 27, 1, 28), []ast.Expression{}, 27, 1, 28)
+	f := &ast.Function{
+		Name:       "f",
+		Body:       []ast.Statement{&ast.Return{Value: callG, PosAt: makeSourcePos(20, 1, 21, 6)}},
+		ReturnType: ast.TypeInt(),
+		PosAt:      makeSourcePos(0, 1, 1, 8),
+	}
+	g := &ast.Function{
+		Name:       "g",
+		Body:       []ast.Statement{&ast.Return{Value: makeIntLiteral(1, false, 60, 1, 61), PosAt: makeSourcePos(53, 1, 54, 6)}},
+		ReturnType: ast.TypeInt(),
+		PosAt:      makeSourcePos(34, 1, 35, 8),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	makeProgram(f, g).Visit(visitor)
+
+	if len(visitor.errors) != 0 {
+		t.Fatalf("expected no errors, got %v", visitor.errors)
+	}
+}
+
+/*
+*
+Test `function f(): int { return 1; } function f(): int { return 2; }`.
+
+- The redefinition is reported once
+*/
+func TestVisitProgram_HoistedRedefinitionIsError(t *testing.T) {
+	makeF := func(value, offset int) *ast.Function {
+		return &ast.Function{
+			Name:       "f",
+			Body:       []ast.Statement{&ast.Return{Value: makeIntLiteral(value, false, offset+27, 1, offset+28), PosAt: makeSourcePos(offset+20, 1, offset+21, 6)}},
+			ReturnType: ast.TypeInt(),
+			PosAt:      makeSourcePos(offset, 1, offset+1, 8),
+		}
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	makeProgram(makeF(1, 0), makeF(2, 32)).Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %v", visitor.errors)
+	}
+	if visitor.errors[0].Pos.Offset != 32 {
+		t.Errorf("expected the error on the second declaration, got %v", visitor.errors[0].Pos)
+	}
+}
+
+// ---------- Function Type Tests ----------
+
+func makeIsPositive() *ast.Function {
+	return &ast.Function{
+		Name:       "isPositive",
+		Params:     []ast.Param{{Name: "x", TypeOf: ast.TypeInt(), PosAt: makeSourcePos(20, 1, 21, 1)}},
+		Body:       []ast.Statement{makeReturn(makeBinaryExpr(makeIdentifier("x", false, 44, 1, 45), lexer.OperatorGreater, makeIntLiteral(0, false, 48, 1, 49), false, 46, 1, 47), 37, 1, 38)},
+		ReturnType: ast.TypeBool(),
+		PosAt:      makeSourcePos(0, 1, 1, 8),
+	}
+}
+
+/*
+*
+Test `function isPositive(x: int): bool { ... } const f: (int) -> bool = isPositive;`.
+
+- The declared function type keeps the signature, so f can be called
+*/
+func TestVisitDeclaration_FunctionTypeKeepsSignature(t *testing.T) {
+	functionType := ast.TypeFunction(&ast.Signature{Params: []*ast.Type{ast.TypeInt()}, ReturnType: ast.TypeBool()})
+	declaration := &ast.Declaration{
+		IsTyped:    true,
+		TypeOf:     functionType,
+		Identifier: makeIdentifier("f", false, 60, 2, 7),
+		Value:      makeIdentifier("isPositive", false, 80, 2, 27),
+		PosAt:      makeSourcePos(54, 2, 1, 5),
+	}
+	call := makeCallExpr(makeIdentifier("f", false, 92, 3, 1), []ast.Expression{makeIntLiteral(1, false, 94, 3, 3)}, 92, 3, 1)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	makeProgram(makeIsPositive(), declaration, call).Visit(visitor)
+
+	if len(visitor.errors) != 0 {
+		t.Fatalf("expected no errors, got %v", visitor.errors)
+	}
+	variable, ok := visitor.context.FindLocalVariable("f")
+	if !ok || variable.TypeOf.String() != "(int) -> bool" {
+		t.Errorf("expected f to be of type (int) -> bool, got %v", variable)
+	}
+}
+
+/*
+*
+Test `const f: (int) -> int = isPositive;`.
+
+- The return types differ, so the declaration is an error
+*/
+func TestVisitDeclaration_FunctionTypeMismatch(t *testing.T) {
+	functionType := ast.TypeFunction(&ast.Signature{Params: []*ast.Type{ast.TypeInt()}, ReturnType: ast.TypeInt()})
+	declaration := &ast.Declaration{
+		IsTyped:    true,
+		TypeOf:     functionType,
+		Identifier: makeIdentifier("f", false, 60, 2, 7),
+		Value:      makeIdentifier("isPositive", false, 80, 2, 27),
+		PosAt:      makeSourcePos(54, 2, 1, 5),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	makeProgram(makeIsPositive(), declaration).Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %v", visitor.errors)
+	}
+	if !strings.Contains(visitor.errors[0].Message, "(int) -> bool") {
+		t.Errorf("expected the error to mention the actual signature, got %q", visitor.errors[0].Message)
+	}
+}
+
+// ---------- Lambda Tests ----------
+
+/*
+*
+Test `const n = 3; (x: int) => x > n` as an expression.
+
+- The return type of an arrow function is inferred from its body
+- n is captured as an upvar of the lambda
+*/
+func TestVisitFunctionExpr_ArrowInfersReturnType(t *testing.T) {
+	declaration := &ast.Declaration{
+		Identifier: makeIdentifier("n", false, 6, 1, 7),
+		Value:      makeIntLiteral(3, false, 10, 1, 11),
+		PosAt:      makeSourcePos(0, 1, 1, 5),
+	}
+	lambda := &ast.FunctionExpr{
+		Params:   []ast.Param{{Name: "x", TypeOf: ast.TypeInt(), PosAt: makeSourcePos(14, 2, 2, 1)}},
+		ExprBody: makeBinaryExpr(makeIdentifier("x", false, 25, 2, 13), lexer.OperatorGreater, makeIdentifier("n", false, 29, 2, 17), false, 27, 2, 15),
+		PosAt:    makeSourcePos(13, 2, 1, 1),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	declaration.Visit(visitor)
+	result, ok := CastVisitExprResult(lambda.Visit(visitor))
+
+	if len(visitor.errors) != 0 {
+		t.Fatalf("expected no errors, got %v", visitor.errors)
+	}
+	if !ok || result.TypeOf.String() != "(int) -> bool" {
+		t.Fatalf("expected type (int) -> bool, got %v", result)
+	}
+	upvars := visitor.functionProtos[0].Upvars()
+	if len(upvars) != 1 || !upvars[0].IsFromParent {
+		t.Errorf("expected n to be captured from the module, got %v", upvars)
+	}
+}
+
+// ---------- Call Expression Tests ----------
+
+/*
+*
+Test `1(2)`.
+
+- Any expression can be called, but it must be of a function type
+*/
+func TestVisitCallExpr_CalleeMustBeCallable(t *testing.T) {
+	call := &ast.CallExpr{
+		Callee:    makeIntLiteral(1, false, 0, 1, 1),
+		Arguments: []ast.Expression{makeIntLiteral(2, false, 2, 1, 3)},
+		PosAt:     makeSourcePos(1, 1, 2, 1),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	call.Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %v", visitor.errors)
+	}
+	if visitor.errors[0].Message != "expression must be callable, but got type int" {
+		t.Errorf("unexpected error message %q", visitor.errors[0].Message)
+	}
+}
+
+// ---------- Nested Array Tests ----------
+
+/*
+*
+Test `var grid: [][]int;` without a value.
+
+- Should store an empty array as the default value
+- Should keep the declared [][]int type
+*/
+func TestVisitDeclaration_NestedArrayDefault(t *testing.T) {
+	gridType := ast.TypeArrayOf(ast.TypeArrayOf(ast.TypeInt()))
+	declaration := &ast.Declaration{
+		IsMutable:  true,
+		IsTyped:    true,
+		TypeOf:     gridType,
+		Identifier: makeIdentifier("grid", false, 4, 1, 5),
+		PosAt:      makeSourcePos(0, 1, 1, 3),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	declaration.Visit(visitor)
+
+	if len(visitor.errors) > 0 {
+		t.Fatalf("unexpected errors: %v", visitor.errors)
+	}
+	variable, ok := visitor.context.FindLocalVariable("grid")
+	if !ok || !variable.TypeOf.IsEqual(gridType) {
+		t.Errorf("expected grid of type [][]int, got %v", variable)
+	}
+	defaultValue := DefaultValue(gridType)
+	if defaultValue.TypeOf != VAL_ARRAY || len(defaultValue.Array) != 0 {
+		t.Errorf("expected an empty array, got %v", defaultValue)
+	}
+}
+
+/*
+*
+Test `var grid = [[], [1]]; var empty = [[]];`.
+
+- Should infer [][]int from the element that is known
+- Should reject `empty` because no element type is known
+*/
+func TestVisitDeclaration_EmptyArrayElements(t *testing.T) {
+	grid := &ast.Declaration{
+		IsMutable:  true,
+		Identifier: makeIdentifier("grid", false, 4, 1, 5),
+		Value: &ast.ArrayLiteral{
+			Elements: []ast.Expression{
+				&ast.ArrayLiteral{PosAt: makeSourcePos(12, 1, 13, 2)},
+				&ast.ArrayLiteral{
+					Elements: []ast.Expression{makeIntLiteral(1, false, 17, 1, 18)},
+					PosAt:    makeSourcePos(16, 1, 17, 3),
+				},
+			},
+			PosAt: makeSourcePos(11, 1, 12, 9),
+		},
+		PosAt: makeSourcePos(0, 1, 1, 3),
+	}
+	empty := &ast.Declaration{
+		IsMutable:  true,
+		Identifier: makeIdentifier("empty", false, 26, 1, 27),
+		Value: &ast.ArrayLiteral{
+			Elements: []ast.Expression{&ast.ArrayLiteral{PosAt: makeSourcePos(35, 1, 36, 2)}},
+			PosAt:    makeSourcePos(34, 1, 35, 4),
+		},
+		PosAt: makeSourcePos(22, 1, 23, 3),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	grid.Visit(visitor)
+	empty.Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(visitor.errors), visitor.errors)
+	}
+	if visitor.errors[0].Message != "cannot infer the element type of empty from an empty array or map, add a type annotation" {
+		t.Errorf("unexpected error %q", visitor.errors[0].Message)
+	}
+	variable, ok := visitor.context.FindLocalVariable("grid")
+	if !ok || !variable.TypeOf.IsEqual(ast.TypeArrayOf(ast.TypeArrayOf(ast.TypeInt()))) {
+		t.Errorf("expected grid of type [][]int, got %v", variable)
+	}
+}
+
+// ---------- Map Tests ----------
+
+func makeStringLiteral(value string, offset, line, col int) *ast.StringLiteral {
+	return &ast.StringLiteral{
+		Value: value,
+		PosAt: makeSourcePos(offset, line, col, len(value)+2),
+	}
+}
+
+/*
+*
+Test `{ "a": 1, 2: 3 }`.
+
+- Should be rejected because the keys have different types
+*/
+func TestVisitMapLiteral_MixedKeysIsError(t *testing.T) {
+	literal := &ast.MapLiteral{
+		Entries: []ast.MapEntry{
+			{Key: makeStringLiteral("a", 2, 1, 3), Value: makeIntLiteral(1, false, 7, 1, 8)},
+			{Key: makeIntLiteral(2, false, 10, 1, 11), Value: makeIntLiteral(3, false, 13, 1, 14)},
+		},
+		PosAt: makeSourcePos(0, 1, 1, 16),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	literal.Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(visitor.errors))
+	}
+	if visitor.errors[0].Message != "map keys must be of type string, but got int" {
+		t.Errorf("unexpected error %q", visitor.errors[0].Message)
+	}
+}
+
+/*
+*
+Test `keys({ "a": true })` and `has({ "a": true }, 1)`.
+
+- Should type keys(...) as []string by binding K to string
+- Should reject the int key passed to has
+*/
+func TestVisitCallExpr_MapBuiltinsBindKeyType(t *testing.T) {
+	makeMap := func(offset int) *ast.MapLiteral {
+		return &ast.MapLiteral{
+			Entries: []ast.MapEntry{{Key: makeStringLiteral("a", offset+2, 1, offset+3), Value: &ast.BoolLiteral{Value: true, PosAt: makeSourcePos(offset+7, 1, offset+8, 4)}}},
+			PosAt:   makeSourcePos(offset, 1, offset+1, 13),
+		}
+	}
+	keysCall := makeCallExpr(makeIdentifier("keys", false, 0, 1, 1), []ast.Expression{makeMap(5)}, 0, 1, 1)
+	hasCall := makeCallExpr(makeIdentifier("has", false, 20, 1, 21), []ast.Expression{makeMap(24), makeIntLiteral(1, false, 39, 1, 40)}, 20, 1, 21)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+
+	result, ok := CastVisitExprResult(keysCall.Visit(visitor))
+	if !ok {
+		t.Fatalf("unexpected errors: %v", visitor.errors)
+	}
+	if !result.TypeOf.IsEqual(ast.TypeArrayOf(ast.TypeString())) {
+		t.Errorf("expected []string, got %s", result.TypeOf)
+	}
+
+	hasCall.Visit(visitor)
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(visitor.errors))
+	}
+	if visitor.errors[0].Message != "argument 1 must be of type string, but got int" {
+		t.Errorf("unexpected error %q", visitor.errors[0].Message)
+	}
+}
+
+// ---------- Struct Tests ----------
+
+func makeStruct(name string, fields ...string) *ast.Struct {
+	decl := &ast.Struct{Name: name, PosAt: makeSourcePos(0, 1, 1, 6)}
+	for i, field := range fields {
+		decl.Fields = append(decl.Fields, ast.StructField{Name: field, TypeOf: ast.TypeInt(), PosAt: makeSourcePos(10+i, 1, 11+i, 1)})
+	}
+	return decl
+}
+
+/*
+*
+Test `struct A { x: int } struct B { x: int } var a: A = B { x: 1 };`.
+
+- Should be rejected because struct types are nominal
+*/
+func TestVisitStructLiteral_NominalTyping(t *testing.T) {
+	program := makeProgram(
+		makeStruct("A", "x"),
+		makeStruct("B", "x"),
+		&ast.Declaration{
+			IsMutable:  true,
+			IsTyped:    true,
+			TypeOf:     ast.TypeNamed("A"),
+			Identifier: makeIdentifier("a", false, 44, 3, 5),
+			Value: &ast.StructLiteral{
+				Name:   "B",
+				Fields: []ast.FieldValue{{Name: "x", Value: makeIntLiteral(1, false, 58, 3, 19), PosAt: makeSourcePos(55, 3, 16, 1)}},
+				PosAt:  makeSourcePos(51, 3, 12, 1),
+			},
+			PosAt: makeSourcePos(40, 3, 1, 3),
+		},
+	)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	program.Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(visitor.errors), visitor.errors)
+	}
+	if visitor.errors[0].Message != "variable a is of type B, but declaration is of type A" {
+		t.Errorf("unexpected error %q", visitor.errors[0].Message)
+	}
+}
+
+/*
+*
+Test `struct P { x: int, y: int } P { y: 2, x: 1 }.y`.
+
+- Should pass the fields to MAKE_STRUCT in declaration order
+- Should read y with GET_FIELD at index 1
+*/
+func TestVisitFieldExpr_ResolvesFieldIndex(t *testing.T) {
+	literal := &ast.StructLiteral{
+		Name: "P",
+		Fields: []ast.FieldValue{
+			{Name: "y", Value: makeIntLiteral(2, false, 35, 2, 8), PosAt: makeSourcePos(32, 2, 5, 1)},
+			{Name: "x", Value: makeIntLiteral(1, false, 41, 2, 14), PosAt: makeSourcePos(38, 2, 11, 1)},
+		},
+		PosAt: makeSourcePos(28, 2, 1, 1),
+	}
+	field := &ast.FieldExpr{Object: literal, Field: "y", PosAt: makeSourcePos(45, 2, 18, 1)}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	visitor.declareTypes([]ast.Statement{makeStruct("P", "x", "y")})
+
+	result, ok := CastVisitExprResult(field.Visit(visitor))
+	if !ok {
+		t.Fatalf("unexpected errors: %v", visitor.errors)
+	}
+	if !result.TypeOf.IsEqual(ast.TypeInt()) {
+		t.Errorf("expected int, got %s", result.TypeOf)
+	}
+	instructions := CastModuleContext(visitor.context).instructions
+	makeStruct := instructions[len(instructions)-2]
+	getField := instructions[len(instructions)-1]
+	// LOAD_CONST y is visited first, into register 0
+	if makeStruct.OpCode != MAKE_STRUCT || makeStruct.Args[2] != 1 || makeStruct.Args[3] != 0 {
+		t.Errorf("expected MAKE_STRUCT with x from register 1 and y from register 0, got %v", makeStruct)
+	}
+	if getField.OpCode != GET_FIELD || getField.Args[2] != 1 {
+		t.Errorf("expected GET_FIELD at index 1, got %v", getField)
+	}
+}
+
+// ---------- Enums ----------
+
+func makeVariant(name string, fields ...string) ast.EnumVariant {
+	variant := ast.EnumVariant{Name: name, PosAt: makeSourcePos(0, 1, 1, len(name))}
+	for i, field := range fields {
+		variant.Fields = append(variant.Fields, ast.StructField{Name: field, TypeOf: ast.TypeInt(), PosAt: makeSourcePos(10+i, 1, 11+i, 1)})
+	}
+	return variant
+}
+
+func makeEnum(name string, variants ...ast.EnumVariant) *ast.Enum {
+	return &ast.Enum{Name: name, Variants: variants, PosAt: makeSourcePos(0, 1, 1, 4)}
+}
+
+func makeMatch(subject ast.Expression, arms ...ast.MatchArm) *ast.MatchExpr {
+	return &ast.MatchExpr{Subject: subject, Arms: arms, PosAt: makeSourcePos(20, 2, 1, 5)}
+}
+
+func makeArm(variant string, body ast.Expression, bindings ...string) ast.MatchArm {
+	return ast.MatchArm{Variant: variant, Bindings: bindings, Body: body, PosAt: makeSourcePos(30, 2, 11, len(variant))}
+}
+
+func makeVariantCall(enum string, variant string, args ...ast.Expression) *ast.CallExpr {
+	field := &ast.FieldExpr{Object: makeIdentifier(enum, false, 20, 2, 1), Field: variant, PosAt: makeSourcePos(25, 2, 6, len(variant))}
+	return &ast.CallExpr{Callee: field, Arguments: args, PosAt: makeSourcePos(20, 2, 1, 1)}
+}
+
+/*
+*
+Test `enum S { A(x: int), B, C } match (S.A(1)) { A(x) => x, C => 0 }`.
+
+- Should report the variants no arm covers
+*/
+func TestVisitMatchExpr_NotExhaustive(t *testing.T) {
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	visitor.declareTypes([]ast.Statement{makeEnum("S", makeVariant("A", "x"), makeVariant("B"), makeVariant("C"))})
+
+	match := makeMatch(
+		makeVariantCall("S", "A", makeIntLiteral(1, false, 29, 2, 10)),
+		makeArm("A", makeIdentifier("x", false, 40, 2, 20), "x"),
+		makeArm("C", makeIntLiteral(0, false, 50, 2, 30)),
+	)
+	match.Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(visitor.errors), visitor.errors)
+	}
+	if visitor.errors[0].Message != "match is not exhaustive, missing B" {
+		t.Errorf("unexpected error %q", visitor.errors[0].Message)
+	}
+}
+
+/*
+*
+Test `enum S { A(x: int), B } match (S.B) { A(x) => x, B => 0 }`.
+
+- Should build the subject with MAKE_VARIANT and tag 1
+- Should test the tag of the first arm only, the last arm is reached by elimination
+- Should read the binding x with GET_PAYLOAD
+*/
+func TestVisitMatchExpr_TagChain(t *testing.T) {
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	visitor.declareTypes([]ast.Statement{makeEnum("S", makeVariant("A", "x"), makeVariant("B"))})
+
+	subject := &ast.FieldExpr{Object: makeIdentifier("S", false, 27, 2, 8), Field: "B", PosAt: makeSourcePos(29, 2, 10, 1)}
+	match := makeMatch(
+		subject,
+		makeArm("A", makeIdentifier("x", false, 40, 2, 20), "x"),
+		makeArm("B", makeIntLiteral(0, false, 50, 2, 30)),
+	)
+	result, ok := CastVisitExprResult(match.Visit(visitor))
+	if !ok {
+		t.Fatalf("unexpected errors: %v", visitor.errors)
+	}
+	if !result.TypeOf.IsEqual(ast.TypeInt()) {
+		t.Errorf("expected int, got %s", result.TypeOf)
+	}
+
+	counts := map[OpCode]int{}
+	instructions := CastModuleContext(visitor.context).instructions
+	for _, instruction := range instructions {
+		counts[instruction.OpCode]++
+	}
+	if instructions[0].OpCode != MAKE_VARIANT || instructions[0].Args[2] != 1 {
+		t.Errorf("expected MAKE_VARIANT with tag 1, got %v", instructions[0])
+	}
+	if counts[GET_TAG] != 1 || counts[EQ_INT] != 1 || counts[JUMP_IF_FALSE] != 1 {
+		t.Errorf("expected a single tag test, got %v", instructions)
+	}
+	if counts[GET_PAYLOAD] != 1 {
+		t.Errorf("expected GET_PAYLOAD for x, got %v", instructions)
+	}
+}
+
+// ---------- Nullable Types ----------
+
+func makeVar(name string, typeOf *ast.Type, value ast.Expression, line int) *ast.Declaration {
+	return &ast.Declaration{
+		IsMutable:  true,
+		IsTyped:    typeOf != nil,
+		TypeOf:     typeOf,
+		Identifier: makeIdentifier(name, false, line*20+4, line, 5),
+		Value:      value,
+		PosAt:      makeSourcePos(line*20, line, 1, 3),
+	}
+}
+
+// makeXPlusOne is `var <name> = x + 1;` on the given line.
+func makeXPlusOne(name string, line int) *ast.Declaration {
+	sum := makeBinaryExpr(makeIdentifier("x", false, line*20+8, line, 9), lexer.OperatorPlus, makeIntLiteral(1, false, line*20+12, line, 13), false, line*20+10, line, 11)
+	return makeVar(name, nil, sum, line)
+}
+
+func makeNullCheck(name string, operator lexer.OperatorSubkind, line int) *ast.BinaryExpr {
+	null := &ast.NullLiteral{PosAt: makeSourcePos(line*20+9, line, 10, 4)}
+	return makeBinaryExpr(makeIdentifier(name, false, line*20+4, line, 5), operator, null, false, line*20+6, line, 7)
+}
+
+/*
+*
+Test `var x: int? = null; if (x != null) { var a = x + 1; } var b = x + 1;`.
+
+- Should narrow x to int inside the if
+- Should reject the unchecked use after the if
+*/
+func TestVisitIf_NarrowsNullable(t *testing.T) {
+	program := makeProgram(
+		makeVar("x", ast.TypeNullableOf(ast.TypeInt()), &ast.NullLiteral{PosAt: makeSourcePos(14, 1, 15, 4)}, 1),
+		&ast.If{Condition: makeNullCheck("x", lexer.OperatorNotEqual, 2), Body: []ast.Statement{makeXPlusOne("a", 3)}, PosAt: makeSourcePos(40, 2, 1, 2)},
+		makeXPlusOne("b", 4),
+	)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	program.Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(visitor.errors), visitor.errors)
+	}
+	if visitor.errors[0].Pos.Line != 4 {
+		t.Errorf("expected the error on line 4, got %d", visitor.errors[0].Pos.Line)
+	}
+	expected := "binary operator + is not supported for types int? and int, int? may be null, check it with != null or use ??"
+	if visitor.errors[0].Message != expected {
+		t.Errorf("unexpected error %q", visitor.errors[0].Message)
+	}
+	if a, ok := visitor.context.FindLocalVariable("a"); !ok || !a.TypeOf.IsEqual(ast.TypeInt()) {
+		t.Errorf("expected a of type int, got %v", a)
+	}
+}
+
+/*
+*
+Test `var x: int? = null; if (x == null) { return 0; } var a = x + 1;`.
+
+- Should narrow x for the rest of the block once the null branch returns
+*/
+func TestVisitIf_EarlyReturnNarrows(t *testing.T) {
+	program := makeProgram(
+		makeVar("x", ast.TypeNullableOf(ast.TypeInt()), &ast.NullLiteral{PosAt: makeSourcePos(14, 1, 15, 4)}, 1),
+		&ast.If{
+			Condition: makeNullCheck("x", lexer.OperatorEqual, 2),
+			Body:      []ast.Statement{makeReturn(makeIntLiteral(0, false, 67, 3, 8), 60, 3, 1)},
+			PosAt:     makeSourcePos(40, 2, 1, 2),
+		},
+		makeXPlusOne("a", 4),
+	)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	program.Visit(visitor)
+
+	if len(visitor.errors) != 0 {
+		t.Fatalf("unexpected errors: %v", visitor.errors)
+	}
+}
+
+/*
+*
+Test `if (x != null) { while (true) { var a = x + 1; x = null; } }`.
+
+- Should forget the narrowing in the loop, the second iteration reads the null
+*/
+func TestVisitWhile_AssignmentForgetsNarrowing(t *testing.T) {
+	loop := &ast.While{
+		Condition: &ast.BoolLiteral{Value: true, PosAt: makeSourcePos(67, 3, 8, 4)},
+		Body: []ast.Statement{
+			makeXPlusOne("a", 4),
+			&ast.Assignment{Target: makeIdentifier("x", false, 100, 5, 1), Value: &ast.NullLiteral{PosAt: makeSourcePos(104, 5, 5, 4)}, PosAt: makeSourcePos(100, 5, 1, 1)},
+		},
+		PosAt: makeSourcePos(60, 3, 1, 5),
+	}
+	program := makeProgram(
+		makeVar("x", ast.TypeNullableOf(ast.TypeInt()), makeIntLiteral(1, false, 14, 1, 15), 1),
+		&ast.If{Condition: makeNullCheck("x", lexer.OperatorNotEqual, 2), Body: []ast.Statement{loop}, PosAt: makeSourcePos(40, 2, 1, 2)},
+	)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	program.Visit(visitor)
+
+	if len(visitor.errors) != 1 || visitor.errors[0].Pos.Line != 4 {
+		t.Fatalf("expected 1 error on line 4, got %v", visitor.errors)
+	}
+}
+
+// makeClearX is `x = null; return null;`, the body of a function clearing x
+// on the given line.
+func makeClearX(line int) []ast.Statement {
+	return []ast.Statement{
+		&ast.Assignment{Target: makeIdentifier("x", false, line*20+2, line, 3), Value: &ast.NullLiteral{PosAt: makeSourcePos(line*20+6, line, 7, 4)}, PosAt: makeSourcePos(line*20+2, line, 3, 1)},
+		makeReturn(&ast.NullLiteral{PosAt: makeSourcePos(line*20+19, line, 20, 4)}, line*20+12, line, 13),
+	}
+}
+
+// makeCallClear is `clear(); var a = x + 1;` inside `if (x != null)`.
+func makeCallClear(line int) *ast.If {
+	call := &ast.CallExpr{Callee: makeIdentifier("clear", false, (line+1)*20, line+1, 1), PosAt: makeSourcePos((line+1)*20+5, line+1, 6, 1)}
+	return &ast.If{
+		Condition: makeNullCheck("x", lexer.OperatorNotEqual, line),
+		Body:      []ast.Statement{call, makeXPlusOne("a", line+2)},
+		PosAt:     makeSourcePos(line*20, line, 1, 2),
+	}
+}
+
+/*
+*
+Test `var x: int? = 5; function clear(): null { x = null; return null; }
+if (x != null) { clear(); var a = x + 1; }`.
+
+- A global assigned by a function is not narrowed, the call sets it to null
+*/
+func TestVisitIf_GlobalAssignedByFunctionIsNotNarrowed(t *testing.T) {
+	clear := &ast.Function{Name: "clear", ReturnType: ast.TypeNull(), Body: makeClearX(2), PosAt: makeSourcePos(40, 2, 1, 8)}
+	program := makeProgram(
+		makeVar("x", ast.TypeNullableOf(ast.TypeInt()), makeIntLiteral(5, false, 34, 1, 15), 1),
+		clear,
+		makeCallClear(3),
+	)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	program.Visit(visitor)
+
+	if len(visitor.errors) != 1 || visitor.errors[0].Pos.Line != 5 {
+		t.Fatalf("expected 1 error on line 5, got %v", visitor.errors)
+	}
+}
+
+/*
+*
+Test `function f(): null { var x: int? = 5; const clear = function (): null {
+x = null; return null; }; if (x != null) { clear(); var a = x + 1; } return; }`.
+
+- A local assigned by a function expression is not narrowed either
+*/
+func TestVisitIf_LocalAssignedByClosureIsNotNarrowed(t *testing.T) {
+	clear := &ast.Declaration{
+		Identifier: makeIdentifier("clear", false, 46, 2, 7),
+		Value:      &ast.FunctionExpr{ReturnType: ast.TypeNull(), Body: makeClearX(3), PosAt: makeSourcePos(54, 2, 15, 8)},
+		PosAt:      makeSourcePos(40, 2, 1, 5),
+	}
+	function := &ast.Function{
+		Name:       "f",
+		ReturnType: ast.TypeNull(),
+		Body: []ast.Statement{
+			makeVar("x", ast.TypeNullableOf(ast.TypeInt()), makeIntLiteral(5, false, 34, 1, 15), 1),
+			clear,
+			makeCallClear(4),
+			&ast.Return{PosAt: makeSourcePos(140, 7, 1, 6)},
+		},
+		PosAt: makeSourcePos(0, 1, 1, 8),
+	}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	makeProgram(function).Visit(visitor)
+
+	if len(visitor.errors) != 1 || visitor.errors[0].Pos.Line != 6 {
+		t.Fatalf("expected 1 error on line 6, got %v", visitor.errors)
+	}
+}
+
+// ---------- Generic Functions ----------
+
+// makeFirst is `function first<T>(xs: []T): T { return xs[0]; }`.
+func makeFirst() *ast.Function {
+	element := &ast.IndexExpr{Array: makeIdentifier("xs", false, 40, 1, 41), Index: makeIntLiteral(0, false, 43, 1, 44), PosAt: makeSourcePos(42, 1, 43, 1)}
+	return &ast.Function{
+		Name:       "first",
+		TypeParams: []string{"T"},
+		Params:     []ast.Param{{Name: "xs", TypeOf: ast.TypeArrayOf(ast.TypeNamed("T")), PosAt: makeSourcePos(18, 1, 19, 2)}},
+		ReturnType: ast.TypeNamed("T"),
+		Body:       []ast.Statement{makeReturn(element, 33, 1, 34)},
+		PosAt:      makeSourcePos(0, 1, 1, 8),
+	}
+}
+
+/*
+*
+Test `function first<T>(xs: []T): T { ... } first([true]);`.
+
+- Should give callers the signature ([]T) -> T with T as a type parameter
+- Should bind T to bool at the call
+*/
+func TestVisitCallExpr_GenericFunction(t *testing.T) {
+	boolArray := &ast.ArrayLiteral{Elements: []ast.Expression{&ast.BoolLiteral{Value: true, PosAt: makeSourcePos(58, 2, 8, 4)}}, PosAt: makeSourcePos(57, 2, 7, 1)}
+	call := makeCallExpr(makeIdentifier("first", false, 51, 2, 1), []ast.Expression{boolArray}, 51, 2, 1)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	makeProgram(makeFirst()).Visit(visitor)
+
+	first, ok := visitor.context.FindLocalVariable("first")
+	if !ok {
+		t.Fatalf("unexpected errors: %v", visitor.errors)
+	}
+	if first.TypeOf.String() != "([]T) -> T" || !first.TypeOf.HasTypeParams() {
+		t.Errorf("expected the generic signature ([]T) -> T, got %s", first.TypeOf)
+	}
+	result, ok := CastVisitExprResult(call.Visit(visitor))
+	if !ok {
+		t.Fatalf("unexpected errors: %v", visitor.errors)
+	}
+	if !result.TypeOf.IsEqual(ast.TypeBool()) {
+		t.Errorf("expected bool, got %s", result.TypeOf)
+	}
+}
+
+// makeMk is `function mk<T>(): T? { return null; }`.
+func makeMk() *ast.Function {
+	return &ast.Function{
+		Name:       "mk",
+		TypeParams: []string{"T"},
+		ReturnType: ast.TypeNullableOf(ast.TypeNamed("T")),
+		Body:       []ast.Statement{makeReturn(&ast.NullLiteral{PosAt: makeSourcePos(27, 1, 28, 4)}, 20, 1, 21)},
+		PosAt:      makeSourcePos(0, 1, 1, 8),
+	}
+}
+
+/*
+*
+Test `function mk<T>(): T? { return null; }` called without anything to bind T.
+
+- Should reject `const x: int? = mk();` instead of typing the call T?
+- Should reject `println(mk() ?? 3);` the same way
+*/
+func TestVisitCallExpr_GenericReturnTypeNotInferred(t *testing.T) {
+	declaration := makeVar("x", ast.TypeNullableOf(ast.TypeInt()), makeCallExpr(makeIdentifier("mk", false, 56, 2, 17), nil, 56, 2, 17), 2)
+	declaration.IsMutable = false
+	coalesce := makeBinaryExpr(makeCallExpr(makeIdentifier("mk", false, 68, 3, 9), nil, 68, 3, 9), lexer.OperatorNullCoalesce, makeIntLiteral(3, false, 76, 3, 17), false, 73, 3, 14)
+	println := makeCallExpr(makeIdentifier("println", false, 60, 3, 1), []ast.Expression{coalesce}, 60, 3, 1)
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	makeProgram(makeMk(), declaration, println).Visit(visitor)
+
+	if len(visitor.errors) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(visitor.errors), visitor.errors)
+	}
+	for _, err := range visitor.errors {
+		if err.Message != "cannot infer T in the return type of mk from its arguments" {
+			t.Errorf("unexpected error %q", err.Message)
+		}
+	}
+}
+
+/*
+*
+Test `function first<T>(xs: []T): T { return 1; }`.
+
+- Should reject the int, T stands for any type inside the body
+*/
+func TestVisitFunction_TypeParamIsOpaque(t *testing.T) {
+	first := makeFirst()
+	first.Body = []ast.Statement{makeReturn(makeIntLiteral(1, false, 40, 1, 41), 33, 1, 34)}
+
+	visitor := NewInstructionsVisitor()
+	visitor.EnterModuleContext()
+	makeProgram(first).Visit(visitor)
+
+	if len(visitor.errors) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(visitor.errors), visitor.errors)
+	}
+	if visitor.errors[0].Message != "return value must be of type T, but got int" {
+		t.Errorf("unexpected error %q", visitor.errors[0].Message)
+	}
+}
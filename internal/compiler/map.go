@@ -0,0 +1,85 @@
+package compiler
+
+// MapKey is the comparable part of a Value, only int, bool and string values
+// are used as keys.
+type MapKey struct {
+	TypeOf ValueType
+	Int    int
+	Bool   bool
+	String string
+}
+
+func (v Value) MapKey() MapKey {
+	return MapKey{TypeOf: v.TypeOf, Int: v.Int, Bool: v.Bool, String: v.String}
+}
+
+// Map keeps its entries in insertion order, so iterating over a map gives the
+// same order on every run. Map values are shared like arrays, every value
+// holding the same map sees its changes.
+type Map struct {
+	keys    []Value
+	values  []Value
+	indexes map[MapKey]int
+}
+
+func NewMap() *Map {
+	return &Map{indexes: map[MapKey]int{}}
+}
+
+func (m *Map) Len() int {
+	return len(m.keys)
+}
+
+func (m *Map) Get(key Value) (Value, bool) {
+	index, ok := m.indexes[key.MapKey()]
+	if !ok {
+		return Value{}, false
+	}
+	return m.values[index], true
+}
+
+func (m *Map) Has(key Value) bool {
+	_, ok := m.indexes[key.MapKey()]
+	return ok
+}
+
+// Set replaces the value of an existing key in place, a new key is added
+// after all the others.
+func (m *Map) Set(key Value, value Value) {
+	if index, ok := m.indexes[key.MapKey()]; ok {
+		m.values[index] = value
+		return
+	}
+	m.indexes[key.MapKey()] = len(m.keys)
+	m.keys = append(m.keys, key)
+	m.values = append(m.values, value)
+}
+
+// Delete removes key and reports whether it was present.
+func (m *Map) Delete(key Value) bool {
+	index, ok := m.indexes[key.MapKey()]
+	if !ok {
+		return false
+	}
+	delete(m.indexes, key.MapKey())
+	m.keys = append(m.keys[:index], m.keys[index+1:]...)
+	m.values = append(m.values[:index], m.values[index+1:]...)
+	for i := index; i < len(m.keys); i++ {
+		m.indexes[m.keys[i].MapKey()] = i
+	}
+	return true
+}
+
+// Keys returns a copy of the keys in insertion order.
+func (m *Map) Keys() []Value {
+	keys := make([]Value, len(m.keys))
+	copy(keys, m.keys)
+	return keys
+}
+
+// Values returns a copy of the values in the order of Keys.
+func (m *Map) Values() []Value {
+	values := make([]Value, len(m.values))
+	copy(values, m.values)
+	return values
+}
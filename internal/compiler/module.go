@@ -0,0 +1,135 @@
+package compiler
+
+import (
+	"fmt"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+)
+
+// Module is a compiled file and the declarations it exports. Proto is nil
+// when the file failed to compile, its errors were reported once already.
+type Module struct {
+	Path    string
+	Proto   *ModuleProto
+	imports []*Module
+	exports *moduleExports
+}
+
+// moduleExports maps each exported function and constant to the global slot
+// holding it, and each exported type to what the compiler knows about it.
+type moduleExports struct {
+	values  map[string]int
+	structs map[string]*structInfo
+	enums   map[string]*enumInfo
+}
+
+func newModuleExports() *moduleExports {
+	return &moduleExports{values: map[string]int{}, structs: map[string]*structInfo{}, enums: map[string]*enumInfo{}}
+}
+
+// importModules makes the exports of every module imported at the top level
+// of statements visible before anything is declared, so an imported struct
+// can be used like one declared in the file.
+func (v *InstructionsVisitor) importModules(statements []ast.Statement) {
+	imported := map[*Module]bool{}
+	for _, statement := range statements {
+		n, ok := statement.(*ast.Import)
+		if !ok {
+			continue
+		}
+		module, ok := v.imports[n]
+		if !ok {
+			v.addError(fmt.Sprintf("cannot import %s, imports are only resolved when compiling a file", n.Path), n.Pos())
+			continue
+		}
+		if module.Proto == nil {
+			continue
+		}
+		if imported[module] {
+			v.addWarning(fmt.Sprintf("module %s is imported twice", n.Path), n.Pos())
+			continue
+		}
+		imported[module] = true
+		for name, slot := range module.exports.values {
+			if v.importName(name, module.Path, n.Pos()) {
+				v.globals.Import(name, slot)
+			}
+		}
+		for name, info := range module.exports.structs {
+			if v.importName(name, module.Path, n.Pos()) {
+				v.structs[name] = info
+			}
+		}
+		for name, info := range module.exports.enums {
+			if v.importName(name, module.Path, n.Pos()) {
+				v.enums[name] = info
+			}
+		}
+	}
+}
+
+// importName records that name comes from the module at path, unless another
+// module already exports it to this file.
+func (v *InstructionsVisitor) importName(name string, path string, pos *common.SourcePos) bool {
+	if from, ok := v.importedFrom[name]; ok {
+		v.addError(fmt.Sprintf("%s is imported from both %s and %s", name, from, path), pos)
+		return false
+	}
+	v.importedFrom[name] = path
+	return true
+}
+
+// exportValue copies the function or constant name, held in reg, into a
+// global slot of its own that the modules importing this one read.
+func (v *InstructionsVisitor) exportValue(name string, typeOf *ast.Type, reg int, pos *common.SourcePos) {
+	if _, ok := v.context.(*ModuleContext); !ok {
+		v.addError(fmt.Sprintf("export of %s must be at the top level", name), pos)
+		return
+	}
+	if _, ok := v.exports.values[name]; ok {
+		v.addError(fmt.Sprintf("%s is exported twice", name), pos)
+		return
+	}
+	if !v.checkExportedType(name, typeOf, pos) {
+		return
+	}
+	slot := v.globalTable.DefineVariable(QualifiedName(v.path, name), false, typeOf)
+	v.exports.values[name] = slot
+	v.context.AddInstruction(InstrAssignGlobal(reg, slot), pos)
+}
+
+// checkExportedType reports the types the export name uses that the modules
+// importing it could not name: structs and enums declared in this file
+// without export.
+func (v *InstructionsVisitor) checkExportedType(name string, t *ast.Type, pos *common.SourcePos) bool {
+	if t == nil {
+		return true
+	}
+	if t.Type == ast.TYPE_NAMED {
+		if _, ok := v.importedFrom[t.Name]; ok {
+			return true
+		}
+		_, isStruct := v.structs[t.Name]
+		_, isEnum := v.enums[t.Name]
+		_, structExported := v.exports.structs[t.Name]
+		_, enumExported := v.exports.enums[t.Name]
+		if (isStruct || isEnum) && !structExported && !enumExported {
+			v.addError(fmt.Sprintf("exported %s uses the type %s, which is not exported", name, t.Name), pos)
+			return false
+		}
+		return true
+	}
+	if !v.checkExportedType(name, t.ElementType, pos) || !v.checkExportedType(name, t.KeyType, pos) {
+		return false
+	}
+	if t.Signature != nil {
+		for _, param := range t.Signature.Params {
+			if !v.checkExportedType(name, param, pos) {
+				return false
+			}
+		}
+		return v.checkExportedType(name, t.Signature.ReturnType, pos)
+	}
+	return true
+}
@@ -1,18 +1,25 @@
 package compiler
 
-import "youpiteron.dev/white-monster-on-friday-night/internal/ast"
+import (
+	"maps"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+)
 
 type ModuleContext struct {
 	currentVarSlot int
+	numLocals      int
 	variables      map[string]Variable
 
 	returnType   *ast.Type
 	instructions []Instruction
+	positions    []*common.SourcePos
 	constants    []Value
 }
 
 func NewModuleContext() *ModuleContext {
-	return &ModuleContext{currentVarSlot: 0, variables: make(map[string]Variable), returnType: ast.TypeInt(), instructions: make([]Instruction, 0), constants: make([]Value, 0)}
+	return &ModuleContext{currentVarSlot: 0, variables: make(map[string]Variable), returnType: ast.TypeInt(), instructions: make([]Instruction, 0), positions: make([]*common.SourcePos, 0), constants: make([]Value, 0)}
 }
 
 func CastModuleContext(context Context) *ModuleContext {
@@ -29,15 +36,9 @@ func (c *ModuleContext) ImplementContextInterface() Context {
 
 func (c *ModuleContext) DefineVariable(name string, mutable bool, typeOf *ast.Type) int {
 	slot := c.currentVarSlot
-	c.variables[name] = Variable{Name: name, Slot: slot, Mutable: mutable, TypeOf: typeOf, FuncSignature: nil}
-	c.currentVarSlot++
-	return slot
-}
-
-func (c *ModuleContext) DefineFunctionVariable(name string, mutable bool, typeOf *ast.Type, funcSignature *FuncSignature) int {
-	slot := c.currentVarSlot
-	c.variables[name] = Variable{Name: name, Slot: slot, Mutable: mutable, TypeOf: typeOf, FuncSignature: funcSignature}
+	c.variables[name] = Variable{Name: name, Slot: slot, Mutable: mutable, TypeOf: typeOf}
 	c.currentVarSlot++
+	c.ReserveVarSlot(slot)
 	return slot
 }
 
@@ -61,13 +62,15 @@ func (c *ModuleContext) FindVariable(name string) (*Variable, *Upvar, bool) {
 	return nil, nil, false
 }
 
-func (c *ModuleContext) AddInstruction(instruction Instruction) int {
+func (c *ModuleContext) AddInstruction(instruction Instruction, pos *common.SourcePos) int {
 	c.instructions = append(c.instructions, instruction)
+	c.positions = append(c.positions, pos)
 	return len(c.instructions) - 1
 }
 
 func (c *ModuleContext) ClearInstructions() {
 	c.instructions = make([]Instruction, 0)
+	c.positions = make([]*common.SourcePos, 0)
 }
 
 func (c *ModuleContext) SetInstruction(index int, instruction Instruction) {
@@ -83,12 +86,41 @@ func (c *ModuleContext) AddParam(param *ast.Type) {
 	panic("COMPILER ERROR: cannot add param to module context")
 }
 
+func (c *ModuleContext) ReserveVarSlot(slot int) {
+	if slot >= c.numLocals {
+		c.numLocals = slot + 1
+	}
+}
+
+// moduleScope is the part of a module context a REPL chunk can declare into.
+type moduleScope struct {
+	currentVarSlot int
+	variables      map[string]Variable
+	constants      int
+}
+
+func (c *ModuleContext) markScope() moduleScope {
+	return moduleScope{currentVarSlot: c.currentVarSlot, variables: maps.Clone(c.variables), constants: len(c.constants)}
+}
+
+// restoreScope forgets the variables declared since scope was marked. Their
+// slots are reused by the next declarations, numLocals keeps counting them.
+func (c *ModuleContext) restoreScope(scope moduleScope) {
+	c.currentVarSlot = scope.currentVarSlot
+	c.variables = scope.variables
+	c.constants = c.constants[:scope.constants]
+}
+
 // ---------- Getters ----------
 
 func (c *ModuleContext) VarSlot() int {
 	return c.currentVarSlot
 }
 
+func (c *ModuleContext) NumLocals() int {
+	return c.numLocals
+}
+
 func (c *ModuleContext) InstructionsLength() int {
 	return len(c.instructions)
 }
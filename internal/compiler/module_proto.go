@@ -1,10 +1,17 @@
 package compiler
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+)
 
 type ModuleProto struct {
+	path         string
 	numLocals    int
 	instructions []Instruction
+	positions    []*common.SourcePos
 	constants    []Value
 	functions    []FunctionProto
 }
@@ -35,6 +42,10 @@ func (m *ModuleProto) Instructions() []Instruction {
 	return m.instructions
 }
 
+func (m *ModuleProto) Positions() []*common.SourcePos {
+	return m.positions
+}
+
 func (m *ModuleProto) Constants() []Value {
 	return m.constants
 }
@@ -43,11 +54,29 @@ func (m *ModuleProto) Functions() []FunctionProto {
 	return m.functions
 }
 
-func BuildModuleProto(context ModuleContext, functions []FunctionProto) *ModuleProto {
+func (m *ModuleProto) Path() string {
+	return m.path
+}
+
+func BuildModuleProto(context ModuleContext, functions []FunctionProto, path string) *ModuleProto {
+	// the REPL keeps appending to the visitor's functions, every chunk gets
+	// its own copy
+	return newModuleProto(path, context.numLocals, context.instructions, context.positions, context.constants, slices.Clone(functions))
+}
+
+// newModuleProto links every function to the functions and the path of the
+// module, which it keeps when it is called from another module.
+func newModuleProto(path string, numLocals int, instructions []Instruction, positions []*common.SourcePos, constants []Value, functions []FunctionProto) *ModuleProto {
+	for i := range functions {
+		functions[i].functions = functions
+		functions[i].path = path
+	}
 	return &ModuleProto{
-		numLocals:    context.currentVarSlot,
-		instructions: context.instructions,
-		constants:    context.constants,
+		path:         path,
+		numLocals:    numLocals,
+		instructions: instructions,
+		positions:    positions,
+		constants:    constants,
 		functions:    functions,
 	}
 }
@@ -0,0 +1,320 @@
+package compiler
+
+import (
+	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
+	"youpiteron.dev/white-monster-on-friday-night/internal/lexer"
+)
+
+// narrowing records that the local in slot of the function owner is known not
+// to be null, so reading it gives typeOf instead of its nullable type. typeOf
+// is nil once an assignment may have stored null in the variable again.
+type narrowing struct {
+	owner  Context
+	name   string
+	slot   int
+	typeOf *ast.Type
+}
+
+// functionContext is the function or module context the current block belongs
+// to. Narrowings never cross it: a closure may run after its variables were
+// set to null.
+func (v *InstructionsVisitor) functionContext() Context {
+	context := v.context
+	for {
+		if _, ok := context.(*BlockContext); !ok {
+			return context
+		}
+		context = context.Parent()
+	}
+}
+
+// markNarrowings and restoreNarrowings scope the narrowings made in a branch
+// or a block to it.
+func (v *InstructionsVisitor) markNarrowings() int {
+	return len(v.narrowings)
+}
+
+func (v *InstructionsVisitor) restoreNarrowings(mark int) {
+	v.narrowings = v.narrowings[:mark]
+}
+
+// narrow marks the nullable locals in names as not null until the narrowings
+// are restored. A variable some function assigns is never narrowed, since any
+// call may run that function and store null in it.
+func (v *InstructionsVisitor) narrow(names []string) {
+	for _, name := range names {
+		variable, ok := v.context.FindLocalVariable(name)
+		if !ok || variable.TypeOf.Type != ast.TYPE_NULLABLE || v.closureWrites[name] {
+			continue
+		}
+		v.narrowings = append(v.narrowings, &narrowing{owner: v.functionContext(), name: name, slot: variable.Slot, typeOf: variable.TypeOf.NonNull()})
+	}
+}
+
+// narrowedType returns the type a read of name gives when a null check has
+// narrowed it. A local declared after the check shadows the narrowed one.
+func (v *InstructionsVisitor) narrowedType(name string) (*ast.Type, bool) {
+	variable, ok := v.context.FindLocalVariable(name)
+	if !ok {
+		return nil, false
+	}
+	owner := v.functionContext()
+	for i := len(v.narrowings) - 1; i >= 0; i-- {
+		n := v.narrowings[i]
+		if n.name == name && n.owner == owner && n.slot == variable.Slot {
+			return n.typeOf, n.typeOf != nil
+		}
+	}
+	return nil, false
+}
+
+// forgetNarrowings drops every narrowing of name, including the ones made
+// outside of the current branch, which may now be followed by a null.
+func (v *InstructionsVisitor) forgetNarrowings(name string) {
+	for _, n := range v.narrowings {
+		if n.name == name {
+			n.typeOf = nil
+		}
+	}
+}
+
+// nullChecks collects the variables a condition proves not null when it is
+// true and when it is false: `x != null` proves x when true, `x == null` when
+// false, `&&` and `||` combine the proofs of their operands and `!` swaps them.
+func nullChecks(condition ast.Expression) ([]string, []string) {
+	switch n := condition.(type) {
+	case *ast.BinaryExpr:
+		switch n.Operator {
+		case lexer.OperatorNotEqual:
+			if name, ok := nullCheckedName(n); ok {
+				return []string{name}, nil
+			}
+		case lexer.OperatorEqual:
+			if name, ok := nullCheckedName(n); ok {
+				return nil, []string{name}
+			}
+		case lexer.OperatorAnd:
+			leftTrue, _ := nullChecks(n.Left)
+			rightTrue, _ := nullChecks(n.Right)
+			return append(leftTrue, rightTrue...), nil
+		case lexer.OperatorOr:
+			_, leftFalse := nullChecks(n.Left)
+			_, rightFalse := nullChecks(n.Right)
+			return nil, append(leftFalse, rightFalse...)
+		}
+	case *ast.UnaryExpr:
+		if n.Operator == lexer.OperatorNot {
+			whenTrue, whenFalse := nullChecks(n.Operand)
+			return whenFalse, whenTrue
+		}
+	}
+	return nil, nil
+}
+
+// nullCheckedName returns x for `x == null`, `null == x` and their `!=` forms.
+func nullCheckedName(n *ast.BinaryExpr) (string, bool) {
+	if identifier, ok := n.Left.(*ast.Identifier); ok {
+		if _, ok := n.Right.(*ast.NullLiteral); ok {
+			return identifier.Name, true
+		}
+	}
+	if identifier, ok := n.Right.(*ast.Identifier); ok {
+		if _, ok := n.Left.(*ast.NullLiteral); ok {
+			return identifier.Name, true
+		}
+	}
+	return "", false
+}
+
+// terminates reports whether statements never complete normally, because they
+// end with a return, a break or a continue. `if (x == null) { return; }` then
+// proves x for the rest of the block.
+func terminates(statements []ast.Statement) bool {
+	if len(statements) == 0 {
+		return false
+	}
+	switch n := statements[len(statements)-1].(type) {
+	case *ast.Return, *ast.Break, *ast.Continue:
+		return true
+	case *ast.Block:
+		return terminates(n.Statements)
+	case *ast.If:
+		return terminates(n.Body) && terminates(n.ElseBody)
+	}
+	return false
+}
+
+// assignedNames collects the variables statements assign to. A loop forgets
+// their narrowings before its first iteration, since the next iteration may
+// start with the null assigned by the previous one.
+func assignedNames(statements []ast.Statement, names map[string]bool) {
+	for _, statement := range statements {
+		switch n := statement.(type) {
+		case *ast.Assignment:
+			if identifier, ok := n.Target.(*ast.Identifier); ok {
+				names[identifier.Name] = true
+			}
+		case *ast.Block:
+			assignedNames(n.Statements, names)
+		case *ast.If:
+			assignedNames(n.Body, names)
+			assignedNames(n.ElseBody, names)
+		case *ast.While:
+			assignedNames(n.Body, names)
+		case *ast.For:
+			assignedNames([]ast.Statement{n.Init, n.Step}, names)
+			assignedNames(n.Body, names)
+		}
+	}
+}
+
+// forgetLoopNarrowings is called before a loop compiles its condition.
+func (v *InstructionsVisitor) forgetLoopNarrowings(statements ...[]ast.Statement) {
+	names := map[string]bool{}
+	for _, body := range statements {
+		assignedNames(body, names)
+	}
+	for name := range names {
+		v.forgetNarrowings(name)
+	}
+}
+
+// writeScanner finds the variables functions assign without declaring them,
+// the globals and the locals of enclosing functions. Like assignedNames it
+// goes by name, so a local sharing its name with such a variable is not
+// narrowed either.
+type writeScanner struct {
+	scopes []map[string]bool
+	// base is the first scope of the function being scanned, 0 at the top
+	// level where assignments are not made by a function.
+	base  int
+	names map[string]bool
+}
+
+func newWriteScanner(names map[string]bool) *writeScanner {
+	return &writeScanner{names: names}
+}
+
+func (s *writeScanner) push() {
+	s.scopes = append(s.scopes, map[string]bool{})
+}
+
+func (s *writeScanner) pop() {
+	s.scopes = s.scopes[:len(s.scopes)-1]
+}
+
+func (s *writeScanner) assign(name string) {
+	if s.base == 0 {
+		return
+	}
+	for i := len(s.scopes) - 1; i >= s.base; i-- {
+		if s.scopes[i][name] {
+			return
+		}
+	}
+	s.names[name] = true
+}
+
+func (s *writeScanner) block(statements []ast.Statement) {
+	s.push()
+	for _, statement := range statements {
+		s.statement(statement)
+	}
+	s.pop()
+}
+
+func (s *writeScanner) function(params []ast.Param, body []ast.Statement, exprBody ast.Expression) {
+	base := s.base
+	s.push()
+	s.base = len(s.scopes) - 1
+	for _, param := range params {
+		s.scopes[s.base][param.Name] = true
+	}
+	for _, statement := range body {
+		s.statement(statement)
+	}
+	s.expression(exprBody)
+	s.pop()
+	s.base = base
+}
+
+func (s *writeScanner) statement(statement ast.Statement) {
+	switch n := statement.(type) {
+	case *ast.Declaration:
+		s.expression(n.Value)
+		s.scopes[len(s.scopes)-1][n.Identifier.Name] = true
+	case *ast.Assignment:
+		if identifier, ok := n.Target.(*ast.Identifier); ok {
+			s.assign(identifier.Name)
+		} else {
+			s.expression(n.Target)
+		}
+		s.expression(n.Value)
+	case *ast.Return:
+		s.expression(n.Value)
+	case *ast.Block:
+		s.block(n.Statements)
+	case *ast.If:
+		s.expression(n.Condition)
+		s.block(n.Body)
+		s.block(n.ElseBody)
+	case *ast.While:
+		s.expression(n.Condition)
+		s.block(n.Body)
+	case *ast.For:
+		s.push()
+		s.statement(n.Init)
+		s.expression(n.Condition)
+		s.statement(n.Step)
+		s.block(n.Body)
+		s.pop()
+	case *ast.Function:
+		s.function(n.Params, n.Body, nil)
+	case ast.Expression:
+		s.expression(n)
+	}
+}
+
+func (s *writeScanner) expression(expression ast.Expression) {
+	switch n := expression.(type) {
+	case *ast.FunctionExpr:
+		s.function(n.Params, n.Body, n.ExprBody)
+	case *ast.BinaryExpr:
+		s.expression(n.Left)
+		s.expression(n.Right)
+	case *ast.UnaryExpr:
+		s.expression(n.Operand)
+	case *ast.ConditionalExpr:
+		s.expression(n.Condition)
+		s.expression(n.Then)
+		s.expression(n.Else)
+	case *ast.MatchExpr:
+		s.expression(n.Subject)
+		for _, arm := range n.Arms {
+			s.expression(arm.Body)
+		}
+	case *ast.CallExpr:
+		s.expression(n.Callee)
+		for _, argument := range n.Arguments {
+			s.expression(argument)
+		}
+	case *ast.IndexExpr:
+		s.expression(n.Array)
+		s.expression(n.Index)
+	case *ast.FieldExpr:
+		s.expression(n.Object)
+	case *ast.ArrayLiteral:
+		for _, element := range n.Elements {
+			s.expression(element)
+		}
+	case *ast.MapLiteral:
+		for _, entry := range n.Entries {
+			s.expression(entry.Key)
+			s.expression(entry.Value)
+		}
+	case *ast.StructLiteral:
+		for _, field := range n.Fields {
+			s.expression(field.Value)
+		}
+	}
+}
@@ -1,10 +1,20 @@
 package compiler
 
+import "youpiteron.dev/white-monster-on-friday-night/internal/common"
+
 type Proto interface {
 	ImplementProtoInterface() Proto
 	NumLocals() int
 	Instructions() []Instruction
+	// Positions is the line table: Positions()[ip] is the source position
+	// the instruction at ip was compiled from.
+	Positions() []*common.SourcePos
 	Constants() []Value
+	// Functions are the protos CLOSURE instructions index.
+	Functions() []FunctionProto
+	// Path is the file the proto was compiled from, it is empty when only
+	// one file was compiled.
+	Path() string
 
 	String() string
 }
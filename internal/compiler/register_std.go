@@ -3,24 +3,70 @@ package compiler
 import "youpiteron.dev/white-monster-on-friday-night/internal/ast"
 
 func RegisterStdGlobals(gt *GlobalTable) {
-	gt.DefineFunctionVariable(
+	gt.DefineVariable(
 		"println",
 		false,
-		ast.TypeNativeFunction(),
-		&FuncSignature{
-			CallArgs:   []*ast.Type{ast.TypeArrayOf(ast.TypeInt())},
+		ast.TypeNativeFunctionOf(&ast.Signature{
+			Params:     []*ast.Type{ast.TypeArrayOf(ast.TypeAny())},
 			ReturnType: ast.TypeNull(),
 			Vararg:     true,
-		},
+		}),
 	)
-	gt.DefineFunctionVariable(
+	gt.DefineVariable(
 		"append",
 		false,
-		ast.TypeNativeFunction(),
-		&FuncSignature{
-			CallArgs:   []*ast.Type{ast.TypeArrayOf(ast.TypeInt()), ast.TypeInt()},
-			ReturnType: ast.TypeArrayOf(ast.TypeInt()),
+		ast.TypeNativeFunctionOf(&ast.Signature{
+			Params:     []*ast.Type{ast.TypeArrayOf(ast.TypeParam("T")), ast.TypeParam("T")},
+			ReturnType: ast.TypeArrayOf(ast.TypeParam("T")),
 			Vararg:     false,
-		},
+		}),
+	)
+	gt.DefineVariable(
+		"float",
+		false,
+		ast.TypeNativeFunctionOf(&ast.Signature{
+			Params:     []*ast.Type{ast.TypeInt()},
+			ReturnType: ast.TypeFloat(),
+			Vararg:     false,
+		}),
+	)
+	gt.DefineVariable(
+		"int",
+		false,
+		ast.TypeNativeFunctionOf(&ast.Signature{
+			Params:     []*ast.Type{ast.TypeFloat()},
+			ReturnType: ast.TypeInt(),
+			Vararg:     false,
+		}),
+	)
+
+	// K and V are bound to the key and value types of the map argument
+	mapOfKV := ast.TypeMapOf(ast.TypeParam("K"), ast.TypeParam("V"))
+	gt.DefineVariable(
+		"has",
+		false,
+		ast.TypeNativeFunctionOf(&ast.Signature{
+			Params:     []*ast.Type{mapOfKV, ast.TypeParam("K")},
+			ReturnType: ast.TypeBool(),
+			Vararg:     false,
+		}),
+	)
+	gt.DefineVariable(
+		"delete",
+		false,
+		ast.TypeNativeFunctionOf(&ast.Signature{
+			Params:     []*ast.Type{mapOfKV, ast.TypeParam("K")},
+			ReturnType: ast.TypeNull(),
+			Vararg:     false,
+		}),
+	)
+	gt.DefineVariable(
+		"keys",
+		false,
+		ast.TypeNativeFunctionOf(&ast.Signature{
+			Params:     []*ast.Type{mapOfKV},
+			ReturnType: ast.TypeArrayOf(ast.TypeParam("K")),
+			Vararg:     false,
+		}),
 	)
 }
@@ -0,0 +1,183 @@
+package compiler
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+	"youpiteron.dev/white-monster-on-friday-night/internal/lexer"
+)
+
+// Resolver compiles a file together with the files it imports. Every module
+// is compiled once and cached by its path, however many files import it, and
+// all of them define their exports in one global table.
+type Resolver struct {
+	searchPath  []string
+	readFile    func(path string) ([]byte, error)
+	globalTable *GlobalTable
+	modules     map[string]*Module
+	loading     []string
+	errors      []common.Error
+	warnings    []common.Error
+}
+
+// NewResolver returns a resolver that reads files with readFile. An import is
+// looked up next to the importing file first, then in each directory of
+// searchPath in order.
+func NewResolver(searchPath []string, readFile func(path string) ([]byte, error)) *Resolver {
+	globalTable := NewGlobalTable()
+	RegisterStdGlobals(globalTable)
+	return &Resolver{searchPath: searchPath, readFile: readFile, globalTable: globalTable, modules: map[string]*Module{}}
+}
+
+// CompileFile compiles the file at path and every module it imports. Imports
+// lists the imported modules in the order they have to run, each one after
+// the modules it imports.
+func (r *Resolver) CompileFile(path string) (*CompileResult, []common.Error) {
+	r.errors = []common.Error{}
+	r.warnings = []common.Error{}
+	source, err := r.readFile(path)
+	if err != nil {
+		return nil, []common.Error{{Message: fmt.Sprintf("cannot read %s: %v", path, err), File: path}}
+	}
+	module := r.compileModule(filepath.Clean(path), source)
+	if len(r.errors) > 0 {
+		return nil, r.errors
+	}
+	imports := []ModuleProto{}
+	importPaths := []string{}
+	for _, imported := range runOrder(module) {
+		imports = append(imports, *imported.Proto)
+		importPaths = append(importPaths, imported.Path)
+	}
+	return &CompileResult{
+		ModuleProto: *module.Proto,
+		GlobalTable: r.globalTable,
+		Warnings:    r.warnings,
+		Imports:     imports,
+		ImportPaths: importPaths,
+	}, nil
+}
+
+// compileModule compiles the modules source imports, then source itself.
+func (r *Resolver) compileModule(path string, source []byte) *Module {
+	module := &Module{Path: path}
+	r.modules[path] = module
+
+	lexerResult := lexer.NewLexer().Lex(string(source))
+	if len(lexerResult.Errors) > 0 {
+		r.addErrors(path, lexerResult.Errors)
+		return module
+	}
+	parser := ast.NewParser(lexerResult.Tokens)
+	program := parser.ParseProgram()
+	if len(parser.Errors) > 0 {
+		r.addErrors(path, parser.Errors)
+		return module
+	}
+
+	r.loading = append(r.loading, path)
+	imports := map[*ast.Import]*Module{}
+	failed := false
+	for _, statement := range program.Statements {
+		n, ok := statement.(*ast.Import)
+		if !ok {
+			continue
+		}
+		imported, ok := r.resolveImport(path, n)
+		if !ok || imported.Proto == nil {
+			failed = true
+			continue
+		}
+		imports[n] = imported
+		module.imports = append(module.imports, imported)
+	}
+	r.loading = r.loading[:len(r.loading)-1]
+	if failed {
+		return module
+	}
+
+	visitor := newModuleVisitor(r.globalTable, path, imports)
+	visitor.EnterModuleContext()
+	program.Visit(visitor)
+	visitor.ExitModuleContext()
+	r.addErrors(path, visitor.errors)
+	for _, warning := range visitor.warnings {
+		warning.File = path
+		r.warnings = append(r.warnings, warning)
+	}
+	if len(visitor.errors) == 0 {
+		module.Proto = &visitor.moduleProtos[len(visitor.moduleProtos)-1]
+		module.exports = visitor.exports
+	}
+	return module
+}
+
+// resolveImport finds the module n names, compiling it when it is not cached
+// yet. An import of a module that is still being compiled is a cycle.
+func (r *Resolver) resolveImport(from string, n *ast.Import) (*Module, bool) {
+	for _, candidate := range r.candidates(from, n.Path) {
+		if slices.Contains(r.loading, candidate) {
+			cycle := slices.Concat(r.loading[slices.Index(r.loading, candidate):], []string{candidate})
+			r.addError(from, fmt.Sprintf("import cycle: %s", strings.Join(cycle, " -> ")), n.Pos())
+			return nil, false
+		}
+		if module, ok := r.modules[candidate]; ok {
+			return module, true
+		}
+		source, err := r.readFile(candidate)
+		if err != nil {
+			continue
+		}
+		return r.compileModule(candidate, source), true
+	}
+	r.addError(from, fmt.Sprintf("module %s not found", n.Path), n.Pos())
+	return nil, false
+}
+
+// candidates lists the paths an import of path from the file at from may
+// refer to, in the order they are tried.
+func (r *Resolver) candidates(from string, path string) []string {
+	if filepath.IsAbs(path) {
+		return []string{filepath.Clean(path)}
+	}
+	candidates := []string{filepath.Join(filepath.Dir(from), path)}
+	for _, dir := range r.searchPath {
+		candidates = append(candidates, filepath.Join(dir, path))
+	}
+	return candidates
+}
+
+func (r *Resolver) addError(path string, message string, pos *common.SourcePos) {
+	r.errors = append(r.errors, common.Error{Message: message, Pos: pos, File: path})
+}
+
+func (r *Resolver) addErrors(path string, errors []common.Error) {
+	for _, err := range errors {
+		err.File = path
+		r.errors = append(r.errors, err)
+	}
+}
+
+// runOrder lists the modules module imports, directly or not, each one after
+// the modules it imports itself.
+func runOrder(module *Module) []*Module {
+	order := []*Module{}
+	visited := map[*Module]bool{}
+	var visit func(m *Module)
+	visit = func(m *Module) {
+		for _, imported := range m.imports {
+			if visited[imported] {
+				continue
+			}
+			visited[imported] = true
+			visit(imported)
+			order = append(order, imported)
+		}
+	}
+	visit(module)
+	return order
+}
@@ -0,0 +1,15 @@
+package compiler
+
+// StructDesc describes the layout of a struct declared in the script, the
+// fields are in declaration order.
+type StructDesc struct {
+	Name   string
+	Fields []string
+}
+
+// Struct values are shared like arrays and maps, every value holding the same
+// struct sees the changes made to its fields.
+type Struct struct {
+	Desc   *StructDesc
+	Fields []Value
+}
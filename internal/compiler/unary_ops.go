@@ -0,0 +1,33 @@
+package compiler
+
+import (
+	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
+	"youpiteron.dev/white-monster-on-friday-night/internal/lexer"
+)
+
+type UnaryOpInfo struct {
+	ResultType *ast.Type
+	OpCode     OpCode
+}
+
+var UnaryOpTable = map[lexer.OperatorSubkind]map[ast.TypeEnum]UnaryOpInfo{
+	lexer.OperatorMinus: {
+		ast.TYPE_INT:   {ResultType: ast.TypeInt(), OpCode: NEG_INT},
+		ast.TYPE_FLOAT: {ResultType: ast.TypeFloat(), OpCode: NEG_FLOAT},
+	},
+	lexer.OperatorNot: {
+		ast.TYPE_BOOL: {ResultType: ast.TypeBool(), OpCode: NOT_BOOL},
+	},
+}
+
+func ResolveUnaryOp(
+	op lexer.OperatorSubkind,
+	operand *ast.Type,
+) (UnaryOpInfo, bool) {
+	opMap, ok := UnaryOpTable[op]
+	if !ok {
+		return UnaryOpInfo{}, false
+	}
+	info, ok := opMap[operand.Type]
+	return info, ok
+}
@@ -16,6 +16,11 @@ const (
 	VAL_NULL
 	VAL_NATIVE_FUNCTION
 	VAL_ARRAY
+	VAL_STRING
+	VAL_FLOAT
+	VAL_MAP
+	VAL_STRUCT
+	VAL_VARIANT
 )
 
 func (t ValueType) String() string {
@@ -26,26 +31,44 @@ func (t ValueType) String() string {
 		"NULL",
 		"NATIVE_FUNCTION",
 		"ARRAY",
+		"STRING",
+		"FLOAT",
+		"MAP",
+		"STRUCT",
+		"VARIANT",
 	}[t]
 }
 
 type Value struct {
 	TypeOf  ValueType
 	Int     int
+	Float   float64
 	Bool    bool
 	Closure Closure
 	Native  NativeFunction
 	Array   []Value
+	String  string
+	Map     *Map
+	Struct  *Struct
+	Variant *Variant
 }
 
 func NewIntValue(value int) Value {
 	return Value{TypeOf: VAL_INT, Int: value}
 }
 
+func NewFloatValue(value float64) Value {
+	return Value{TypeOf: VAL_FLOAT, Float: value}
+}
+
 func NewBoolValue(value bool) Value {
 	return Value{TypeOf: VAL_BOOL, Bool: value}
 }
 
+func NewStringValue(value string) Value {
+	return Value{TypeOf: VAL_STRING, String: value}
+}
+
 func NewNullValue() Value {
 	return Value{TypeOf: VAL_NULL}
 }
@@ -62,14 +85,91 @@ func NewArrayValue(elements []Value) Value {
 	return Value{TypeOf: VAL_ARRAY, Array: elements}
 }
 
+func NewMapValue(m *Map) Value {
+	return Value{TypeOf: VAL_MAP, Map: m}
+}
+
+func NewStructValue(s *Struct) Value {
+	return Value{TypeOf: VAL_STRUCT, Struct: s}
+}
+
+func NewVariantValue(variant *Variant) Value {
+	return Value{TypeOf: VAL_VARIANT, Variant: variant}
+}
+
+// Copy returns a deep copy of v, writing into the arrays, maps, structs and
+// enum values of the copy never changes v. Values shared inside v are shared
+// inside the copy too, so a value that contains itself is copied once.
+func (v Value) Copy() Value {
+	return v.copySeen(map[any]Value{})
+}
+
+func (v Value) copySeen(seen map[any]Value) Value {
+	switch v.TypeOf {
+	case VAL_ARRAY:
+		if len(v.Array) == 0 {
+			return NewArrayValue([]Value{})
+		}
+		if copied, ok := seen[&v.Array[0]]; ok {
+			return copied
+		}
+		elements := make([]Value, len(v.Array))
+		copied := NewArrayValue(elements)
+		seen[&v.Array[0]] = copied
+		for i, element := range v.Array {
+			elements[i] = element.copySeen(seen)
+		}
+		return copied
+	case VAL_MAP:
+		if copied, ok := seen[v.Map]; ok {
+			return copied
+		}
+		m := NewMap()
+		copied := NewMapValue(m)
+		seen[v.Map] = copied
+		for _, key := range v.Map.Keys() {
+			value, _ := v.Map.Get(key)
+			m.Set(key, value.copySeen(seen))
+		}
+		return copied
+	case VAL_STRUCT:
+		if copied, ok := seen[v.Struct]; ok {
+			return copied
+		}
+		s := &Struct{Desc: v.Struct.Desc, Fields: make([]Value, len(v.Struct.Fields))}
+		copied := NewStructValue(s)
+		seen[v.Struct] = copied
+		for i, field := range v.Struct.Fields {
+			s.Fields[i] = field.copySeen(seen)
+		}
+		return copied
+	case VAL_VARIANT:
+		if copied, ok := seen[v.Variant]; ok {
+			return copied
+		}
+		variant := &Variant{Desc: v.Variant.Desc, Tag: v.Variant.Tag, Payload: make([]Value, len(v.Variant.Payload))}
+		copied := NewVariantValue(variant)
+		seen[v.Variant] = copied
+		for i, field := range v.Variant.Payload {
+			variant.Payload[i] = field.copySeen(seen)
+		}
+		return copied
+	}
+	return v
+}
+
 func DefaultValue(typeOf *ast.Type) Value {
 	switch typeOf.Type {
 	case ast.TYPE_INT:
 		return NewIntValue(0)
+	case ast.TYPE_FLOAT:
+		return NewFloatValue(0)
 	case ast.TYPE_BOOL:
 		return NewBoolValue(false)
-	case ast.TYPE_NULL:
+	case ast.TYPE_NULL, ast.TYPE_NULLABLE:
 		return NewNullValue()
+	case ast.TYPE_STRING:
+		return NewStringValue("")
 	case ast.TYPE_ARRAY:
 		return NewArrayValue([]Value{})
 	}
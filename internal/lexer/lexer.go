@@ -2,6 +2,8 @@ package lexer
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"youpiteron.dev/white-monster-on-friday-night/internal/common"
 )
@@ -15,10 +17,17 @@ const (
 	StateIdentifier
 	StateNumber
 	StateOperator
+	StateString
+	StateLineComment
+	StateBlockComment
 )
 
+// LexResult holds the tokens of the input and, in Trivia, every comment and
+// blank line in source order. Doc comments are also kept on the token after
+// them.
 type LexResult struct {
 	Tokens []Token
+	Trivia []Trivia
 	Errors []common.Error
 }
 
@@ -41,7 +50,11 @@ func (l *Lexer) Lex(input string) LexResult {
 	l.reset(input)
 
 	var tokens []Token
+	var trivia []Trivia
 	var errors []common.Error
+	// newlines counts the line breaks since the last token or comment, the
+	// second one ends a blank line
+	newlines := 0
 
 	for !l.eof() {
 		ch := l.peek()
@@ -51,9 +64,29 @@ func (l *Lexer) Lex(input string) LexResult {
 		case StateInitial:
 			// whitespace
 			if isWs(ch) {
+				if ch == '\n' {
+					newlines++
+					if newlines == 2 {
+						pos := l.posSpan(1)
+						trivia = append(trivia, Trivia{Kind: TriviaBlankLine, Pos: &pos})
+					}
+				}
 				l.next()
 				continue
 			}
+			newlines = 0
+
+			// comments
+			if ch == '/' && (l.peekNext() == '/' || l.peekNext() == '*') {
+				l.state = StateLineComment
+				if l.peekNext() == '*' {
+					l.state = StateBlockComment
+				}
+				l.startPos = l.capturePos()
+				l.buf = l.nextString()
+				l.buf += l.nextString()
+				continue
+			}
 
 			// punctuator
 			if subkind, ok := punctuatorSubkind(ch); ok {
@@ -77,6 +110,14 @@ func (l *Lexer) Lex(input string) LexResult {
 				continue
 			}
 
+			// string
+			if ch == '"' {
+				l.state = StateString
+				l.startPos = l.capturePos()
+				l.buf = l.nextString()
+				continue
+			}
+
 			// number
 			if isDigit(ch) {
 				l.state = StateNumber
@@ -104,7 +145,7 @@ func (l *Lexer) Lex(input string) LexResult {
 
 		case StateIdentifier:
 			if !l.eof() && isIdentContinue(l.peek()) {
-				l.buf += string(l.next())
+				l.buf += l.nextString()
 				continue
 			}
 
@@ -121,8 +162,8 @@ func (l *Lexer) Lex(input string) LexResult {
 			continue
 
 		case StateNumber:
-			if !l.eof() && isDigit(l.peek()) {
-				l.buf += string(l.next())
+			if !l.eof() && isNumberContinue(l.buf, l.peek(), l.peekNext()) {
+				l.buf += l.nextString()
 				continue
 			}
 
@@ -137,9 +178,68 @@ func (l *Lexer) Lex(input string) LexResult {
 			l.startPos = nil
 			continue
 
+		case StateString:
+			if ch == '\n' {
+				pos := l.finishPos(*l.startPos, len(l.buf))
+				errors = append(errors, common.Error{
+					Message: "unterminated string literal",
+					Pos:     &pos,
+				})
+				l.state = StateInitial
+				l.buf = ""
+				l.startPos = nil
+				continue
+			}
+
+			l.buf += l.nextString()
+			if ch == '\\' && !l.eof() {
+				l.buf += l.nextString()
+				continue
+			}
+			if ch != '"' {
+				continue
+			}
+
+			if tok, err := l.flushString(); tok != nil {
+				tokens = append(tokens, *tok)
+			} else if err != nil {
+				errors = append(errors, *err)
+			}
+
+			l.state = StateInitial
+			l.buf = ""
+			l.startPos = nil
+			continue
+
+		case StateLineComment:
+			if ch != '\n' {
+				l.buf += l.nextString()
+				continue
+			}
+
+			trivia = append(trivia, l.flushComment(tokens))
+
+			l.state = StateInitial
+			l.buf = ""
+			l.startPos = nil
+			continue
+
+		case StateBlockComment:
+			l.buf += l.nextString()
+			if len(l.buf) < 4 || !strings.HasSuffix(l.buf, "*/") {
+				continue
+			}
+
+			trivia = append(trivia, l.flushComment(tokens))
+
+			l.state = StateInitial
+			l.buf = ""
+			l.startPos = nil
+			continue
+
 		case StateOperator:
-			if !l.eof() && isOperatorContinue(l.peek()) {
-				l.buf += string(l.next())
+			if !l.eof() && isOperatorContinue(l.buf, l.peek()) {
+				l.buf += l.nextString()
 				continue
 			}
 			if tok, err := l.flushOperator(); tok != nil {
@@ -169,10 +269,49 @@ func (l *Lexer) Lex(input string) LexResult {
 			} else if err != nil {
 				errors = append(errors, *err)
 			}
+		case StateOperator:
+			if tok, err := l.flushOperator(); tok != nil {
+				tokens = append(tokens, *tok)
+			} else if err != nil {
+				errors = append(errors, *err)
+			}
+		case StateString:
+			pos := l.finishPos(*l.startPos, len(l.buf))
+			errors = append(errors, common.Error{
+				Message: "unterminated string literal",
+				Pos:     &pos,
+			})
+		case StateLineComment:
+			trivia = append(trivia, l.flushComment(tokens))
+		case StateBlockComment:
+			pos := l.finishPos(*l.startPos, len(l.buf))
+			errors = append(errors, common.Error{
+				Message: "unterminated block comment",
+				Pos:     &pos,
+			})
 		}
 	}
 
-	return LexResult{Tokens: tokens, Errors: errors}
+	attachTrivia(tokens, trivia)
+	return LexResult{Tokens: tokens, Trivia: trivia, Errors: errors}
+}
+
+// attachTrivia hands every doc comment to the first token after it. A doc
+// comment after the last token has nothing to describe and is dropped.
+func attachTrivia(tokens []Token, trivia []Trivia) {
+	i := 0
+	for _, t := range trivia {
+		if t.Kind != TriviaDocComment {
+			continue
+		}
+		for i < len(tokens) && tokens[i].Pos.Offset < t.Pos.Offset {
+			i++
+		}
+		if i == len(tokens) {
+			return
+		}
+		tokens[i].Trivia = append(tokens[i].Trivia, t)
+	}
 }
 
 // ---------- Flushers ----------
@@ -186,51 +325,11 @@ func (l *Lexer) flushIdentifier() (*Token, *common.Error) {
 	pos := l.finishPos(*l.startPos, len(lex))
 
 	// keywords
-	if lex == "return" {
-		return &Token{
-			Lexeme:  lex,
-			Kind:    Keyword,
-			Subkind: KeywordReturn,
-			Pos:     &pos,
-		}, nil
-	}
-	if lex == "const" {
+	if subkind, ok := keywordSubkind(lex); ok {
 		return &Token{
 			Lexeme:  lex,
 			Kind:    Keyword,
-			Subkind: KeywordConst,
-			Pos:     &pos,
-		}, nil
-	}
-	if lex == "var" {
-		return &Token{
-			Lexeme:  lex,
-			Kind:    Keyword,
-			Subkind: KeywordVar,
-			Pos:     &pos,
-		}, nil
-	}
-	if lex == "function" {
-		return &Token{
-			Lexeme:  lex,
-			Kind:    Keyword,
-			Subkind: KeywordFunction,
-			Pos:     &pos,
-		}, nil
-	}
-	if lex == "if" {
-		return &Token{
-			Lexeme:  lex,
-			Kind:    Keyword,
-			Subkind: KeywordIf,
-			Pos:     &pos,
-		}, nil
-	}
-	if lex == "else" {
-		return &Token{
-			Lexeme:  lex,
-			Kind:    Keyword,
-			Subkind: KeywordElse,
+			Subkind: subkind,
 			Pos:     &pos,
 		}, nil
 	}
@@ -316,6 +415,18 @@ func (l *Lexer) flushNumber() (*Token, *common.Error) {
 	lex := l.buf
 	pos := l.finishPos(*l.startPos, len(lex))
 
+	if strings.ContainsAny(lex, ".eE") {
+		if _, err := strconv.ParseFloat(lex, 64); err != nil {
+			return nil, &common.Error{Message: fmt.Sprintf("invalid float literal: %s", lex), Pos: &pos}
+		}
+		return &Token{
+			Lexeme:  lex,
+			Kind:    Constant,
+			Subkind: Float,
+			Pos:     &pos,
+		}, nil
+	}
+
 	return &Token{
 		Lexeme:  lex,
 		Kind:    Constant,
@@ -324,6 +435,47 @@ func (l *Lexer) flushNumber() (*Token, *common.Error) {
 	}, nil
 }
 
+func (l *Lexer) flushString() (*Token, *common.Error) {
+	if l.startPos == nil {
+		return nil, nil
+	}
+
+	lex := l.buf
+	pos := l.finishPos(*l.startPos, len(lex))
+
+	if _, err := Unquote(lex); err != nil {
+		return nil, &common.Error{Message: err.Error(), Pos: &pos}
+	}
+	return &Token{
+		Lexeme:  lex,
+		Kind:    Constant,
+		Subkind: String,
+		Pos:     &pos,
+	}, nil
+}
+
+// flushComment returns the trivia for the comment in the buffer, tokens are
+// the tokens lexed before it.
+func (l *Lexer) flushComment(tokens []Token) Trivia {
+	lex := strings.TrimRight(l.buf, "\r")
+	pos := l.finishPos(*l.startPos, len(lex))
+
+	kind := TriviaLineComment
+	if strings.HasPrefix(lex, "///") {
+		kind = TriviaDocComment
+	} else if strings.HasPrefix(lex, "/*") {
+		kind = TriviaBlockComment
+	}
+	trailing := len(tokens) > 0 && tokens[len(tokens)-1].Pos.Line == pos.Line
+
+	return Trivia{
+		Kind:     kind,
+		Text:     lex,
+		Pos:      &pos,
+		Trailing: trailing,
+	}
+}
+
 // ---------- Helpers ----------
 
 func isWs(ch byte) bool {
@@ -348,18 +500,43 @@ func isIdentContinue(ch byte) bool {
 	return isLetter(ch) || isDigit(ch)
 }
 
+// isNumberContinue reports whether ch extends the number literal in buf. A
+// '.' is only part of the number when a digit follows it, and an exponent
+// may carry a sign: 1.5, 1e-3 and 2.5E+10 are all single literals.
+func isNumberContinue(buf string, ch byte, next byte) bool {
+	if isDigit(ch) {
+		return true
+	}
+	hasExponent := strings.ContainsAny(buf, "eE")
+	switch ch {
+	case '.':
+		return !hasExponent && !strings.Contains(buf, ".") && isDigit(next)
+	case 'e', 'E':
+		return !hasExponent && (isDigit(next) || next == '+' || next == '-')
+	case '+', '-':
+		last := buf[len(buf)-1]
+		return last == 'e' || last == 'E'
+	}
+	return false
+}
+
 func isOperatorStart(ch byte) bool {
-	return ch == '+' || ch == '-' || ch == '*' || ch == '/' || ch == '=' || ch == '!' || ch == '>' || ch == '<' || ch == '&' || ch == '|' || ch == '.'
+	return ch == '+' || ch == '-' || ch == '*' || ch == '/' || ch == '=' || ch == '!' || ch == '>' || ch == '<' || ch == '&' || ch == '|' || ch == '.' || ch == '?'
 }
 
-func isOperatorContinue(ch byte) bool {
-	return ch == '=' || ch == '&' || ch == '|' || ch == '.'
+// isOperatorContinue reports whether ch extends the operator in buf into a
+// longer operator (or a prefix of one), so the lexer always takes the longest
+// match: "+" followed by "+" is "++", but "=" followed by "-" is "=" and "-".
+func isOperatorContinue(buf string, ch byte) bool {
+	lex := buf + string(ch)
+	if _, ok := operatorSubkind(lex); ok {
+		return true
+	}
+	return lex == ".."
 }
 
 func punctuatorSubkind(ch byte) (PunctuatorSubkind, bool) {
 	switch ch {
-	case '=':
-		return Assign, true
 	case '{':
 		return BlockStart, true
 	case '}':
@@ -410,11 +587,71 @@ func operatorSubkind(lex string) (OperatorSubkind, bool) {
 		return OperatorOr, true
 	case "...":
 		return OperatorRest, true
+	case "!":
+		return OperatorNot, true
+	case "++":
+		return OperatorIncrement, true
+	case "--":
+		return OperatorDecrement, true
+	case "?":
+		return OperatorQuestion, true
+	case "->":
+		return OperatorArrow, true
+	case "=>":
+		return OperatorFatArrow, true
+	case "+=":
+		return OperatorPlusAssign, true
+	case "-=":
+		return OperatorMinusAssign, true
+	case "*=":
+		return OperatorStarAssign, true
+	case "/=":
+		return OperatorSlashAssign, true
+	case ".":
+		return OperatorDot, true
+	case "??":
+		return OperatorNullCoalesce, true
 	default:
 		return 0, false
 	}
 }
 
+func keywordSubkind(lex string) (KeywordSubkind, bool) {
+	switch lex {
+	case "const":
+		return KeywordConst, true
+	case "var":
+		return KeywordVar, true
+	case "return":
+		return KeywordReturn, true
+	case "function":
+		return KeywordFunction, true
+	case "if":
+		return KeywordIf, true
+	case "else":
+		return KeywordElse, true
+	case "while":
+		return KeywordWhile, true
+	case "for":
+		return KeywordFor, true
+	case "break":
+		return KeywordBreak, true
+	case "continue":
+		return KeywordContinue, true
+	case "struct":
+		return KeywordStruct, true
+	case "enum":
+		return KeywordEnum, true
+	case "match":
+		return KeywordMatch, true
+	case "import":
+		return KeywordImport, true
+	case "export":
+		return KeywordExport, true
+	}
+	return 0, false
+}
+
 func typeSubkind(lex string) (TypeSubkind, bool) {
 	switch lex {
 	case "int":
@@ -423,6 +660,10 @@ func typeSubkind(lex string) (TypeSubkind, bool) {
 		return TypeBool, true
 	case "null":
 		return TypeNull, true
+	case "string":
+		return TypeString, true
+	case "float":
+		return TypeFloat, true
 	}
 	return 0, false
 }
@@ -437,6 +678,13 @@ func (l *Lexer) peek() byte {
 	return l.input[l.idx]
 }
 
+func (l *Lexer) peekNext() byte {
+	if l.idx+1 >= len(l.input) {
+		return 0
+	}
+	return l.input[l.idx+1]
+}
+
 func (l *Lexer) next() byte {
 	ch := l.input[l.idx]
 	l.idx++
@@ -451,6 +699,14 @@ func (l *Lexer) next() byte {
 	return ch
 }
 
+// nextString consumes one byte and returns it as a one-byte string, so the
+// bytes of a multi-byte UTF-8 sequence are appended to the buffer unchanged.
+func (l *Lexer) nextString() string {
+	start := l.idx
+	l.next()
+	return l.input[start:l.idx]
+}
+
 // ---------- Position helpers ----------
 
 func (l *Lexer) posSpan(length int) common.SourcePos {
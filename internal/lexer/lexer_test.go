@@ -0,0 +1,239 @@
+package lexer
+
+import (
+	"testing"
+)
+
+func lexSubkinds(t *testing.T, input string) []any {
+	t.Helper()
+
+	result := NewLexer().Lex(input)
+	if len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	subkinds := make([]any, len(result.Tokens))
+	for i, token := range result.Tokens {
+		subkinds[i] = token.Subkind
+	}
+	return subkinds
+}
+
+func expectSubkinds(t *testing.T, input string, expected ...any) {
+	t.Helper()
+
+	got := lexSubkinds(t, input)
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d tokens for %q, got %d: %v", len(expected), input, len(got), got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("token %d of %q: expected %v, got %v", i, input, expected[i], got[i])
+		}
+	}
+}
+
+// ---------- Operator Tests ----------
+
+func TestLex_OperatorsTakeLongestMatch(t *testing.T) {
+	expectSubkinds(t, "a == b", IdentifierName, OperatorEqual, IdentifierName)
+	expectSubkinds(t, "a != b", IdentifierName, OperatorNotEqual, IdentifierName)
+	expectSubkinds(t, "i++", IdentifierName, OperatorIncrement)
+	expectSubkinds(t, "--i", OperatorDecrement, IdentifierName)
+	expectSubkinds(t, "x...", IdentifierName, OperatorRest)
+	expectSubkinds(t, "x => x", IdentifierName, OperatorFatArrow, IdentifierName)
+	expectSubkinds(t, "() -> int", ParenOpen, ParenClose, OperatorArrow, TypeInt)
+}
+
+func TestLex_OperatorsSplitWhenLongerMatchIsInvalid(t *testing.T) {
+	expectSubkinds(t, "a=-1", IdentifierName, Assign, OperatorMinus, Integer)
+	expectSubkinds(t, "!!b", OperatorNot, OperatorNot, IdentifierName)
+	expectSubkinds(t, "a<-b", IdentifierName, OperatorLess, OperatorMinus, IdentifierName)
+	expectSubkinds(t, "a?-1:b", IdentifierName, OperatorQuestion, OperatorMinus, Integer, Colon, IdentifierName)
+}
+
+// ---------- Keyword Tests ----------
+
+func TestLex_LoopKeywords(t *testing.T) {
+	expectSubkinds(t, "while for break continue", KeywordWhile, KeywordFor, KeywordBreak, KeywordContinue)
+}
+
+// ---------- String Tests ----------
+
+func TestLex_StringLiteral(t *testing.T) {
+	result := NewLexer().Lex(`var s: string = "a \"b\" c";`)
+	if len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	if len(result.Tokens) != 7 {
+		t.Fatalf("expected 7 tokens, got %d", len(result.Tokens))
+	}
+	if result.Tokens[3].Subkind != TypeString {
+		t.Errorf("expected string type, got %v", result.Tokens[3].Subkind)
+	}
+	str := result.Tokens[5]
+	if str.Kind != Constant || str.Subkind != String {
+		t.Fatalf("expected string constant, got %v %v", str.Kind, str.Subkind)
+	}
+	if str.Lexeme != `"a \"b\" c"` {
+		t.Errorf("expected lexeme to keep the source text, got %s", str.Lexeme)
+	}
+	if str.Pos.Column != 17 || str.Pos.Length != 11 {
+		t.Errorf("expected string at column 17 with length 11, got %d and %d", str.Pos.Column, str.Pos.Length)
+	}
+}
+
+func TestLex_StringLiteralErrors(t *testing.T) {
+	inputs := []string{
+		`"unterminated`,
+		"\"line\nbreak\"",
+		`"bad \q escape"`,
+		`"bad \u{110000} code point"`,
+		`"bad \u41 escape"`,
+	}
+	for _, input := range inputs {
+		if result := NewLexer().Lex(input); len(result.Errors) == 0 {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestLex_NonASCIIStringAndComment(t *testing.T) {
+	result := NewLexer().Lex("\"héllo 🐟\" // café")
+	if len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	if len(result.Tokens) != 1 || result.Tokens[0].Lexeme != "\"héllo 🐟\"" {
+		t.Fatalf("expected the lexeme to keep its UTF-8 bytes, got %v", result.Tokens)
+	}
+	if value, err := Unquote(result.Tokens[0].Lexeme); err != nil || value != "héllo 🐟" {
+		t.Errorf("expected héllo 🐟, got %q (%v)", value, err)
+	}
+	if len(result.Trivia) != 1 || result.Trivia[0].Text != "// café" {
+		t.Errorf("expected the comment to keep its UTF-8 bytes, got %v", result.Trivia)
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	cases := map[string]string{
+		`""`:                "",
+		`"tab\there"`:       "tab\there",
+		`"line\n"`:          "line\n",
+		`"\"quoted\""`:      `"quoted"`,
+		`"back\\slash"`:     `back\slash`,
+		`"\u{48}\u{1F47E}"`: "H\U0001F47E",
+	}
+	for input, expected := range cases {
+		got, err := Unquote(input)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", input, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("Unquote(%s): expected %q, got %q", input, expected, got)
+		}
+	}
+}
+
+// ---------- Number Tests ----------
+
+func TestLex_FloatLiterals(t *testing.T) {
+	expectSubkinds(t, "1.5 1e-3 2.5E+10 42", Float, Float, Float, Integer)
+	expectSubkinds(t, "var x: float", KeywordVar, IdentifierName, Colon, TypeFloat)
+	expectSubkinds(t, "xs...", IdentifierName, OperatorRest)
+}
+
+func TestLex_InvalidFloatLiteral(t *testing.T) {
+	if result := NewLexer().Lex("1e+;"); len(result.Errors) == 0 {
+		t.Error("expected error for exponent without digits")
+	}
+}
+
+// ---------- Comment Tests ----------
+
+func TestLex_CommentsAreSkipped(t *testing.T) {
+	expectSubkinds(t, "a // line comment\nb", IdentifierName, IdentifierName)
+	expectSubkinds(t, "a /* block\n comment */ / b", IdentifierName, OperatorSlash, IdentifierName)
+	expectSubkinds(t, "a /**/ b /*/ c */", IdentifierName, IdentifierName)
+	expectSubkinds(t, "a // comment at end", IdentifierName)
+}
+
+func TestLex_UnterminatedBlockComment(t *testing.T) {
+	if result := NewLexer().Lex("a /* never closed"); len(result.Errors) != 1 {
+		t.Errorf("expected 1 error, got %d", len(result.Errors))
+	}
+}
+
+func TestLex_DocCommentsAttachToNextToken(t *testing.T) {
+	result := NewLexer().Lex("/// first\n// not kept\n/// second\nvar a;")
+	if len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	trivia := result.Tokens[0].Trivia
+	if len(trivia) != 2 {
+		t.Fatalf("expected 2 doc comments on 'var', got %d", len(trivia))
+	}
+	if trivia[0].Text != "/// first" || trivia[1].Text != "/// second" {
+		t.Errorf("unexpected doc comments: %q, %q", trivia[0].Text, trivia[1].Text)
+	}
+	if trivia[1].Pos.Line != 3 {
+		t.Errorf("expected second doc comment on line 3, got %d", trivia[1].Pos.Line)
+	}
+}
+
+func TestLex_KeepsCommentsAndBlankLines(t *testing.T) {
+	result := NewLexer().Lex("// a\n\n\n/// b\nx; /* c */\ny; // d")
+	if len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	expected := []Trivia{
+		{Kind: TriviaLineComment, Text: "// a"},
+		{Kind: TriviaBlankLine},
+		{Kind: TriviaDocComment, Text: "/// b"},
+		{Kind: TriviaBlockComment, Text: "/* c */", Trailing: true},
+		{Kind: TriviaLineComment, Text: "// d", Trailing: true},
+	}
+	if len(result.Trivia) != len(expected) {
+		t.Fatalf("expected %d trivia, got %d: %v", len(expected), len(result.Trivia), result.Trivia)
+	}
+	for i, want := range expected {
+		got := result.Trivia[i]
+		if got.Kind != want.Kind || got.Text != want.Text || got.Trailing != want.Trailing {
+			t.Errorf("trivia %d: expected %v %q (trailing %v), got %v %q (trailing %v)", i, want.Kind, want.Text, want.Trailing, got.Kind, got.Text, got.Trailing)
+		}
+	}
+	if len(result.Tokens[0].Trivia) != 1 {
+		t.Errorf("expected only the doc comment on 'x', got %v", result.Tokens[0].Trivia)
+	}
+}
+
+// ---------- Assignment Operator Tests ----------
+
+func TestLex_CompoundAssignOperators(t *testing.T) {
+	expectSubkinds(t, "a += 1", IdentifierName, OperatorPlusAssign, Integer)
+	expectSubkinds(t, "a -= b *= c /= d", IdentifierName, OperatorMinusAssign, IdentifierName, OperatorStarAssign, IdentifierName, OperatorSlashAssign, IdentifierName)
+	expectSubkinds(t, "a = b == c", IdentifierName, Assign, IdentifierName, OperatorEqual, IdentifierName)
+	expectSubkinds(t, "a /= b // comment", IdentifierName, OperatorSlashAssign, IdentifierName)
+}
+
+// ---------- Struct Tests ----------
+
+func TestLex_StructAndFieldAccess(t *testing.T) {
+	expectSubkinds(t, "struct P { x: int }", KeywordStruct, IdentifierName, BlockStart, IdentifierName, Colon, TypeInt, BlockEnd)
+	expectSubkinds(t, "p.x.y", IdentifierName, OperatorDot, IdentifierName, OperatorDot, IdentifierName)
+	expectSubkinds(t, "xs... 1.5 a.b", IdentifierName, OperatorRest, Float, IdentifierName, OperatorDot, IdentifierName)
+}
+
+func TestLex_EnumAndMatch(t *testing.T) {
+	expectSubkinds(t, "enum S { A(r: int) }", KeywordEnum, IdentifierName, BlockStart, IdentifierName, ParenOpen, IdentifierName, Colon, TypeInt, ParenClose, BlockEnd)
+	expectSubkinds(t, "match (s) { A(r) => r, _ => 0 }", KeywordMatch, ParenOpen, IdentifierName, ParenClose, BlockStart, IdentifierName, ParenOpen, IdentifierName, ParenClose, OperatorFatArrow, IdentifierName, Comma, IdentifierName, OperatorFatArrow, Integer, BlockEnd)
+}
+
+func TestLex_NullableAndCoalesce(t *testing.T) {
+	expectSubkinds(t, "var x: int? = y ?? 1;", KeywordVar, IdentifierName, Colon, TypeInt, OperatorQuestion, Assign, IdentifierName, OperatorNullCoalesce, Integer, StatementEnd)
+	expectSubkinds(t, "a ? b : c", IdentifierName, OperatorQuestion, IdentifierName, Colon, IdentifierName)
+}
+
+func TestLex_ImportAndExport(t *testing.T) {
+	expectSubkinds(t, `import "lib/math.wmofn";`, KeywordImport, String, StatementEnd)
+	expectSubkinds(t, "export const exported = 1;", KeywordExport, KeywordConst, IdentifierName, Assign, Integer, StatementEnd)
+}
@@ -37,6 +37,15 @@ const (
 	KeywordFunction
 	KeywordIf
 	KeywordElse
+	KeywordWhile
+	KeywordFor
+	KeywordBreak
+	KeywordContinue
+	KeywordStruct
+	KeywordEnum
+	KeywordMatch
+	KeywordImport
+	KeywordExport
 )
 
 func (k KeywordSubkind) String() string {
@@ -47,6 +56,15 @@ func (k KeywordSubkind) String() string {
 		"function",
 		"if",
 		"else",
+		"while",
+		"for",
+		"break",
+		"continue",
+		"struct",
+		"enum",
+		"match",
+		"import",
+		"export",
 	}[k]
 }
 
@@ -98,6 +116,8 @@ const (
 	Integer ConstantSubkind = iota
 	Boolean
 	Null
+	String
+	Float
 )
 
 func (k ConstantSubkind) String() string {
@@ -105,6 +125,8 @@ func (k ConstantSubkind) String() string {
 		"numeric",
 		"boolean",
 		"null",
+		"string",
+		"float",
 	}[k]
 }
 
@@ -124,6 +146,18 @@ const (
 	OperatorAnd
 	OperatorOr
 	OperatorRest
+	OperatorNot
+	OperatorIncrement
+	OperatorDecrement
+	OperatorQuestion
+	OperatorArrow
+	OperatorFatArrow
+	OperatorPlusAssign
+	OperatorMinusAssign
+	OperatorStarAssign
+	OperatorSlashAssign
+	OperatorDot
+	OperatorNullCoalesce
 )
 
 func (k OperatorSubkind) String() string {
@@ -141,6 +175,18 @@ func (k OperatorSubkind) String() string {
 		"&&",
 		"||",
 		"...",
+		"!",
+		"++",
+		"--",
+		"?",
+		"->",
+		"=>",
+		"+=",
+		"-=",
+		"*=",
+		"/=",
+		".",
+		"??",
 	}[k]
 }
 
@@ -150,6 +196,8 @@ const (
 	TypeInt TypeSubkind = iota
 	TypeBool
 	TypeNull
+	TypeString
+	TypeFloat
 )
 
 func (k TypeSubkind) String() string {
@@ -157,9 +205,42 @@ func (k TypeSubkind) String() string {
 		"int",
 		"bool",
 		"null",
+		"string",
+		"float",
 	}[k]
 }
 
+// ---- Trivia ----
+
+type TriviaKind int
+
+const (
+	TriviaDocComment TriviaKind = iota
+	TriviaLineComment
+	TriviaBlockComment
+	TriviaBlankLine
+)
+
+func (k TriviaKind) String() string {
+	return [...]string{
+		"doc_comment",
+		"line_comment",
+		"block_comment",
+		"blank_line",
+	}[k]
+}
+
+// Trivia is source text that is not part of the grammar: comments and blank
+// lines. A run of blank lines is a single TriviaBlankLine with no text.
+// Trailing is set when the trivia starts on the line of the token before it,
+// as the comment in `x = 1; // one` does.
+type Trivia struct {
+	Kind     TriviaKind
+	Text     string
+	Pos      *common.SourcePos
+	Trailing bool
+}
+
 // ---- Token ----
 
 type Token struct {
@@ -167,6 +248,7 @@ type Token struct {
 	Kind    TokenKind
 	Subkind any
 	Pos     *common.SourcePos
+	Trivia  []Trivia
 }
 
 func (t Token) String() string {
@@ -0,0 +1,91 @@
+package lexer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Unquote returns the value of a double-quoted string literal lexeme with
+// its escape sequences (\n, \t, \", \\ and \u{...}) resolved.
+func Unquote(lex string) (string, error) {
+	if len(lex) < 2 || lex[0] != '"' || lex[len(lex)-1] != '"' {
+		return "", fmt.Errorf("invalid string literal: %s", lex)
+	}
+	body := lex[1 : len(lex)-1]
+
+	var sb strings.Builder
+	for i := 0; i < len(body); i++ {
+		ch := body[i]
+		if ch != '\\' {
+			sb.WriteByte(ch)
+			continue
+		}
+
+		i++
+		if i >= len(body) {
+			return "", fmt.Errorf("unterminated escape sequence in string literal")
+		}
+		switch body[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case '"':
+			sb.WriteByte('"')
+		case '\\':
+			sb.WriteByte('\\')
+		case 'u':
+			r, length, err := unquoteUnicode(body[i+1:])
+			if err != nil {
+				return "", err
+			}
+			sb.WriteRune(r)
+			i += length
+		default:
+			return "", fmt.Errorf("invalid escape sequence '\\%c' in string literal", body[i])
+		}
+	}
+	return sb.String(), nil
+}
+
+// unquoteUnicode parses the "{hex}" part of a \u{...} escape and returns the
+// rune along with the number of bytes consumed.
+func unquoteUnicode(s string) (rune, int, error) {
+	end := strings.IndexByte(s, '}')
+	if len(s) == 0 || s[0] != '{' || end < 2 || end > 7 {
+		return 0, 0, fmt.Errorf("invalid unicode escape in string literal, expected \\u{XXXX}")
+	}
+	code, err := strconv.ParseUint(s[1:end], 16, 32)
+	if err != nil || !utf8.ValidRune(rune(code)) {
+		return 0, 0, fmt.Errorf("invalid unicode code point '%s' in string literal", s[1:end])
+	}
+	return rune(code), end + 1, nil
+}
+
+// Quote returns the double-quoted string literal whose value is s, the
+// inverse of Unquote. Control characters other than \n and \t are written as
+// \u{...} escapes, other bytes, including UTF-8 sequences, are kept as is.
+func Quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '\n':
+			sb.WriteString(`\n`)
+		case ch == '\t':
+			sb.WriteString(`\t`)
+		case ch == '"':
+			sb.WriteString(`\"`)
+		case ch == '\\':
+			sb.WriteString(`\\`)
+		case ch < 0x20 || ch == 0x7f:
+			fmt.Fprintf(&sb, `\u{%x}`, ch)
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -6,8 +6,9 @@ import (
 )
 
 func Append(vm api.VM, args ...compiler.Value) (compiler.Value, error) {
-	array := args[0].Array
-	element := args[1]
-	array = append(array, element)
+	// copy, so that writing into the result never changes the argument
+	array := make([]compiler.Value, len(args[0].Array), len(args[0].Array)+1)
+	copy(array, args[0].Array)
+	array = append(array, args[1])
 	return compiler.Value{TypeOf: compiler.VAL_ARRAY, Array: array}, nil
 }
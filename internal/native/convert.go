@@ -0,0 +1,23 @@
+package native
+
+import (
+	"fmt"
+	"math"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/api"
+	"youpiteron.dev/white-monster-on-friday-night/internal/compiler"
+)
+
+func Float(vm api.VM, args ...compiler.Value) (compiler.Value, error) {
+	return compiler.NewFloatValue(float64(args[0].Int)), nil
+}
+
+// Int truncates its argument towards zero. A float that is not finite or out
+// of the range of int is an error, go would convert it to an arbitrary int.
+func Int(vm api.VM, args ...compiler.Value) (compiler.Value, error) {
+	f := args[0].Float
+	if math.IsNaN(f) || f < math.MinInt64 || f >= -math.MinInt64 {
+		return compiler.Value{}, fmt.Errorf("cannot convert %s to int", Format(args[0]))
+	}
+	return compiler.NewIntValue(int(f)), nil
+}
@@ -0,0 +1,21 @@
+package native
+
+import (
+	"youpiteron.dev/white-monster-on-friday-night/internal/api"
+	"youpiteron.dev/white-monster-on-friday-night/internal/compiler"
+)
+
+func Has(vm api.VM, args ...compiler.Value) (compiler.Value, error) {
+	return compiler.NewBoolValue(args[0].Map.Has(args[1])), nil
+}
+
+// Delete removes the key from the map, deleting a missing key does nothing.
+func Delete(vm api.VM, args ...compiler.Value) (compiler.Value, error) {
+	args[0].Map.Delete(args[1])
+	return compiler.NewNullValue(), nil
+}
+
+// Keys returns the keys of the map in the order they were first added.
+func Keys(vm api.VM, args ...compiler.Value) (compiler.Value, error) {
+	return compiler.NewArrayValue(args[0].Map.Keys()), nil
+}
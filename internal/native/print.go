@@ -2,6 +2,8 @@ package native
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"youpiteron.dev/white-monster-on-friday-night/internal/api"
 	"youpiteron.dev/white-monster-on-friday-night/internal/compiler"
@@ -10,20 +12,144 @@ import (
 func Println(vm api.VM, args ...compiler.Value) (compiler.Value, error) {
 	values := make([]any, 0, len(args))
 	for _, val := range args[0].Array {
-		switch val.TypeOf {
-		case compiler.VAL_INT:
-			values = append(values, val.Int)
-		case compiler.VAL_BOOL:
-			values = append(values, val.Bool)
-		case compiler.VAL_NULL:
-			values = append(values, nil)
-		case compiler.VAL_CLOSURE:
-			values = append(values, val.Closure.Proto.String())
-		case compiler.VAL_NATIVE_FUNCTION:
-			values = append(values, val.Native)
-		}
+		values = append(values, printable(val))
 	}
 
 	fmt.Println(values...)
 	return compiler.NewNullValue(), nil
 }
+
+// Format returns the text println prints for a value.
+func Format(val compiler.Value) string {
+	return fmt.Sprint(printable(val))
+}
+
+// printableMap prints like a go map, but keeps the insertion order of the
+// entries instead of sorting them.
+type printableMap struct {
+	keys   []any
+	values []any
+}
+
+func (m printableMap) String() string {
+	entries := make([]string, len(m.keys))
+	for i := range m.keys {
+		entries[i] = fmt.Sprintf("%v:%v", m.keys[i], m.values[i])
+	}
+	return "map[" + strings.Join(entries, " ") + "]"
+}
+
+// printableStruct prints as `Point{x:1 y:2}`.
+type printableStruct struct {
+	name   string
+	fields []string
+	values []any
+}
+
+func (s printableStruct) String() string {
+	fields := make([]string, len(s.fields))
+	for i := range s.fields {
+		fields[i] = fmt.Sprintf("%s:%v", s.fields[i], s.values[i])
+	}
+	return s.name + "{" + strings.Join(fields, " ") + "}"
+}
+
+// printableVariant prints as `Shape.Circle(3)`, or `Shape.Empty` without a
+// payload.
+type printableVariant struct {
+	name   string
+	values []any
+}
+
+func (v printableVariant) String() string {
+	if len(v.values) == 0 {
+		return v.name
+	}
+	values := make([]string, len(v.values))
+	for i, value := range v.values {
+		values[i] = fmt.Sprintf("%v", value)
+	}
+	return v.name + "(" + strings.Join(values, ", ") + ")"
+}
+
+// printableFloat prints like a go float, with a fractional part when it is a
+// whole number, so that `2.0` does not print like the int `2`.
+type printableFloat float64
+
+func (f printableFloat) String() string {
+	text := strconv.FormatFloat(float64(f), 'g', -1, 64)
+	if strings.ContainsAny(text, ".eIN") {
+		return text
+	}
+	return text + ".0"
+}
+
+func printable(val compiler.Value) any {
+	return printableSeen(val, map[any]bool{})
+}
+
+// printableSeen converts val for printing. seen holds the structs, maps and
+// variants being printed around val, one that contains itself prints as
+// `Node{...}`, `map[...]` or `Shape.Circle(...)` the second time.
+func printableSeen(val compiler.Value, seen map[any]bool) any {
+	switch val.TypeOf {
+	case compiler.VAL_INT:
+		return val.Int
+	case compiler.VAL_FLOAT:
+		return printableFloat(val.Float)
+	case compiler.VAL_BOOL:
+		return val.Bool
+	case compiler.VAL_STRING:
+		return val.String
+	case compiler.VAL_NULL:
+		return nil
+	case compiler.VAL_CLOSURE:
+		return val.Closure.Proto.String()
+	case compiler.VAL_NATIVE_FUNCTION:
+		return val.Native
+	case compiler.VAL_ARRAY:
+		elements := make([]any, 0, len(val.Array))
+		for _, element := range val.Array {
+			elements = append(elements, printableSeen(element, seen))
+		}
+		return elements
+	case compiler.VAL_MAP:
+		if seen[val.Map] {
+			return "map[...]"
+		}
+		seen[val.Map] = true
+		m := printableMap{}
+		for _, key := range val.Map.Keys() {
+			value, _ := val.Map.Get(key)
+			m.keys = append(m.keys, printableSeen(key, seen))
+			m.values = append(m.values, printableSeen(value, seen))
+		}
+		delete(seen, val.Map)
+		return m
+	case compiler.VAL_STRUCT:
+		if seen[val.Struct] {
+			return val.Struct.Desc.Name + "{...}"
+		}
+		seen[val.Struct] = true
+		s := printableStruct{name: val.Struct.Desc.Name, fields: val.Struct.Desc.Fields}
+		for _, field := range val.Struct.Fields {
+			s.values = append(s.values, printableSeen(field, seen))
+		}
+		delete(seen, val.Struct)
+		return s
+	case compiler.VAL_VARIANT:
+		desc := val.Variant.Desc
+		name := desc.Name + "." + desc.Variants[val.Variant.Tag].Name
+		if seen[val.Variant] {
+			return name + "(...)"
+		}
+		seen[val.Variant] = true
+		v := printableVariant{name: name}
+		for _, field := range val.Variant.Payload {
+			v.values = append(v.values, printableSeen(field, seen))
+		}
+		delete(seen, val.Variant)
+		return v
+	}
+	return nil
+}
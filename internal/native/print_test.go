@@ -0,0 +1,61 @@
+package native
+
+import (
+	"math"
+	"testing"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/compiler"
+)
+
+// ---------- Format Tests ----------
+
+/*
+*
+Test printing `struct Node { value: int, next: Node? }` linked to itself.
+
+- The struct prints once, its second occurrence as `Node{...}`
+- A struct shared by two elements without a cycle prints in full both times
+*/
+func TestFormat_SelfReferencingStruct(t *testing.T) {
+	desc := &compiler.StructDesc{Name: "Node", Fields: []string{"value", "next"}}
+	node := &compiler.Struct{Desc: desc, Fields: []compiler.Value{{TypeOf: compiler.VAL_INT, Int: 1}, compiler.NewNullValue()}}
+	value := compiler.Value{TypeOf: compiler.VAL_STRUCT, Struct: node}
+	node.Fields[1] = value
+
+	if got := Format(value); got != "Node{value:1 next:Node{...}}" {
+		t.Errorf("unexpected output %q", got)
+	}
+
+	leaf := &compiler.Struct{Desc: desc, Fields: []compiler.Value{{TypeOf: compiler.VAL_INT, Int: 2}, compiler.NewNullValue()}}
+	shared := compiler.Value{TypeOf: compiler.VAL_STRUCT, Struct: leaf}
+	array := compiler.Value{TypeOf: compiler.VAL_ARRAY, Array: []compiler.Value{shared, shared}}
+	if got := Format(array); got != "[Node{value:2 next:<nil>} Node{value:2 next:<nil>}]" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestFormat_FloatKeepsFraction(t *testing.T) {
+	cases := map[float64]string{2: "2.0", -3: "-3.0", 2.5: "2.5", 1e300: "1e+300"}
+
+	for f, expected := range cases {
+		if got := Format(compiler.NewFloatValue(f)); got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	}
+	if got := Format(compiler.NewIntValue(2)); got != "2" {
+		t.Errorf("expected the int 2 to print as 2, got %q", got)
+	}
+}
+
+// ---------- Convert Tests ----------
+
+func TestInt_RejectsFloatsOutOfRange(t *testing.T) {
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), 1e300, -1e300, 9223372036854775808} {
+		if _, err := Int(nil, compiler.NewFloatValue(f)); err == nil {
+			t.Errorf("expected int(%v) to fail", f)
+		}
+	}
+	if got, err := Int(nil, compiler.NewFloatValue(-2.7)); err != nil || got.Int != -2 {
+		t.Errorf("expected -2, got %d (%v)", got.Int, err)
+	}
+}
@@ -3,23 +3,33 @@ package vm
 import "youpiteron.dev/white-monster-on-friday-night/internal/compiler"
 
 type Frame struct {
+	proto     compiler.Proto
 	constants []compiler.Value
 	upvalues  []*compiler.UpvalueCell
-	locals    []compiler.Value
+	locals    []*compiler.Value
 	registers []compiler.Value
 	ip        int
 	retval    *compiler.Value
 }
 
 func NewFrame(proto compiler.Proto, upvalues []*compiler.UpvalueCell) *Frame {
-	return &Frame{constants: proto.Constants(), upvalues: upvalues, locals: make([]compiler.Value, proto.NumLocals()), registers: make([]compiler.Value, 0), ip: 0, retval: nil}
+	return &Frame{proto: proto, constants: proto.Constants(), upvalues: upvalues, locals: make([]*compiler.Value, proto.NumLocals()), registers: make([]compiler.Value, 0), ip: 0, retval: nil}
 }
 
+// GetLocal returns the cell holding the local in slot. Closures capture this
+// pointer, so it stays valid until CloseLocals detaches it.
 func (f *Frame) GetLocal(slot int) *compiler.Value {
-	return &f.locals[slot]
+	f.growLocals(slot)
+	if f.locals[slot] == nil {
+		f.locals[slot] = &compiler.Value{TypeOf: compiler.VAL_NULL}
+	}
+	return f.locals[slot]
 }
 
+// GetRegister returns the register in slot, growing the registers like
+// SetRegister so that a register that was never written reads as a zero Value.
 func (f *Frame) GetRegister(slot int) *compiler.Value {
+	f.growRegisters(slot)
 	return &f.registers[slot]
 }
 
@@ -31,29 +41,52 @@ func (f *Frame) GetUpvar(slot int) *compiler.Value {
 }
 
 func (f *Frame) SetLocal(slot int, value compiler.Value) {
+	*f.GetLocal(slot) = value
+}
+
+// CloseLocals moves the locals in slots [from, to) into fresh cells holding
+// the same values. Closures that captured the old cells keep seeing them,
+// while code running afterwards works on the new ones.
+func (f *Frame) CloseLocals(from int, to int) {
+	for slot := from; slot < to && slot < len(f.locals); slot++ {
+		if f.locals[slot] == nil {
+			continue
+		}
+		value := *f.locals[slot]
+		f.locals[slot] = &value
+	}
+}
+
+func (f *Frame) growLocals(slot int) {
 	if slot >= len(f.locals) {
-		newLocals := make([]compiler.Value, (slot+1)*2)
+		newLocals := make([]*compiler.Value, (slot+1)*2)
 		copy(newLocals, f.locals)
 		f.locals = newLocals
 	}
-	f.locals[slot] = value
 }
 
 func (f *Frame) SetRegister(slot int, value compiler.Value) {
+	f.growRegisters(slot)
+	f.registers[slot] = value
+}
+
+func (f *Frame) growRegisters(slot int) {
 	if slot >= len(f.registers) {
 		newRegisters := make([]compiler.Value, (slot+1)*2)
 		copy(newRegisters, f.registers)
 		f.registers = newRegisters
 	}
-	f.registers[slot] = value
 }
 
 func (f *Frame) SetUpvar(slot int, value compiler.Value) {
 	*f.upvalues[slot].Ptr = value
 }
 
-func (f *Frame) SetConstants(constants []compiler.Value) {
-	f.constants = constants
+// SetProto swaps the code the frame runs, keeping its locals. The REPL uses
+// it to run each chunk in the same module frame.
+func (f *Frame) SetProto(proto compiler.Proto) {
+	f.proto = proto
+	f.constants = proto.Constants()
 }
 
 func (f *Frame) GetConstant(index int) compiler.Value {
@@ -68,6 +101,20 @@ func (f *Frame) SetIp(ip int) {
 	f.ip = ip
 }
 
+// StackFrame describes where the frame currently is in the source. For a
+// caller frame the ip still points at the CALL instruction.
+func (f *Frame) StackFrame() StackFrame {
+	name := moduleFrameName
+	if functionProto, ok := f.proto.(*compiler.FunctionProto); ok {
+		name = functionProto.Name()
+	}
+	positions := f.proto.Positions()
+	if f.ip < 0 || f.ip >= len(positions) {
+		return StackFrame{Function: name, Module: f.proto.Path(), Pos: nil}
+	}
+	return StackFrame{Function: name, Module: f.proto.Path(), Pos: positions[f.ip]}
+}
+
 func (f *Frame) SetRetval(retval *compiler.Value) {
 	f.retval = retval
 }
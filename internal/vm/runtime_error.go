@@ -0,0 +1,109 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+	"youpiteron.dev/white-monster-on-friday-night/internal/compiler"
+)
+
+const moduleFrameName = "<module>"
+
+// StackFrame is a frame of a stack trace. Module is the path of the file the
+// function was compiled from, empty when only one file was compiled.
+type StackFrame struct {
+	Function string
+	Module   string
+	Pos      *common.SourcePos
+}
+
+func (s StackFrame) String() string {
+	location := s.location()
+	if location == "" {
+		return s.Function
+	}
+	return fmt.Sprintf("%s (%s)", s.Function, location)
+}
+
+// location is `module:line:column`, without the parts that are unknown.
+func (s StackFrame) location() string {
+	location := s.Module
+	if s.Pos != nil {
+		if location != "" {
+			location += ":"
+		}
+		location += fmt.Sprintf("%d:%d", s.Pos.Line, s.Pos.Column)
+	}
+	return location
+}
+
+// RuntimeError is returned by RunModuleProto when the script fails. The stack
+// trace starts at the frame that failed and ends at the module frame.
+type RuntimeError struct {
+	Message     string
+	Instruction compiler.Instruction
+	Pos         *common.SourcePos
+	StackTrace  []StackFrame
+}
+
+func (e *RuntimeError) Error() string {
+	var builder strings.Builder
+	builder.WriteString("runtime error: ")
+	builder.WriteString(e.Message)
+	if e.Pos != nil {
+		failed := StackFrame{Pos: e.Pos}
+		if len(e.StackTrace) > 0 {
+			failed.Module = e.StackTrace[0].Module
+		}
+		builder.WriteString(" at ")
+		builder.WriteString(failed.location())
+	}
+	lines := traceLines(e.StackTrace)
+	if len(lines) > 2*traceEdge {
+		omitted := fmt.Sprintf("  ... %d more frames", len(lines)-2*traceEdge)
+		lines = append(append(lines[:traceEdge:traceEdge], omitted), lines[len(lines)-traceEdge:]...)
+	}
+	for _, line := range lines {
+		builder.WriteString("\n")
+		builder.WriteString(line)
+	}
+	return builder.String()
+}
+
+// traceEdge is how many lines of a long stack trace Error keeps at each end.
+const traceEdge = 10
+
+// traceLines prints one line per frame, a run of identical frames, as left by
+// a function calling itself, is printed once with the number of repeats.
+func traceLines(stackTrace []StackFrame) []string {
+	lines := []string{}
+	for i := 0; i < len(stackTrace); {
+		frame := stackTrace[i].String()
+		run := 1
+		for i+run < len(stackTrace) && stackTrace[i+run].String() == frame {
+			run++
+		}
+		lines = append(lines, "  at "+frame)
+		if run > 1 {
+			lines = append(lines, fmt.Sprintf("  ... repeated %d more times", run-1))
+		}
+		i += run
+	}
+	return lines
+}
+
+// newRuntimeError captures the stack at the failing instruction. It has to be
+// called before any frame is unwound.
+func (v *VM) newRuntimeError(message string, instruction compiler.Instruction) *RuntimeError {
+	stackTrace := make([]StackFrame, 0, len(v.frames))
+	for i := len(v.frames) - 1; i >= 0; i-- {
+		stackTrace = append(stackTrace, v.frames[i].StackFrame())
+	}
+	return &RuntimeError{
+		Message:     message,
+		Instruction: instruction,
+		Pos:         stackTrace[0].Pos,
+		StackTrace:  stackTrace,
+	}
+}
@@ -1,39 +1,62 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 
 	"youpiteron.dev/white-monster-on-friday-night/internal/compiler"
 	"youpiteron.dev/white-monster-on-friday-night/internal/native"
 )
 
+// maxFrames bounds the call depth, so unbounded recursion in a script is
+// reported as a runtime error instead of exhausting the Go stack.
+const maxFrames = 10000
+
 type VM struct {
-	frames         []Frame
-	moduleInstance *ModuleInstance
-	globals        []compiler.Value
+	frames  []*Frame
+	globals []compiler.Value
 }
 
 func (v *VM) ImplementVMInterface() {}
 
 func NewVM(gt *compiler.GlobalTable) *VM {
-	vm := &VM{frames: make([]Frame, 0), moduleInstance: nil, globals: make([]compiler.Value, gt.Length())}
+	vm := &VM{frames: make([]*Frame, 0), globals: make([]compiler.Value, gt.Length())}
 	vm.initStdlibValues(gt)
 	return vm
 }
 
-func (v *VM) RunModuleProto(moduleProto *compiler.ModuleProto) int {
-	v.moduleInstance = NewModuleInstance(moduleProto)
+// RunModuleProto runs the module and returns its exit value. On a runtime
+// error every function frame is unwound, so the VM can keep running chunks
+// in the same module frame afterwards.
+func (v *VM) RunModuleProto(moduleProto *compiler.ModuleProto) (int, *RuntimeError) {
 	if len(v.frames) == 0 {
 		frame := NewFrame(moduleProto, make([]*compiler.UpvalueCell, 0))
-		v.frames = append(v.frames, *frame)
+		v.frames = append(v.frames, frame)
 	} else {
-		v.currentFrame().SetConstants(moduleProto.Constants())
+		v.currentFrame().SetProto(moduleProto)
+	}
+	retval, err := v.runInstructions(moduleProto.Instructions())
+	if err != nil {
+		v.frames = v.frames[:1]
+		v.currentFrame().SetRetval(nil)
+		v.currentFrame().SetIp(0)
+		return 0, err
 	}
-	retval := v.runInstructions(moduleProto.Instructions())
 	if retval == nil {
-		return 0
+		return 0, nil
 	}
-	return retval.Int
+	return retval.Int, nil
+}
+
+// RunImport runs the top level of an imported module in a frame of its own,
+// which sets the globals the module exports. A module runs after the modules
+// it imports and before the ones importing it.
+func (v *VM) RunImport(moduleProto *compiler.ModuleProto) *RuntimeError {
+	depth := len(v.frames)
+	v.frames = append(v.frames, NewFrame(moduleProto, make([]*compiler.UpvalueCell, 0)))
+	_, err := v.runInstructions(moduleProto.Instructions())
+	v.frames = v.frames[:depth]
+	return err
 }
 
 func (v *VM) initStdlibValues(gt *compiler.GlobalTable) {
@@ -51,19 +74,55 @@ func (v *VM) initStdlibValues(gt *compiler.GlobalTable) {
 			Native: native.Append,
 		}
 	}
+	// float
+	if variable, ok := gt.FindVariable("float"); ok {
+		v.globals[variable.Slot] = compiler.Value{
+			TypeOf: compiler.VAL_NATIVE_FUNCTION,
+			Native: native.Float,
+		}
+	}
+	// int
+	if variable, ok := gt.FindVariable("int"); ok {
+		v.globals[variable.Slot] = compiler.Value{
+			TypeOf: compiler.VAL_NATIVE_FUNCTION,
+			Native: native.Int,
+		}
+	}
+	// has
+	if variable, ok := gt.FindVariable("has"); ok {
+		v.globals[variable.Slot] = compiler.Value{
+			TypeOf: compiler.VAL_NATIVE_FUNCTION,
+			Native: native.Has,
+		}
+	}
+	// delete
+	if variable, ok := gt.FindVariable("delete"); ok {
+		v.globals[variable.Slot] = compiler.Value{
+			TypeOf: compiler.VAL_NATIVE_FUNCTION,
+			Native: native.Delete,
+		}
+	}
+	// keys
+	if variable, ok := gt.FindVariable("keys"); ok {
+		v.globals[variable.Slot] = compiler.Value{
+			TypeOf: compiler.VAL_NATIVE_FUNCTION,
+			Native: native.Keys,
+		}
+	}
 }
 
 func (v *VM) currentFrame() *Frame {
-	return &v.frames[len(v.frames)-1]
+	return v.frames[len(v.frames)-1]
 }
 
-func (v *VM) runInstructions(instructions []compiler.Instruction) *compiler.Value {
+func (v *VM) runInstructions(instructions []compiler.Instruction) (*compiler.Value, *RuntimeError) {
 	for {
 		frame := v.currentFrame()
 		if frame.ip >= len(instructions) || frame.retval != nil {
 			break
 		}
 		instruction := instructions[frame.ip]
+		var err error
 		switch instruction.OpCode {
 		case compiler.LOAD_CONST:
 			v.opLoadConst(instruction.Args)
@@ -86,7 +145,7 @@ func (v *VM) runInstructions(instructions []compiler.Instruction) *compiler.Valu
 		case compiler.MUL_INT:
 			v.opMulInt(instruction.Args)
 		case compiler.DIV_INT:
-			v.opDivInt(instruction.Args)
+			err = v.opDivInt(instruction.Args)
 		case compiler.EQ_INT:
 			v.opEqInt(instruction.Args)
 		case compiler.EQ_BOOL:
@@ -110,7 +169,7 @@ func (v *VM) runInstructions(instructions []compiler.Instruction) *compiler.Valu
 		case compiler.CLOSURE:
 			v.opClosure(instruction.Args)
 		case compiler.CALL:
-			v.opCall(instruction.Args)
+			err = v.opCall(instruction.Args)
 		case compiler.RETURN:
 			v.opReturn(instruction.Args)
 		case compiler.JUMP_IF_FALSE:
@@ -120,7 +179,77 @@ func (v *VM) runInstructions(instructions []compiler.Instruction) *compiler.Valu
 		case compiler.MAKE_ARRAY:
 			v.opMakeArray(instruction.Args)
 		case compiler.INDEX_ARRAY:
-			v.opIndexArray(instruction.Args)
+			err = v.opIndexArray(instruction.Args)
+		case compiler.CLOSE_VARS:
+			v.opCloseVars(instruction.Args)
+		case compiler.NEG_INT:
+			v.opNegInt(instruction.Args)
+		case compiler.NOT_BOOL:
+			v.opNotBool(instruction.Args)
+		case compiler.MOVE:
+			v.opMove(instruction.Args)
+		case compiler.JUMP_IF_TRUE:
+			v.opJumpIfTrue(instruction.Args)
+		case compiler.CONCAT_STRING:
+			v.opConcatString(instruction.Args)
+		case compiler.EQ_STRING:
+			v.opEqString(instruction.Args)
+		case compiler.NE_STRING:
+			v.opNeString(instruction.Args)
+		case compiler.LT_STRING:
+			v.opLtString(instruction.Args)
+		case compiler.ADD_FLOAT:
+			v.opAddFloat(instruction.Args)
+		case compiler.SUB_FLOAT:
+			v.opSubFloat(instruction.Args)
+		case compiler.MUL_FLOAT:
+			v.opMulFloat(instruction.Args)
+		case compiler.DIV_FLOAT:
+			v.opDivFloat(instruction.Args)
+		case compiler.EQ_FLOAT:
+			v.opEqFloat(instruction.Args)
+		case compiler.NE_FLOAT:
+			v.opNeFloat(instruction.Args)
+		case compiler.GT_FLOAT:
+			v.opGtFloat(instruction.Args)
+		case compiler.GTE_FLOAT:
+			v.opGteFloat(instruction.Args)
+		case compiler.LT_FLOAT:
+			v.opLtFloat(instruction.Args)
+		case compiler.LTE_FLOAT:
+			v.opLteFloat(instruction.Args)
+		case compiler.NEG_FLOAT:
+			v.opNegFloat(instruction.Args)
+		case compiler.STORE_INDEX:
+			err = v.opStoreIndex(instruction.Args)
+		case compiler.MAKE_MAP:
+			v.opMakeMap(instruction.Args)
+		case compiler.INDEX_MAP:
+			err = v.opIndexMap(instruction.Args)
+		case compiler.STORE_MAP:
+			v.opStoreMap(instruction.Args)
+		case compiler.MAKE_STRUCT:
+			v.opMakeStruct(instruction.Args)
+		case compiler.GET_FIELD:
+			err = v.opGetField(instruction.Args)
+		case compiler.SET_FIELD:
+			err = v.opSetField(instruction.Args)
+		case compiler.MAKE_VARIANT:
+			v.opMakeVariant(instruction.Args)
+		case compiler.GET_TAG:
+			err = v.opGetTag(instruction.Args)
+		case compiler.GET_PAYLOAD:
+			err = v.opGetPayload(instruction.Args)
+		case compiler.IS_NULL:
+			v.opIsNull(instruction.Args)
+		case compiler.COPY:
+			v.opCopy(instruction.Args)
+		}
+		if err != nil {
+			if runtimeError, ok := err.(*RuntimeError); ok {
+				return nil, runtimeError
+			}
+			return nil, v.newRuntimeError(err.Error(), instruction)
 		}
 		frame.AdvanceIp()
 	}
@@ -130,7 +259,7 @@ func (v *VM) runInstructions(instructions []compiler.Instruction) *compiler.Valu
 		v.currentFrame().retval = nil
 	}
 	v.currentFrame().SetIp(0)
-	return retval
+	return retval, nil
 }
 
 func (v *VM) opLoadConst(args []int) {
@@ -143,6 +272,11 @@ func (v *VM) opLoadVar(args []int) {
 	v.currentFrame().SetRegister(args[0], *value)
 }
 
+func (v *VM) opMove(args []int) {
+	value := v.currentFrame().GetRegister(args[1])
+	v.currentFrame().SetRegister(args[0], *value)
+}
+
 func (v *VM) opLoadGlobal(args []int) {
 	value := v.globals[args[1]]
 	v.currentFrame().SetRegister(args[0], value)
@@ -189,11 +323,15 @@ func (v *VM) opMulInt(args []int) {
 	v.currentFrame().SetRegister(args[0], result)
 }
 
-func (v *VM) opDivInt(args []int) {
+func (v *VM) opDivInt(args []int) error {
 	left := v.currentFrame().GetRegister(args[1])
 	right := v.currentFrame().GetRegister(args[2])
+	if right.Int == 0 {
+		return errors.New("integer division by zero")
+	}
 	result := compiler.Value{TypeOf: compiler.VAL_INT, Int: left.Int / right.Int}
 	v.currentFrame().SetRegister(args[0], result)
+	return nil
 }
 
 func (v *VM) opEqInt(args []int) {
@@ -266,8 +404,124 @@ func (v *VM) opOrBool(args []int) {
 	v.currentFrame().SetRegister(args[0], result)
 }
 
+func (v *VM) opAddFloat(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_FLOAT, Float: left.Float + right.Float}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opSubFloat(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_FLOAT, Float: left.Float - right.Float}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opMulFloat(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_FLOAT, Float: left.Float * right.Float}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opDivFloat(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_FLOAT, Float: left.Float / right.Float}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opEqFloat(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_BOOL, Bool: left.Float == right.Float}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opNeFloat(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_BOOL, Bool: left.Float != right.Float}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opGtFloat(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_BOOL, Bool: left.Float > right.Float}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opGteFloat(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_BOOL, Bool: left.Float >= right.Float}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opLtFloat(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_BOOL, Bool: left.Float < right.Float}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opLteFloat(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_BOOL, Bool: left.Float <= right.Float}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opConcatString(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_STRING, String: left.String + right.String}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opEqString(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_BOOL, Bool: left.String == right.String}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opNeString(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_BOOL, Bool: left.String != right.String}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opLtString(args []int) {
+	left := v.currentFrame().GetRegister(args[1])
+	right := v.currentFrame().GetRegister(args[2])
+	result := compiler.Value{TypeOf: compiler.VAL_BOOL, Bool: left.String < right.String}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opNegInt(args []int) {
+	operand := v.currentFrame().GetRegister(args[1])
+	result := compiler.Value{TypeOf: compiler.VAL_INT, Int: -operand.Int}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opNegFloat(args []int) {
+	operand := v.currentFrame().GetRegister(args[1])
+	result := compiler.Value{TypeOf: compiler.VAL_FLOAT, Float: -operand.Float}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opNotBool(args []int) {
+	operand := v.currentFrame().GetRegister(args[1])
+	result := compiler.Value{TypeOf: compiler.VAL_BOOL, Bool: !operand.Bool}
+	v.currentFrame().SetRegister(args[0], result)
+}
+
 func (v *VM) opClosure(args []int) {
-	proto := v.moduleInstance.functions[args[1]]
+	proto := v.currentFrame().proto.Functions()[args[1]]
 	closure := &compiler.Closure{Proto: &proto, Upvalues: make([]*compiler.UpvalueCell, len(proto.Upvars()))}
 	for i, upvar := range proto.Upvars() {
 		if upvar.IsFromParent {
@@ -280,22 +534,28 @@ func (v *VM) opClosure(args []int) {
 	v.currentFrame().SetRegister(args[0], value)
 }
 
-func (v *VM) opCall(args []int) {
+func (v *VM) opCall(args []int) error {
 	function := v.currentFrame().GetRegister(args[1])
 	funcArgs := args[2:]
 	switch function.TypeOf {
 	case compiler.VAL_CLOSURE:
+		if len(v.frames) >= maxFrames {
+			return errors.New("stack overflow")
+		}
 		frame := NewFrame(function.Closure.Proto, function.Closure.Upvalues)
 		for i, argument := range funcArgs {
 			value := v.currentFrame().GetRegister(argument)
 			frame.SetLocal(i, *value)
 		}
-		v.frames = append(v.frames, *frame)
+		v.frames = append(v.frames, frame)
 		functionProto := function.Closure.Proto
-		retval := v.runInstructions(functionProto.Instructions())
+		retval, runtimeError := v.runInstructions(functionProto.Instructions())
 		v.frames = v.frames[:len(v.frames)-1]
+		if runtimeError != nil {
+			return runtimeError
+		}
 		v.currentFrame().SetRegister(args[0], *retval)
-		return
+		return nil
 	case compiler.VAL_NATIVE_FUNCTION:
 		values := make([]compiler.Value, len(funcArgs))
 		for i, argument := range funcArgs {
@@ -303,11 +563,12 @@ func (v *VM) opCall(args []int) {
 		}
 		retval, err := function.Native(v, values...)
 		if err != nil {
-			panic(fmt.Sprintf("VM ERROR: native function %v returned error: %v", function.Native, err))
+			return err
 		}
 		v.currentFrame().SetRegister(args[0], retval)
-		return
+		return nil
 	}
+	return fmt.Errorf("value of type %v is not callable", function.TypeOf)
 }
 
 func (v *VM) opReturn(args []int) {
@@ -322,6 +583,13 @@ func (v *VM) opJumpIfFalse(args []int) {
 	}
 }
 
+func (v *VM) opJumpIfTrue(args []int) {
+	condition := v.currentFrame().GetRegister(args[0])
+	if condition.Bool {
+		v.currentFrame().SetIp(args[1])
+	}
+}
+
 func (v *VM) opJump(args []int) {
 	v.currentFrame().SetIp(args[0])
 }
@@ -336,9 +604,143 @@ func (v *VM) opMakeArray(args []int) {
 	v.currentFrame().SetRegister(args[0], result)
 }
 
-func (v *VM) opIndexArray(args []int) {
+func (v *VM) opIndexArray(args []int) error {
 	array := v.currentFrame().GetRegister(args[1])
 	index := v.currentFrame().GetRegister(args[2])
+	if index.Int < 0 || index.Int >= len(array.Array) {
+		return fmt.Errorf("index %d out of range for array of length %d", index.Int, len(array.Array))
+	}
 	result := array.Array[index.Int]
 	v.currentFrame().SetRegister(args[0], result)
+	return nil
+}
+
+// opStoreIndex writes into the backing array shared by every value holding
+// the same array, so the element changes for all of them.
+func (v *VM) opStoreIndex(args []int) error {
+	array := v.currentFrame().GetRegister(args[0])
+	index := v.currentFrame().GetRegister(args[1])
+	if index.Int < 0 || index.Int >= len(array.Array) {
+		return fmt.Errorf("index %d out of range for array of length %d", index.Int, len(array.Array))
+	}
+	array.Array[index.Int] = *v.currentFrame().GetRegister(args[2])
+	return nil
+}
+
+func (v *VM) opMakeMap(args []int) {
+	m := compiler.NewMap()
+	entries := args[1:]
+	for i := 0; i < len(entries); i += 2 {
+		m.Set(*v.currentFrame().GetRegister(entries[i]), *v.currentFrame().GetRegister(entries[i+1]))
+	}
+	v.currentFrame().SetRegister(args[0], compiler.NewMapValue(m))
+}
+
+func (v *VM) opIndexMap(args []int) error {
+	m := v.currentFrame().GetRegister(args[1])
+	key := v.currentFrame().GetRegister(args[2])
+	result, ok := m.Map.Get(*key)
+	if !ok {
+		return fmt.Errorf("key %s not found in map", native.Format(*key))
+	}
+	v.currentFrame().SetRegister(args[0], result)
+	return nil
+}
+
+func (v *VM) opStoreMap(args []int) {
+	m := v.currentFrame().GetRegister(args[0])
+	m.Map.Set(*v.currentFrame().GetRegister(args[1]), *v.currentFrame().GetRegister(args[2]))
+}
+
+func (v *VM) opMakeStruct(args []int) {
+	desc := v.currentFrame().GetConstant(args[1]).Struct.Desc
+	fields := make([]compiler.Value, len(args)-2)
+	for i, field := range args[2:] {
+		fields[i] = *v.currentFrame().GetRegister(field)
+	}
+	result := compiler.NewStructValue(&compiler.Struct{Desc: desc, Fields: fields})
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+// checkField makes sure object is a struct with a field at index. The loader
+// only knows field and payload indexes are non negative, the value they are
+// applied to is known when the instruction runs.
+func checkField(object *compiler.Value, index int) error {
+	if object.TypeOf != compiler.VAL_STRUCT || object.Struct == nil {
+		return fmt.Errorf("value of type %v has no fields", object.TypeOf)
+	}
+	if index >= len(object.Struct.Fields) {
+		return fmt.Errorf("field %d out of range for struct %s", index, object.Struct.Desc.Name)
+	}
+	return nil
+}
+
+// checkVariant makes sure variant is an enum value, like checkField.
+func checkVariant(variant *compiler.Value) error {
+	if variant.TypeOf != compiler.VAL_VARIANT || variant.Variant == nil {
+		return fmt.Errorf("value of type %v is not an enum value", variant.TypeOf)
+	}
+	return nil
+}
+
+func (v *VM) opGetField(args []int) error {
+	object := v.currentFrame().GetRegister(args[1])
+	if err := checkField(object, args[2]); err != nil {
+		return err
+	}
+	v.currentFrame().SetRegister(args[0], object.Struct.Fields[args[2]])
+	return nil
+}
+
+func (v *VM) opMakeVariant(args []int) {
+	desc := v.currentFrame().GetConstant(args[1]).Variant.Desc
+	payload := make([]compiler.Value, len(args)-3)
+	for i, field := range args[3:] {
+		payload[i] = *v.currentFrame().GetRegister(field)
+	}
+	result := compiler.NewVariantValue(&compiler.Variant{Desc: desc, Tag: args[2], Payload: payload})
+	v.currentFrame().SetRegister(args[0], result)
+}
+
+func (v *VM) opGetTag(args []int) error {
+	variant := v.currentFrame().GetRegister(args[1])
+	if err := checkVariant(variant); err != nil {
+		return err
+	}
+	v.currentFrame().SetRegister(args[0], compiler.NewIntValue(variant.Variant.Tag))
+	return nil
+}
+
+func (v *VM) opGetPayload(args []int) error {
+	variant := v.currentFrame().GetRegister(args[1])
+	if err := checkVariant(variant); err != nil {
+		return err
+	}
+	if args[2] >= len(variant.Variant.Payload) {
+		return fmt.Errorf("payload %d out of range for %s.%s", args[2], variant.Variant.Desc.Name, variant.Variant.Desc.Variants[variant.Variant.Tag].Name)
+	}
+	v.currentFrame().SetRegister(args[0], variant.Variant.Payload[args[2]])
+	return nil
+}
+
+func (v *VM) opIsNull(args []int) {
+	value := v.currentFrame().GetRegister(args[1])
+	v.currentFrame().SetRegister(args[0], compiler.NewBoolValue(value.TypeOf == compiler.VAL_NULL))
+}
+
+func (v *VM) opCopy(args []int) {
+	v.currentFrame().SetRegister(args[0], v.currentFrame().GetRegister(args[1]).Copy())
+}
+
+func (v *VM) opSetField(args []int) error {
+	object := v.currentFrame().GetRegister(args[0])
+	if err := checkField(object, args[1]); err != nil {
+		return err
+	}
+	object.Struct.Fields[args[1]] = *v.currentFrame().GetRegister(args[2])
+	return nil
+}
+
+func (v *VM) opCloseVars(args []int) {
+	v.currentFrame().CloseLocals(args[0], args[1])
 }
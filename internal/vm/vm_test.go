@@ -0,0 +1,1235 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"youpiteron.dev/white-monster-on-friday-night/internal/ast"
+	"youpiteron.dev/white-monster-on-friday-night/internal/common"
+	"youpiteron.dev/white-monster-on-friday-night/internal/compiler"
+	"youpiteron.dev/white-monster-on-friday-night/internal/lexer"
+)
+
+func runSource(t *testing.T, source string) int {
+	t.Helper()
+
+	retval, runtimeError := runSourceWithError(t, source)
+	if runtimeError != nil {
+		t.Fatalf("unexpected runtime error: %v", runtimeError)
+	}
+	return retval
+}
+
+func runSourceWithError(t *testing.T, source string) (int, *RuntimeError) {
+	t.Helper()
+
+	lexerResult := lexer.NewLexer().Lex(source)
+	if len(lexerResult.Errors) > 0 {
+		t.Fatalf("unexpected lexer errors: %v", lexerResult.Errors)
+	}
+	parser := ast.NewParser(lexerResult.Tokens)
+	program := parser.ParseProgram()
+	if len(parser.Errors) > 0 {
+		t.Fatalf("unexpected parser errors: %v", parser.Errors)
+	}
+	compileResult, errors := compiler.NewCompiler().CompileToModuleProto(program)
+	if len(errors) > 0 {
+		t.Fatalf("unexpected compile errors: %v", errors)
+	}
+
+	vm := NewVM(compileResult.GlobalTable)
+	return vm.RunModuleProto(&compileResult.ModuleProto)
+}
+
+// ---------- Loop Tests ----------
+
+func TestRun_ForLoopWithBreakAndContinue(t *testing.T) {
+	source := `
+		var sum = 0;
+		for (var i = 0; i < 10; i = i + 1) {
+			if (i == 3) {
+				continue;
+			}
+			if (i == 8) {
+				break;
+			}
+			sum = sum + i;
+		}
+		return sum;
+	`
+
+	if retval := runSource(t, source); retval != 25 {
+		t.Errorf("expected 25, got %d", retval)
+	}
+}
+
+func TestRun_NestedWhileLoops(t *testing.T) {
+	source := `
+		var count = 0;
+		var i = 0;
+		while (i < 4) {
+			var j = 0;
+			while (j < i) {
+				count = count + 1;
+				j = j + 1;
+			}
+			i = i + 1;
+		}
+		return count;
+	`
+
+	if retval := runSource(t, source); retval != 6 {
+		t.Errorf("expected 6, got %d", retval)
+	}
+}
+
+// ---------- Closure Tests ----------
+
+func TestRun_BreakClosesLoopVariables(t *testing.T) {
+	source := `
+		var g = [() => 0];
+		for (var i = 0; i < 3; i++) {
+			var v = i * 10;
+			g = append(g, () => v);
+			if (i == 0) {
+				continue;
+			}
+			break;
+		}
+		// reuses the slots i and v had in the loop above
+		{
+			var y = 999;
+			var z = 999;
+		}
+		return g[2]();
+	`
+
+	if retval := runSource(t, source); retval != 10 {
+		t.Errorf("expected 10, got %d", retval)
+	}
+}
+
+func TestRun_ClosureEscapesBlock(t *testing.T) {
+	source := `
+		var f: () -> int = () => 0;
+		{
+			var k = 1;
+			f = () => k;
+		}
+		// reuses the slot k had in the block above
+		var z = 5;
+		return f();
+	`
+
+	if retval := runSource(t, source); retval != 1 {
+		t.Errorf("expected 1, got %d", retval)
+	}
+}
+
+func TestRun_NestedClosureCapturesOuterLocal(t *testing.T) {
+	source := `
+		function outer(): int {
+			var x = 1;
+			var y = 2;
+			function middle(): int {
+				function inner(): int {
+					return y;
+				}
+				return inner();
+			}
+			return middle();
+		}
+		return outer();
+	`
+
+	if retval := runSource(t, source); retval != 2 {
+		t.Errorf("expected 2, got %d", retval)
+	}
+}
+
+// ---------- Frame Tests ----------
+
+func TestFrame_CloseLocalsKeepsCapturedCell(t *testing.T) {
+	proto := &compiler.ModuleProto{}
+	frame := NewFrame(proto, nil)
+
+	frame.SetLocal(0, compiler.NewIntValue(1))
+	captured := frame.GetLocal(0)
+
+	frame.CloseLocals(0, 1)
+	frame.SetLocal(0, compiler.NewIntValue(2))
+
+	if captured.Int != 1 {
+		t.Errorf("expected captured cell to keep 1, got %d", captured.Int)
+	}
+	if frame.GetLocal(0).Int != 2 {
+		t.Errorf("expected fresh cell to hold 2, got %d", frame.GetLocal(0).Int)
+	}
+}
+
+// ---------- Unary Operator Tests ----------
+
+func TestRun_UnaryOperatorsAndIncrement(t *testing.T) {
+	source := `
+		var x = 5;
+		var n = 0;
+		for (var i = 0; i < 4; i++) {
+			if (!(i == 2)) {
+				n++;
+			}
+		}
+		--n;
+		return -x * 10 + n;
+	`
+
+	if retval := runSource(t, source); retval != -48 {
+		t.Errorf("expected -48, got %d", retval)
+	}
+}
+
+// ---------- Short-Circuit Tests ----------
+
+func TestRun_LogicalOperatorsShortCircuit(t *testing.T) {
+	source := `
+		var calls = 0;
+		function touch(result: bool): bool {
+			calls = calls + 1;
+			return result;
+		}
+		var arr: []int = [1, 2];
+		var i = 5;
+		var guarded = i < 2 && arr[i] > 0;
+		false && touch(true);
+		true || touch(true);
+		true && touch(false);
+		var both = touch(false) || touch(true);
+		if (guarded || !both) {
+			return -1;
+		}
+		return calls;
+	`
+
+	if retval := runSource(t, source); retval != 3 {
+		t.Errorf("expected 3 calls, got %d", retval)
+	}
+}
+
+// ---------- Conditional Expression Tests ----------
+
+func TestRun_ConditionalExpr(t *testing.T) {
+	source := `
+		function sign(x: int): int {
+			return x > 0 ? 1 : x < 0 ? -1 : 0;
+		}
+		var calls = 0;
+		function touch(): int {
+			calls = calls + 1;
+			return calls;
+		}
+		var picked = sign(-7) == -1 ? touch() : touch() + 100;
+		return picked * 100 + sign(5) * 10 + sign(0) + calls;
+	`
+
+	if retval := runSource(t, source); retval != 111 {
+		t.Errorf("expected 111, got %d", retval)
+	}
+}
+
+// ---------- String Tests ----------
+
+func TestRun_Strings(t *testing.T) {
+	source := `
+		var name: string;
+		name = name + "wmo" + "fn";
+		var score = 0;
+		if (name == "wmofn") {
+			score = score + 1;
+		}
+		if (name != "other") {
+			score = score + 10;
+		}
+		if ("apple" < "banana") {
+			score = score + 100;
+		}
+		return score;
+	`
+
+	if retval := runSource(t, source); retval != 111 {
+		t.Errorf("expected 111, got %d", retval)
+	}
+}
+
+func TestRun_NonASCIIStrings(t *testing.T) {
+	source := `
+		var word = "caf" + "é";
+		if (word == "caf\u{e9}" && word != "cafe") {
+			return 1;
+		}
+		return 0;
+	`
+
+	if retval := runSource(t, source); retval != 1 {
+		t.Errorf("expected 1, got %d", retval)
+	}
+}
+
+// ---------- Float Tests ----------
+
+func TestRun_FloatArithmeticAndConversions(t *testing.T) {
+	source := `
+		function average(xs: []int): float {
+			var sum = 0.0;
+			for (var i = 0; i < 4; i++) {
+				sum = sum + float(xs[i]);
+			}
+			return sum / 4.0;
+		}
+		var avg = average([1, 2, 3, 5]);
+		var negated = -avg;
+		if (avg > 2.5 && negated <= -2.75 && 1e-3 == 0.001) {
+			return int(avg * 100.0);
+		}
+		return -1;
+	`
+
+	if retval := runSource(t, source); retval != 275 {
+		t.Errorf("expected 275, got %d", retval)
+	}
+}
+
+func TestRun_ReturnWithoutValue(t *testing.T) {
+	source := `
+		var calls = 0;
+		function bump(): null {
+			calls = calls + 1;
+			return;
+		}
+		bump();
+		bump();
+		return calls;
+	`
+
+	if retval := runSource(t, source); retval != 2 {
+		t.Errorf("expected 2, got %d", retval)
+	}
+}
+
+// ---------- Runtime Error Tests ----------
+
+func TestRun_DivisionByZeroReportsStackTrace(t *testing.T) {
+	source := `var zero = 0;
+function run(): int {
+	function divide(a: int, b: int): int {
+		return a / b;
+	}
+	return divide(10, zero);
+}
+return run();`
+
+	_, runtimeError := runSourceWithError(t, source)
+	if runtimeError == nil {
+		t.Fatalf("expected runtime error")
+	}
+	if runtimeError.Message != "integer division by zero" {
+		t.Errorf("unexpected message %q", runtimeError.Message)
+	}
+	if runtimeError.Instruction.OpCode != compiler.DIV_INT {
+		t.Errorf("expected failing instruction DIV_INT, got %v", runtimeError.Instruction.OpCode)
+	}
+	if runtimeError.Pos == nil || runtimeError.Pos.Line != 4 {
+		t.Fatalf("expected error on line 4, got %v", runtimeError.Pos)
+	}
+
+	expected := []struct {
+		function string
+		line     int
+	}{{"divide", 4}, {"run", 6}, {"<module>", 8}}
+	if len(runtimeError.StackTrace) != len(expected) {
+		t.Fatalf("expected %d frames, got %v", len(expected), runtimeError.StackTrace)
+	}
+	for i, frame := range runtimeError.StackTrace {
+		if frame.Function != expected[i].function || frame.Pos == nil || frame.Pos.Line != expected[i].line {
+			t.Errorf("frame %d: expected %s at line %d, got %v", i, expected[i].function, expected[i].line, frame)
+		}
+	}
+}
+
+func TestRun_IndexOutOfRange(t *testing.T) {
+	source := `
+		var xs = [1, 2, 3];
+		return xs[3];
+	`
+
+	_, runtimeError := runSourceWithError(t, source)
+	if runtimeError == nil {
+		t.Fatalf("expected runtime error")
+	}
+	if runtimeError.Message != "index 3 out of range for array of length 3" {
+		t.Errorf("unexpected message %q", runtimeError.Message)
+	}
+	if runtimeError.Pos == nil || runtimeError.Pos.Line != 3 {
+		t.Errorf("expected error on line 3, got %v", runtimeError.Pos)
+	}
+}
+
+func TestRun_RecoversAfterRuntimeError(t *testing.T) {
+	c := compiler.NewCompiler()
+	globalTable := c.StartREPL()
+	vm := NewVM(globalTable)
+
+	chunks := []string{
+		"var x = 10;",
+		"function f(d: int): int { return x / d; }",
+		"f(0);",
+		"return f(2);",
+	}
+	var retval int
+	var runtimeError *RuntimeError
+	for i, chunk := range chunks {
+		lexerResult := lexer.NewLexer().Lex(chunk)
+		program := ast.NewParser(lexerResult.Tokens).ParseProgram()
+		compileResult, errors := c.CompileREPLChunk(program)
+		if len(errors) > 0 {
+			t.Fatalf("unexpected compile errors in chunk %d: %v", i, errors)
+		}
+		retval, runtimeError = vm.RunModuleProto(&compileResult.ModuleProto)
+		if (runtimeError != nil) != (i == 2) {
+			t.Fatalf("chunk %d: unexpected runtime error state: %v", i, runtimeError)
+		}
+	}
+	if retval != 5 {
+		t.Errorf("expected 5, got %d", retval)
+	}
+}
+
+func TestRun_RuntimeErrorDropsChunkDeclarations(t *testing.T) {
+	c := compiler.NewCompiler()
+	globalTable := c.StartREPL()
+	vm := NewVM(globalTable)
+
+	compileChunk := func(chunk string) (*compiler.CompileResult, []common.Error) {
+		lexerResult := lexer.NewLexer().Lex(chunk)
+		return c.CompileREPLChunk(ast.NewParser(lexerResult.Tokens).ParseProgram())
+	}
+
+	compileResult, errors := compileChunk("var z = 1 / 0;")
+	if len(errors) > 0 {
+		t.Fatalf("unexpected compile errors: %v", errors)
+	}
+	if _, runtimeError := vm.RunModuleProto(&compileResult.ModuleProto); runtimeError == nil {
+		t.Fatal("expected runtime error")
+	}
+	c.RollbackREPLChunk()
+
+	if _, errors := compileChunk("return z;"); len(errors) != 1 || errors[0].Message != "variable z not found" {
+		t.Fatalf("expected z to be dropped with the failed chunk, got %v", errors)
+	}
+	compileResult, errors = compileChunk("var z = 4; return z;")
+	if len(errors) > 0 {
+		t.Fatalf("unexpected compile errors: %v", errors)
+	}
+	if retval, runtimeError := vm.RunModuleProto(&compileResult.ModuleProto); runtimeError != nil || retval != 4 {
+		t.Errorf("expected 4, got %d (%v)", retval, runtimeError)
+	}
+}
+
+// ---------- Recursion Tests ----------
+
+func TestRun_RecursiveFib(t *testing.T) {
+	source := `
+		function fib(n: int): int {
+			if (n < 2) {
+				return n;
+			}
+			return fib(n - 1) + fib(n - 2);
+		}
+		return fib(15);
+	`
+
+	if retval := runSource(t, source); retval != 610 {
+		t.Errorf("expected 610, got %d", retval)
+	}
+}
+
+func TestRun_UnboundedRecursionOverflows(t *testing.T) {
+	source := `
+		function down(n: int): int {
+			return down(n + 1);
+		}
+		return down(0);
+	`
+
+	_, runtimeError := runSourceWithError(t, source)
+	if runtimeError == nil {
+		t.Fatalf("expected runtime error")
+	}
+	if runtimeError.Message != "stack overflow" {
+		t.Errorf("unexpected message %q", runtimeError.Message)
+	}
+	trace := runtimeError.StackTrace
+	if len(trace) != maxFrames || trace[0].Function != "down" || trace[len(trace)-1].Function != "<module>" {
+		t.Errorf("expected a trace from down to <module> of %d frames, got %d", maxFrames, len(trace))
+	}
+	lines := strings.Split(runtimeError.Error(), "\n")
+	if len(lines) != 4 || lines[2] != fmt.Sprintf("  ... repeated %d more times", maxFrames-2) {
+		t.Errorf("expected the repeated frames to be collapsed, got:\n%s", runtimeError.Error())
+	}
+}
+
+func TestRun_MutualRecursionOverflowTraceIsBounded(t *testing.T) {
+	source := `
+		function ping(n: int): int {
+			return pong(n + 1);
+		}
+		function pong(n: int): int {
+			return ping(n + 1);
+		}
+		return ping(0);
+	`
+
+	_, runtimeError := runSourceWithError(t, source)
+	if runtimeError == nil {
+		t.Fatalf("expected runtime error")
+	}
+	lines := strings.Split(runtimeError.Error(), "\n")
+	if len(lines) != 2*traceEdge+2 || lines[traceEdge+1] != fmt.Sprintf("  ... %d more frames", maxFrames-2*traceEdge) {
+		t.Errorf("expected %d lines with the middle frames omitted, got %d", 2*traceEdge+2, len(lines))
+	}
+	if !strings.HasPrefix(lines[len(lines)-1], "  at <module>") {
+		t.Errorf("expected the trace to end at <module>, got %q", lines[len(lines)-1])
+	}
+}
+
+func TestRun_MutualRecursion(t *testing.T) {
+	source := `
+		function isEven(n: int): bool {
+			if (n == 0) {
+				return true;
+			}
+			return isOdd(n - 1);
+		}
+		function isOdd(n: int): bool {
+			if (n == 0) {
+				return false;
+			}
+			return isEven(n - 1);
+		}
+		function count(): int {
+			var evens = 0;
+			for (var i = 0; i < 10; i++) {
+				if (isEven(i)) {
+					evens++;
+				}
+			}
+			return evens;
+		}
+		return isOdd(7) ? count() : -1;
+	`
+
+	if retval := runSource(t, source); retval != 5 {
+		t.Errorf("expected 5, got %d", retval)
+	}
+}
+
+func TestRun_MutualRecursionInsideFunction(t *testing.T) {
+	source := `
+		function countdown(n: int): int {
+			function ping(n: int): int {
+				if (n == 0) {
+					return 0;
+				}
+				return 1 + pong(n - 1);
+			}
+			function pong(n: int): int {
+				if (n == 0) {
+					return 0;
+				}
+				return 2 + ping(n - 1);
+			}
+			return ping(n);
+		}
+		return countdown(5);
+	`
+
+	if retval := runSource(t, source); retval != 7 {
+		t.Errorf("expected 7, got %d", retval)
+	}
+}
+
+// ---------- Function Type Tests ----------
+
+func TestRun_HigherOrderFunctions(t *testing.T) {
+	source := `
+		function map(xs: []int, n: int, f: (int) -> int): []int {
+			var out: []int;
+			for (var i = 0; i < n; i++) {
+				out = append(out, f(xs[i]));
+			}
+			return out;
+		}
+		function adder(n: int): (int) -> int {
+			function add(x: int): int {
+				return x + n;
+			}
+			return add;
+		}
+		function sumFloats(xs: []int, n: int, convert: (int) -> float): float {
+			var sum = 0.0;
+			for (var i = 0; i < n; i++) {
+				sum = sum + convert(xs[i]);
+			}
+			return sum;
+		}
+		function double(x: int): int {
+			return x * 2;
+		}
+
+		const fs = [double, adder(100)];
+		const second = fs[1];
+		const mapped = map([1, 2, 3], 3, double);
+		return mapped[2] + second(1) + int(sumFloats(mapped, 3, float));
+	`
+
+	if retval := runSource(t, source); retval != 6+101+12 {
+		t.Errorf("expected %d, got %d", 6+101+12, retval)
+	}
+}
+
+// ---------- Lambda Tests ----------
+
+func TestRun_Lambdas(t *testing.T) {
+	source := `
+		function apply(f: (int) -> int, x: int): int {
+			return f(x);
+		}
+		var offset = 10;
+		const addOffset = function (x: int): int {
+			return x + offset;
+		};
+		const curry = (x: int) => (y: int) => x * 10 + y;
+		const four = curry(4);
+		offset = 20;
+		return apply(addOffset, 1) + apply((x: int) => x * x, 5) + four(2);
+	`
+
+	if retval := runSource(t, source); retval != 21+25+42 {
+		t.Errorf("expected %d, got %d", 21+25+42, retval)
+	}
+}
+
+// ---------- Postfix Chain Tests ----------
+
+func TestRun_CallAndIndexChains(t *testing.T) {
+	source := `
+		function makeAdder(n: int): (int) -> int {
+			return (x: int) => x + n;
+		}
+		function pair(x: int): []int {
+			return [x, x * 2, x * 3];
+		}
+		function counter(): () -> int {
+			var count = 0;
+			return function (): int {
+				count++;
+				return count;
+			};
+		}
+		const handlers = [makeAdder(10), makeAdder(20)];
+		const grid = [[1, 2], [3, 4]];
+		return makeAdder(1)(2) + handlers[1](5) + pair(4)[2] + grid[1][0] + counter()() + ((x: int) => x * 100)(1);
+	`
+
+	if retval := runSource(t, source); retval != 3+25+12+3+1+100 {
+		t.Errorf("expected %d, got %d", 3+25+12+3+1+100, retval)
+	}
+}
+
+// ---------- Assignment Tests ----------
+
+func TestRun_IndexAndCompoundAssignment(t *testing.T) {
+	source := `
+		var grid = [[1, 2], [3, 4]];
+		grid[1][0] = 10;
+		grid[0][1] *= 5;
+		var total = 0;
+		for (var i = 0; i < 2; i++) {
+			for (var j = 0; j < 2; j += 1) {
+				total += grid[i][j];
+			}
+		}
+		var s = "a";
+		s += "b";
+		var f = 1.5;
+		f *= 2.0;
+		total -= 1;
+		total /= 2;
+		if (s == "ab" && f == 3.0) {
+			return total;
+		}
+		return -1;
+	`
+
+	if retval := runSource(t, source); retval != (1+10+10+4-1)/2 {
+		t.Errorf("expected %d, got %d", (1+10+10+4-1)/2, retval)
+	}
+}
+
+func TestRun_ElementAssignmentIsShared(t *testing.T) {
+	source := `
+		var xs = [1, 2];
+		var ys = xs;
+		var zs = append(xs, 3);
+		ys[0] = 7;
+		zs[1] = 9;
+		return xs[0] * 10 + xs[1];
+	`
+
+	if retval := runSource(t, source); retval != 72 {
+		t.Errorf("expected 72, got %d", retval)
+	}
+}
+
+func TestRun_StoreIndexOutOfRange(t *testing.T) {
+	source := `
+		var xs = [1, 2, 3];
+		xs[-1] = 0;
+	`
+
+	_, runtimeError := runSourceWithError(t, source)
+	if runtimeError == nil {
+		t.Fatalf("expected runtime error")
+	}
+	if runtimeError.Message != "index -1 out of range for array of length 3" {
+		t.Errorf("unexpected message %q", runtimeError.Message)
+	}
+	if runtimeError.Pos == nil || runtimeError.Pos.Line != 3 {
+		t.Errorf("expected error on line 3, got %v", runtimeError.Pos)
+	}
+}
+
+// ---------- Nested Array Tests ----------
+
+func TestRun_MatrixExample(t *testing.T) {
+	source, err := os.ReadFile("../../example/matrix.wmofn")
+	if err != nil {
+		t.Fatalf("failed to read example: %v", err)
+	}
+
+	if retval := runSource(t, string(source)); retval != 285 {
+		t.Errorf("expected 285, got %d", retval)
+	}
+}
+
+func TestRun_EmptyArrayDefaults(t *testing.T) {
+	source := `
+		var rows: [][]int;
+		var row: []int = [];
+		row = append(row, 4);
+		rows = [row, [], [5, 6]];
+		rows[1] = append(rows[1], 7);
+		return rows[0][0] + rows[1][0] + rows[2][1];
+	`
+
+	if retval := runSource(t, source); retval != 4+7+6 {
+		t.Errorf("expected %d, got %d", 4+7+6, retval)
+	}
+}
+
+// ---------- Map Tests ----------
+
+func TestRun_Maps(t *testing.T) {
+	source := `
+		var stock: map[string]int = { "pear": 3, "apple": 5 };
+		stock["fig"] = 7;
+		stock["pear"] += 10;
+		delete(stock, "apple");
+		delete(stock, "missing");
+		stock["apple"] = 1;
+
+		var total = 0;
+		const names = keys(stock);
+		for (var i = 0; i < 3; i++) {
+			total = total * 100 + stock[names[i]];
+		}
+		if (has(stock, "kiwi") || !has(stock, "fig")) {
+			return -1;
+		}
+		return total;
+	`
+
+	// keys keep insertion order: pear, fig and then apple, added again after the delete
+	if retval := runSource(t, source); retval != 130701 {
+		t.Errorf("expected 130701, got %d", retval)
+	}
+}
+
+func TestRun_MapsAreShared(t *testing.T) {
+	source := `
+		function count(words: []string): map[string]int {
+			var counts: map[string]int;
+			for (var i = 0; i < 3; i++) {
+				if (!has(counts, words[i])) {
+					counts[words[i]] = 0;
+				}
+				counts[words[i]] += 1;
+			}
+			return counts;
+		}
+		const first = count(["a", "b", "a"]);
+		var second = count(["b", "b", "b"]);
+		var alias = second;
+		alias["c"] = 9;
+		return first["a"] * 1000 + second["b"] * 100 + second["c"] * 10 + first["b"];
+	`
+
+	if retval := runSource(t, source); retval != 2391 {
+		t.Errorf("expected 2391, got %d", retval)
+	}
+}
+
+func TestRun_ConstValuesAreNotWrittenThroughAliases(t *testing.T) {
+	source := `
+		const a = [1];
+		var b = a;
+		b[0] = 2;
+
+		const grid = [[10]];
+		var row = grid[0];
+		row[0] = 20;
+
+		function first(xs: []int): int {
+			var ys = xs;
+			ys[0] = 30;
+			return xs[0];
+		}
+
+		var v = [100];
+		const c = v;
+		v[0] = 200;
+
+		const m = { "k": 1000 };
+		var boxes = [m];
+		boxes[0]["k"] = 2000;
+
+		return a[0] + grid[0][0] + first(a) + c[0] + m["k"];
+	`
+
+	if retval := runSource(t, source); retval != 1112 {
+		t.Errorf("expected 1112, got %d", retval)
+	}
+}
+
+func TestRun_MissingMapKey(t *testing.T) {
+	source := `
+		var m = { 1: true };
+		return m[2] ? 1 : 0;
+	`
+
+	_, runtimeError := runSourceWithError(t, source)
+	if runtimeError == nil {
+		t.Fatalf("expected runtime error")
+	}
+	if runtimeError.Message != "key 2 not found in map" {
+		t.Errorf("unexpected message %q", runtimeError.Message)
+	}
+}
+
+// ---------- Struct Tests ----------
+
+func TestRun_Structs(t *testing.T) {
+	source := `
+		struct Point { x: int, y: int }
+		struct Segment { from: Point, to: Point }
+
+		function length2(s: Segment): int {
+			const dx = s.to.x - s.from.x;
+			const dy = s.to.y - s.from.y;
+			return dx * dx + dy * dy;
+		}
+
+		var p = Point { y: 4, x: 3 };
+		var s = Segment { from: Point { x: 0, y: 0 }, to: p };
+		p.x = 6;
+		p.y *= 2;
+		s.to.x += 1;
+		return length2(s) * 100 + p.x;
+	`
+
+	// s.to and p are the same struct, so both see every change
+	if retval := runSource(t, source); retval != (49+64)*100+7 {
+		t.Errorf("expected %d, got %d", (49+64)*100+7, retval)
+	}
+}
+
+func TestRun_RecursiveStruct(t *testing.T) {
+	source := `
+		struct Node { value: int, children: []Node }
+
+		function sum(node: Node): int {
+			// only the root has a child
+			if (node.value == 1) {
+				return node.value + sum(node.children[0]);
+			}
+			return node.value;
+		}
+
+		var leaf = Node { value: 2, children: [] };
+		var tree = Node { value: 1, children: [leaf] };
+		tree.children[0].value = 40;
+		return sum(tree);
+	`
+
+	if retval := runSource(t, source); retval != 41 {
+		t.Errorf("expected 41, got %d", retval)
+	}
+}
+
+func TestRun_Enums(t *testing.T) {
+	source := `
+		enum Shape { Circle(r: int), Rect(w: int, h: int), Empty }
+		enum List { Cons(head: int, tail: List), Nil }
+
+		function area(s: Shape): int {
+			return match (s) {
+				Circle(r) => 3 * r * r,
+				Rect(w, h) => w * h,
+				Empty => 0,
+			};
+		}
+
+		function sum(list: List): int {
+			return match (list) { Cons(head, tail) => head + sum(tail), Nil => 0 };
+		}
+
+		var shapes: []Shape = [Shape.Circle(2), Shape.Rect(3, 4), Shape.Empty];
+		var total = 0;
+		for (var i = 0; i < 3; i++) {
+			total += area(shapes[i]);
+		}
+		const list = List.Cons(1, List.Cons(2, List.Cons(3, List.Nil)));
+		const height = match (shapes[1]) { Rect(_, h) => h, _ => 0 };
+		return total * 100 + sum(list) * 10 + height;
+	`
+
+	if retval := runSource(t, source); retval != 24*100+6*10+4 {
+		t.Errorf("expected %d, got %d", 24*100+6*10+4, retval)
+	}
+}
+
+func TestRun_MatchArmClosures(t *testing.T) {
+	source := `
+		enum Opt { Some(v: int), None }
+
+		var f = match (Opt.Some(5)) { Some(v) => () => v, None => () => 0 };
+		// reuses the slot v had in the arm above
+		var g = 7;
+		return f() + g;
+	`
+
+	if retval := runSource(t, source); retval != 12 {
+		t.Errorf("expected 12, got %d", retval)
+	}
+}
+
+func TestRun_NullableTypes(t *testing.T) {
+	source := `
+		function find(xs: []int, x: int): int? {
+			for (var i = 0; i < 3; i++) {
+				if (xs[i] == x) {
+					return i;
+				}
+			}
+			return null;
+		}
+
+		function orZero(x: int?): int {
+			if (x == null) {
+				return 0;
+			}
+			return x * 10;
+		}
+
+		var xs = [4, 5, 6];
+		var found = find(xs, 6);
+		var missing = find(xs, 9);
+		var total = 0;
+		if (found != null && found > 1) {
+			total += found;
+		}
+		if (missing != null) {
+			total += 100;
+		} else {
+			total += 1000;
+		}
+		const fallback = missing ?? found ?? 7;
+		const next = found != null ? found + 1 : 0;
+		return total + fallback * 10000 + next * 100000 + orZero(missing) + orZero(found);
+	`
+
+	// found is 2 and missing is null
+	expected := 1002 + 2*10000 + 3*100000 + 0 + 20
+	if retval := runSource(t, source); retval != expected {
+		t.Errorf("expected %d, got %d", expected, retval)
+	}
+}
+
+func TestRun_NullCoalesceShortCircuits(t *testing.T) {
+	source := `
+		var calls = 0;
+		function fallback(): int {
+			calls++;
+			return 5;
+		}
+
+		var set: int? = 1;
+		var unset: int? = null;
+		const a = set ?? fallback();
+		const b = unset ?? fallback();
+		return a * 100 + b * 10 + calls;
+	`
+
+	if retval := runSource(t, source); retval != 151 {
+		t.Errorf("expected 151, got %d", retval)
+	}
+}
+
+func TestRun_GenericFunctions(t *testing.T) {
+	source := `
+		function first<T>(xs: []T): T {
+			return xs[0];
+		}
+
+		function mapTwo<T, U>(xs: []T, f: (T) -> U): []U {
+			var out: []U = [];
+			for (var i = 0; i < 2; i++) {
+				out = append(out, f(xs[i]));
+			}
+			return out;
+		}
+
+		function orElse<T>(x: T?, fallback: T): T {
+			if (x != null) {
+				return x;
+			}
+			return fallback;
+		}
+
+		var flags = append([true], false);
+		var lengths = mapTwo(["a", "bc"], (s: string) => s == "a" ? 1 : 2);
+		var missing: int? = null;
+		const picked = first(flags) ? first(lengths) : 0;
+		return picked * 100 + lengths[1] * 10 + orElse(missing, 3);
+	`
+
+	if retval := runSource(t, source); retval != 123 {
+		t.Errorf("expected 123, got %d", retval)
+	}
+}
+
+// ---------- Module Tests ----------
+
+func compileFiles(t *testing.T, files map[string]string, path string) *compiler.CompileResult {
+	t.Helper()
+
+	readFile := func(path string) ([]byte, error) {
+		source, ok := files[path]
+		if !ok {
+			return nil, fmt.Errorf("no such file")
+		}
+		return []byte(source), nil
+	}
+	compileResult, errors := compiler.NewResolver(nil, readFile).CompileFile(path)
+	if len(errors) > 0 {
+		t.Fatalf("unexpected compile errors: %v", errors)
+	}
+	return compileResult
+}
+
+func runFiles(t *testing.T, files map[string]string, path string) int {
+	t.Helper()
+
+	return runCompileResult(t, compileFiles(t, files, path))
+}
+
+// runCompileResult runs the imports of a program, then the program itself.
+func runCompileResult(t *testing.T, compileResult *compiler.CompileResult) int {
+	t.Helper()
+
+	vm := NewVM(compileResult.GlobalTable)
+	for _, imported := range compileResult.Imports {
+		if runtimeError := vm.RunImport(&imported); runtimeError != nil {
+			t.Fatalf("unexpected runtime error: %v", runtimeError)
+		}
+	}
+	retval, runtimeError := vm.RunModuleProto(&compileResult.ModuleProto)
+	if runtimeError != nil {
+		t.Fatalf("unexpected runtime error: %v", runtimeError)
+	}
+	return retval
+}
+
+func TestRun_Imports(t *testing.T) {
+	files := map[string]string{
+		"lib/geo.wmofn": `
+			export struct Point { x: int, y: int }
+
+			const scale = 10;
+
+			export function scaled(p: Point): Point {
+				return Point { x: p.x * scale, y: p.y * scale };
+			}
+
+			export function makeCounter(): () -> int {
+				var n = 0;
+				return function (): int {
+					n++;
+					return n;
+				};
+			}
+		`,
+		"lib/origin.wmofn": `
+			import "geo.wmofn";
+			export const origin = Point { x: 1, y: 2 };
+		`,
+		"main.wmofn": `
+			import "lib/geo.wmofn";
+			import "lib/origin.wmofn";
+
+			const p = scaled(origin);
+			const counter = makeCounter();
+			counter();
+			return p.x + p.y + counter();
+		`,
+	}
+
+	if retval := runFiles(t, files, "main.wmofn"); retval != 32 {
+		t.Errorf("expected 32, got %d", retval)
+	}
+}
+
+func TestRun_StackTraceNamesModules(t *testing.T) {
+	files := map[string]string{
+		"rterr.wmofn": `export function boom(d: int): int { return 1 / d; }`,
+		"main.wmofn": `import "rterr.wmofn";
+return boom(0);`,
+	}
+
+	compileResult := compileFiles(t, files, "main.wmofn")
+	vm := NewVM(compileResult.GlobalTable)
+	for _, imported := range compileResult.Imports {
+		if runtimeError := vm.RunImport(&imported); runtimeError != nil {
+			t.Fatalf("unexpected runtime error: %v", runtimeError)
+		}
+	}
+	_, runtimeError := vm.RunModuleProto(&compileResult.ModuleProto)
+	if runtimeError == nil {
+		t.Fatalf("expected runtime error")
+	}
+
+	expected := []string{"boom (rterr.wmofn:1:46)", "<module> (main.wmofn:2:12)"}
+	if len(runtimeError.StackTrace) != len(expected) {
+		t.Fatalf("expected %d frames, got %v", len(expected), runtimeError.StackTrace)
+	}
+	for i, frame := range runtimeError.StackTrace {
+		if frame.String() != expected[i] {
+			t.Errorf("frame %d: expected %s, got %s", i, expected[i], frame)
+		}
+	}
+	if !strings.Contains(runtimeError.Error(), "\n  at boom (rterr.wmofn:1:46)") {
+		t.Errorf("expected the trace to name the module, got %s", runtimeError.Error())
+	}
+}
+
+// ---------- Bytecode Tests ----------
+
+func TestRun_LoadedBytecode(t *testing.T) {
+	files := map[string]string{
+		"lib.wmofn": `
+			export enum Shape { Circle(r: int), Empty }
+			export function makeAdder(n: int): (int) -> int {
+				return (x: int) => x + n;
+			}
+		`,
+		"main.wmofn": `
+			import "lib.wmofn";
+			const add = makeAdder(40);
+			const sizes = { "big": Shape.Circle(2) };
+			const size = match (sizes["big"]) { Circle(r) => r, Empty => 0 };
+			const half = "half";
+			return add(size) + int(0.5 * 2.0) - (half == "half" ? 1 : 0);
+		`,
+	}
+	var buffer bytes.Buffer
+	if err := compiler.Save(&buffer, compileFiles(t, files, "main.wmofn")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded, err := compiler.Load(&buffer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if retval := runCompileResult(t, loaded); retval != 42 {
+		t.Errorf("expected 42, got %d", retval)
+	}
+}
+
+/*
+*
+Test running loaded files whose instructions pass the loader but do not fit
+the values they run on.
+
+- Should report a runtime error instead of letting the VM panic
+*/
+func TestRun_LoadedBytecodeWithBadOperands(t *testing.T) {
+	source := `
+		struct Point { x: int }
+		enum Shape { Circle(r: int), Empty }
+		var p = Point { x: 1 };
+		p.x = 2;
+		const s = Shape.Circle(3);
+		return p.x + match (s) { Circle(r) => r, Empty => 0 };
+	`
+	cases := []struct {
+		name    string
+		opCode  compiler.OpCode
+		arg     int
+		value   int
+		message string
+	}{
+		{"field past the struct", compiler.GET_FIELD, 2, 5, "field 5 out of range for struct Point"},
+		{"stored field past the struct", compiler.SET_FIELD, 1, 5, "field 5 out of range for struct Point"},
+		{"payload past the variant", compiler.GET_PAYLOAD, 2, 5, "payload 5 out of range for Shape.Circle"},
+		{"field of a register never written", compiler.GET_FIELD, 1, 500, "value of type INT has no fields"},
+		{"tag of a register never written", compiler.GET_TAG, 1, 500, "value of type INT is not an enum value"},
+	}
+
+	for _, c := range cases {
+		result := compileFiles(t, map[string]string{"main.wmofn": source}, "main.wmofn")
+		altered := false
+		for _, instruction := range result.ModuleProto.Instructions() {
+			if instruction.OpCode == c.opCode && !altered {
+				instruction.Args[c.arg] = c.value
+				altered = true
+			}
+		}
+		if !altered {
+			t.Fatalf("%s: no %s in the module", c.name, c.opCode)
+		}
+		var buffer bytes.Buffer
+		if err := compiler.Save(&buffer, result); err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+		loaded, err := compiler.Load(&buffer)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+
+		_, runtimeError := NewVM(loaded.GlobalTable).RunModuleProto(&loaded.ModuleProto)
+		if runtimeError == nil || runtimeError.Message != c.message {
+			t.Errorf("%s: expected %q, got %v", c.name, c.message, runtimeError)
+		}
+	}
+}